@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// buildInfo is what the uploader tells the server about the code being tested
+type buildInfo struct {
+	Branch            string
+	CommitSHA         string
+	CommitMessage     string
+	PullRequestNumber int
+	// BaseCommitSHA is the merge base with TargetBranch, the diff is taken
+	// against the build of that commit when the server has one
+	BaseCommitSHA string
+	TargetBranch  string
+}
+
+/*
+inferBuildInfo fills in whatever isn't set on info from the CI environment,
+then from the git checkout in the working directory. GitHub Actions, GitLab CI,
+CircleCI, Buildkite, Travis CI and Jenkins are recognised.
+*/
+func inferBuildInfo(info buildInfo) buildInfo {
+	ci := fromCIEnv()
+
+	if info.Branch == "" {
+		info.Branch = ci.Branch
+	}
+	if info.CommitSHA == "" {
+		info.CommitSHA = ci.CommitSHA
+	}
+	if info.CommitMessage == "" {
+		info.CommitMessage = ci.CommitMessage
+	}
+	if info.PullRequestNumber == 0 {
+		info.PullRequestNumber = ci.PullRequestNumber
+	}
+	if info.TargetBranch == "" {
+		info.TargetBranch = ci.TargetBranch
+	}
+	if info.BaseCommitSHA == "" {
+		info.BaseCommitSHA = ci.BaseCommitSHA
+	}
+
+	// CI checkouts are often a detached HEAD, so git's branch is the last resort
+	if info.Branch == "" {
+		if branch := git("rev-parse", "--abbrev-ref", "HEAD"); branch != "HEAD" {
+			info.Branch = branch
+		}
+	}
+	if info.CommitSHA == "" {
+		info.CommitSHA = git("rev-parse", "HEAD")
+	}
+	if info.CommitMessage == "" && info.CommitSHA != "" {
+		info.CommitMessage = git("log", "-1", "--pretty=%B", info.CommitSHA)
+	}
+	if info.BaseCommitSHA == "" && info.TargetBranch != "" {
+		// CI usually only has the remote tracking branch for the target
+		info.BaseCommitSHA = git("merge-base", "HEAD", "origin/"+info.TargetBranch)
+		if info.BaseCommitSHA == "" {
+			info.BaseCommitSHA = git("merge-base", "HEAD", info.TargetBranch)
+		}
+	}
+
+	return info
+}
+
+func fromCIEnv() buildInfo {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		info := buildInfo{
+			Branch:       firstEnv("GITHUB_HEAD_REF", "GITHUB_REF_NAME"),
+			CommitSHA:    os.Getenv("GITHUB_SHA"),
+			TargetBranch: os.Getenv("GITHUB_BASE_REF"),
+		}
+		// Pull request refs look like refs/pull/123/merge
+		if ref := os.Getenv("GITHUB_REF"); strings.HasPrefix(ref, "refs/pull/") {
+			number, _, _ := strings.Cut(strings.TrimPrefix(ref, "refs/pull/"), "/")
+			info.PullRequestNumber = atoi(number)
+		}
+		// On pull requests GITHUB_SHA is a merge commit the pull request never
+		// shows, the event has the commit that was pushed and the one it's
+		// merged into
+		if event, ok := githubPullRequestEvent(os.Getenv("GITHUB_EVENT_PATH")); ok {
+			info.CommitSHA = event.PullRequest.Head.SHA
+			info.BaseCommitSHA = event.PullRequest.Base.SHA
+			info.PullRequestNumber = event.PullRequest.Number
+		}
+		return info
+	case os.Getenv("GITLAB_CI") == "true":
+		return buildInfo{
+			Branch:            firstEnv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "CI_COMMIT_REF_NAME"),
+			CommitSHA:         os.Getenv("CI_COMMIT_SHA"),
+			CommitMessage:     os.Getenv("CI_COMMIT_MESSAGE"),
+			PullRequestNumber: atoi(os.Getenv("CI_MERGE_REQUEST_IID")),
+			BaseCommitSHA:     os.Getenv("CI_MERGE_REQUEST_DIFF_BASE_SHA"),
+			TargetBranch:      os.Getenv("CI_MERGE_REQUEST_TARGET_BRANCH_NAME"),
+		}
+	case os.Getenv("CIRCLECI") == "true":
+		// CIRCLE_PULL_REQUEST is the pull request URL
+		pullRequest := os.Getenv("CIRCLE_PULL_REQUEST")
+		return buildInfo{
+			Branch:            os.Getenv("CIRCLE_BRANCH"),
+			CommitSHA:         os.Getenv("CIRCLE_SHA1"),
+			PullRequestNumber: atoi(pullRequest[strings.LastIndex(pullRequest, "/")+1:]),
+		}
+	case os.Getenv("BUILDKITE") == "true":
+		return buildInfo{
+			Branch:            os.Getenv("BUILDKITE_BRANCH"),
+			CommitSHA:         os.Getenv("BUILDKITE_COMMIT"),
+			CommitMessage:     os.Getenv("BUILDKITE_MESSAGE"),
+			PullRequestNumber: atoi(os.Getenv("BUILDKITE_PULL_REQUEST")),
+			TargetBranch:      os.Getenv("BUILDKITE_PULL_REQUEST_BASE_BRANCH"),
+		}
+	case os.Getenv("TRAVIS") == "true":
+		info := buildInfo{
+			Branch:            firstEnv("TRAVIS_PULL_REQUEST_BRANCH", "TRAVIS_BRANCH"),
+			CommitSHA:         os.Getenv("TRAVIS_COMMIT"),
+			CommitMessage:     os.Getenv("TRAVIS_COMMIT_MESSAGE"),
+			PullRequestNumber: atoi(os.Getenv("TRAVIS_PULL_REQUEST")),
+		}
+		// On pull requests TRAVIS_BRANCH is the branch being merged into
+		if info.PullRequestNumber != 0 {
+			info.TargetBranch = os.Getenv("TRAVIS_BRANCH")
+		}
+		return info
+	case os.Getenv("JENKINS_URL") != "":
+		return buildInfo{
+			Branch:            strings.TrimPrefix(firstEnv("CHANGE_BRANCH", "BRANCH_NAME", "GIT_BRANCH"), "origin/"),
+			CommitSHA:         os.Getenv("GIT_COMMIT"),
+			PullRequestNumber: atoi(os.Getenv("CHANGE_ID")),
+			TargetBranch:      os.Getenv("CHANGE_TARGET"),
+		}
+	default:
+		return buildInfo{}
+	}
+}
+
+// githubEvent is the part of a GitHub Actions event payload the uploader reads
+type githubEvent struct {
+	PullRequest *struct {
+		Number int `json:"number"`
+		Head   struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			SHA string `json:"sha"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+// githubPullRequestEvent reads the event payload at path, reporting whether
+// it is for a pull request
+func githubPullRequestEvent(path string) (githubEvent, bool) {
+	var event githubEvent
+	if path == "" {
+		return event, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &event) != nil {
+		return event, false
+	}
+	return event, event.PullRequest != nil && event.PullRequest.Head.SHA != ""
+}
+
+// firstEnv returns the first of the environment variables that is set
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// atoi parses a number, returning 0 for anything else (like Travis' "false")
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// git runs a git command and returns its trimmed output, or "" if it fails
+func git(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromCIEnvGitHubPullRequest(t *testing.T) {
+	event := filepath.Join(t.TempDir(), "event.json")
+	payload := `{"action": "synchronize", "pull_request": {"number": 42,
+		"head": {"sha": "1111111111111111111111111111111111111111", "ref": "feature"},
+		"base": {"sha": "2222222222222222222222222222222222222222", "ref": "main"}}}`
+	if err := os.WriteFile(event, []byte(payload), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_HEAD_REF", "feature")
+	t.Setenv("GITHUB_BASE_REF", "main")
+	t.Setenv("GITHUB_REF", "refs/pull/42/merge")
+	t.Setenv("GITHUB_SHA", "3333333333333333333333333333333333333333")
+	t.Setenv("GITHUB_EVENT_PATH", event)
+
+	want := buildInfo{
+		Branch:            "feature",
+		CommitSHA:         "1111111111111111111111111111111111111111",
+		PullRequestNumber: 42,
+		BaseCommitSHA:     "2222222222222222222222222222222222222222",
+		TargetBranch:      "main",
+	}
+	if got := fromCIEnv(); got != want {
+		t.Errorf("fromCIEnv() = %+v, want %+v", got, want)
+	}
+
+	// Push events have no pull request and GITHUB_SHA is the pushed commit
+	if err := os.WriteFile(event, []byte(`{"ref": "refs/heads/main", "after": "3333"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITHUB_HEAD_REF", "")
+	t.Setenv("GITHUB_BASE_REF", "")
+	t.Setenv("GITHUB_REF", "refs/heads/main")
+	t.Setenv("GITHUB_REF_NAME", "main")
+
+	want = buildInfo{Branch: "main", CommitSHA: "3333333333333333333333333333333333333333"}
+	if got := fromCIEnv(); got != want {
+		t.Errorf("fromCIEnv() on push = %+v, want %+v", got, want)
+	}
+}
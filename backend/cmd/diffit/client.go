@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+)
+
+// client talks to the diffit API, retrying requests that fail for reasons
+// that might go away: network errors, 5xx responses and rate limiting
+type client struct {
+	server  string
+	token   string
+	retries int
+	http    *http.Client
+}
+
+func newClient(server, token string, retries int) *client {
+	return &client{
+		server:  strings.TrimRight(server, "/"),
+		token:   token,
+		retries: retries,
+		http:    &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// apiError is a non-2xx response from the server
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("server responded %d: %s", e.Status, e.Message)
+}
+
+// retryable reports whether a failed request is worth trying again
+func retryable(err error) bool {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= 500 || apiErr.Status == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+/*
+do sends a request built by newBody, decoding a JSON response into out when it
+isn't nil. newBody is called again for every attempt since a request body
+can only be read once; it may be nil for requests without one.
+*/
+func (c *client) do(ctx context.Context, method, path string, newBody func() (io.Reader, string, error), out any) error {
+	return c.doIdempotent(ctx, method, path, "", newBody, out)
+}
+
+/*
+doIdempotent is do for requests that create something. Every attempt carries
+the same Idempotency-Key, so when a response is lost after the server acted
+on the request the retry gets back what the first attempt created rather
+than making another.
+*/
+func (c *client) doIdempotent(ctx context.Context, method, path, key string, newBody func() (io.Reader, string, error), out any) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.send(ctx, method, path, key, newBody, out)
+		if err == nil || !retryable(err) || attempt >= c.retries {
+			return err
+		}
+
+		// 0.5s, 1s, 2s, ... capped at 30s
+		backoff := min(500*time.Millisecond<<attempt, 30*time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (c *client) send(ctx context.Context, method, path, key string, newBody func() (io.Reader, string, error), out any) error {
+	var body io.Reader
+	contentType := ""
+	if newBody != nil {
+		var err error
+		body, contentType, err = newBody()
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &body) != nil || body.Error == "" {
+			body.Error = strings.TrimSpace(string(data))
+		}
+		return &apiError{Status: resp.StatusCode, Message: body.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// jsonBody encodes v as a request body
+func jsonBody(v any) func() (io.Reader, string, error) {
+	return func() (io.Reader, string, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(data), "application/json", nil
+	}
+}
+
+// resolveProject accepts a project ID or slug and returns the project ID
+func (c *client) resolveProject(ctx context.Context, project string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(project); err == nil {
+		return id, nil
+	}
+
+	var p models.Project
+	if err := c.do(ctx, http.MethodGet, "/api/projects/slug/"+url.PathEscape(project), nil, &p); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to find project %q: %w", project, err)
+	}
+	return p.ID, nil
+}
+
+func (c *client) createBuild(ctx context.Context, req models.CreateBuildRequest) (*models.Build, error) {
+	var build models.Build
+	if err := c.doIdempotent(ctx, http.MethodPost, "/api/builds", uuid.NewString(), jsonBody(req), &build); err != nil {
+		return nil, fmt.Errorf("failed to create build: %w", err)
+	}
+	return &build, nil
+}
+
+// snapshotUpload is one screenshot to upload
+type snapshotUpload struct {
+	Name   string
+	Path   string
+	Width  int
+	Height int
+}
+
+func (c *client) uploadSnapshot(ctx context.Context, buildID uuid.UUID, upload snapshotUpload, browser, viewport string) (*models.Snapshot, error) {
+	newBody := func() (io.Reader, string, error) {
+		file, err := os.Open(upload.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+
+		var buf bytes.Buffer
+		form := multipart.NewWriter(&buf)
+		fields := map[string]string{
+			"build_id": buildID.String(),
+			"name":     upload.Name,
+			"width":    strconv.Itoa(upload.Width),
+			"height":   strconv.Itoa(upload.Height),
+			"browser":  browser,
+			"viewport": viewport,
+		}
+		for key, value := range fields {
+			if value == "" {
+				continue
+			}
+			if err := form.WriteField(key, value); err != nil {
+				return nil, "", err
+			}
+		}
+
+		part, err := form.CreateFormFile("image", filepath.Base(upload.Path))
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := io.Copy(part, file); err != nil {
+			return nil, "", err
+		}
+		if err := form.Close(); err != nil {
+			return nil, "", err
+		}
+
+		return &buf, form.FormDataContentType(), nil
+	}
+
+	var snapshot models.Snapshot
+	if err := c.doIdempotent(ctx, http.MethodPost, "/api/snapshots", uuid.NewString(), newBody, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", upload.Name, err)
+	}
+	return &snapshot, nil
+}
+
+func (c *client) finalizeBuild(ctx context.Context, buildID uuid.UUID) (*models.Build, error) {
+	var build models.Build
+	if err := c.do(ctx, http.MethodPost, "/api/builds/"+buildID.String()+"/finalize", nil, &build); err != nil {
+		return nil, fmt.Errorf("failed to finalize build: %w", err)
+	}
+	return &build, nil
+}
+
+func (c *client) getBuild(ctx context.Context, buildID uuid.UUID) (*models.Build, error) {
+	var build models.Build
+	if err := c.do(ctx, http.MethodGet, "/api/builds/"+buildID.String(), nil, &build); err != nil {
+		return nil, fmt.Errorf("failed to get build: %w", err)
+	}
+	return &build, nil
+}
+
+func (c *client) changedSnapshots(ctx context.Context, buildID uuid.UUID) ([]models.Snapshot, error) {
+	var snapshots []models.Snapshot
+	if err := c.do(ctx, http.MethodGet, "/api/builds/"+buildID.String()+"/snapshots/changed", nil, &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to get changed snapshots: %w", err)
+	}
+	return snapshots, nil
+}
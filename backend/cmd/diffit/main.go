@@ -0,0 +1,152 @@
+/*
+Command diffit uploads a directory of screenshots to a diffit server as a new
+build, waits for the diffs and exits non-zero if anything needs reviewing, so
+it can gate a CI pipeline:
+
+	diffit upload --project my-app ./screenshots
+
+Branch, commit and pull request are read from the CI environment or git when
+not given as flags.
+*/
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const usage = `usage: diffit upload [flags] <directory>
+
+Exit status is 0 when there is nothing to review, 1 when the build has
+unreviewed changes and 2 when something went wrong.
+
+flags:`
+
+// Exit statuses
+const (
+	exitOK         = 0
+	exitUnreviewed = 1
+	exitError      = 2
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "upload" {
+		fmt.Fprintln(os.Stderr, usage)
+		newUploadFlags(&uploadFlags{}).PrintDefaults()
+		os.Exit(exitError)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := upload(ctx, os.Args[2:])
+	switch {
+	case err == nil:
+		os.Exit(exitOK)
+	case errors.Is(err, errUnreviewed):
+		os.Exit(exitUnreviewed)
+	default:
+		fmt.Fprintf(os.Stderr, "diffit: %v\n", err)
+		os.Exit(exitError)
+	}
+}
+
+type uploadFlags struct {
+	server, token, project  string
+	branch, commit, message string
+	pr                      int
+	baseBuild, baseCommit   string
+	targetBranch            string
+	browser, viewport       string
+	concurrency, retries    int
+	noWait                  bool
+	waitTimeout             time.Duration
+}
+
+func newUploadFlags(f *uploadFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&f.server, "server", cmp.Or(os.Getenv("DIFFIT_SERVER"), "http://localhost:8080"), "diffit server URL (DIFFIT_SERVER)")
+	fs.StringVar(&f.token, "token", os.Getenv("DIFFIT_TOKEN"), "project API token (DIFFIT_TOKEN)")
+	fs.StringVar(&f.project, "project", os.Getenv("DIFFIT_PROJECT"), "project ID or slug (DIFFIT_PROJECT)")
+	fs.StringVar(&f.branch, "branch", "", "branch name, detected from CI or git by default")
+	fs.StringVar(&f.commit, "commit", "", "commit SHA, detected from CI or git by default")
+	fs.StringVar(&f.message, "commit-message", "", "commit message, detected from CI or git by default")
+	fs.IntVar(&f.pr, "pr", 0, "pull request number, detected from CI by default")
+	fs.StringVar(&f.baseBuild, "base-build", "", "ID of a build to compare against instead of the branch baselines")
+	fs.StringVar(&f.baseCommit, "base-commit", "", "merge base commit to compare against, detected from CI or git by default")
+	fs.StringVar(&f.targetBranch, "target-branch", "", "branch a pull request merges into, used to find the merge base")
+	fs.StringVar(&f.browser, "browser", "", "browser the screenshots were taken in")
+	fs.StringVar(&f.viewport, "viewport", "", "viewport the screenshots were taken at")
+	fs.IntVar(&f.concurrency, "concurrency", 4, "number of parallel uploads")
+	fs.IntVar(&f.retries, "retries", 3, "times to retry a failed request")
+	fs.BoolVar(&f.noWait, "no-wait", false, "exit after finalizing without waiting for diffs")
+	fs.DurationVar(&f.waitTimeout, "wait-timeout", 10*time.Minute, "how long to wait for diffs")
+
+	return fs
+}
+
+func upload(ctx context.Context, args []string) error {
+	var f uploadFlags
+	fs := newUploadFlags(&f)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one screenshot directory")
+	}
+	if f.project == "" {
+		return errors.New("--project is required")
+	}
+	if f.token == "" {
+		return errors.New("--token is required")
+	}
+
+	var baseBuildID *uuid.UUID
+	if f.baseBuild != "" {
+		id, err := uuid.Parse(f.baseBuild)
+		if err != nil {
+			return fmt.Errorf("invalid --base-build %q", f.baseBuild)
+		}
+		baseBuildID = &id
+	}
+
+	c := newClient(f.server, f.token, max(f.retries, 0))
+
+	return runUpload(ctx, c, uploadOptions{
+		Dir:     fs.Arg(0),
+		Project: f.project,
+		Build: inferBuildInfo(buildInfo{
+			Branch:            f.branch,
+			CommitSHA:         f.commit,
+			CommitMessage:     f.message,
+			PullRequestNumber: f.pr,
+			BaseCommitSHA:     f.baseCommit,
+			TargetBranch:      f.targetBranch,
+		}),
+		BaseBuildID: baseBuildID,
+		Browser:     f.browser,
+		Viewport:    f.viewport,
+		Concurrency: f.concurrency,
+		Wait:        !f.noWait,
+		WaitTimeout: f.waitTimeout,
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+)
+
+// pollInterval is how often the build is checked while waiting for diffs
+const pollInterval = 2 * time.Second
+
+// errUnreviewed means the upload worked but there are changes to review
+var errUnreviewed = errors.New("build has unreviewed changes")
+
+type uploadOptions struct {
+	Dir         string
+	Project     string
+	Build       buildInfo
+	BaseBuildID *uuid.UUID
+	Browser     string
+	Viewport    string
+	Concurrency int
+	Wait        bool
+	WaitTimeout time.Duration
+}
+
+// runUpload creates a build from a directory of screenshots and reports on it
+func runUpload(ctx context.Context, c *client, opts uploadOptions) error {
+	uploads, err := findScreenshots(opts.Dir)
+	if err != nil {
+		return err
+	}
+	if len(uploads) == 0 {
+		return fmt.Errorf("no screenshots found in %s", opts.Dir)
+	}
+
+	projectID, err := c.resolveProject(ctx, opts.Project)
+	if err != nil {
+		return err
+	}
+
+	if opts.Build.Branch == "" {
+		return errors.New("couldn't work out the branch, pass --branch")
+	}
+
+	req := models.CreateBuildRequest{
+		ProjectID:   projectID,
+		Branch:      opts.Build.Branch,
+		BaseBuildID: opts.BaseBuildID,
+	}
+	if opts.Build.CommitSHA != "" {
+		req.CommitSHA = &opts.Build.CommitSHA
+	}
+	if opts.Build.CommitMessage != "" {
+		req.CommitMessage = &opts.Build.CommitMessage
+	}
+	if opts.Build.PullRequestNumber != 0 {
+		req.PullRequestNumber = &opts.Build.PullRequestNumber
+	}
+	if opts.Build.BaseCommitSHA != "" {
+		req.BaseCommitSHA = &opts.Build.BaseCommitSHA
+	}
+
+	build, err := c.createBuild(ctx, req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created build #%d on %s\n", build.BuildNumber, build.Branch)
+	if build.BaseBuildID != nil {
+		fmt.Printf("Comparing against build %s\n", build.BaseBuildID)
+	}
+
+	if err := uploadAll(ctx, c, build.ID, uploads, opts); err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded %d snapshots\n", len(uploads))
+
+	build, err = c.finalizeBuild(ctx, build.ID)
+	if err != nil {
+		return err
+	}
+
+	if !opts.Wait {
+		fmt.Println("Build finalized, not waiting for diffs")
+		return nil
+	}
+
+	build, err = waitForBuild(ctx, c, build, opts.WaitTimeout)
+	if err != nil {
+		return err
+	}
+
+	return summarize(ctx, c, build)
+}
+
+/*
+findScreenshots walks dir for PNG and JPEG files. Each snapshot is named by its
+path relative to dir without the extension, so shots/home/mobile.png becomes
+"home/mobile" whatever the platform's path separator.
+*/
+func findScreenshots(dir string) ([]snapshotUpload, error) {
+	var uploads []snapshotUpload
+	seen := map[string]string{}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".png", ".jpg", ".jpeg":
+		default:
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		name := strings.TrimSuffix(rel, path.Ext(rel))
+
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("%s and %s would both be uploaded as %q", other, p, name)
+		}
+		seen[name] = p
+
+		width, height, err := imageSize(p)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+
+		uploads = append(uploads, snapshotUpload{Name: name, Path: p, Width: width, Height: height})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Name < uploads[j].Name })
+	return uploads, nil
+}
+
+func imageSize(p string) (int, int, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// uploadAll uploads snapshots on opts.Concurrency workers, stopping at the first failure
+func uploadAll(ctx context.Context, c *client, buildID uuid.UUID, uploads []snapshotUpload, opts uploadOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan snapshotUpload)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for i := 0; i < max(opts.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for upload := range jobs {
+				if _, err := c.uploadSnapshot(ctx, buildID, upload, opts.Browser, opts.Viewport); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, upload := range uploads {
+		select {
+		case jobs <- upload:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// waitForBuild polls until the build has finished processing
+func waitForBuild(ctx context.Context, c *client, build *models.Build, timeout time.Duration) (*models.Build, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for build.Status != models.BuildStatusCompleted && build.Status != models.BuildStatusFailed {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("build #%d still %s after %s", build.BuildNumber, build.Status, timeout)
+		case <-time.After(pollInterval):
+		}
+
+		var err error
+		build, err = c.getBuild(ctx, build.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return build, nil
+}
+
+// summarize prints the outcome of a finished build
+func summarize(ctx context.Context, c *client, build *models.Build) error {
+	if build.Status == models.BuildStatusFailed {
+		return fmt.Errorf("build #%d failed", build.BuildNumber)
+	}
+
+	changed, err := c.changedSnapshots(ctx, build.ID)
+	if err != nil {
+		return err
+	}
+
+	var unreviewed []models.Snapshot
+	for _, snapshot := range changed {
+		if snapshot.ReviewStatus == models.ReviewStatusUnreviewed {
+			unreviewed = append(unreviewed, snapshot)
+		}
+	}
+
+	fmt.Printf("\nBuild #%d: %d snapshots, %d changed, %d new, %d removed, %d unchanged, %d approved\n",
+		build.BuildNumber, build.TotalSnapshots, build.ChangedSnapshots, build.NewSnapshots,
+		build.RemovedSnapshots, build.UnchangedSnapshots, build.ApprovedSnapshots)
+
+	fmt.Printf("Review state: %s\n", strings.ReplaceAll(string(build.ReviewState), "_", " "))
+
+	if build.FailedSnapshots > 0 {
+		return fmt.Errorf("%d snapshots of build #%d couldn't be compared", build.FailedSnapshots, build.BuildNumber)
+	}
+
+	if len(unreviewed) == 0 {
+		fmt.Println("No unreviewed changes")
+		return nil
+	}
+
+	fmt.Printf("%d snapshots need review:\n", len(unreviewed))
+	for _, snapshot := range unreviewed {
+		switch {
+		case snapshot.ChangeType != nil && *snapshot.ChangeType != models.ChangeTypeChanged:
+			fmt.Printf("  %s (%s)\n", snapshot.Name, *snapshot.ChangeType)
+		case snapshot.DiffPercentage != nil:
+			fmt.Printf("  %s (%.2f%% different)\n", snapshot.Name, *snapshot.DiffPercentage)
+		default:
+			fmt.Printf("  %s\n", snapshot.Name)
+		}
+	}
+
+	return errUnreviewed
+}
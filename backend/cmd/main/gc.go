@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/crzytrane/diffit/internal/config"
+	"github.com/crzytrane/diffit/internal/database"
+	"github.com/crzytrane/diffit/internal/imagestore"
+	"github.com/crzytrane/diffit/internal/janitor"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/google/uuid"
+)
+
+const gcUsage = `usage: diffit gc [flags]
+
+Deletes builds that have expired under their project's retention policy,
+files in storage nothing points at and unreferenced images.
+
+flags:
+  -dry-run          list what would be deleted without deleting it
+  -project ref      only collect the project with this ID or slug
+  -v                list every build and file, not just the totals`
+
+// runGC implements the gc subcommand
+func runGC(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dryRun := flags.Bool("dry-run", false, "")
+	projectRef := flags.String("project", "", "")
+	verbose := flags.Bool("v", false, "")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%v\n%s", err, gcUsage)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("too many arguments\n%s", gcUsage)
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	store, err := newStorage(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	gc := janitor.New(db.Pool, store, cfg.RetentionFileGrace)
+
+	if *projectRef != "" {
+		projectID, err := projectIDByRef(ctx, repository.NewProjectRepository(db.Pool), *projectRef)
+		if err != nil {
+			return err
+		}
+
+		var reports []models.RetentionReport
+		report, err := gc.Collect(ctx, projectID, *dryRun)
+		if report != nil {
+			reports = append(reports, *report)
+		}
+		printReports(reports, *dryRun, *verbose)
+		if err != nil {
+			return err
+		}
+	} else {
+		reports, err := gc.CollectAll(ctx, *dryRun)
+		printReports(reports, *dryRun, *verbose)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Images are shared across a project's builds, so they're only swept
+	// when the deletions above have really happened
+	if !*dryRun {
+		deleted, err := imagestore.New(db.Pool, store).Sweep(ctx, cfg.ImageSweepGrace)
+		fmt.Printf("removed %d unreferenced images\n", deleted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// projectIDByRef looks a project up by ID or slug
+func projectIDByRef(ctx context.Context, projects *repository.ProjectRepository, ref string) (uuid.UUID, error) {
+	if projectID, err := uuid.Parse(ref); err == nil {
+		return projectID, nil
+	}
+
+	project, err := projects.GetBySlug(ctx, ref)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("project %q not found", ref)
+	}
+	return project.ID, nil
+}
+
+// printReports prints a line per project with totals, and with verbose every
+// build and file under it
+func printReports(reports []models.RetentionReport, dryRun, verbose bool) {
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT\tBUILDS\tFILES\tBYTES")
+	for _, report := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", report.ProjectID, len(report.ExpiredBuilds), len(report.OrphanedFiles), report.OrphanedBytes)
+		if !verbose {
+			continue
+		}
+		for _, build := range report.ExpiredBuilds {
+			fmt.Fprintf(tw, "  build #%d\t%s\t%s\t%s\n", build.BuildNumber, build.Branch, build.Reason, build.CreatedAt.Local().Format("2006-01-02"))
+		}
+		for _, file := range report.OrphanedFiles {
+			fmt.Fprintf(tw, "  %s\t\t\t%d\n", file.Path, file.SizeBytes)
+		}
+	}
+	tw.Flush()
+
+	builds, files, bytes := 0, 0, int64(0)
+	for _, report := range reports {
+		builds += len(report.ExpiredBuilds)
+		files += len(report.OrphanedFiles)
+		bytes += report.OrphanedBytes
+	}
+	fmt.Printf("%s %d expired builds and %d orphaned files (%d bytes)\n", verb, builds, files, bytes)
+}
@@ -15,18 +15,30 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/crzytrane/diffit/internal/archive"
+	"github.com/crzytrane/diffit/internal/auth"
 	"github.com/crzytrane/diffit/internal/config"
 	"github.com/crzytrane/diffit/internal/database"
 	"github.com/crzytrane/diffit/internal/diffimage"
+	"github.com/crzytrane/diffit/internal/diffqueue"
+	"github.com/crzytrane/diffit/internal/diffsettings"
+	"github.com/crzytrane/diffit/internal/events"
 	"github.com/crzytrane/diffit/internal/handlers"
+	"github.com/crzytrane/diffit/internal/imagestore"
+	"github.com/crzytrane/diffit/internal/janitor"
+	"github.com/crzytrane/diffit/internal/live"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/notify"
 	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/crzytrane/diffit/internal/webhooks"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/n7olkachev/imgdiff/pkg/imgdiff"
 )
 
 func corsMiddleware(allowOrigins []string) func(http.Handler) http.Handler {
@@ -69,6 +81,58 @@ func corsMiddleware(allowOrigins []string) func(http.Handler) http.Handler {
 	}
 }
 
+/*
+legacyDiffOptions returns the diff options for the legacy endpoints. When the
+request names a project (by ID or slug) in the "project" form value its diff
+settings and rules apply, otherwise the defaults are used.
+*/
+func legacyDiffOptions(r *http.Request, resolver *diffsettings.Resolver) (func(name string) diffimage.DiffOptions, error) {
+	ref := r.FormValue("project")
+	if ref == "" {
+		return func(string) diffimage.DiffOptions { return diffimage.DefaultDiffOptions() }, nil
+	}
+
+	if resolver == nil {
+		return nil, fmt.Errorf("project settings need a database connection")
+	}
+
+	settings, err := resolver.LoadByRef(r.Context(), ref)
+	if err != nil {
+		return nil, err
+	}
+	return settings.Options, nil
+}
+
+// newStorage creates the storage backend selected in the config
+func newStorage(cfg *config.Config) (storage.Storage, error) {
+	switch cfg.StorageBackend {
+	case "filesystem", "":
+		store, err := storage.NewFileSystem(cfg.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Storage initialized at %s", cfg.StoragePath)
+		return store, nil
+	case "s3":
+		store, err := storage.NewS3(storage.S3Options{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			Prefix:          cfg.S3Prefix,
+			UsePathStyle:    cfg.S3UsePathStyle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Storage initialized in bucket %s at %s", cfg.S3Bucket, cfg.S3Endpoint)
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
 type healthResponse struct {
 	Status   string `json:"status"`
 	Database string `json:"database,omitempty"`
@@ -77,6 +141,20 @@ type healthResponse struct {
 func main() {
 	cfg := config.Load()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := runGC(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	envOrDefaultPort := cmp.Or(os.Getenv("PORT"), cfg.Port)
 	envOrDefaultPortInt, err := strconv.Atoi(envOrDefaultPort)
 	if err != nil {
@@ -85,6 +163,9 @@ func main() {
 	port := flag.Int("port", envOrDefaultPortInt, "port to run on")
 	flag.Parse()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize database
 	db, err := database.New(cfg.DatabaseURL)
 	if err != nil {
@@ -93,24 +174,75 @@ func main() {
 	} else {
 		defer db.Close()
 
-		// Run migrations
-		if err := db.Migrate(context.Background()); err != nil {
-			log.Fatalf("Failed to run migrations: %v", err)
+		// Run migrations, unless they're applied separately with `diffit migrate`
+		if cfg.AutoMigrate {
+			if err := db.Migrate(context.Background()); err != nil {
+				log.Fatalf("Failed to run migrations: %v", err)
+			}
+			log.Println("Database connected and migrations complete")
+		} else {
+			log.Println("Database connected")
 		}
-		log.Println("Database connected and migrations complete")
 	}
 
 	// Initialize storage
-	store, err := storage.New(cfg.StoragePath)
+	store, err := newStorage(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
-	log.Printf("Storage initialized at %s", cfg.StoragePath)
 
-	// Initialize handlers
+	// Initialize handlers and the diff worker pool
 	var h *handlers.Handlers
+	var authn *auth.Middleware
+	var diffSettings *diffsettings.Resolver
 	if db != nil {
-		h = handlers.New(db.Pool, store)
+		diffSettings = diffsettings.NewResolver(db.Pool)
+
+		// Commit statuses are posted in the background, let them finish after the queue stops
+		notifier := notify.New(db.Pool, cfg.PublicURL)
+		defer notifier.Wait()
+
+		// Events are recorded as webhook deliveries when published and sent by
+		// their own workers
+		bus := events.NewBus()
+		dispatcher := webhooks.New(db.Pool, webhooks.Options{
+			Workers:              cfg.WebhookWorkers,
+			MaxAttempts:          cfg.WebhookMaxAttempts,
+			Timeout:              cfg.WebhookTimeout,
+			AllowPrivateNetworks: cfg.WebhookAllowPrivateNetworks,
+		})
+		bus.Subscribe(dispatcher.Handle)
+		dispatcher.Start(ctx)
+		defer dispatcher.Wait()
+
+		// Build progress is streamed to browsers connected to any replica
+		hub := live.New(db.Pool)
+		bus.Subscribe(hub.Publish)
+		go hub.Listen(ctx)
+
+		queue := diffqueue.New(db.Pool, store, notifier, bus, diffqueue.Options{
+			Workers:     cfg.DiffWorkers,
+			MaxAttempts: cfg.DiffMaxAttempts,
+		})
+		queue.Start(ctx)
+		defer queue.Wait()
+		log.Printf("Diff queue started with %d workers", cfg.DiffWorkers)
+
+		images := imagestore.New(db.Pool, store)
+		go images.RunSweeper(ctx, cfg.ImageSweepInterval, cfg.ImageSweepGrace)
+
+		// Expired builds and files nothing uses are removed in the background,
+		// or on demand with `diffit gc`
+		gc := janitor.New(db.Pool, store, cfg.RetentionFileGrace)
+		go gc.Run(ctx, cfg.RetentionInterval)
+
+		authn = auth.New(db.Pool, auth.Options{
+			SessionTTL:    cfg.SessionTTL,
+			SecureCookies: cfg.SecureCookies,
+			AdminToken:    cfg.AdminToken,
+		})
+
+		h = handlers.New(db.Pool, store, images, queue, notifier, bus, dispatcher, hub, gc, authn)
 	}
 
 	r := chi.NewRouter()
@@ -144,15 +276,28 @@ func main() {
 	// API routes (only if database is connected)
 	if h != nil {
 		r.Route("/api", func(r chi.Router) {
+			r.Use(authn.Session)
+
+			requireProjectAdmin := authn.RequireRole(models.RoleAdmin, auth.ProjectFromURLParam("projectID"))
+
+			// Accounts and sessions
+			r.Route("/auth", func(r chi.Router) {
+				r.Post("/register", h.Auth.Register)
+				r.Post("/login", h.Auth.Login)
+				r.Post("/logout", h.Auth.Logout)
+				r.Get("/me", h.Auth.Me)
+			})
+			r.With(authn.RequireSiteAdmin).Get("/users", h.Auth.ListUsers)
+
 			// Projects
 			r.Route("/projects", func(r chi.Router) {
 				r.Get("/", h.Projects.List)
-				r.Post("/", h.Projects.Create)
+				r.With(authn.RequireUser).Post("/", h.Projects.Create)
 				r.Get("/slug/{slug}", h.Projects.GetBySlug)
 				r.Route("/{projectID}", func(r chi.Router) {
 					r.Get("/", h.Projects.Get)
-					r.Put("/", h.Projects.Update)
-					r.Delete("/", h.Projects.Delete)
+					r.With(requireProjectAdmin).Put("/", h.Projects.Update)
+					r.With(requireProjectAdmin).Delete("/", h.Projects.Delete)
 
 					// Nested builds
 					r.Get("/builds", h.Builds.ListByProject)
@@ -160,17 +305,116 @@ func main() {
 
 					// Nested baselines
 					r.Get("/baselines", h.Baselines.ListByProject)
+
+					// Diff setting overrides by snapshot name
+					r.Route("/diff-rules", func(r chi.Router) {
+						r.Get("/", h.DiffRules.List)
+						r.With(requireProjectAdmin).Post("/", h.DiffRules.Create)
+						r.With(requireProjectAdmin).Put("/{ruleID}", h.DiffRules.Update)
+						r.With(requireProjectAdmin).Delete("/{ruleID}", h.DiffRules.Delete)
+					})
+
+					// Which branches a branch inherits baselines from
+					r.Route("/branch-rules", func(r chi.Router) {
+						r.Get("/", h.Branches.ListRules)
+						r.With(requireProjectAdmin).Post("/", h.Branches.CreateRule)
+						r.With(requireProjectAdmin).Put("/{ruleID}", h.Branches.UpdateRule)
+						r.With(requireProjectAdmin).Delete("/{ruleID}", h.Branches.DeleteRule)
+					})
+
+					// Copying a merged branch's baselines onto its target
+					r.With(authn.RequireRole(models.RoleReviewer, auth.ProjectFromURLParam("projectID"))).Post("/branches/promote", h.Branches.Promote)
+					r.Route("/merge-hook", func(r chi.Router) {
+						r.Use(requireProjectAdmin)
+						r.Get("/", h.Branches.GetMergeHook)
+						r.Post("/", h.Branches.SaveMergeHook)
+						r.Delete("/", h.Branches.DeleteMergeHook)
+					})
+
+					// Regions masked out of diffs
+					r.Route("/ignore-regions", func(r chi.Router) {
+						requireReviewer := authn.RequireRole(models.RoleReviewer, auth.ProjectFromURLParam("projectID"))
+
+						r.Get("/", h.IgnoreRegions.List)
+						r.With(requireReviewer).Post("/", h.IgnoreRegions.Create)
+						r.Get("/{regionID}", h.IgnoreRegions.Get)
+						r.With(requireReviewer).Put("/{regionID}", h.IgnoreRegions.Update)
+						r.With(requireReviewer).Delete("/{regionID}", h.IgnoreRegions.Delete)
+					})
+
+					// API tokens for CI uploads
+					r.Route("/tokens", func(r chi.Router) {
+						r.Use(authn.RequireTokenAdmin(auth.ProjectFromURLParam("projectID")))
+						r.Get("/", h.APITokens.List)
+						r.Post("/", h.APITokens.Create)
+						r.Delete("/{tokenID}", h.APITokens.Revoke)
+					})
+
+					// Reporting builds back to the git host
+					r.Route("/commit-status", func(r chi.Router) {
+						r.Use(requireProjectAdmin)
+						r.Get("/", h.CommitStatus.Get)
+						r.Put("/", h.CommitStatus.Save)
+						r.Delete("/", h.CommitStatus.Delete)
+					})
+
+					// Sending project events to other services
+					r.Route("/webhooks", func(r chi.Router) {
+						r.Use(requireProjectAdmin)
+						r.Get("/", h.Webhooks.List)
+						r.Post("/", h.Webhooks.Create)
+						r.Route("/{webhookID}", func(r chi.Router) {
+							r.Get("/", h.Webhooks.Get)
+							r.Put("/", h.Webhooks.Update)
+							r.Delete("/", h.Webhooks.Delete)
+							r.Get("/deliveries", h.Webhooks.ListDeliveries)
+							r.Post("/deliveries/{deliveryID}/redeliver", h.Webhooks.Redeliver)
+						})
+					})
+
+					// Deleting old builds and the files they leave behind
+					r.Route("/retention", func(r chi.Router) {
+						r.Use(requireProjectAdmin)
+						r.Get("/", h.Retention.Get)
+						r.Put("/", h.Retention.Save)
+						r.Delete("/", h.Retention.Delete)
+						r.Get("/report", h.Retention.Report)
+					})
+
+					// Who can review and administer the project
+					r.Route("/members", func(r chi.Router) {
+						r.With(authn.RequireRole(models.RoleViewer, auth.ProjectFromURLParam("projectID"))).Get("/", h.Members.List)
+						r.With(requireProjectAdmin).Post("/", h.Members.Save)
+						r.With(requireProjectAdmin).Delete("/{userID}", h.Members.Delete)
+					})
 				})
 			})
 
+			// Merge events from the git host, authenticated by the hook secret
+			r.Post("/hooks/merge/{projectID}", h.Branches.ReceiveMerge)
+
 			// Builds
+			// Uploading builds and snapshots needs an API token for the project
 			r.Route("/builds", func(r chi.Router) {
-				r.Post("/", h.Builds.Create)
+				r.With(authn.RequireProjectToken(h.Builds.ProjectFromBody)).Post("/", h.Builds.Create)
 				r.Route("/{buildID}", func(r chi.Router) {
+					requireBuildToken := authn.RequireProjectToken(h.Builds.ProjectFromURL)
+
 					r.Get("/", h.Builds.Get)
-					r.Delete("/", h.Builds.Delete)
-					r.Patch("/status", h.Builds.UpdateStatus)
-					r.Post("/finalize", h.Builds.Finalize)
+					r.With(authn.RequireRole(models.RoleAdmin, h.Builds.ProjectFromURL)).Delete("/", h.Builds.Delete)
+					r.With(requireBuildToken).Patch("/status", h.Builds.UpdateStatus)
+					r.With(requireBuildToken).Post("/finalize", h.Builds.Finalize)
+					// Every screenshot of a build at once, from a zip or tar.gz
+					r.With(requireBuildToken).Post("/archive", h.Snapshots.UploadArchive)
+
+					// Reviewing every remaining change at once
+					requireReviewer := authn.RequireRole(models.RoleReviewer, h.Builds.ProjectFromURL)
+					r.With(requireReviewer).Post("/approve", h.Builds.Approve)
+					r.With(requireReviewer).Post("/reject", h.Builds.Reject)
+					r.Get("/reviews", h.Builds.ListReviews)
+
+					// Live progress as Server-Sent Events
+					r.Get("/events", h.Events.Stream)
 
 					// Nested snapshots
 					r.Get("/snapshots", h.Snapshots.ListByBuild)
@@ -180,24 +424,33 @@ func main() {
 
 			// Snapshots
 			r.Route("/snapshots", func(r chi.Router) {
-				r.Post("/", h.Snapshots.Create)
-				r.Post("/batch-review", h.Snapshots.BatchReview)
+				r.With(authn.RequireProjectToken(h.Snapshots.ProjectFromForm)).Post("/", h.Snapshots.Create)
+				r.With(authn.RequireRole(models.RoleReviewer, h.Snapshots.ProjectFromBatch)).Post("/batch-review", h.Snapshots.BatchReview)
 				r.Route("/{snapshotID}", func(r chi.Router) {
 					r.Get("/", h.Snapshots.Get)
-					r.Delete("/", h.Snapshots.Delete)
-					r.Post("/review", h.Snapshots.Review)
+					r.With(authn.RequireRole(models.RoleAdmin, h.Snapshots.ProjectFromURL)).Delete("/", h.Snapshots.Delete)
+					r.With(authn.RequireRole(models.RoleReviewer, h.Snapshots.ProjectFromURL)).Post("/review", h.Snapshots.Review)
 					r.Get("/image/{imageType}", h.Snapshots.GetImage)
+
+					// Changed regions with cropped thumbnails
+					r.Get("/regions", h.Regions.List)
+					r.Get("/regions/{regionID}/{imageType}", h.Regions.GetImage)
 				})
 			})
 
 			// Baselines
 			r.Route("/baselines", func(r chi.Router) {
-				r.Post("/", h.Baselines.Create)
-				r.Post("/from-snapshot", h.Baselines.CreateFromSnapshot)
+				r.With(authn.RequireRole(models.RoleReviewer, h.Baselines.ProjectFromForm)).Post("/", h.Baselines.Create)
+				r.With(authn.RequireRole(models.RoleReviewer, h.Baselines.ProjectFromSnapshotBody)).Post("/from-snapshot", h.Baselines.CreateFromSnapshot)
 				r.Route("/{baselineID}", func(r chi.Router) {
 					r.Get("/", h.Baselines.Get)
-					r.Delete("/", h.Baselines.Delete)
+					r.With(authn.RequireRole(models.RoleAdmin, h.Baselines.ProjectFromURL)).Delete("/", h.Baselines.Delete)
 					r.Get("/image", h.Baselines.GetImage)
+
+					// History of the baseline's images
+					r.Get("/versions", h.Baselines.ListVersions)
+					r.Get("/versions/{versionID}/image", h.Baselines.GetVersionImage)
+					r.With(authn.RequireRole(models.RoleReviewer, h.Baselines.ProjectFromURL)).Post("/versions/{versionID}/rollback", h.Baselines.Rollback)
 				})
 			})
 		})
@@ -225,6 +478,12 @@ func main() {
 		}
 		defer otherUpload.Close()
 
+		optionsFor, err := legacyDiffOptions(r, diffSettings)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		dst, err := os.MkdirTemp("", "extracted-")
 		if err != nil {
 			return
@@ -280,11 +539,9 @@ func main() {
 			return
 		}
 
-		threshold := float64(0.1)
-		resultDiff := *imgdiff.Diff(image1, image2, &imgdiff.Options{
-			Threshold: threshold,
-			DiffImage: false,
-		})
+		options := optionsFor("")
+		options.TransparentBackground = true
+		resultDiff := diffimage.Compare(image1, image2, options)
 
 		writer := bufio.NewWriter(diffFile)
 
@@ -312,6 +569,11 @@ func main() {
 	// Legacy /archive endpoint
 	r.Post("/archive", func(w http.ResponseWriter, r *http.Request) {
 		baseFilePath, err := archive.UnpackArchiveFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer os.RemoveAll(baseFilePath)
 
 		baseDir := fmt.Sprintf("%s/base/", baseFilePath)
 		featureDir := fmt.Sprintf("%s/feature/", baseFilePath)
@@ -325,8 +587,9 @@ func main() {
 			DiffDir:    diffDir,
 		}
 
+		optionsFor, err := legacyDiffOptions(r, diffSettings)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -339,7 +602,8 @@ func main() {
 
 		diffs := make([]diffimage.DiffResult, len(files))
 		for index, diff := range files {
-			diffResult, err := diffimage.DiffImage(diff, diffimage.DiffOptions{Threshold: 0.1})
+			name := strings.TrimPrefix(cmp.Or(diff.FeaturePath, diff.BasePath), featureDir)
+			diffResult, err := diffimage.DiffImage(diff, optionsFor(name))
 			fmt.Printf("Diff %d\n\t- %s\n\t- %s\n\t- %s\n", index, diffResult.Input.BasePath, diffResult.Input.FeaturePath, diffResult.Input.DiffPath)
 			diffs[index] = diffResult
 			if err != nil {
@@ -356,8 +620,17 @@ func main() {
 		http.Redirect(w, r, "http://localhost:5173/", http.StatusFound)
 	})
 
+	server := &http.Server{Addr: fmt.Sprintf(":%v", *port), Handler: r}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
 	fmt.Printf("Listening on port %v\n", *port)
-	err = http.ListenAndServe(fmt.Sprintf(":%v", *port), r)
+	err = server.ListenAndServe()
 
-	fmt.Printf("Finished: %v", err)
+	fmt.Printf("Finished: %v\n", err)
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/config"
+	"github.com/crzytrane/diffit/internal/database"
+)
+
+const migrateUsage = `usage: diffit migrate [command]
+
+commands:
+  up [n]     apply the next n pending migrations, all of them by default
+  down [n]   roll back the last n applied migrations, 1 by default
+  status     list migrations and when they were applied`
+
+// runMigrate implements the migrate subcommand
+func runMigrate(cfg *config.Config, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up", "down", "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+
+	steps := 0
+	if command == "down" {
+		steps = 1
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of migrations %q\n%s", args[1], migrateUsage)
+		}
+		steps = n
+	}
+	if len(args) > 2 || (command == "status" && len(args) > 1) {
+		return fmt.Errorf("too many arguments\n%s", migrateUsage)
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := db.MigrateUp(ctx, steps)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		reverted, err := db.MigrateDown(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+		return err
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return tw.Flush()
+	}
+
+	return nil
+}
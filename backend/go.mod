@@ -8,7 +8,9 @@ require (
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/google/uuid v1.6.0
 	github.com/jackc/pgx/v5 v5.8.0
-	github.com/n7olkachev/imgdiff v1.0.2
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.43.0
+	golang.org/x/image v0.25.0
 )
 
 require (
@@ -16,5 +18,5 @@ require (
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/puddle/v2 v2.2.2 // indirect
 	golang.org/x/sync v0.17.0 // indirect
-	golang.org/x/text v0.29.0 // indirect
+	golang.org/x/text v0.30.0 // indirect
 )
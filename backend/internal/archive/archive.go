@@ -1,105 +1,203 @@
+/*
+Package archive reads uploaded archives of screenshots: zip, tar, tar.gz and
+tar.zst behind one Reader. Tar formats are read as they stream in, zip needs
+random access and is spooled to a temporary file that Close removes. Every
+entry is read through Limits, counting the bytes actually decompressed rather
+than trusting sizes the archive claims, so a zip bomb stops at the limit.
+*/
 package archive
 
 import (
-	"archive/zip"
-	// "bytes"
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
-func UnpackArchiveFromRequest(r *http.Request) (string, error) {
-	err := r.ParseMultipartForm(32 << 20) // 32 MB max
-	if err != nil {
-		fmt.Printf("error processing multipart form, err %s\n", err.Error())
-		return "", err
+var (
+	// ErrUnsupportedFormat is returned for archives that aren't zip or tar,
+	// plain or compressed with gzip or zstd
+	ErrUnsupportedFormat = errors.New("archive must be a zip, tar, tar.gz or tar.zst file")
+	// ErrLimitExceeded is returned once an archive goes over one of its Limits
+	ErrLimitExceeded = errors.New("archive exceeds limits")
+)
+
+// Limits bound what reading an archive can cost
+type Limits struct {
+	// MaxFiles is the most files an archive may hold
+	MaxFiles int
+	// MaxFileSize is the most bytes a single file may decompress to
+	MaxFileSize int64
+	// MaxTotalSize is the most bytes all files together may decompress to
+	MaxTotalSize int64
+	// MaxRatio is how many times larger than the archive its contents may be
+	MaxRatio int64
+}
+
+// DefaultLimits suit archives of screenshots
+var DefaultLimits = Limits{
+	MaxFiles:     10000,
+	MaxFileSize:  64 << 20,
+	MaxTotalSize: 4 << 30,
+	MaxRatio:     200,
+}
+
+// Entry is a regular file in an archive. Its contents can be read until the
+// next call to Next.
+type Entry struct {
+	// Name is the file's path with forward slashes and no leading ./ or /
+	Name string
+	io.Reader
+}
+
+// Reader steps through the regular files of an archive. Directories, links
+// and macOS metadata are skipped.
+type Reader interface {
+	// Next returns the next file, io.EOF after the last
+	Next() (*Entry, error)
+	// Close releases the archive and anything it was spooled to
+	Close() error
+}
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is where a tar header keeps its magic
+const tarMagicOffset = 257
+
+// Open reads an archive from r, telling its format from the first bytes
+func Open(r io.Reader, limits Limits) (Reader, error) {
+	counted := &countingReader{r: r}
+	buffered := bufio.NewReaderSize(counted, 1024)
+	magic, err := buffered.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
 	}
 
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		fmt.Printf("err doing FormFile\n")
-		return "", err
+	guard := &guard{limits: limits, compressed: counted}
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		return openZip(buffered, guard)
+	case bytes.HasPrefix(magic, gzipMagic):
+		return openTarGz(buffered, guard)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return openTarZst(buffered, guard)
+	case len(magic) > tarMagicOffset && bytes.HasPrefix(magic[tarMagicOffset:], tarMagic):
+		return newTarReader(buffered, guard, nil), nil
+	default:
+		return nil, ErrUnsupportedFormat
 	}
-	defer file.Close()
+}
 
-	dst, err := os.MkdirTemp("", "extracted-")
+/*
+Walk calls fn with the name and contents of every file in the archive read
+from r, in the order they're stored. An error from fn stops the walk and is
+returned. The archive is closed, and any temporary file removed, before Walk
+returns.
+*/
+func Walk(r io.Reader, limits Limits, fn func(name string, contents io.Reader) error) error {
+	reader, err := Open(r, limits)
 	if err != nil {
-		return "", err
+		return err
 	}
-	// todo add this back in!
-	// defer os.RemoveAll(dst)
+	defer reader.Close()
+
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry.Name, entry); err != nil {
+			return err
+		}
+	}
+}
 
-	zipFile, err := os.CreateTemp(dst, "upload-*.zip")
-	// todo add this back in!
-	// defer os.Remove(zipFile.Name())
-	err = os.MkdirAll("./uploads/extracted/", os.ModePerm)
+// Extract writes every file in the archive read from r under dir, closing
+// each as soon as it's written
+func Extract(r io.Reader, limits Limits, dir string) error {
+	return Walk(r, limits, func(name string, contents io.Reader) error {
+		// cleanName has already stopped names escaping dir
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
 
-	fmt.Printf("Tmp dir = %s\n", dst)
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, contents); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	})
+}
 
-	_, err = io.Copy(zipFile, file)
+/*
+UnpackArchiveFromRequest extracts the archive in the request's "file"
+multipart field into a new temporary directory and returns its path. The
+upload is read as it arrives. The caller owns the directory and must remove
+it, on error it has already been removed.
+*/
+func UnpackArchiveFromRequest(r *http.Request) (string, error) {
+	file, err := multipartFile(r, "file")
 	if err != nil {
-		fmt.Printf("Error copying file\n")
 		return "", err
 	}
 
-	archive, err := zip.OpenReader(zipFile.Name())
+	dst, err := os.MkdirTemp("", "extracted-")
 	if err != nil {
-		fmt.Printf("Failed to open zip\n")
 		return "", err
 	}
-	defer archive.Close()
 
-	for _, f := range archive.File {
-		filePath := filepath.Join(dst, f.Name)
-		// fmt.Println("Unzipping file", filePath)
-
-		// Check for Zip Slip vulnerability
-		if !strings.HasPrefix(filePath, filepath.Clean(dst)+string(os.PathSeparator)) {
-			return "", errors.New("Invalid file path")
-		}
+	if err := Extract(file, DefaultLimits, dst); err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
 
-		// Create directories if the entry is a directory
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(filePath, os.ModePerm)
-			continue
-		}
+	return dst, nil
+}
 
-		// Create the destination directory if necessary
-		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
-			return "", err
-		}
+// multipartFile returns the contents of a file field of a multipart request
+// without buffering the form
+func multipartFile(r *http.Request, field string) (io.Reader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, errors.New("request must be multipart/form-data")
+	}
 
-		// Open the file in the ZIP archive
-		fileInArchive, err := f.Open()
-		if err != nil {
-			return "", err
+	form, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("request has no %s field", field)
 		}
-		defer fileInArchive.Close()
-
-		// Create a new file in the destination directory
-		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("failed to read multipart form: %w", err)
 		}
-		defer dstFile.Close()
-
-		// Copy the contents of the file
-		if _, err := io.Copy(dstFile, fileInArchive); err != nil {
-			return "", err
+		if part.FormName() == field {
+			return part, nil
 		}
-
-		//todo probably remove these when this is working as intended
-		// fmt.Fprintf(w, "File uploaded successfully: %s", header.Filename)
-
-		// fmt.Printf("Filename: %s, size: %s\n", header.Filename, string(header.Size))
-		// todo return something better later on
 	}
-
-	return dst, nil
 }
 
 func ArchiveData(baseDir string, featureDir string, diffDir string) error {
@@ -131,3 +229,14 @@ func ArchiveData(baseDir string, featureDir string, diffDir string) error {
 	//   log.Fatal(err)
 	// }
 }
+
+// cleanName normalises a path inside an archive, false for paths that
+// aren't screenshots: directories, ones escaping the archive and the
+// metadata macOS adds to zips
+func cleanName(name string) (string, bool) {
+	name = path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))[1:]
+	if name == "" || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._") {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,76 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+)
+
+// minRatioBase is the archive size the ratio limit is measured against at
+// the least, so small archives of uncompressible files aren't refused
+const minRatioBase = 1 << 20
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// guard enforces an archive's Limits across all of its entries
+type guard struct {
+	limits Limits
+	// compressed counts the bytes of the archive itself read so far
+	compressed *countingReader
+	files      int
+	total      int64
+}
+
+// entry admits the next file, declaredSize being what the archive claims it
+// holds. The size claimed is only used to refuse early, the returned entry
+// counts what is actually read.
+func (g *guard) entry(name string, contents io.Reader, declaredSize int64) (*Entry, error) {
+	g.files++
+	if g.files > g.limits.MaxFiles {
+		return nil, fmt.Errorf("%w: more than %d files", ErrLimitExceeded, g.limits.MaxFiles)
+	}
+	if declaredSize > g.limits.MaxFileSize {
+		return nil, g.fileTooLarge(name)
+	}
+
+	return &Entry{Name: name, Reader: &limitedReader{r: contents, guard: g, name: name}}, nil
+}
+
+func (g *guard) fileTooLarge(name string) error {
+	return fmt.Errorf("%w: %s is larger than %d bytes", ErrLimitExceeded, name, g.limits.MaxFileSize)
+}
+
+// limitedReader reads an entry's contents, failing once it or the archive
+// has decompressed more than its limits allow
+type limitedReader struct {
+	r     io.Reader
+	guard *guard
+	name  string
+	n     int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	l.guard.total += int64(n)
+
+	limits := l.guard.limits
+	switch {
+	case l.n > limits.MaxFileSize:
+		return n, l.guard.fileTooLarge(l.name)
+	case l.guard.total > limits.MaxTotalSize:
+		return n, fmt.Errorf("%w: contents are larger than %d bytes", ErrLimitExceeded, limits.MaxTotalSize)
+	case l.guard.total > limits.MaxRatio*max(l.guard.compressed.n, minRatioBase):
+		return n, fmt.Errorf("%w: contents are more than %d times the size of the archive", ErrLimitExceeded, limits.MaxRatio)
+	}
+	return n, err
+}
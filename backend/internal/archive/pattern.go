@@ -0,0 +1,98 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultPattern is how screenshots are laid out in an archive unless told otherwise
+const DefaultPattern = "{browser}/{viewport}/{name}.png"
+
+// placeholders are the parts of a path a pattern can capture, with what each
+// matches. Only the name may span directories.
+var placeholders = map[string]string{
+	"browser":  `[^/]+`,
+	"viewport": `[^/]+`,
+	"name":     `.+`,
+}
+
+// Match is what a pattern captured from a file's path in an archive
+type Match struct {
+	Name     string
+	Browser  string
+	Viewport string
+}
+
+/*
+Pattern maps paths inside an archive to snapshots, such as
+"{browser}/{viewport}/{name}.png". {name} is required, {browser} and
+{viewport} are optional, and everything else must match literally.
+*/
+type Pattern struct {
+	re *regexp.Regexp
+}
+
+// ParsePattern compiles a pattern, reporting unknown or repeated placeholders
+func ParsePattern(pattern string) (*Pattern, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	seen := map[string]bool{}
+	rest := pattern
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, errors.New("pattern has an unclosed {")
+		}
+
+		placeholder := rest[start+1 : start+end]
+		match, ok := placeholders[placeholder]
+		if !ok {
+			return nil, fmt.Errorf("pattern has unknown placeholder {%s}", placeholder)
+		}
+		if seen[placeholder] {
+			return nil, fmt.Errorf("pattern repeats {%s}", placeholder)
+		}
+		seen[placeholder] = true
+
+		expr.WriteString(regexp.QuoteMeta(rest[:start]))
+		expr.WriteString("(?P<" + placeholder + ">" + match + ")")
+		rest = rest[start+end+1:]
+	}
+
+	if !seen["name"] {
+		return nil, errors.New("pattern must contain {name}")
+	}
+
+	expr.WriteString("$")
+	return &Pattern{re: regexp.MustCompile(expr.String())}, nil
+}
+
+// Match captures the snapshot a path in an archive holds, false when the
+// path doesn't fit the pattern
+func (p *Pattern) Match(path string) (Match, bool) {
+	groups := p.re.FindStringSubmatch(path)
+	if groups == nil {
+		return Match{}, false
+	}
+
+	var match Match
+	for i, group := range p.re.SubexpNames() {
+		switch group {
+		case "name":
+			match.Name = groups[i]
+		case "browser":
+			match.Browser = groups[i]
+		case "viewport":
+			match.Viewport = groups[i]
+		}
+	}
+	return match, true
+}
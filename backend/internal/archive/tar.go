@@ -0,0 +1,69 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxZstdWindow bounds the memory a zstd stream can make the decoder allocate
+const maxZstdWindow = 32 << 20
+
+// tarReader reads a tar as it streams in
+type tarReader struct {
+	tar   *tar.Reader
+	guard *guard
+	// close releases the decompressor, if any
+	close func() error
+}
+
+func newTarReader(r io.Reader, guard *guard, close func() error) *tarReader {
+	return &tarReader{tar: tar.NewReader(r), guard: guard, close: close}
+}
+
+func openTarGz(r io.Reader, guard *guard) (Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip: %w", err)
+	}
+	return newTarReader(gz, guard, gz.Close), nil
+}
+
+func openTarZst(r io.Reader, guard *guard) (Reader, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zstd: %w", err)
+	}
+	return newTarReader(zr, guard, func() error {
+		zr.Close()
+		return nil
+	}), nil
+}
+
+func (t *tarReader) Next() (*Entry, error) {
+	for {
+		header, err := t.tar.Next()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		name, ok := cleanName(header.Name)
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		return t.guard.entry(name, t.tar, header.Size)
+	}
+}
+
+func (t *tarReader) Close() error {
+	if t.close != nil {
+		return t.close()
+	}
+	return nil
+}
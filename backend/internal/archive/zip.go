@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// zipReader reads a zip spooled to a temporary file
+type zipReader struct {
+	file    *os.File
+	zip     *zip.Reader
+	guard   *guard
+	next    int
+	current io.ReadCloser
+}
+
+// openZip spools r to a temporary file, as zip keeps its directory at the end
+func openZip(r io.Reader, guard *guard) (Reader, error) {
+	file, err := os.CreateTemp("", "archive-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool archive: %w", err)
+	}
+	z := &zipReader{file: file, guard: guard}
+
+	size, err := io.Copy(file, io.LimitReader(r, guard.limits.MaxTotalSize+1))
+	if err != nil {
+		z.Close()
+		return nil, fmt.Errorf("failed to spool archive: %w", err)
+	}
+	if size > guard.limits.MaxTotalSize {
+		z.Close()
+		return nil, fmt.Errorf("%w: archive is larger than %d bytes", ErrLimitExceeded, guard.limits.MaxTotalSize)
+	}
+
+	z.zip, err = zip.NewReader(file, size)
+	if err != nil {
+		z.Close()
+		return nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+	return z, nil
+}
+
+func (z *zipReader) Next() (*Entry, error) {
+	z.closeCurrent()
+
+	for z.next < len(z.zip.File) {
+		f := z.zip.File[z.next]
+		z.next++
+
+		name, ok := cleanName(f.Name)
+		if !ok || !f.Mode().IsRegular() {
+			continue
+		}
+
+		// Checked before converting, the size could overflow an int64
+		if f.UncompressedSize64 > uint64(z.guard.limits.MaxFileSize) {
+			return nil, z.guard.fileTooLarge(name)
+		}
+
+		contents, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		z.current = contents
+		return z.guard.entry(name, contents, int64(f.UncompressedSize64))
+	}
+
+	return nil, io.EOF
+}
+
+func (z *zipReader) closeCurrent() {
+	if z.current != nil {
+		z.current.Close()
+		z.current = nil
+	}
+}
+
+// Close removes the spooled archive
+func (z *zipReader) Close() error {
+	z.closeCurrent()
+	return errors.Join(z.file.Close(), os.Remove(z.file.Name()))
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionCookie is the name of the login session cookie
+const SessionCookie = "diffit_session"
+
+// MinPasswordLength is the shortest password accepted for an account
+const MinPasswordLength = 8
+
+// dummyHash is compared against when a login names an unknown user, so the
+// response takes as long as for a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("diffit-dummy-password"), bcrypt.DefaultCost)
+
+type userContextKey struct{}
+
+// UserFromContext returns the logged in user, if any
+func UserFromContext(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userContextKey{}).(*models.User)
+	return user
+}
+
+// HashPassword hashes a password for storage
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Login checks an email and password and returns the user, or nil if they don't match
+func (m *Middleware) Login(ctx context.Context, email, password string) (*models.User, error) {
+	user, hash, err := m.users.GetCredentials(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check password: %w", err)
+	}
+
+	return user, nil
+}
+
+// StartSession creates a session for user and sets the session cookie
+func (m *Middleware) StartSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	token, err := randomString()
+	if err != nil {
+		return fmt.Errorf("failed to generate session: %w", err)
+	}
+
+	// Expired sessions are cleaned up as new ones are made
+	if err := m.sessions.DeleteExpired(r.Context()); err != nil {
+		log.Printf("auth: %v", err)
+	}
+
+	expiresAt := time.Now().Add(m.options.SessionTTL)
+	if err := m.sessions.Create(r.Context(), user.ID, HashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, m.cookie(r, token, expiresAt))
+	return nil
+}
+
+// EndSession deletes the current session and clears the cookie
+func (m *Middleware) EndSession(w http.ResponseWriter, r *http.Request) error {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		if err := m.sessions.Delete(r.Context(), HashToken(cookie.Value)); err != nil {
+			return err
+		}
+	}
+
+	cookie := m.cookie(r, "", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+func (m *Middleware) cookie(r *http.Request, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   m.options.SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Session loads the user from the session cookie, if there is one. It never
+// rejects a request, use RequireUser or RequireRole for that.
+func (m *Middleware) Session(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(SessionCookie)
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := m.sessions.GetUser(r.Context(), HashToken(cookie.Value))
+		if err != nil {
+			log.Printf("auth: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to authenticate")
+			return
+		}
+		if user == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey{}, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireUser only lets logged in users through
+func (m *Middleware) RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) == nil {
+			respondError(w, http.StatusUnauthorized, "Login required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSiteAdmin only lets site admins through
+func (m *Middleware) RequireSiteAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := UserFromContext(r.Context())
+		if user == nil {
+			respondError(w, http.StatusUnauthorized, "Login required")
+			return
+		}
+		if !user.IsAdmin {
+			respondError(w, http.StatusForbidden, "Site admin required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+RequireRole only lets logged in users through who have at least role in the
+project resolve finds for the request. Site admins have every role in every
+project.
+*/
+func (m *Middleware) RequireRole(role models.Role, resolve ProjectResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := UserFromContext(r.Context())
+			if user == nil {
+				respondError(w, http.StatusUnauthorized, "Login required")
+				return
+			}
+
+			if !user.IsAdmin {
+				projectID, err := resolve(r)
+				if err != nil {
+					respondResolveError(w, err)
+					return
+				}
+
+				have, err := m.members.GetRole(r.Context(), projectID, user.ID)
+				if err != nil {
+					log.Printf("auth: %v", err)
+					respondError(w, http.StatusInternalServerError, "Failed to authenticate")
+					return
+				}
+				if !have.Valid() || !have.Includes(role) {
+					respondError(w, http.StatusForbidden, fmt.Sprintf("The %s role is required for this project", role))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
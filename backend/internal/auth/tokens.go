@@ -0,0 +1,216 @@
+/*
+Package auth authenticates API requests. CI authenticates with project-scoped
+API tokens sent as "Authorization: Bearer <token>", and may only write to the
+project its token belongs to. People log in with a password and get a session
+cookie, and their role in a project decides what they may change in it.
+*/
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TokenPrefix starts every API token so they're easy to spot in logs and secret scanners
+const TokenPrefix = "dft_"
+
+// GenerateToken creates a new random token and returns it with its hash and
+// the short prefix shown in token listings
+func GenerateToken() (token, hash, prefix string, err error) {
+	secret, err := randomString()
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token = TokenPrefix + secret
+	return token, HashToken(token), token[:len(TokenPrefix)+6], nil
+}
+
+// randomString returns 32 random bytes encoded for use in headers and cookies
+func randomString() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashToken returns the hex SHA-256 of a token, which is what gets stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type tokenContextKey struct{}
+
+// TokenFromContext returns the API token that authenticated the request, if any
+func TokenFromContext(ctx context.Context) *models.APIToken {
+	token, _ := ctx.Value(tokenContextKey{}).(*models.APIToken)
+	return token
+}
+
+// Error is returned by a ProjectResolver to reject a request with a specific status
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ProjectResolver works out which project a request writes to
+type ProjectResolver func(r *http.Request) (uuid.UUID, error)
+
+// ProjectFromURLParam resolves the project from a project ID URL parameter
+func ProjectFromURLParam(name string) ProjectResolver {
+	return func(r *http.Request) (uuid.UUID, error) {
+		id, err := uuid.Parse(chi.URLParam(r, name))
+		if err != nil {
+			return uuid.Nil, &Error{Status: http.StatusBadRequest, Message: "Invalid project ID"}
+		}
+		return id, nil
+	}
+}
+
+// Options configures sessions
+type Options struct {
+	// SessionTTL is how long a login lasts
+	SessionTTL time.Duration
+	// SecureCookies marks the session cookie Secure, for deployments behind HTTPS
+	SecureCookies bool
+	// AdminToken is a bootstrap secret that may manage API tokens, none when empty
+	AdminToken string
+}
+
+type Middleware struct {
+	tokens   *repository.APITokenRepository
+	users    *repository.UserRepository
+	sessions *repository.SessionRepository
+	members  *repository.ProjectMemberRepository
+	options  Options
+}
+
+func New(pool *pgxpool.Pool, options Options) *Middleware {
+	if options.SessionTTL <= 0 {
+		options.SessionTTL = 30 * 24 * time.Hour
+	}
+
+	return &Middleware{
+		tokens:   repository.NewAPITokenRepository(pool),
+		users:    repository.NewUserRepository(pool),
+		sessions: repository.NewSessionRepository(pool),
+		members:  repository.NewProjectMemberRepository(pool),
+		options:  options,
+	}
+}
+
+/*
+RequireProjectToken only lets requests through that carry a valid API token
+for the project resolve finds for the request. Requests without a valid token
+get 401, tokens for a different project get 403.
+*/
+func (m *Middleware) RequireProjectToken(resolve ProjectResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="diffit"`)
+				respondError(w, http.StatusUnauthorized, "API token required")
+				return
+			}
+
+			token, err := m.tokens.Authenticate(r.Context(), HashToken(raw))
+			if err != nil {
+				log.Printf("auth: %v", err)
+				respondError(w, http.StatusInternalServerError, "Failed to authenticate")
+				return
+			}
+			if token == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="diffit", error="invalid_token"`)
+				respondError(w, http.StatusUnauthorized, "Invalid API token")
+				return
+			}
+
+			projectID, err := resolve(r)
+			if err != nil {
+				respondResolveError(w, err)
+				return
+			}
+
+			if projectID != token.ProjectID {
+				respondError(w, http.StatusForbidden, "API token is not valid for this project")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), tokenContextKey{}, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+/*
+RequireTokenAdmin guards managing a project's API tokens. Only the project's
+admins may, or requests bearing the bootstrap AdminToken when one is
+configured. Nobody else can mint a token that writes to the project.
+*/
+func (m *Middleware) RequireTokenAdmin(resolve ProjectResolver) func(http.Handler) http.Handler {
+	requireAdmin := m.RequireRole(models.RoleAdmin, resolve)
+
+	return func(next http.Handler) http.Handler {
+		admin := requireAdmin(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if raw, ok := bearerToken(r); ok && m.options.AdminToken != "" &&
+				subtle.ConstantTimeCompare([]byte(raw), []byte(m.options.AdminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			admin.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken reads the token from an "Authorization: Bearer" or "Token" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || (!strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "Token")) {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// respondResolveError responds to a failed ProjectResolver
+func respondResolveError(w http.ResponseWriter, err error) {
+	var authErr *Error
+	if errors.As(err, &authErr) {
+		respondError(w, authErr.Status, authErr.Message)
+		return
+	}
+	log.Printf("auth: %v", err)
+	respondError(w, http.StatusInternalServerError, "Failed to authenticate")
+}
+
+func respondError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,98 @@
+/*
+Package branches works out which branches a build's baselines come from. A
+branch uses its own baselines first, then those of the parent given by the
+first matching branch rule, then that branch's parent and so on, and finally
+the project's default branch.
+*/
+package branches
+
+import (
+	"context"
+	"path"
+	"slices"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxDepth bounds how many parents are followed, so a long or cyclic rule
+// set can't make lookups unbounded
+const maxDepth = 10
+
+// Resolver loads a project's branch rules to build baseline chains
+type Resolver struct {
+	projectRepo *repository.ProjectRepository
+	ruleRepo    *repository.BranchRuleRepository
+}
+
+func NewResolver(pool *pgxpool.Pool) *Resolver {
+	return &Resolver{
+		projectRepo: repository.NewProjectRepository(pool),
+		ruleRepo:    repository.NewBranchRuleRepository(pool),
+	}
+}
+
+// Chain returns the branches baselines for branch are looked up in, in order
+func (r *Resolver) Chain(ctx context.Context, projectID uuid.UUID, branch string) ([]string, error) {
+	project, err := r.projectRepo.GetByID(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := r.ruleRepo.ListByProject(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Chain(branch, project.DefaultBranch, rules), nil
+}
+
+/*
+Chain follows rules from branch to build its lookup chain, ending with the
+default branch. Rules must already be ordered by priority; the first whose
+pattern matches a branch names its parent. A parent already in the chain
+ends it, so cyclic rules are harmless.
+*/
+func Chain(branch, defaultBranch string, rules []models.BranchRule) []string {
+	chain := []string{branch}
+
+	for current := branch; len(chain) <= maxDepth; {
+		parent, ok := parentOf(current, rules)
+		if !ok || slices.Contains(chain, parent) {
+			break
+		}
+		chain = append(chain, parent)
+		current = parent
+	}
+
+	if !slices.Contains(chain, defaultBranch) {
+		chain = append(chain, defaultBranch)
+	}
+	return chain
+}
+
+// parentOf returns the parent the first matching rule gives a branch
+func parentOf(branch string, rules []models.BranchRule) (string, bool) {
+	for _, rule := range rules {
+		if Matches(rule.Pattern, branch) {
+			return rule.ParentBranch, true
+		}
+	}
+	return "", false
+}
+
+// Matches reports whether a branch name matches a rule pattern. Patterns use
+// path.Match syntax, so "feature/*" matches "feature/login" but not
+// "feature/login/v2".
+func Matches(pattern, branch string) bool {
+	matched, err := path.Match(pattern, branch)
+	return err == nil && matched
+}
+
+// ValidPattern reports whether a pattern is well formed
+func ValidPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"regexp"
+	"testing"
+)
+
+// dropTable matches statements that throw a table's rows away
+var dropTable = regexp.MustCompile(`(?i)\bDROP\s+TABLE\b`)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range migrations {
+		if i > 0 && migration.Version <= migrations[i-1].Version {
+			t.Errorf("migration %d_%s is out of order", migration.Version, migration.Name)
+		}
+		if migration.Down == "" {
+			t.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+		}
+		// Up scripts run against databases full of data, only rolling back
+		// may drop a table
+		if dropTable.MatchString(migration.Up) {
+			t.Errorf("migration %d_%s drops a table in its up script", migration.Version, migration.Name)
+		}
+	}
+}
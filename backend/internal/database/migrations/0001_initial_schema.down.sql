@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS snapshots;
+DROP TABLE IF EXISTS baselines;
+DROP TABLE IF EXISTS builds;
+DROP TABLE IF EXISTS projects;
+DROP FUNCTION IF EXISTS update_updated_at_column();
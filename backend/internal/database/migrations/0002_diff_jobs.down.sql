@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS diff_jobs;
+ALTER TABLE snapshots DROP COLUMN IF EXISTS failure_reason;
@@ -0,0 +1,23 @@
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS failure_reason TEXT;
+
+-- Diff jobs queue, consumed by the diff worker pool
+CREATE TABLE IF NOT EXISTS diff_jobs (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	snapshot_id UUID NOT NULL REFERENCES snapshots(id) ON DELETE CASCADE,
+	status VARCHAR(50) DEFAULT 'pending',
+	attempts INTEGER DEFAULT 0,
+	max_attempts INTEGER DEFAULT 3,
+	last_error TEXT,
+	run_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	locked_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_diff_jobs_status_run_at ON diff_jobs(status, run_at);
+CREATE INDEX IF NOT EXISTS idx_diff_jobs_snapshot_id ON diff_jobs(snapshot_id);
+
+DROP TRIGGER IF EXISTS update_diff_jobs_updated_at ON diff_jobs;
+CREATE TRIGGER update_diff_jobs_updated_at
+	BEFORE UPDATE ON diff_jobs
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS diff_rules;
+ALTER TABLE projects DROP COLUMN IF EXISTS ignore_antialiasing;
+ALTER TABLE projects DROP COLUMN IF EXISTS acceptable_diff_percentage;
+ALTER TABLE projects DROP COLUMN IF EXISTS diff_threshold;
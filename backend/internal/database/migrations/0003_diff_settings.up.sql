@@ -0,0 +1,24 @@
+-- Per-project diff settings
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS diff_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.1;
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS acceptable_diff_percentage DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS ignore_antialiasing BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Diff setting overrides matched against snapshot names
+CREATE TABLE IF NOT EXISTS diff_rules (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	pattern VARCHAR(500) NOT NULL,
+	priority INTEGER NOT NULL DEFAULT 0,
+	diff_threshold DOUBLE PRECISION,
+	acceptable_diff_percentage DOUBLE PRECISION,
+	ignore_antialiasing BOOLEAN,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_diff_rules_project_id ON diff_rules(project_id);
+
+DROP TRIGGER IF EXISTS update_diff_rules_updated_at ON diff_rules;
+CREATE TRIGGER update_diff_rules_updated_at
+	BEFORE UPDATE ON diff_rules
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,22 @@
+-- Rectangles excluded from diffs, attached to a baseline or a snapshot name pattern
+CREATE TABLE IF NOT EXISTS ignore_regions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	baseline_id UUID REFERENCES baselines(id) ON DELETE CASCADE,
+	pattern VARCHAR(500),
+	x INTEGER NOT NULL,
+	y INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	selector VARCHAR(500),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	CHECK ((baseline_id IS NULL) <> (pattern IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_ignore_regions_project_id ON ignore_regions(project_id);
+
+DROP TRIGGER IF EXISTS update_ignore_regions_updated_at ON ignore_regions;
+CREATE TRIGGER update_ignore_regions_updated_at
+	BEFORE UPDATE ON ignore_regions
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_baselines_image_path;
+DROP INDEX IF EXISTS idx_snapshots_base_image_path;
+DROP INDEX IF EXISTS idx_snapshots_comparison_image_path;
+ALTER TABLE baselines DROP COLUMN IF EXISTS image_hash;
+ALTER TABLE snapshots DROP COLUMN IF EXISTS comparison_image_hash;
+DROP TABLE IF EXISTS image_blobs;
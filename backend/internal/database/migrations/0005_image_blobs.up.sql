@@ -0,0 +1,21 @@
+-- Content-addressed images, keyed by the SHA-256 of their decoded pixels.
+-- Rows nothing points at any more are removed by the image sweeper.
+CREATE TABLE IF NOT EXISTS image_blobs (
+	project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	hash CHAR(64) NOT NULL,
+	path VARCHAR(500) NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	last_used_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	PRIMARY KEY (project_id, hash)
+);
+
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS comparison_image_hash CHAR(64);
+ALTER TABLE baselines ADD COLUMN IF NOT EXISTS image_hash CHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_image_blobs_last_used_at ON image_blobs(last_used_at);
+CREATE INDEX IF NOT EXISTS idx_snapshots_comparison_image_path ON snapshots(comparison_image_path);
+CREATE INDEX IF NOT EXISTS idx_snapshots_base_image_path ON snapshots(base_image_path);
+CREATE INDEX IF NOT EXISTS idx_baselines_image_path ON baselines(image_path);
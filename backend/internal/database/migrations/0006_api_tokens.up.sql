@@ -0,0 +1,14 @@
+-- Project-scoped API tokens used by CI to upload builds. Only the SHA-256 of
+-- the token is stored, the token itself is shown once when it's created.
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	name VARCHAR(255) NOT NULL,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	token_prefix VARCHAR(16) NOT NULL,
+	last_used_at TIMESTAMP WITH TIME ZONE,
+	revoked_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_project_id ON api_tokens(project_id);
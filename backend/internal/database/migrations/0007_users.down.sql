@@ -0,0 +1,4 @@
+ALTER TABLE snapshots DROP COLUMN IF EXISTS reviewed_by_user_id;
+DROP TABLE IF EXISTS project_members;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,45 @@
+-- Local user accounts. Site admins can manage every project and add users.
+CREATE TABLE IF NOT EXISTS users (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	email VARCHAR(255) NOT NULL UNIQUE,
+	name VARCHAR(255) NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Login sessions, only the SHA-256 of the session cookie is stored
+CREATE TABLE IF NOT EXISTS sessions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Per-project roles
+CREATE TABLE IF NOT EXISTS project_members (
+	project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role VARCHAR(50) NOT NULL CHECK (role IN ('viewer', 'reviewer', 'admin')),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	PRIMARY KEY (project_id, user_id)
+);
+
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS reviewed_by_user_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_project_members_user_id ON project_members(user_id);
+
+DROP TRIGGER IF EXISTS update_users_updated_at ON users;
+CREATE TRIGGER update_users_updated_at
+	BEFORE UPDATE ON users
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+DROP TRIGGER IF EXISTS update_project_members_updated_at ON project_members;
+CREATE TRIGGER update_project_members_updated_at
+	BEFORE UPDATE ON project_members
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
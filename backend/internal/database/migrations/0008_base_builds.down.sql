@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_snapshots_base_snapshot_id;
+DROP INDEX IF EXISTS idx_builds_base_build_id;
+DROP INDEX IF EXISTS idx_builds_commit_sha;
+ALTER TABLE snapshots DROP COLUMN IF EXISTS base_snapshot_id;
+ALTER TABLE builds DROP COLUMN IF EXISTS base_commit_sha;
+ALTER TABLE builds DROP COLUMN IF EXISTS base_build_id;
@@ -0,0 +1,11 @@
+-- Builds can be compared against another build of the project, usually the
+-- build of a pull request's merge base, instead of the branch baselines.
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS base_build_id UUID REFERENCES builds(id) ON DELETE SET NULL;
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS base_commit_sha VARCHAR(40);
+
+-- The snapshot in the base build a snapshot was diffed against
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS base_snapshot_id UUID REFERENCES snapshots(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_builds_commit_sha ON builds(project_id, commit_sha);
+CREATE INDEX IF NOT EXISTS idx_builds_base_build_id ON builds(base_build_id);
+CREATE INDEX IF NOT EXISTS idx_snapshots_base_snapshot_id ON snapshots(base_snapshot_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_snapshots_change_type;
+DELETE FROM snapshots WHERE change_type = 'removed';
+ALTER TABLE builds DROP COLUMN IF EXISTS unchanged_snapshots;
+ALTER TABLE builds DROP COLUMN IF EXISTS removed_snapshots;
+ALTER TABLE builds DROP COLUMN IF EXISTS new_snapshots;
+ALTER TABLE snapshots DROP COLUMN IF EXISTS change_type;
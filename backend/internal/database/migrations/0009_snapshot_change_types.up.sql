@@ -0,0 +1,21 @@
+-- How each snapshot differs from its baseline, set once the diff has run.
+-- Removed snapshots are placeholder rows created when a build is finalized
+-- for baselines the build didn't upload a screenshot for.
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS change_type VARCHAR(20);
+
+UPDATE snapshots SET change_type = CASE
+	WHEN baseline_id IS NULL AND base_snapshot_id IS NULL THEN 'new'
+	WHEN diff_percentage > 0 THEN 'changed'
+	ELSE 'unchanged'
+END
+WHERE status = 'completed' AND change_type IS NULL;
+
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS new_snapshots INTEGER DEFAULT 0;
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS removed_snapshots INTEGER DEFAULT 0;
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS unchanged_snapshots INTEGER DEFAULT 0;
+
+UPDATE builds SET
+	new_snapshots = (SELECT COUNT(*) FROM snapshots WHERE build_id = builds.id AND change_type = 'new'),
+	unchanged_snapshots = (SELECT COUNT(*) FROM snapshots WHERE build_id = builds.id AND change_type = 'unchanged');
+
+CREATE INDEX IF NOT EXISTS idx_snapshots_change_type ON snapshots(build_id, change_type);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_builds_review_state;
+DROP TABLE IF EXISTS build_review_transitions;
+ALTER TABLE builds DROP COLUMN IF EXISTS review_state;
@@ -0,0 +1,29 @@
+-- The review outcome of a whole build, kept in step with its snapshots
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS review_state VARCHAR(20) NOT NULL DEFAULT 'pending';
+
+UPDATE builds SET review_state = CASE
+	WHEN EXISTS (
+		SELECT 1 FROM snapshots
+		WHERE build_id = builds.id AND change_type IN ('new', 'changed', 'removed') AND review_status = 'rejected'
+	) THEN 'rejected'
+	WHEN EXISTS (
+		SELECT 1 FROM snapshots
+		WHERE build_id = builds.id AND change_type IN ('new', 'changed', 'removed') AND review_status = 'unreviewed'
+	) THEN 'needs_review'
+	ELSE 'approved'
+END
+WHERE status = 'completed';
+
+-- Every change of a build's review state and who made it
+CREATE TABLE IF NOT EXISTS build_review_transitions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+	from_state VARCHAR(20) NOT NULL,
+	to_state VARCHAR(20) NOT NULL,
+	user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+	reviewed_by VARCHAR(255),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_build_review_transitions_build_id ON build_review_transitions(build_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_builds_review_state ON builds(review_state);
@@ -0,0 +1,17 @@
+-- Where and how each project reports build results as commit statuses
+CREATE TABLE IF NOT EXISTS commit_status_configs (
+	project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+	host VARCHAR(20) NOT NULL CHECK (host IN ('github', 'gitlab')),
+	api_url VARCHAR(500),
+	repository VARCHAR(255) NOT NULL,
+	token TEXT NOT NULL,
+	context VARCHAR(255) NOT NULL DEFAULT 'diffit',
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+DROP TRIGGER IF EXISTS update_commit_status_configs_updated_at ON commit_status_configs;
+CREATE TRIGGER update_commit_status_configs_updated_at
+	BEFORE UPDATE ON commit_status_configs
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
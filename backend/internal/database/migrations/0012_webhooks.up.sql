@@ -0,0 +1,46 @@
+-- Outgoing webhook subscriptions. An empty events array subscribes to every event.
+CREATE TABLE IF NOT EXISTS webhooks (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	url VARCHAR(2000) NOT NULL,
+	secret VARCHAR(255) NOT NULL,
+	events TEXT[] NOT NULL DEFAULT '{}',
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Every attempt to deliver an event to a webhook, doubling as the retry queue
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event VARCHAR(50) NOT NULL,
+	event_id UUID NOT NULL,
+	payload JSONB NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	response_status INTEGER,
+	response_body TEXT,
+	last_error TEXT,
+	run_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	locked_at TIMESTAMP WITH TIME ZONE,
+	delivered_at TIMESTAMP WITH TIME ZONE,
+	redelivery_of UUID REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_project_id ON webhooks(project_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_run_at ON webhook_deliveries(status, run_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+
+DROP TRIGGER IF EXISTS update_webhooks_updated_at ON webhooks;
+CREATE TRIGGER update_webhooks_updated_at
+	BEFORE UPDATE ON webhooks
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+DROP TRIGGER IF EXISTS update_webhook_deliveries_updated_at ON webhook_deliveries;
+CREATE TRIGGER update_webhook_deliveries_updated_at
+	BEFORE UPDATE ON webhook_deliveries
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
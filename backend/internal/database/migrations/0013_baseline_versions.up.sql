@@ -0,0 +1,25 @@
+-- Every image a baseline has had. Rows are never updated, rolling back
+-- records the restored image as a new version.
+CREATE TABLE IF NOT EXISTS baseline_versions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	baseline_id UUID NOT NULL REFERENCES baselines(id) ON DELETE CASCADE,
+	version INTEGER NOT NULL,
+	image_path VARCHAR(500) NOT NULL,
+	image_hash CHAR(64),
+	width INTEGER,
+	height INTEGER,
+	source_snapshot_id UUID REFERENCES snapshots(id) ON DELETE SET NULL,
+	approved_by_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+	approved_by VARCHAR(255),
+	restored_from_id UUID REFERENCES baseline_versions(id) ON DELETE SET NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	UNIQUE (baseline_id, version)
+);
+
+-- Existing baselines start their history with the image they have now
+INSERT INTO baseline_versions (baseline_id, version, image_path, image_hash, width, height, source_snapshot_id, created_at)
+SELECT b.id, 1, b.image_path, b.image_hash, b.width, b.height, b.source_snapshot_id, b.updated_at
+FROM baselines b
+WHERE NOT EXISTS (SELECT 1 FROM baseline_versions v WHERE v.baseline_id = b.id);
+
+CREATE INDEX IF NOT EXISTS idx_baseline_versions_image_path ON baseline_versions(image_path);
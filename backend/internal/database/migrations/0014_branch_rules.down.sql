@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS merge_hooks;
+DROP TABLE IF EXISTS branch_rules;
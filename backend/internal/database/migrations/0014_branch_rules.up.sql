@@ -0,0 +1,33 @@
+-- Which branch a branch inherits baselines from, matched against branch names.
+-- Branches without a matching rule fall back to the project's default branch.
+CREATE TABLE IF NOT EXISTS branch_rules (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	pattern VARCHAR(500) NOT NULL,
+	parent_branch VARCHAR(255) NOT NULL,
+	priority INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_branch_rules_project_id ON branch_rules(project_id);
+
+DROP TRIGGER IF EXISTS update_branch_rules_updated_at ON branch_rules;
+CREATE TRIGGER update_branch_rules_updated_at
+	BEFORE UPDATE ON branch_rules
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Incoming merge webhooks from the git host, which promote a merged branch's
+-- baselines onto the branch it was merged into
+CREATE TABLE IF NOT EXISTS merge_hooks (
+	project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+	secret VARCHAR(255) NOT NULL,
+	delete_source BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+DROP TRIGGER IF EXISTS update_merge_hooks_updated_at ON merge_hooks;
+CREATE TRIGGER update_merge_hooks_updated_at
+	BEFORE UPDATE ON merge_hooks
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
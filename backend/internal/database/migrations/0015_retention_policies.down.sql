@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_baseline_versions_source_snapshot_id;
+DROP INDEX IF EXISTS idx_baselines_source_snapshot_id;
+DROP INDEX IF EXISTS idx_builds_project_branch_number;
+DROP TABLE IF EXISTS retention_policies;
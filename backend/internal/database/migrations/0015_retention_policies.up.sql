@@ -0,0 +1,19 @@
+-- How long each project keeps its builds. Unset limits keep builds forever,
+-- projects without a policy never have builds expired.
+CREATE TABLE IF NOT EXISTS retention_policies (
+	project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+	keep_builds_per_branch INTEGER CHECK (keep_builds_per_branch > 0),
+	max_age_days INTEGER CHECK (max_age_days > 0),
+	keep_baseline_sources BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+DROP TRIGGER IF EXISTS update_retention_policies_updated_at ON retention_policies;
+CREATE TRIGGER update_retention_policies_updated_at
+	BEFORE UPDATE ON retention_policies
+	FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE INDEX IF NOT EXISTS idx_builds_project_branch_number ON builds(project_id, branch, build_number DESC);
+CREATE INDEX IF NOT EXISTS idx_baselines_source_snapshot_id ON baselines(source_snapshot_id);
+CREATE INDEX IF NOT EXISTS idx_baseline_versions_source_snapshot_id ON baseline_versions(source_snapshot_id);
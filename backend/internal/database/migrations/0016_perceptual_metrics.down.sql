@@ -0,0 +1,7 @@
+ALTER TABLE snapshots DROP COLUMN IF EXISTS diff_regions;
+ALTER TABLE snapshots DROP COLUMN IF EXISTS diff_region_count;
+ALTER TABLE snapshots DROP COLUMN IF EXISTS ssim;
+ALTER TABLE projects DROP COLUMN IF EXISTS min_region_pixels;
+ALTER TABLE projects DROP COLUMN IF EXISTS min_ssim;
+ALTER TABLE projects DROP COLUMN IF EXISTS change_criterion;
+ALTER TABLE projects DROP COLUMN IF EXISTS color_metric;
@@ -0,0 +1,11 @@
+-- How projects compare pixel colours and decide a snapshot has changed
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS color_metric VARCHAR(20) NOT NULL DEFAULT 'yiq';
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS change_criterion VARCHAR(20) NOT NULL DEFAULT 'pixels';
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS min_ssim DOUBLE PRECISION NOT NULL DEFAULT 0.99;
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS min_region_pixels INTEGER NOT NULL DEFAULT 1;
+
+-- Perceptual metrics recorded by the diff. diff_regions holds the largest
+-- connected regions of changed pixels, diff_region_count counts all of them.
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS ssim DOUBLE PRECISION;
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS diff_region_count INTEGER;
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS diff_regions JSONB;
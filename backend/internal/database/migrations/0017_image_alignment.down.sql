@@ -0,0 +1,2 @@
+UPDATE snapshots SET change_type = 'changed' WHERE change_type = 'resized';
+ALTER TABLE projects DROP COLUMN IF EXISTS alignment;
@@ -0,0 +1,2 @@
+-- How projects line up screenshots whose dimensions changed
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS alignment VARCHAR(20) NOT NULL DEFAULT 'top-left';
@@ -0,0 +1,24 @@
+-- Clusters of changed pixels found by the diff, in diff image coordinates.
+-- The base and comparison areas are the parts of each image a region shows,
+-- null when it only covers area the other image added or removed.
+CREATE TABLE IF NOT EXISTS snapshot_regions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	snapshot_id UUID NOT NULL REFERENCES snapshots(id) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	x INTEGER NOT NULL,
+	y INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	pixels INTEGER NOT NULL,
+	base_x INTEGER,
+	base_y INTEGER,
+	base_width INTEGER,
+	base_height INTEGER,
+	comparison_x INTEGER,
+	comparison_y INTEGER,
+	comparison_width INTEGER,
+	comparison_height INTEGER,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_snapshot_regions_snapshot_id ON snapshot_regions(snapshot_id, position);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_snapshots_idempotency_key;
+ALTER TABLE snapshots DROP COLUMN IF EXISTS idempotency_key;
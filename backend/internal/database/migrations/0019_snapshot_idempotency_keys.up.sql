@@ -0,0 +1,5 @@
+-- Key a client sends with a snapshot upload so retrying it after a timeout
+-- returns the snapshot already created instead of adding another
+ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_snapshots_idempotency_key
+	ON snapshots(build_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
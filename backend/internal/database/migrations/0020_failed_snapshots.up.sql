@@ -0,0 +1,11 @@
+-- Snapshots whose diff failed keep a build from being approved
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS failed_snapshots INTEGER NOT NULL DEFAULT 0;
+
+UPDATE builds SET failed_snapshots = failed.count
+FROM (
+	SELECT build_id, COUNT(*) AS count FROM snapshots WHERE status = 'failed' GROUP BY build_id
+) AS failed
+WHERE builds.id = failed.build_id;
+
+UPDATE builds SET review_state = 'needs_review'
+WHERE status = 'completed' AND review_state = 'approved' AND failed_snapshots > 0;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_builds_idempotency_key;
+ALTER TABLE builds DROP COLUMN IF EXISTS idempotency_key;
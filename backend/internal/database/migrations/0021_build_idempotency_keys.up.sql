@@ -0,0 +1,5 @@
+-- Key a client sends when creating a build so retrying the request after a
+-- timeout returns the build already created instead of adding another
+ALTER TABLE builds ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_builds_idempotency_key
+	ON builds(project_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,154 @@
+package derived
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"time"
+)
+
+// EncodeGIF writes frames as a looping GIF showing each frame for delay.
+// Frames are reduced to a fixed 256 colour palette with dithering.
+func EncodeGIF(w io.Writer, frames []image.Image, delay time.Duration) error {
+	animation := &gif.GIF{}
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, paletted.Rect, frame, bounds.Min)
+
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, int(delay/(10*time.Millisecond)))
+	}
+
+	if err := gif.EncodeAll(w, animation); err != nil {
+		return fmt.Errorf("failed to encode gif: %w", err)
+	}
+	return nil
+}
+
+/*
+EncodeAPNG writes frames as a looping animated PNG showing each frame for
+delay. Every frame is encoded as a standalone PNG and its image data moved
+into the animation, so frames must share their size and must all be opaque
+or all be translucent for the encoder to pick the same pixel format.
+*/
+func EncodeAPNG(w io.Writer, frames []image.Image, delay time.Duration) error {
+	if len(frames) == 0 {
+		return errors.New("an animation needs at least one frame")
+	}
+
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+	sequence := uint32(0)
+
+	for i, frame := range frames {
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, frame); err != nil {
+			return fmt.Errorf("failed to encode frame: %w", err)
+		}
+
+		chunks, err := pngChunks(encoded.Bytes())
+		if err != nil {
+			return err
+		}
+
+		var header []byte
+		for _, chunk := range chunks {
+			if chunk.kind == "IHDR" {
+				header = chunk.data
+			}
+		}
+		if len(header) < 8 {
+			return errors.New("encoded frame has no header")
+		}
+
+		if i == 0 {
+			writeChunk(&out, "IHDR", header)
+
+			control := make([]byte, 8)
+			binary.BigEndian.PutUint32(control[0:], uint32(len(frames)))
+			binary.BigEndian.PutUint32(control[4:], 0) // loop forever
+			writeChunk(&out, "acTL", control)
+		}
+
+		// Frame control: size, offset, delay in milliseconds, no disposal or blending
+		control := make([]byte, 26)
+		binary.BigEndian.PutUint32(control[0:], sequence)
+		copy(control[4:12], header[0:8])
+		binary.BigEndian.PutUint16(control[20:], uint16(delay.Milliseconds()))
+		binary.BigEndian.PutUint16(control[22:], 1000)
+		writeChunk(&out, "fcTL", control)
+		sequence++
+
+		for _, chunk := range chunks {
+			if chunk.kind != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				writeChunk(&out, "IDAT", chunk.data)
+				continue
+			}
+
+			data := make([]byte, 4+len(chunk.data))
+			binary.BigEndian.PutUint32(data, sequence)
+			copy(data[4:], chunk.data)
+			writeChunk(&out, "fdAT", data)
+			sequence++
+		}
+	}
+
+	writeChunk(&out, "IEND", nil)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// pngChunks splits an encoded PNG into its chunks
+func pngChunks(encoded []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(encoded, []byte(pngSignature)) {
+		return nil, errors.New("not a png")
+	}
+
+	var chunks []pngChunk
+	rest := encoded[len(pngSignature):]
+	for len(rest) >= 12 {
+		length := int(binary.BigEndian.Uint32(rest))
+		if len(rest) < 12+length {
+			return nil, errors.New("truncated png chunk")
+		}
+		chunks = append(chunks, pngChunk{kind: string(rest[4:8]), data: rest[8 : 8+length]})
+		rest = rest[12+length:]
+	}
+	return chunks, nil
+}
+
+func writeChunk(w *bytes.Buffer, kind string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	w.Write(length[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
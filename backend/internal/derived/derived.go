@@ -0,0 +1,121 @@
+/*
+Package derived caches files generated from a snapshot's stored images, such
+as region thumbnails, in storage beside them. A snapshot's derived files live
+under projectID/derived/snapshotID/ so the janitor can drop them once the
+snapshot is gone. Names must capture everything the output depends on, a
+cached file is never regenerated.
+
+Resized and re-encoded variants of any stored image live under
+projectID/derived/variants/ followed by the image's own path, and are kept
+for as long as that image is.
+*/
+package derived
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/google/uuid"
+)
+
+type Cache struct {
+	storage storage.Storage
+}
+
+func New(storage storage.Storage) *Cache {
+	return &Cache{storage: storage}
+}
+
+// Path is where a snapshot's derived file called name is kept
+func Path(projectID, snapshotID uuid.UUID, name string) string {
+	return storage.Key(projectID, storage.StorageTypeDerived, path.Join(snapshotID.String(), name))
+}
+
+// Owner returns the snapshot a derived file belongs to, false when
+// relativePath isn't a derived file
+func Owner(relativePath string) (uuid.UUID, bool) {
+	parts := strings.Split(relativePath, "/")
+	if len(parts) < 4 || parts[1] != string(storage.StorageTypeDerived) {
+		return uuid.Nil, false
+	}
+
+	snapshotID, err := uuid.Parse(parts[2])
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return snapshotID, true
+}
+
+/*
+Get opens a snapshot's derived file called name. When it isn't cached yet
+generate writes it, and it is saved before being returned.
+*/
+func (c *Cache) Get(projectID, snapshotID uuid.UUID, name string, generate func(w io.Writer) error) (io.ReadCloser, error) {
+	return c.getOrCreate(projectID, path.Join(snapshotID.String(), name), generate)
+}
+
+// getOrCreate opens the derived file filename of a project, generating and
+// saving it when it doesn't exist
+func (c *Cache) getOrCreate(projectID uuid.UUID, filename string, generate func(w io.Writer) error) (io.ReadCloser, error) {
+	file, err := c.storage.GetFile(storage.Key(projectID, storage.StorageTypeDerived, filename))
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := generate(&buf); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.storage.SaveFileWithName(projectID, storage.StorageTypeDerived, filename, bytes.NewReader(buf.Bytes())); err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(&buf), nil
+}
+
+// Invalidate deletes every derived file of a snapshot, for when the images
+// they were made from change
+func (c *Cache) Invalidate(projectID, snapshotID uuid.UUID) error {
+	return c.storage.DeleteDir(storage.Key(projectID, storage.StorageTypeDerived, snapshotID.String()))
+}
+
+// Open opens a stored file as it is
+func (c *Cache) Open(relativePath string) (io.ReadCloser, error) {
+	return c.storage.GetFile(relativePath)
+}
+
+// LoadImage decodes a stored image
+func (c *Cache) LoadImage(relativePath string) (image.Image, error) {
+	file, err := c.storage.GetFile(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// EncodePNG writes img as a PNG, favouring speed over size
+func EncodePNG(w io.Writer, img image.Image) error {
+	enc := &png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(w, img); err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package derived
+
+import (
+	"image"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Crop returns the part of img inside area grown by padding on every side,
+// clamped to the image
+func Crop(img image.Image, area image.Rectangle, padding int) *image.NRGBA {
+	area = area.Inset(-padding).Intersect(img.Bounds())
+	cropped := image.NewNRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, area.Min, draw.Src)
+	return cropped
+}
+
+// Fit scales img down to fit within maxWidth by maxHeight keeping its aspect
+// ratio, images that already fit are returned as they are
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return img
+	}
+
+	scale := min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	scaled := image.NewNRGBA(image.Rect(0, 0, max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1)))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
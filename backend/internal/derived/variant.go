@@ -0,0 +1,134 @@
+package derived
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Format is an encoding an image can be served in
+type Format string
+
+const (
+	FormatPNG  Format = "png"
+	FormatWebP Format = "webp"
+	FormatJPEG Format = "jpeg"
+)
+
+// jpegQuality is the quality JPEG variants are encoded at
+const jpegQuality = 85
+
+// ValidFormat reports whether format is a known format
+func ValidFormat(format Format) bool {
+	return format == FormatPNG || format == FormatWebP || format == FormatJPEG
+}
+
+// ContentType is the media type of format
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+/*
+Variant describes a copy of a stored image scaled down to fit within Width by
+Height, keeping its aspect ratio, and encoded as Format. A zero Width or
+Height leaves that side unbounded. Images are never enlarged.
+*/
+type Variant struct {
+	Width  int
+	Height int
+	Format Format
+}
+
+// Name identifies the variant, it's the file name the variant is cached under
+func (v Variant) Name() string {
+	return fmt.Sprintf("%dx%d.%s", v.Width, v.Height, v.Format)
+}
+
+// variantDir splits the stored file at source into its project and the
+// directory under that project's derived files holding its variants
+func variantDir(source string) (uuid.UUID, string, error) {
+	project, rest, _ := strings.Cut(source, "/")
+	projectID, err := uuid.Parse(project)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("invalid stored file path %q", source)
+	}
+	return projectID, path.Join("variants", rest), nil
+}
+
+// VariantSource returns the stored file a variant was made from, false when
+// relativePath isn't a variant
+func VariantSource(relativePath string) (string, bool) {
+	parts := strings.Split(relativePath, "/")
+	if len(parts) < 5 || parts[1] != string(storage.StorageTypeDerived) || parts[2] != "variants" {
+		return "", false
+	}
+	return path.Join(append(parts[:1:1], parts[3:len(parts)-1]...)...), true
+}
+
+/*
+GetVariant opens a variant of the stored image at source, generating and
+saving it the first time it's asked for. Variants are keyed by their source's
+path, so a source whose contents change under the same path needs
+InvalidateVariants.
+*/
+func (c *Cache) GetVariant(source string, variant Variant) (io.ReadCloser, error) {
+	projectID, dir, err := variantDir(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getOrCreate(projectID, path.Join(dir, variant.Name()), func(w io.Writer) error {
+		img, err := c.LoadImage(source)
+		if err != nil {
+			return err
+		}
+
+		width, height := variant.Width, variant.Height
+		if width == 0 {
+			width = img.Bounds().Dx()
+		}
+		if height == 0 {
+			height = img.Bounds().Dy()
+		}
+		return Encode(w, Fit(img, width, height), variant.Format)
+	})
+}
+
+// InvalidateVariants deletes every variant of the stored file at source
+func (c *Cache) InvalidateVariants(source string) error {
+	projectID, dir, err := variantDir(source)
+	if err != nil {
+		return err
+	}
+	return c.storage.DeleteDir(storage.Key(projectID, storage.StorageTypeDerived, dir))
+}
+
+// Encode writes img in format. JPEG has no transparency, so images are
+// flattened onto white first.
+func Encode(w io.Writer, img image.Image, format Format) error {
+	switch format {
+	case FormatWebP:
+		if err := EncodeWebP(w, img); err != nil {
+			return fmt.Errorf("failed to encode image: %w", err)
+		}
+		return nil
+	case FormatJPEG:
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Rect, img, img.Bounds().Min, draw.Over)
+		if err := jpeg.Encode(w, flat, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return fmt.Errorf("failed to encode image: %w", err)
+		}
+		return nil
+	default:
+		return EncodePNG(w, img)
+	}
+}
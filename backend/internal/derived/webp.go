@@ -0,0 +1,444 @@
+package derived
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+)
+
+/*
+EncodeWebP writes img as a lossless WebP (VP8L). It keeps to the parts of the
+format that pay off for screenshots: the subtract green transform, runs that
+repeat the pixel to the left or the row above, and one set of Huffman codes
+for the whole image.
+*/
+func EncodeWebP(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > webpMaxSize || height > webpMaxSize {
+		return fmt.Errorf("webp images must be between 1 and %d pixels wide and high", webpMaxSize)
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Rect, img, bounds.Min, draw.Src)
+
+	// Pixels as ARGB with green subtracted from red and blue
+	pixels := make([]uint32, width*height)
+	alpha := false
+	for i := range pixels {
+		p := nrgba.Pix[i*4 : i*4+4]
+		r, g, b, a := p[0], p[1], p[2], p[3]
+		pixels[i] = uint32(a)<<24 | uint32(r-g)<<16 | uint32(g)<<8 | uint32(b-g)
+		alpha = alpha || a != 255
+	}
+
+	symbols := webpSymbols(pixels, width)
+
+	var histograms [5][]int
+	for i, size := range webpAlphabetSizes {
+		histograms[i] = make([]int, size)
+	}
+	for _, s := range symbols {
+		if s.length > 0 {
+			prefix, _, _ := webpPrefix(s.length)
+			histograms[0][256+prefix]++
+			prefix, _, _ = webpPrefix(s.distance)
+			histograms[4][prefix]++
+			continue
+		}
+		histograms[0][s.argb>>8&0xff]++
+		histograms[1][s.argb>>16&0xff]++
+		histograms[2][s.argb&0xff]++
+		histograms[3][s.argb>>24]++
+	}
+
+	var bw webpBitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(boolBit(alpha), 1)
+	bw.write(0, 3) // version
+
+	bw.write(1, 1) // a transform follows
+	bw.write(2, 2) // subtract green
+	bw.write(0, 1) // no more transforms
+	bw.write(0, 1) // no colour cache
+	bw.write(0, 1) // one group of prefix codes
+
+	var codes [5]webpCode
+	for i, histogram := range histograms {
+		codes[i] = bw.writePrefixCode(histogram)
+	}
+
+	for _, s := range symbols {
+		if s.length > 0 {
+			prefix, extraBits, extra := webpPrefix(s.length)
+			codes[0].write(&bw, 256+prefix)
+			bw.write(extra, extraBits)
+			prefix, extraBits, extra = webpPrefix(s.distance)
+			codes[4].write(&bw, prefix)
+			bw.write(extra, extraBits)
+			continue
+		}
+		codes[0].write(&bw, int(s.argb>>8&0xff))
+		codes[1].write(&bw, int(s.argb>>16&0xff))
+		codes[2].write(&bw, int(s.argb&0xff))
+		codes[3].write(&bw, int(s.argb>>24))
+	}
+
+	data := bw.bytes()
+	padded := len(data) + len(data)%2
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+8+padded))
+	out.WriteString("WEBPVP8L")
+	binary.Write(&out, binary.LittleEndian, uint32(len(data)))
+	out.Write(data)
+	if padded > len(data) {
+		out.WriteByte(0)
+	}
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+const (
+	webpMaxSize = 16384
+	// webpMaxRun is the longest backward reference VP8L allows
+	webpMaxRun = 4096
+	// webpMinRun is the shortest run worth coding as a backward reference
+	webpMinRun = 3
+	// Distance codes for the pixel above and the pixel to the left
+	webpDistanceAbove = 1
+	webpDistanceLeft  = 2
+)
+
+// webpAlphabetSizes are the sizes of the green (with run lengths), red, blue,
+// alpha and distance alphabets
+var webpAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// webpCodeLengthOrder is the order code length code lengths are written in
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpSymbol is a literal pixel, or when length is set a run copying the
+// pixels at distance
+type webpSymbol struct {
+	argb     uint32
+	length   int
+	distance int
+}
+
+// webpSymbols turns pixels into literals and runs repeating the pixel to the
+// left or the row above
+func webpSymbols(pixels []uint32, width int) []webpSymbol {
+	var symbols []webpSymbol
+	matching := func(i, offset int) int {
+		n := 0
+		for i+n < len(pixels) && n < webpMaxRun && pixels[i+n] == pixels[i+n-offset] {
+			n++
+		}
+		return n
+	}
+
+	for i := 0; i < len(pixels); {
+		left, above := 0, 0
+		if i >= 1 {
+			left = matching(i, 1)
+		}
+		if i >= width {
+			above = matching(i, width)
+		}
+
+		switch {
+		case above >= webpMinRun && above >= left:
+			symbols = append(symbols, webpSymbol{length: above, distance: webpDistanceAbove})
+			i += above
+		case left >= webpMinRun:
+			symbols = append(symbols, webpSymbol{length: left, distance: webpDistanceLeft})
+			i += left
+		default:
+			symbols = append(symbols, webpSymbol{argb: pixels[i]})
+			i++
+		}
+	}
+	return symbols
+}
+
+// webpPrefix splits a run length or distance code into its prefix symbol and
+// the extra bits that follow it
+func webpPrefix(value int) (prefix, extraBits int, extra uint32) {
+	if value <= 4 {
+		return value - 1, 0, 0
+	}
+	value--
+	highest := bits.Len(uint(value)) - 1
+	second := (value >> (highest - 1)) & 1
+	extraBits = highest - 1
+	return 2*highest + second, extraBits, uint32(value & (1<<extraBits - 1))
+}
+
+// webpCode is a canonical prefix code, codes are stored bit reversed to be
+// written least significant bit first
+type webpCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (c webpCode) write(bw *webpBitWriter, symbol int) {
+	bw.write(c.codes[symbol], c.lengths[symbol])
+}
+
+// newWebPCode builds the canonical code for a set of code lengths
+func newWebPCode(lengths []int) webpCode {
+	code := webpCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+
+	var counts [16]int
+	for _, length := range lengths {
+		counts[length]++
+	}
+	counts[0] = 0
+
+	var next [16]uint32
+	for length := 1; length < 16; length++ {
+		next[length] = (next[length-1] + uint32(counts[length-1])) << 1
+	}
+
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		code.codes[symbol] = bits.Reverse32(next[length]) >> (32 - length)
+		next[length]++
+	}
+	return code
+}
+
+/*
+writePrefixCode writes the code for a histogram and returns it. Codes of one
+or two small symbols use the simple form, where a single symbol takes no bits
+at all. Everything else gets Huffman code lengths, themselves Huffman coded.
+*/
+func (bw *webpBitWriter) writePrefixCode(histogram []int) webpCode {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		lengths := make([]int, len(histogram))
+		if len(used) == 0 {
+			used = []int{0}
+		}
+
+		bw.write(1, 1) // simple code
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newWebPCode(lengths)
+	}
+
+	lengths := huffmanLengths(histogram, 15)
+
+	// Code lengths as symbols 0 to 15, with 17 and 18 for runs of zeroes
+	type lengthSymbol struct {
+		symbol, extraBits int
+		extra             uint32
+	}
+	var sequence []lengthSymbol
+	for i := 0; i < len(lengths); {
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == lengths[i] {
+			run++
+		}
+
+		if lengths[i] != 0 || run < 3 {
+			sequence = append(sequence, lengthSymbol{symbol: lengths[i]})
+			i++
+			continue
+		}
+
+		run = min(run, 138)
+		if run <= 10 {
+			sequence = append(sequence, lengthSymbol{symbol: 17, extraBits: 3, extra: uint32(run - 3)})
+		} else {
+			sequence = append(sequence, lengthSymbol{symbol: 18, extraBits: 7, extra: uint32(run - 11)})
+		}
+		i += run
+	}
+
+	lengthHistogram := make([]int, 19)
+	for _, s := range sequence {
+		lengthHistogram[s.symbol]++
+	}
+	// A code with a single symbol would be read as taking no bits
+	if nonZero(lengthHistogram) == 1 {
+		if lengthHistogram[0] == 0 {
+			lengthHistogram[0] = 1
+		} else {
+			lengthHistogram[1] = 1
+		}
+	}
+	lengthLengths := huffmanLengths(lengthHistogram, 7)
+	lengthCode := newWebPCode(lengthLengths)
+
+	count := len(webpCodeLengthOrder)
+	for count > 4 && lengthLengths[webpCodeLengthOrder[count-1]] == 0 {
+		count--
+	}
+
+	bw.write(0, 1) // normal code
+	bw.write(uint32(count-4), 4)
+	for _, symbol := range webpCodeLengthOrder[:count] {
+		bw.write(uint32(lengthLengths[symbol]), 3)
+	}
+	bw.write(0, 1) // every symbol's length follows
+	for _, s := range sequence {
+		lengthCode.write(bw, s.symbol)
+		bw.write(s.extra, s.extraBits)
+	}
+
+	return newWebPCode(lengths)
+}
+
+func nonZero(counts []int) int {
+	n := 0
+	for _, count := range counts {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+huffmanLengths returns Huffman code lengths for a histogram, no longer than
+maxLength. When the optimal code is too deep the counts are flattened and the
+code rebuilt. Symbols that never occur get length 0.
+*/
+func huffmanLengths(histogram []int, maxLength int) []int {
+	counts := append([]int(nil), histogram...)
+
+	for {
+		lengths, deepest := huffmanTree(counts)
+		if deepest <= maxLength {
+			return lengths
+		}
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = (count + 1) / 2
+			}
+		}
+	}
+}
+
+type huffmanNode struct {
+	count       int
+	symbol      int
+	left, right *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
+
+// huffmanTree returns the code lengths of an optimal prefix code and its
+// longest length. A lone symbol gets length 1.
+func huffmanTree(counts []int) ([]int, int) {
+	lengths := make([]int, len(counts))
+
+	var nodes huffmanHeap
+	for symbol, count := range counts {
+		if count > 0 {
+			nodes = append(nodes, &huffmanNode{count: count, symbol: symbol})
+		}
+	}
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbol] = 1
+		return lengths, 1
+	}
+
+	heap.Init(&nodes)
+	for nodes.Len() > 1 {
+		a := heap.Pop(&nodes).(*huffmanNode)
+		b := heap.Pop(&nodes).(*huffmanNode)
+		heap.Push(&nodes, &huffmanNode{count: a.count + b.count, symbol: min(a.symbol, b.symbol), left: a, right: b})
+	}
+
+	deepest := 0
+	var walk func(node *huffmanNode, depth int)
+	walk = func(node *huffmanNode, depth int) {
+		if node.left == nil {
+			lengths[node.symbol] = depth
+			deepest = max(deepest, depth)
+			return
+		}
+		walk(node.left, depth+1)
+		walk(node.right, depth+1)
+	}
+	if nodes.Len() == 1 {
+		walk(nodes[0], 0)
+	}
+	return lengths, deepest
+}
+
+// webpBitWriter packs bits least significant first
+type webpBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits int
+}
+
+func (bw *webpBitWriter) write(value uint32, n int) {
+	bw.acc |= uint64(value&(1<<n-1)) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *webpBitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		return append(bw.buf, byte(bw.acc))
+	}
+	return bw.buf
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
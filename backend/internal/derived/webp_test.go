@@ -0,0 +1,161 @@
+package derived
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		img   *image.NRGBA
+		alpha bool
+	}{
+		{name: "single pixel", img: filled(1, 1, color.NRGBA{R: 12, G: 200, B: 99, A: 255})},
+		{name: "single colour", img: filled(64, 48, color.NRGBA{R: 255, G: 255, B: 255, A: 255})},
+		{name: "single translucent colour", img: filled(33, 17, color.NRGBA{R: 40, G: 80, B: 120, A: 128}), alpha: true},
+		{name: "opaque screenshot", img: screenshot(257, 190, false)},
+		{name: "translucent screenshot", img: screenshot(190, 257, true), alpha: true},
+		{name: "opaque noise", img: noise(97, 61, false)},
+		{name: "translucent noise", img: noise(61, 97, true), alpha: true},
+		{name: "one row", img: screenshot(5000, 1, false)},
+		{name: "one column", img: screenshot(1, 5000, false)},
+		// Rows wider than the longest backward reference, split into several runs
+		{name: "large", img: screenshot(1920, 2400, false)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, tt.img); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := webp.DecodeConfig(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("DecodeConfig: %v", err)
+			}
+			if config.Width != tt.img.Rect.Dx() || config.Height != tt.img.Rect.Dy() {
+				t.Errorf("config is %dx%d, want %dx%d", config.Width, config.Height, tt.img.Rect.Dx(), tt.img.Rect.Dy())
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			got, ok := decoded.(*image.NRGBA)
+			if !ok {
+				t.Fatalf("decoded a %T, want *image.NRGBA", decoded)
+			}
+			// The alpha hint follows the signature byte and the 14 bit width and height
+			if alpha := binary.LittleEndian.Uint32(buf.Bytes()[21:25])>>28&1 == 1; alpha != tt.alpha {
+				t.Errorf("alpha hint is %v, want %v", alpha, tt.alpha)
+			}
+			assertSamePixels(t, got, tt.img)
+		})
+	}
+}
+
+func TestEncodeWebPOffsetBounds(t *testing.T) {
+	img := screenshot(40, 30, true)
+	sub := img.SubImage(image.Rect(5, 7, 35, 27))
+
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, sub); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	want := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	for y := range 20 {
+		for x := range 30 {
+			want.SetNRGBA(x, y, img.NRGBAAt(x+5, y+7))
+		}
+	}
+	assertSamePixels(t, decoded.(*image.NRGBA), want)
+}
+
+func TestEncodeWebPRejectsSize(t *testing.T) {
+	for _, rect := range []image.Rectangle{
+		image.Rect(0, 0, 0, 10),
+		image.Rect(0, 0, 10, 0),
+		image.Rect(0, 0, webpMaxSize+1, 1),
+	} {
+		if err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(rect)); err == nil {
+			t.Errorf("EncodeWebP of a %dx%d image succeeded", rect.Dx(), rect.Dy())
+		}
+	}
+}
+
+func filled(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// screenshot draws flat bands, repeated rows and a few noisy patches, the mix
+// of runs and literals a page screenshot has
+func screenshot(width, height int, translucent bool) *image.NRGBA {
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		band := uint8(y / 24 * 37)
+		for x := range width {
+			c := color.NRGBA{R: band, G: 255 - band, B: uint8(x / 50 * 11), A: 255}
+			if (x/16+y/16)%7 == 0 {
+				c = color.NRGBA{R: uint8(rng.UintN(256)), G: uint8(rng.UintN(256)), B: uint8(rng.UintN(256)), A: 255}
+			}
+			if translucent {
+				c.A = uint8(x * 255 / max(width-1, 1))
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// noise has every pixel different, leaving the encoder nothing but literals
+func noise(width, height int, translucent bool) *image.NRGBA {
+	rng := rand.New(rand.NewPCG(3, 4))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = uint8(rng.UintN(256))
+		img.Pix[i+1] = uint8(rng.UintN(256))
+		img.Pix[i+2] = uint8(rng.UintN(256))
+		img.Pix[i+3] = 255
+		if translucent {
+			img.Pix[i+3] = uint8(rng.UintN(256))
+		}
+	}
+	return img
+}
+
+func assertSamePixels(t *testing.T, got, want *image.NRGBA) {
+	t.Helper()
+
+	if got.Rect.Size() != want.Rect.Size() {
+		t.Fatalf("decoded %v, want %v", got.Rect.Size(), want.Rect.Size())
+	}
+	for y := range want.Rect.Dy() {
+		for x := range want.Rect.Dx() {
+			g := got.NRGBAAt(got.Rect.Min.X+x, got.Rect.Min.Y+y)
+			w := want.NRGBAAt(want.Rect.Min.X+x, want.Rect.Min.Y+y)
+			if g != w {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package diffimage
+
+import (
+	"hash/fnv"
+	"image"
+	"slices"
+)
+
+// Alignment is how images of different sizes are lined up before comparing
+type Alignment string
+
+const (
+	// AlignTopLeft lines the images up by their top left corners, the area
+	// only one of them covers is added or removed
+	AlignTopLeft Alignment = "top-left"
+	// AlignContent matches identical rows between the images so content
+	// pushed down by inserted rows, or pulled up by removed ones, is still
+	// compared with itself
+	AlignContent Alignment = "content"
+)
+
+// maxAlignEdits bounds the rows content alignment will insert or remove
+// before falling back to top left alignment
+const maxAlignEdits = 1000
+
+// ValidAlignment reports whether alignment is a known alignment
+func ValidAlignment(alignment Alignment) bool {
+	return alignment == AlignTopLeft || alignment == AlignContent
+}
+
+// rowPair is a row of the comparison: a base image row and the feature image
+// row compared with it, -1 when only the other image has the row
+type rowPair struct {
+	base, feature int
+}
+
+// alignRows pairs up the rows of two images, top to bottom
+func alignRows(base, feature *image.NRGBA, alignment Alignment) []rowPair {
+	if alignment == AlignContent {
+		if rows, ok := matchRows(rowHashes(base, feature), rowHashes(feature, base)); ok {
+			return rows
+		}
+	}
+
+	height := max(base.Rect.Dy(), feature.Rect.Dy())
+	rows := make([]rowPair, height)
+	for y := range rows {
+		rows[y] = rowPair{base: -1, feature: -1}
+		if y < base.Rect.Dy() {
+			rows[y].base = y
+		}
+		if y < feature.Rect.Dy() {
+			rows[y].feature = y
+		}
+	}
+	return rows
+}
+
+// sourceArea is the part of one image shown within bounds of the aligned
+// comparison, row picks that image's row from each pair
+func sourceArea(bounds image.Rectangle, rows []rowPair, row func(rowPair) int, width int) image.Rectangle {
+	var area image.Rectangle
+	if bounds.Min.X >= width {
+		return area
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if source := row(rows[y]); source >= 0 {
+			line := image.Rect(bounds.Min.X, source, min(bounds.Max.X, width), source+1)
+			if area.Empty() {
+				area = line
+			} else {
+				area = area.Union(line)
+			}
+		}
+	}
+	return area
+}
+
+// rowHashes hashes each row of img, over the width it shares with other
+func rowHashes(img, other *image.NRGBA) []uint64 {
+	width := min(img.Rect.Dx(), other.Rect.Dx())
+	hashes := make([]uint64, img.Rect.Dy())
+	for y := range hashes {
+		h := fnv.New64a()
+		h.Write(img.Pix[y*img.Stride : y*img.Stride+width*4])
+		hashes[y] = h.Sum64()
+	}
+	return hashes
+}
+
+/*
+matchRows aligns two sequences of row hashes with Myers' diff algorithm.
+Matching rows are paired, and within each run of edits removed rows are
+paired with inserted ones so rows changed in place are still compared. The
+rows left over are only in one image. Reports false when the images need more
+than maxAlignEdits edits.
+*/
+func matchRows(a, b []uint64) ([]rowPair, bool) {
+	// Common leading and trailing rows need no searching
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	middle, ok := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if !ok {
+		return nil, false
+	}
+
+	rows := make([]rowPair, 0, max(len(a), len(b)))
+	for i := 0; i < prefix; i++ {
+		rows = append(rows, rowPair{base: i, feature: i})
+	}
+
+	var removed, inserted []int
+	flush := func() {
+		paired := min(len(removed), len(inserted))
+		for i := 0; i < paired; i++ {
+			rows = append(rows, rowPair{base: removed[i], feature: inserted[i]})
+		}
+		for _, i := range removed[paired:] {
+			rows = append(rows, rowPair{base: i, feature: -1})
+		}
+		for _, j := range inserted[paired:] {
+			rows = append(rows, rowPair{base: -1, feature: j})
+		}
+		removed, inserted = removed[:0], inserted[:0]
+	}
+
+	for _, pair := range middle {
+		switch {
+		case pair.base >= 0 && pair.feature >= 0:
+			flush()
+			rows = append(rows, rowPair{base: pair.base + prefix, feature: pair.feature + prefix})
+		case pair.base >= 0:
+			removed = append(removed, pair.base+prefix)
+		default:
+			inserted = append(inserted, pair.feature+prefix)
+		}
+	}
+	flush()
+
+	for i := 0; i < suffix; i++ {
+		rows = append(rows, rowPair{base: len(a) - suffix + i, feature: len(b) - suffix + i})
+	}
+
+	return rows, true
+}
+
+// myers returns the shortest edit script turning a into b as row pairs:
+// matches pair both rows, removals have no feature row and insertions no base row
+func myers(a, b []uint64) ([]rowPair, bool) {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxAlignEdits)
+	offset := limit + 1
+
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, slices.Clone(v))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, offset, n, m), true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// backtrack walks the saved Myers frontiers back from the end of both
+// sequences to recover the edit script
+func backtrack(trace [][]int, offset, x, y int) []rowPair {
+	var script []rowPair
+
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			script = append(script, rowPair{base: x, feature: y})
+		}
+
+		if x == prevX {
+			script = append(script, rowPair{base: -1, feature: prevY})
+		} else {
+			script = append(script, rowPair{base: prevX, feature: -1})
+		}
+		x, y = prevX, prevY
+	}
+
+	for x > 0 && y > 0 {
+		x--
+		y--
+		script = append(script, rowPair{base: x, feature: y})
+	}
+
+	slices.Reverse(script)
+	return script
+}
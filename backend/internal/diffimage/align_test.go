@@ -0,0 +1,238 @@
+package diffimage
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// seq turns each character of s into a row hash
+func seq(s string) []uint64 {
+	hashes := make([]uint64, len(s))
+	for i := range s {
+		hashes[i] = uint64(s[i])
+	}
+	return hashes
+}
+
+// distinct returns n row hashes unlike each other and any other call's
+func distinct(from, n int) []uint64 {
+	hashes := make([]uint64, n)
+	for i := range hashes {
+		hashes[i] = uint64(from + i)
+	}
+	return hashes
+}
+
+func pairs(ps ...[2]int) []rowPair {
+	rows := make([]rowPair, len(ps))
+	for i, p := range ps {
+		rows[i] = rowPair{base: p[0], feature: p[1]}
+	}
+	return rows
+}
+
+func TestMatchRows(t *testing.T) {
+	tests := []struct {
+		name          string
+		base, feature string
+		want          []rowPair
+	}{
+		{name: "identical", base: "abc", feature: "abc", want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, 2})},
+		{name: "both empty", base: "", feature: "", want: pairs()},
+		{name: "base empty", base: "", feature: "ab", want: pairs([2]int{-1, 0}, [2]int{-1, 1})},
+		{name: "feature empty", base: "ab", feature: "", want: pairs([2]int{0, -1}, [2]int{1, -1})},
+		{
+			name: "inserted in the middle", base: "abc", feature: "abXc",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{-1, 2}, [2]int{2, 3}),
+		},
+		{
+			name: "inserted at the top", base: "abc", feature: "XYabc",
+			want: pairs([2]int{-1, 0}, [2]int{-1, 1}, [2]int{0, 2}, [2]int{1, 3}, [2]int{2, 4}),
+		},
+		{
+			name: "removed from the middle", base: "abXc", feature: "abc",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, -1}, [2]int{3, 2}),
+		},
+		{
+			name: "removed from the bottom", base: "abcde", feature: "abc",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, 2}, [2]int{3, -1}, [2]int{4, -1}),
+		},
+		{
+			name: "changed in place", base: "abc", feature: "aXc",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, 2}),
+		},
+		{
+			name: "several rows changed in place", base: "abcd", feature: "aXYd",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, 2}, [2]int{3, 3}),
+		},
+		{
+			name: "changed and grown", base: "abc", feature: "aXYc",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{-1, 2}, [2]int{2, 3}),
+		},
+		{
+			name: "changed and shrunk", base: "aXYc", feature: "abc",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, -1}, [2]int{3, 2}),
+		},
+		{
+			name: "inserted among repeated rows", base: "aaab", feature: "aaaab",
+			want: pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, 2}, [2]int{-1, 3}, [2]int{3, 4}),
+		},
+		{
+			name: "separate insertion and removal", base: "abcdef", feature: "aXbcdf",
+			want: pairs([2]int{0, 0}, [2]int{-1, 1}, [2]int{1, 2}, [2]int{2, 3}, [2]int{3, 4}, [2]int{4, -1}, [2]int{5, 5}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchRows(seq(tt.base), seq(tt.feature))
+			if !ok {
+				t.Fatal("matchRows gave up")
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matchRows(%q, %q) = %v, want %v", tt.base, tt.feature, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRowsEditLimit(t *testing.T) {
+	// Shared rows above and below the changes aren't edits
+	shared := distinct(0, 3*maxAlignEdits)
+	a := append(append(slices.Clone(shared), distinct(10000, maxAlignEdits/2)...), shared...)
+	b := append(append(slices.Clone(shared), distinct(20000, maxAlignEdits/2)...), shared...)
+	if _, ok := matchRows(a, b); !ok {
+		t.Errorf("matchRows gave up on %d edits between long shared runs", maxAlignEdits)
+	}
+
+	a = append(append(slices.Clone(shared), distinct(10000, maxAlignEdits/2+1)...), shared...)
+	if rows, ok := matchRows(a, b); ok {
+		t.Errorf("matchRows with %d edits = %d rows, want it to give up", maxAlignEdits+1, len(rows))
+	}
+}
+
+// checkScript fails unless script is an edit script turning a into b with
+// edits insertions and removals
+func checkScript(t *testing.T, a, b []uint64, script []rowPair, edits int) {
+	t.Helper()
+
+	i, j, made := 0, 0, 0
+	for _, pair := range script {
+		switch {
+		case pair.base >= 0 && pair.feature >= 0:
+			if pair.base != i || pair.feature != j || a[i] != b[j] {
+				t.Fatalf("script matches %v at base %d, feature %d", pair, i, j)
+			}
+			i, j = i+1, j+1
+		case pair.base >= 0:
+			if pair.base != i {
+				t.Fatalf("script removes %v at base %d", pair, i)
+			}
+			i, made = i+1, made+1
+		case pair.feature >= 0:
+			if pair.feature != j {
+				t.Fatalf("script inserts %v at feature %d", pair, j)
+			}
+			j, made = j+1, made+1
+		default:
+			t.Fatalf("script holds an empty pair")
+		}
+	}
+	if i != len(a) || j != len(b) {
+		t.Fatalf("script ends at base %d, feature %d, want %d, %d", i, j, len(a), len(b))
+	}
+	if made != edits {
+		t.Errorf("script makes %d edits, want %d", made, edits)
+	}
+}
+
+func TestMyers(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"abc", "xyz", 6},
+		// The example from Myers' paper
+		{"abcabba", "cbabac", 5},
+		{"abcdef", "defabc", 6},
+		{"xaxbxc", "abc", 3},
+		{"aaaa", "aa", 2},
+	}
+
+	for _, tt := range tests {
+		a, b := seq(tt.a), seq(tt.b)
+		script, ok := myers(a, b)
+		if !ok {
+			t.Errorf("myers(%q, %q) gave up", tt.a, tt.b)
+			continue
+		}
+		checkScript(t, a, b, script, tt.edits)
+	}
+}
+
+func TestMyersEditLimit(t *testing.T) {
+	a, b := distinct(0, maxAlignEdits/2), distinct(maxAlignEdits, maxAlignEdits/2)
+	script, ok := myers(a, b)
+	if !ok {
+		t.Fatalf("myers gave up on exactly %d edits", maxAlignEdits)
+	}
+	checkScript(t, a, b, script, maxAlignEdits)
+
+	if _, ok := myers(append(a, 1<<40), b); ok {
+		t.Errorf("myers didn't give up on %d edits", maxAlignEdits+1)
+	}
+}
+
+// rowsImage returns an image one pixel wide with a colour per value, so
+// equal values give equal rows
+func rowsImage(values ...int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, len(values)))
+	for y, v := range values {
+		img.SetNRGBA(0, y, color.NRGBA{R: uint8(v), G: uint8(v >> 8), B: uint8(v >> 16), A: 255})
+	}
+	return img
+}
+
+// movedBlock returns a base of rows p then q and a feature of new rows then
+// p, so the p rows match only when content alignment doesn't give up
+func movedBlock(n int) (*image.NRGBA, *image.NRGBA) {
+	var base, feature []int
+	for i := range n {
+		base = append(base, i)
+		feature = append(feature, 2*n+i)
+	}
+	for i := range n {
+		base = append(base, n+i)
+		feature = append(feature, i)
+	}
+	return rowsImage(base...), rowsImage(feature...)
+}
+
+func TestAlignRows(t *testing.T) {
+	base, feature := rowsImage(10, 20, 30), rowsImage(10, 99, 20, 30)
+
+	if got, want := alignRows(base, feature, AlignContent), pairs([2]int{0, 0}, [2]int{-1, 1}, [2]int{1, 2}, [2]int{2, 3}); !slices.Equal(got, want) {
+		t.Errorf("content alignment = %v, want %v", got, want)
+	}
+	if got, want := alignRows(base, feature, AlignTopLeft), pairs([2]int{0, 0}, [2]int{1, 1}, [2]int{2, 2}, [2]int{-1, 3}); !slices.Equal(got, want) {
+		t.Errorf("top left alignment = %v, want %v", got, want)
+	}
+
+	// A moved block within the edit limit is matched where it moved to
+	base, feature = movedBlock(10)
+	if got := alignRows(base, feature, AlignContent); slices.Equal(got, alignRows(base, feature, AlignTopLeft)) {
+		t.Error("content alignment of a moved block matched rows top left")
+	}
+
+	// Beyond the limit content alignment falls back to top left
+	base, feature = movedBlock(maxAlignEdits/2 + 1)
+	if got := alignRows(base, feature, AlignContent); !slices.Equal(got, alignRows(base, feature, AlignTopLeft)) {
+		t.Error("content alignment needing too many edits didn't fall back to top left")
+	}
+}
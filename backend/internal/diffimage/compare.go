@@ -0,0 +1,385 @@
+package diffimage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// maxDelta is the largest value colorDelta can return
+const maxDelta = 35215.0
+
+var (
+	diffColor        = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+	antialiasedColor = color.NRGBA{R: 255, G: 200, B: 0, A: 255}
+	// addedColor marks area only the feature image covers, removedColor area
+	// only the base image covers
+	addedColor   = color.NRGBA{R: 0, G: 180, B: 0, A: 255}
+	removedColor = color.NRGBA{R: 0, G: 100, B: 255, A: 255}
+)
+
+type DiffOptions struct {
+	// Threshold is the color difference (0 to 1) above which two pixels are
+	// considered different. Lower is more precise.
+	Threshold float64
+	// AcceptablePercentage is the share of differing pixels (0 to 100) at or
+	// below which the images are still treated as equal
+	AcceptablePercentage float64
+	// IgnoreAntialiasing skips pixels that look like anti-aliasing artifacts
+	IgnoreAntialiasing bool
+	// IgnoreRegions are rectangles, in base image coordinates, whose pixels are
+	// left out of the diff count and the diff image
+	IgnoreRegions []image.Rectangle
+	// TransparentBackground leaves unchanged pixels out of the diff image so
+	// it can be overlaid on the base image
+	TransparentBackground bool
+	// ColorMetric is how pixel colours are compared, YIQ when empty. With
+	// CIEDE2000 Threshold is a share of the largest difference, so 0.1 allows
+	// a delta E of 10.
+	ColorMetric ColorMetric
+	// Criterion decides whether the images count as changed, CriterionPixels
+	// when empty
+	Criterion ChangeCriterion
+	// MinSSIM is the structural similarity (0 to 1) below which images are
+	// changed under CriterionSSIM
+	MinSSIM float64
+	// MinRegionPixels is the size of the smallest connected region of
+	// differing pixels that counts as a change under CriterionRegions
+	MinRegionPixels int
+	// Alignment is how images of different sizes are lined up, AlignTopLeft
+	// when empty
+	Alignment Alignment
+	// MeasureSSIM measures SSIM even when the criterion doesn't use it
+	MeasureSSIM bool
+	// FindRegions groups differing pixels into Regions and Clusters even when
+	// the criterion doesn't use them
+	FindRegions bool
+}
+
+// DefaultDiffOptions returns the options used when nothing is configured
+func DefaultDiffOptions() DiffOptions {
+	return DiffOptions{
+		Threshold:       0.1,
+		ColorMetric:     ColorMetricYIQ,
+		Criterion:       CriterionPixels,
+		MinSSIM:         0.99,
+		MinRegionPixels: 1,
+		Alignment:       AlignTopLeft,
+	}
+}
+
+// Comparison is the result of comparing two decoded images
+type Comparison struct {
+	// Equal is true when no pixels differ or the differences don't meet the
+	// change criterion. Images of different sizes are never equal.
+	Equal bool
+	// Resized is true when the images have different dimensions
+	Resized bool
+	// BaseSize and FeatureSize are the dimensions of the compared images
+	BaseSize, FeatureSize image.Point
+	DiffPixelsCount       int
+	// DiffPercentage is DiffPixelsCount relative to the compared area
+	DiffPercentage float64
+	// SSIM is the structural similarity of the images' luminance, 1 when they
+	// look the same. It's NaN unless CriterionSSIM or MeasureSSIM asked for it.
+	SSIM float64
+	// Regions are the connected areas of differing pixels, largest first.
+	// Like Clusters they're only found for CriterionRegions or FindRegions.
+	Regions []Region
+	// Clusters group differing pixels close to each other, largest first
+	Clusters []Cluster
+	// Image highlights differing pixels in red over the base image, or over
+	// nothing when TransparentBackground is set. When the sizes differ it
+	// spans both images as aligned, with added area in green and removed area
+	// in blue.
+	Image *image.NRGBA
+}
+
+// buildMask marks every pixel covered by an ignore region, nil means nothing is ignored
+func buildMask(bounds image.Rectangle, regions []image.Rectangle) ([]bool, int) {
+	if len(regions) == 0 {
+		return nil, 0
+	}
+
+	width := bounds.Dx()
+	mask := make([]bool, width*bounds.Dy())
+	masked := 0
+	for _, region := range regions {
+		region = region.Intersect(bounds)
+		for y := region.Min.Y; y < region.Max.Y; y++ {
+			for x := region.Min.X; x < region.Max.X; x++ {
+				if !mask[y*width+x] {
+					mask[y*width+x] = true
+					masked++
+				}
+			}
+		}
+	}
+	return mask, masked
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	return nrgba
+}
+
+/*
+Compare diffs two images pixel by pixel using the YIQ color space, the same
+measure imgdiff uses, or CIEDE2000, with optional anti-aliasing detection.
+Images of different sizes are lined up by the options' alignment first, pixels
+only one image covers count as different and pixels inside ignore regions are
+skipped entirely. Differing pixels are grouped into connected regions and the
+images' structural similarity is measured when the criterion or the options
+ask for them.
+*/
+func Compare(base, feature image.Image, options DiffOptions) Comparison {
+	img1 := toNRGBA(base)
+	img2 := toNRGBA(feature)
+
+	baseWidth, featureWidth := img1.Rect.Dx(), img2.Rect.Dx()
+	rows := alignRows(img1, img2, options.Alignment)
+	width, height := max(baseWidth, featureWidth), len(rows)
+
+	similar := func(p1, p2 color.NRGBA) bool {
+		return colorDelta(p1, p2) <= maxDelta*options.Threshold*options.Threshold
+	}
+	if options.ColorMetric == ColorMetricCIEDE2000 {
+		similar = func(p1, p2 color.NRGBA) bool {
+			return pixelDeltaE(p1, p2) <= options.Threshold*100
+		}
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	mask, masked := buildMask(img1.Rect, options.IgnoreRegions)
+	changed := make([]bool, width*height)
+
+	workers := runtime.NumCPU()
+	counts := make([]int, workers)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for y := i; y < height; y += workers {
+				row := rows[y]
+				for x := 0; x < width; x++ {
+					at := y*width + x
+					inBase := row.base >= 0 && x < baseWidth
+					inFeature := row.feature >= 0 && x < featureWidth
+
+					switch {
+					case inBase && mask != nil && mask[row.base*baseWidth+x]:
+						continue
+					case !inFeature:
+						if inBase {
+							out.SetNRGBA(x, y, removedColor)
+							changed[at] = true
+							counts[i]++
+						}
+						continue
+					case !inBase:
+						out.SetNRGBA(x, y, addedColor)
+						changed[at] = true
+						counts[i]++
+						continue
+					}
+
+					p1 := img1.NRGBAAt(x, row.base)
+					p2 := img2.NRGBAAt(x, row.feature)
+
+					if p1 == p2 || similar(p1, p2) {
+						if !options.TransparentBackground {
+							out.SetNRGBA(x, y, p1)
+						}
+						continue
+					}
+
+					if options.IgnoreAntialiasing &&
+						(antialiased(img1, img2, x, row.base, row.feature) || antialiased(img2, img1, x, row.feature, row.base)) {
+						out.SetNRGBA(x, y, antialiasedColor)
+						continue
+					}
+
+					out.SetNRGBA(x, y, diffColor)
+					changed[at] = true
+					counts[i]++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	diffPixels := 0
+	for _, count := range counts {
+		diffPixels += count
+	}
+
+	// Ignored pixels can't change, so they don't count towards the area either
+	percentage := 0.0
+	if total := width*height - masked; total > 0 {
+		percentage = float64(diffPixels) / float64(total) * 100
+	}
+
+	comparison := Comparison{
+		BaseSize:        img1.Rect.Size(),
+		FeatureSize:     img2.Rect.Size(),
+		DiffPixelsCount: diffPixels,
+		DiffPercentage:  percentage,
+		SSIM:            math.NaN(),
+		Image:           out,
+	}
+	if options.MeasureSSIM || options.Criterion == CriterionSSIM {
+		lumaBase, lumaFeature := lumaPlanes(img1, img2, rows, mask, width)
+		comparison.SSIM = ssim(lumaBase, lumaFeature, width, height)
+	}
+	if options.FindRegions || options.Criterion == CriterionRegions {
+		comparison.Regions = connectedRegions(changed, width, height)
+		comparison.Clusters = clusterRegions(changed, width, height)
+		for i := range comparison.Clusters {
+			cluster := &comparison.Clusters[i]
+			cluster.Base = sourceArea(cluster.Bounds, rows, func(row rowPair) int { return row.base }, baseWidth)
+			cluster.Feature = sourceArea(cluster.Bounds, rows, func(row rowPair) int { return row.feature }, featureWidth)
+		}
+	}
+	comparison.Resized = comparison.BaseSize != comparison.FeatureSize
+	comparison.Equal = !comparison.Resized && (diffPixels == 0 || !meetsCriterion(comparison, options))
+
+	return comparison
+}
+
+// meetsCriterion reports whether a comparison with differing pixels counts
+// as a change under the options' criterion
+func meetsCriterion(comparison Comparison, options DiffOptions) bool {
+	switch options.Criterion {
+	case CriterionSSIM:
+		return comparison.SSIM < options.MinSSIM
+	case CriterionRegions:
+		// Regions are ordered largest first
+		return len(comparison.Regions) > 0 && comparison.Regions[0].Pixels >= options.MinRegionPixels
+	default:
+		return comparison.DiffPercentage > options.AcceptablePercentage
+	}
+}
+
+// blend composites a channel over a white background
+func blend(c uint8, a float64) float64 {
+	return 255 + (float64(c)-255)*a
+}
+
+func yiq(p color.NRGBA) (float64, float64, float64) {
+	a := float64(p.A) / 255
+	r, g, b := blend(p.R, a), blend(p.G, a), blend(p.B, a)
+
+	y := r*0.29889531 + g*0.58662247 + b*0.11448223
+	i := r*0.59597799 - g*0.27417610 - b*0.32180189
+	q := r*0.21147017 - g*0.52261711 + b*0.31114694
+	return y, i, q
+}
+
+func colorDelta(p1, p2 color.NRGBA) float64 {
+	y1, i1, q1 := yiq(p1)
+	y2, i2, q2 := yiq(p2)
+
+	y, i, q := y1-y2, i1-i2, q1-q2
+	return 0.5053*y*y + 0.299*i*i + 0.1957*q*q
+}
+
+func brightnessDelta(p1, p2 color.NRGBA) float64 {
+	y1, _, _ := yiq(p1)
+	y2, _, _ := yiq(p2)
+	return y1 - y2
+}
+
+/*
+antialiased reports whether the pixel at x, y1 in img looks like part of an
+anti-aliased edge: it sits between a darker and a brighter neighbour, and
+those neighbours belong to flat areas in both images. otherY1 is the row of
+other lined up with y1. This follows V. Vyšniauskas, "Anti-aliased Pixel
+and Intensity Slope Detector" (2009), as implemented by pixelmatch.
+*/
+func antialiased(img, other *image.NRGBA, x1, y1, otherY1 int) bool {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	x0, y0 := max(x1-1, 0), max(y1-1, 0)
+	x2, y2 := min(x1+1, width-1), min(y1+1, height-1)
+
+	center := img.NRGBAAt(x1, y1)
+	zeroes := 0
+	if x1 == x0 || x1 == x2 || y1 == y0 || y1 == y2 {
+		zeroes = 1
+	}
+
+	var darkest, brightest float64
+	var minX, minY, maxX, maxY int
+
+	for x := x0; x <= x2; x++ {
+		for y := y0; y <= y2; y++ {
+			if x == x1 && y == y1 {
+				continue
+			}
+
+			delta := brightnessDelta(center, img.NRGBAAt(x, y))
+			switch {
+			case delta == 0:
+				zeroes++
+				if zeroes > 2 {
+					return false
+				}
+			case delta < darkest:
+				darkest, minX, minY = delta, x, y
+			case delta > brightest:
+				brightest, maxX, maxY = delta, x, y
+			}
+		}
+	}
+
+	// A pixel without both a darker and a brighter neighbour is not on a gradient
+	if darkest == 0 || brightest == 0 {
+		return false
+	}
+
+	dy := otherY1 - y1
+	return (hasManySiblings(img, minX, minY) && hasManySiblings(other, minX, minY+dy)) ||
+		(hasManySiblings(img, maxX, maxY) && hasManySiblings(other, maxX, maxY+dy))
+}
+
+// hasManySiblings reports whether a pixel has more than two identical neighbours
+func hasManySiblings(img *image.NRGBA, x1, y1 int) bool {
+	if !(image.Point{x1, y1}).In(img.Rect) {
+		return false
+	}
+
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	x0, y0 := max(x1-1, 0), max(y1-1, 0)
+	x2, y2 := min(x1+1, width-1), min(y1+1, height-1)
+
+	center := img.NRGBAAt(x1, y1)
+	zeroes := 0
+	if x1 == x0 || x1 == x2 || y1 == y0 || y1 == y2 {
+		zeroes = 1
+	}
+
+	for x := x0; x <= x2; x++ {
+		for y := y0; y <= y2; y++ {
+			if x == x1 && y == y1 {
+				continue
+			}
+			if img.NRGBAAt(x, y) == center {
+				zeroes++
+			}
+			if zeroes > 2 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
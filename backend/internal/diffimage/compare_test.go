@@ -0,0 +1,179 @@
+package diffimage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var (
+	black = color.NRGBA{A: 255}
+	white = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+func TestCompareThreshold(t *testing.T) {
+	base := filledImage(10, 10, color.NRGBA{R: 100, G: 100, B: 100, A: 255})
+
+	tests := []struct {
+		name      string
+		feature   color.NRGBA
+		threshold float64
+		diff      int
+	}{
+		{name: "identical", feature: color.NRGBA{R: 100, G: 100, B: 100, A: 255}, threshold: 0, diff: 0},
+		{name: "slightly lighter", feature: color.NRGBA{R: 104, G: 104, B: 104, A: 255}, threshold: 0.1, diff: 0},
+		{name: "slightly lighter at threshold 0", feature: color.NRGBA{R: 104, G: 104, B: 104, A: 255}, threshold: 0, diff: 100},
+		{name: "much lighter", feature: color.NRGBA{R: 180, G: 180, B: 180, A: 255}, threshold: 0.1, diff: 100},
+		{name: "much lighter at threshold 1", feature: color.NRGBA{R: 180, G: 180, B: 180, A: 255}, threshold: 1, diff: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comparison := Compare(base, filledImage(10, 10, tt.feature), DiffOptions{Threshold: tt.threshold})
+			if comparison.DiffPixelsCount != tt.diff {
+				t.Errorf("DiffPixelsCount = %d, want %d", comparison.DiffPixelsCount, tt.diff)
+			}
+			if comparison.Equal != (tt.diff == 0) {
+				t.Errorf("Equal = %v with %d differing pixels", comparison.Equal, comparison.DiffPixelsCount)
+			}
+		})
+	}
+}
+
+func TestCompareAcceptablePercentage(t *testing.T) {
+	base := filledImage(10, 10, white)
+	feature := filledImage(10, 10, white)
+	feature.SetNRGBA(3, 4, black)
+
+	for _, tt := range []struct {
+		acceptable float64
+		equal      bool
+	}{
+		{acceptable: 0, equal: false},
+		{acceptable: 0.5, equal: false},
+		{acceptable: 1, equal: true},
+	} {
+		comparison := Compare(base, feature, DiffOptions{Threshold: 0.1, AcceptablePercentage: tt.acceptable})
+		if comparison.DiffPixelsCount != 1 || comparison.DiffPercentage != 1 {
+			t.Fatalf("one pixel of 100: %d pixels, %v%%, want 1 and 1%%", comparison.DiffPixelsCount, comparison.DiffPercentage)
+		}
+		if comparison.Equal != tt.equal {
+			t.Errorf("acceptable %v%%: Equal = %v, want %v", tt.acceptable, comparison.Equal, tt.equal)
+		}
+	}
+}
+
+func TestCompareDiffImage(t *testing.T) {
+	base := filledImage(4, 4, white)
+	feature := filledImage(4, 4, white)
+	feature.SetNRGBA(1, 2, black)
+
+	comparison := Compare(base, feature, DiffOptions{Threshold: 0.1})
+	if got := comparison.Image.NRGBAAt(1, 2); got != diffColor {
+		t.Errorf("changed pixel = %v, want %v", got, diffColor)
+	}
+	if got := comparison.Image.NRGBAAt(0, 0); got != white {
+		t.Errorf("unchanged pixel = %v, want the base pixel %v", got, white)
+	}
+
+	comparison = Compare(base, feature, DiffOptions{Threshold: 0.1, TransparentBackground: true})
+	if got := comparison.Image.NRGBAAt(0, 0); got != (color.NRGBA{}) {
+		t.Errorf("unchanged pixel on a transparent background = %v, want transparent", got)
+	}
+}
+
+func TestCompareSmallerFeature(t *testing.T) {
+	// The bottom two rows of the base are missing from the feature
+	comparison := Compare(filledImage(5, 10, white), filledImage(5, 8, white), DiffOptions{Threshold: 0.1})
+	if comparison.DiffPixelsCount != 10 {
+		t.Errorf("DiffPixelsCount = %d, want the 10 pixels the feature lacks", comparison.DiffPixelsCount)
+	}
+}
+
+// edge draws a black left half and a white right half. With a grey column
+// between them the edge looks anti-aliased.
+func edge(width, height int, smooth bool) *image.NRGBA {
+	img := filledImage(width, height, white)
+	for y := range height {
+		for x := range width / 2 {
+			img.SetNRGBA(x, y, black)
+		}
+		if smooth {
+			img.SetNRGBA(width/2, y, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestCompareIgnoreAntialiasing(t *testing.T) {
+	base, feature := edge(10, 10, false), edge(10, 10, true)
+
+	if got := Compare(base, feature, DiffOptions{Threshold: 0.1}).DiffPixelsCount; got != 10 {
+		t.Errorf("smoothed edge: DiffPixelsCount = %d, want the 10 grey pixels", got)
+	}
+
+	comparison := Compare(base, feature, DiffOptions{Threshold: 0.1, IgnoreAntialiasing: true})
+	if comparison.DiffPixelsCount != 0 || !comparison.Equal {
+		t.Errorf("smoothed edge ignoring anti-aliasing: DiffPixelsCount = %d, want 0", comparison.DiffPixelsCount)
+	}
+	if got := comparison.Image.NRGBAAt(5, 5); got != antialiasedColor {
+		t.Errorf("anti-aliased pixel = %v, want %v", got, antialiasedColor)
+	}
+
+	// A lone changed pixel in a flat area is a real change
+	dotted := filledImage(10, 10, white)
+	dotted.SetNRGBA(7, 3, black)
+	if got := Compare(filledImage(10, 10, white), dotted, DiffOptions{Threshold: 0.1, IgnoreAntialiasing: true}).DiffPixelsCount; got != 1 {
+		t.Errorf("dot ignoring anti-aliasing: DiffPixelsCount = %d, want 1", got)
+	}
+}
+
+func filledImage(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestCompareIgnoreRegions(t *testing.T) {
+	base := filledImage(10, 10, white)
+	feature := filledImage(10, 10, white)
+	// A changed 4x5 block, half of it under an ignore region, and one pixel outside it
+	for y := 2; y < 7; y++ {
+		for x := 2; x < 6; x++ {
+			feature.SetNRGBA(x, y, black)
+		}
+	}
+	feature.SetNRGBA(9, 9, black)
+
+	comparison := Compare(base, feature, DiffOptions{
+		Threshold: 0.1,
+		IgnoreRegions: []image.Rectangle{
+			image.Rect(0, 0, 4, 10),
+			// Overlapping regions and ones reaching outside the image mask pixels once
+			image.Rect(-5, -5, 3, 3),
+		},
+	})
+	if comparison.DiffPixelsCount != 11 {
+		t.Errorf("DiffPixelsCount = %d, want the 10 changed pixels outside the region and the lone one", comparison.DiffPixelsCount)
+	}
+	// Ignored pixels aren't part of the area either
+	if want := 11.0 / 60 * 100; comparison.DiffPercentage != want {
+		t.Errorf("DiffPercentage = %v, want %v", comparison.DiffPercentage, want)
+	}
+	if got := comparison.Image.NRGBAAt(2, 2); got != (color.NRGBA{}) {
+		t.Errorf("ignored pixel = %v, want it left out of the diff image", got)
+	}
+	if got := comparison.Image.NRGBAAt(4, 2); got != diffColor {
+		t.Errorf("changed pixel next to the region = %v, want %v", got, diffColor)
+	}
+
+	// Everything ignored leaves nothing to differ
+	comparison = Compare(base, feature, DiffOptions{Threshold: 0.1, IgnoreRegions: []image.Rectangle{base.Rect}})
+	if comparison.DiffPixelsCount != 0 || comparison.DiffPercentage != 0 || !comparison.Equal {
+		t.Errorf("fully ignored: %d pixels, %v%%, equal %v", comparison.DiffPixelsCount, comparison.DiffPercentage, comparison.Equal)
+	}
+}
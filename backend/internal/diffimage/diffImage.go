@@ -12,8 +12,6 @@ import (
 	_ "image/jpeg"
 	"image/png"
 	_ "image/png"
-
-	"github.com/n7olkachev/imgdiff/pkg/imgdiff"
 )
 
 type DiffResult struct {
@@ -21,10 +19,6 @@ type DiffResult struct {
 	Input   ToDiff
 }
 
-type DiffOptions struct {
-	Threshold float64
-}
-
 /*
 Compares two images and gets the differences between them
 If there is a difference it will create a diff file. If images
@@ -71,10 +65,7 @@ func DiffImage(toDiff ToDiff, options DiffOptions) (DiffResult, error) {
 
 	fmt.Print("About to do the diff!\n")
 
-	resultDiff := imgdiff.Diff(image1, image2, &imgdiff.Options{
-		Threshold: float64(options.Threshold),
-		DiffImage: true,
-	})
+	resultDiff := Compare(image1, image2, options)
 
 	fmt.Print("Diff has been done!\n")
 
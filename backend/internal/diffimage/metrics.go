@@ -0,0 +1,437 @@
+package diffimage
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// ColorMetric is how the colours of two pixels are compared
+type ColorMetric string
+
+const (
+	// ColorMetricYIQ weighs differences in the YIQ colour space, it's fast and
+	// the default
+	ColorMetricYIQ ColorMetric = "yiq"
+	// ColorMetricCIEDE2000 uses the CIEDE2000 perceptual colour difference,
+	// which is slower but ignores shifts the eye can't see
+	ColorMetricCIEDE2000 ColorMetric = "ciede2000"
+)
+
+// ChangeCriterion decides whether two compared images count as changed
+type ChangeCriterion string
+
+const (
+	// CriterionPixels treats images as changed when more than
+	// AcceptablePercentage of their pixels differ
+	CriterionPixels ChangeCriterion = "pixels"
+	// CriterionSSIM treats images as changed when their structural similarity
+	// drops below MinSSIM
+	CriterionSSIM ChangeCriterion = "ssim"
+	// CriterionRegions treats images as changed when any connected region of
+	// differing pixels has at least MinRegionPixels pixels
+	CriterionRegions ChangeCriterion = "regions"
+)
+
+// ValidColorMetric reports whether metric is a known colour metric
+func ValidColorMetric(metric ColorMetric) bool {
+	return metric == ColorMetricYIQ || metric == ColorMetricCIEDE2000
+}
+
+// ValidChangeCriterion reports whether criterion is a known change criterion
+func ValidChangeCriterion(criterion ChangeCriterion) bool {
+	return criterion == CriterionPixels || criterion == CriterionSSIM || criterion == CriterionRegions
+}
+
+// Region is a connected area of differing pixels
+type Region struct {
+	Bounds image.Rectangle
+	// Pixels is how many differing pixels the region holds
+	Pixels int
+}
+
+/*
+connectedRegions groups the set pixels of a width by height mask into
+8-connected regions, largest first. Regions of equal size are ordered top to
+bottom, then left to right.
+*/
+func connectedRegions(changed []bool, width, height int) []Region {
+	var regions []Region
+	components(changed, width, height, func(component, i int) {
+		x, y := i%width, i/width
+		pixel := image.Rect(x, y, x+1, y+1)
+		if component == len(regions) {
+			regions = append(regions, Region{Bounds: pixel})
+		}
+		regions[component].Pixels++
+		regions[component].Bounds = regions[component].Bounds.Union(pixel)
+	})
+
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].Pixels > regions[j].Pixels
+	})
+	return regions
+}
+
+// clusterCell is the size of the grid cells changed pixels are clustered by,
+// changes in touching cells end up in the same cluster
+const clusterCell = 16
+
+// Cluster is a group of nearby changed pixels, the unit a reviewer looks at
+type Cluster struct {
+	Region
+	// Base and Feature are the areas of each image the cluster covers, empty
+	// when it only covers area the other image added or removed
+	Base, Feature image.Rectangle
+}
+
+/*
+clusterRegions groups the set pixels of a width by height mask that lie
+within about a grid cell of each other, so the separate strokes of one
+changed button or line of text form a single cluster. Clusters are ordered
+like connectedRegions, their Base and Feature areas are left for the caller.
+*/
+func clusterRegions(changed []bool, width, height int) []Cluster {
+	columns := (width + clusterCell - 1) / clusterCell
+	rows := (height + clusterCell - 1) / clusterCell
+
+	cells := make([]Region, columns*rows)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !changed[y*width+x] {
+				continue
+			}
+			cell := &cells[(y/clusterCell)*columns+x/clusterCell]
+			pixel := image.Rect(x, y, x+1, y+1)
+			if cell.Pixels == 0 {
+				cell.Bounds = pixel
+			} else {
+				cell.Bounds = cell.Bounds.Union(pixel)
+			}
+			cell.Pixels++
+		}
+	}
+
+	occupied := make([]bool, len(cells))
+	for i, cell := range cells {
+		occupied[i] = cell.Pixels > 0
+	}
+
+	var clusters []Cluster
+	components(occupied, columns, rows, func(component, i int) {
+		if component == len(clusters) {
+			clusters = append(clusters, Cluster{Region: Region{Bounds: cells[i].Bounds}})
+		}
+		clusters[component].Pixels += cells[i].Pixels
+		clusters[component].Bounds = clusters[component].Bounds.Union(cells[i].Bounds)
+	})
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Pixels > clusters[j].Pixels
+	})
+	return clusters
+}
+
+/*
+components walks the 8-connected components of the set cells of a width by
+height grid, calling visit for every set cell with the index of its component.
+Components are numbered from 0 in the order their first cell appears, top to
+bottom, then left to right.
+*/
+func components(set []bool, width, height int, visit func(component, i int)) {
+	visited := make([]bool, len(set))
+	var queue []int
+	component := 0
+
+	for start, on := range set {
+		if !on || visited[start] {
+			continue
+		}
+
+		visited[start] = true
+		queue = append(queue[:0], start)
+
+		for len(queue) > 0 {
+			i := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			visit(component, i)
+
+			x, y := i%width, i/width
+			for ny := max(y-1, 0); ny <= min(y+1, height-1); ny++ {
+				for nx := max(x-1, 0); nx <= min(x+1, width-1); nx++ {
+					n := ny*width + nx
+					if set[n] && !visited[n] {
+						visited[n] = true
+						queue = append(queue, n)
+					}
+				}
+			}
+		}
+
+		component++
+	}
+}
+
+// SSIM window size and step, and the stabilising constants for 8-bit luminance
+const (
+	ssimWindow = 8
+	ssimStep   = 4
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// luma returns the brightness of a pixel composited over white
+func luma(p color.NRGBA) float64 {
+	y, _, _ := yiq(p)
+	return y
+}
+
+/*
+lumaPlanes returns the luminance of both images as aligned in rows, width
+wide. NaN marks pixels an image doesn't have, and pixels the mask ignores take
+the base image's luminance in both so they can't count against similarity.
+*/
+func lumaPlanes(img1, img2 *image.NRGBA, rows []rowPair, mask []bool, width int) ([]float64, []float64) {
+	baseWidth, featureWidth := img1.Rect.Dx(), img2.Rect.Dx()
+	lumaBase := make([]float64, width*len(rows))
+	lumaFeature := make([]float64, width*len(rows))
+
+	for y, row := range rows {
+		for x := 0; x < width; x++ {
+			at := y*width + x
+			inBase := row.base >= 0 && x < baseWidth
+			inFeature := row.feature >= 0 && x < featureWidth
+
+			switch {
+			case inBase && mask != nil && mask[row.base*baseWidth+x]:
+				lumaBase[at] = luma(img1.NRGBAAt(x, row.base))
+				lumaFeature[at] = lumaBase[at]
+			case inBase && inFeature:
+				lumaBase[at] = luma(img1.NRGBAAt(x, row.base))
+				lumaFeature[at] = luma(img2.NRGBAAt(x, row.feature))
+			default:
+				lumaBase[at], lumaFeature[at] = math.NaN(), math.NaN()
+			}
+		}
+	}
+	return lumaBase, lumaFeature
+}
+
+/*
+ssim returns the mean structural similarity of two luminance planes, from 1
+for identical images down towards 0, over 8x8 windows every 4 pixels. NaN
+marks pixels only one image has, windows holding any count as entirely
+different.
+*/
+func ssim(lumaBase, lumaFeature []float64, width, height int) float64 {
+	if width == 0 || height == 0 {
+		return 1
+	}
+
+	window := min(ssimWindow, width, height)
+
+	// Window origins, with a last one flush against the edge so every pixel is covered
+	origins := func(size int) []int {
+		var starts []int
+		for start := 0; start+window <= size; start += ssimStep {
+			starts = append(starts, start)
+		}
+		if last := size - window; starts[len(starts)-1] != last {
+			starts = append(starts, last)
+		}
+		return starts
+	}
+	xs, ys := origins(width), origins(height)
+
+	workers := runtime.NumCPU()
+	sums := make([]float64, workers)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for row := w; row < len(ys); row += workers {
+				y0 := ys[row]
+				for _, x0 := range xs {
+					sums[w] += windowSSIM(lumaBase, lumaFeature, width, x0, y0, window)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := 0.0
+	for _, sum := range sums {
+		total += sum
+	}
+	return total / float64(len(xs)*len(ys))
+}
+
+// windowSSIM is the structural similarity of one window of two luminance
+// planes, 0 when the window reaches past either image
+func windowSSIM(a, b []float64, stride, x0, y0, window int) float64 {
+	n := float64(window * window)
+
+	var sumA, sumB float64
+	for y := y0; y < y0+window; y++ {
+		for x := x0; x < x0+window; x++ {
+			sumA += a[y*stride+x]
+			sumB += b[y*stride+x]
+		}
+	}
+	if math.IsNaN(sumA) || math.IsNaN(sumB) {
+		return 0
+	}
+	meanA, meanB := sumA/n, sumB/n
+
+	var varA, varB, covariance float64
+	for y := y0; y < y0+window; y++ {
+		for x := x0; x < x0+window; x++ {
+			da, db := a[y*stride+x]-meanA, b[y*stride+x]-meanB
+			varA += da * da
+			varB += db * db
+			covariance += da * db
+		}
+	}
+	varA, varB, covariance = varA/(n-1), varB/(n-1), covariance/(n-1)
+
+	return ((2*meanA*meanB + ssimC1) * (2*covariance + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
+
+// srgbToLinear maps 8-bit sRGB channel values to linear light
+var srgbToLinear = func() [256]float64 {
+	var table [256]float64
+	for i := range table {
+		table[i] = linearize(float64(i) / 255)
+	}
+	return table
+}()
+
+func linearize(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// lab converts a pixel composited over white to CIELAB under a D65 white point
+func lab(p color.NRGBA) (float64, float64, float64) {
+	var r, g, b float64
+	if p.A == 255 {
+		r, g, b = srgbToLinear[p.R], srgbToLinear[p.G], srgbToLinear[p.B]
+	} else {
+		a := float64(p.A) / 255
+		r = linearize(blend(p.R, a) / 255)
+		g = linearize(blend(p.G, a) / 255)
+		b = linearize(blend(p.B, a) / 255)
+	}
+
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+// pixelDeltaE is the CIEDE2000 difference between two pixels
+func pixelDeltaE(p1, p2 color.NRGBA) float64 {
+	l1, a1, b1 := lab(p1)
+	l2, a2, b2 := lab(p2)
+	return ciede2000(l1, a1, b1, l2, a2, b2)
+}
+
+/*
+ciede2000 returns the CIEDE2000 colour difference between two CIELAB colours
+with unit weighting factors. A difference around 2.3 is just noticeable, 100
+separates black from white. This follows "The CIEDE2000 Color-Difference
+Formula: Implementation Notes" by Sharma, Wu and Dalal.
+*/
+func ciede2000(l1, a1, b1, l2, a2, b2 float64) float64 {
+	const pow25To7 = 6103515625.0 // 25^7
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	cBar7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25To7)))
+
+	a1p, a2p := (1+g)*a1, (1+g)*a2
+	c1p, c2p := math.Hypot(a1p, b1), math.Hypot(a2p, b2)
+	h1p, h2p := hueAngle(b1, a1p), hueAngle(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+
+	var dhp float64
+	switch {
+	case c1p*c2p == 0:
+		dhp = 0
+	case math.Abs(h2p-h1p) <= 180:
+		dhp = h2p - h1p
+	case h2p-h1p > 180:
+		dhp = h2p - h1p - 360
+	default:
+		dhp = h2p - h1p + 360
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(radians(dhp/2))
+
+	lBarP := (l1 + l2) / 2
+	cBarP := (c1p + c2p) / 2
+
+	var hBarP float64
+	switch {
+	case c1p*c2p == 0:
+		hBarP = h1p + h2p
+	case math.Abs(h1p-h2p) <= 180:
+		hBarP = (h1p + h2p) / 2
+	case h1p+h2p < 360:
+		hBarP = (h1p + h2p + 360) / 2
+	default:
+		hBarP = (h1p + h2p - 360) / 2
+	}
+
+	t := 1 - 0.17*math.Cos(radians(hBarP-30)) +
+		0.24*math.Cos(radians(2*hBarP)) +
+		0.32*math.Cos(radians(3*hBarP+6)) -
+		0.20*math.Cos(radians(4*hBarP-63))
+
+	dTheta := 30 * math.Exp(-math.Pow((hBarP-275)/25, 2))
+	cBarP7 := math.Pow(cBarP, 7)
+	rc := 2 * math.Sqrt(cBarP7/(cBarP7+pow25To7))
+
+	lOffset := (lBarP - 50) * (lBarP - 50)
+	sl := 1 + 0.015*lOffset/math.Sqrt(20+lOffset)
+	sc := 1 + 0.045*cBarP
+	sh := 1 + 0.015*cBarP*t
+	rt := -math.Sin(radians(2*dTheta)) * rc
+
+	dl, dc, dh := dLp/sl, dCp/sc, dHp/sh
+	return math.Sqrt(dl*dl + dc*dc + dh*dh + rt*dc*dh)
+}
+
+// hueAngle is the hue in degrees, 0 to 360, of a colour's a and b components
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,172 @@
+package diffimage
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// sharmaPairs are the test data from "The CIEDE2000 Color-Difference Formula:
+// Implementation Notes, Supplementary Test Data, and Mathematical
+// Observations" by Sharma, Wu and Dalal: two CIELAB colours and their
+// difference
+var sharmaPairs = [][7]float64{
+	{50.0000, 2.6772, -79.7751, 50.0000, 0.0000, -82.7485, 2.0425},
+	{50.0000, 3.1571, -77.2803, 50.0000, 0.0000, -82.7485, 2.8615},
+	{50.0000, 2.8361, -74.0200, 50.0000, 0.0000, -82.7485, 3.4412},
+	{50.0000, -1.3802, -84.2814, 50.0000, 0.0000, -82.7485, 1.0000},
+	{50.0000, -1.1848, -84.8006, 50.0000, 0.0000, -82.7485, 1.0000},
+	{50.0000, -0.9009, -85.5211, 50.0000, 0.0000, -82.7485, 1.0000},
+	{50.0000, 0.0000, 0.0000, 50.0000, -1.0000, 2.0000, 2.3669},
+	{50.0000, -1.0000, 2.0000, 50.0000, 0.0000, 0.0000, 2.3669},
+	{50.0000, 2.4900, -0.0010, 50.0000, -2.4900, 0.0009, 7.1792},
+	{50.0000, 2.4900, -0.0010, 50.0000, -2.4900, 0.0010, 7.1792},
+	{50.0000, 2.4900, -0.0010, 50.0000, -2.4900, 0.0011, 7.2195},
+	{50.0000, 2.4900, -0.0010, 50.0000, -2.4900, 0.0012, 7.2195},
+	{50.0000, -0.0010, 2.4900, 50.0000, 0.0009, -2.4900, 4.8045},
+	{50.0000, -0.0010, 2.4900, 50.0000, 0.0010, -2.4900, 4.8045},
+	{50.0000, -0.0010, 2.4900, 50.0000, 0.0011, -2.4900, 4.7461},
+	{50.0000, 2.5000, 0.0000, 50.0000, 0.0000, -2.5000, 4.3065},
+	{50.0000, 2.5000, 0.0000, 73.0000, 25.0000, -18.0000, 27.1492},
+	{50.0000, 2.5000, 0.0000, 61.0000, -5.0000, 29.0000, 22.8977},
+	{50.0000, 2.5000, 0.0000, 56.0000, -27.0000, -3.0000, 31.9030},
+	{50.0000, 2.5000, 0.0000, 58.0000, 24.0000, 15.0000, 19.4535},
+	{50.0000, 2.5000, 0.0000, 50.0000, 3.1736, 0.5854, 1.0000},
+	{50.0000, 2.5000, 0.0000, 50.0000, 3.2972, 0.0000, 1.0000},
+	{50.0000, 2.5000, 0.0000, 50.0000, 1.8634, 0.5757, 1.0000},
+	{50.0000, 2.5000, 0.0000, 50.0000, 3.2592, 0.3350, 1.0000},
+	{60.2574, -34.0099, 36.2677, 60.4626, -34.1751, 39.4387, 1.2644},
+	{63.0109, -31.0961, -5.8663, 62.8187, -29.7946, -4.0864, 1.2630},
+	{61.2901, 3.7196, -5.3901, 61.4292, 2.2480, -4.9620, 1.8731},
+	{35.0831, -44.1164, 3.7933, 35.0232, -40.0716, 1.5901, 1.8645},
+	{22.7233, 20.0904, -46.6940, 23.0331, 14.9730, -42.5619, 2.0373},
+	{36.4612, 47.8580, 18.3852, 36.2715, 50.5065, 21.2231, 1.4146},
+	{90.8027, -2.0831, 1.4410, 91.1528, -1.6435, 0.0447, 1.4441},
+	{90.9257, -0.5406, -0.9208, 88.6381, -0.8985, -0.7239, 1.5381},
+	{6.7747, -0.2908, -2.4247, 5.8714, -0.0985, -2.2286, 0.6377},
+	{2.0776, 0.0795, -1.1350, 0.9033, -0.0636, -0.5514, 0.9082},
+}
+
+func TestCIEDE2000(t *testing.T) {
+	for i, pair := range sharmaPairs {
+		l1, a1, b1, l2, a2, b2, want := pair[0], pair[1], pair[2], pair[3], pair[4], pair[5], pair[6]
+
+		// The published differences are rounded to 4 decimal places
+		if got := ciede2000(l1, a1, b1, l2, a2, b2); math.Abs(got-want) > 0.5e-4 {
+			t.Errorf("pair %d: ciede2000 = %.4f, want %.4f", i+1, got, want)
+		}
+		if got := ciede2000(l2, a2, b2, l1, a1, b1); math.Abs(got-want) > 0.5e-4 {
+			t.Errorf("pair %d swapped: ciede2000 = %.4f, want %.4f", i+1, got, want)
+		}
+	}
+}
+
+func TestPixelDeltaE(t *testing.T) {
+	black := color.NRGBA{A: 255}
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+	if got := pixelDeltaE(black, white); math.Abs(got-100) > 0.01 {
+		t.Errorf("black to white = %.4f, want 100", got)
+	}
+	if got := pixelDeltaE(white, color.NRGBA{}); got > 1e-9 {
+		t.Errorf("white to transparent = %.4f, want 0 as both show white", got)
+	}
+}
+
+// stripes draws vertical bars of alternating brightness, offset pixels to
+// the right
+func stripes(width, height, offset int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			v := uint8(40)
+			if (x+offset)/3%2 == 0 {
+				v = 220
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+// planes returns the aligned luminance of two images of the same size
+func planes(img1, img2 *image.NRGBA) ([]float64, []float64, int, int) {
+	rows := alignRows(img1, img2, AlignTopLeft)
+	width := max(img1.Rect.Dx(), img2.Rect.Dx())
+	lumaBase, lumaFeature := lumaPlanes(img1, img2, rows, nil, width)
+	return lumaBase, lumaFeature, width, len(rows)
+}
+
+func TestSSIM(t *testing.T) {
+	img := stripes(64, 40, 0)
+
+	if got := ssim(planes(img, img)); math.Abs(got-1) > 1e-12 {
+		t.Errorf("identical images: ssim = %v, want 1", got)
+	}
+
+	// Flat areas of the same brightness are as similar as can be
+	flat := filledImage(32, 32, color.NRGBA{R: 90, G: 90, B: 90, A: 255})
+	if got := ssim(planes(flat, flat)); math.Abs(got-1) > 1e-12 {
+		t.Errorf("identical flat images: ssim = %v, want 1", got)
+	}
+
+	// Shifting by half a stripe inverts the pattern, by a pixel only moves
+	// its edges
+	shiftedByPixel := ssim(planes(img, stripes(64, 40, 1)))
+	shiftedByStripe := ssim(planes(img, stripes(64, 40, 3)))
+	if shiftedByPixel >= 1 || shiftedByPixel <= shiftedByStripe {
+		t.Errorf("ssim shifted by a pixel = %v, by a stripe = %v, want 1 > pixel > stripe", shiftedByPixel, shiftedByStripe)
+	}
+	if shiftedByStripe >= 0 {
+		t.Errorf("ssim of inverted stripes = %v, want below 0", shiftedByStripe)
+	}
+
+	// Symmetric in its arguments
+	lumaBase, lumaFeature, width, height := planes(img, stripes(64, 40, 1))
+	if a, b := ssim(lumaBase, lumaFeature, width, height), ssim(lumaFeature, lumaBase, width, height); math.Abs(a-b) > 1e-12 {
+		t.Errorf("ssim isn't symmetric: %v and %v", a, b)
+	}
+
+	// Windows reaching into area only one image has count as entirely different
+	taller := image.NewNRGBA(image.Rect(0, 0, 64, 80))
+	copy(taller.Pix, img.Pix)
+	if got := ssim(planes(img, taller)); got > 0.55 || got < 0.45 {
+		t.Errorf("image against itself twice as tall: ssim = %v, want about 0.5", got)
+	}
+
+	if got := ssim(nil, nil, 0, 0); got != 1 {
+		t.Errorf("empty images: ssim = %v, want 1", got)
+	}
+}
+
+func TestCompareMeasuresOnlyWhatIsAsked(t *testing.T) {
+	base, feature := stripes(32, 32, 0), stripes(32, 32, 1)
+
+	tests := []struct {
+		name    string
+		options DiffOptions
+		ssim    bool
+		regions bool
+	}{
+		{name: "pixels", options: DiffOptions{Threshold: 0.1, Criterion: CriterionPixels}},
+		{name: "ssim criterion", options: DiffOptions{Threshold: 0.1, Criterion: CriterionSSIM, MinSSIM: 0.99}, ssim: true},
+		{name: "regions criterion", options: DiffOptions{Threshold: 0.1, Criterion: CriterionRegions, MinRegionPixels: 1}, regions: true},
+		{name: "everything", options: DiffOptions{Threshold: 0.1, MeasureSSIM: true, FindRegions: true}, ssim: true, regions: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comparison := Compare(base, feature, tt.options)
+			if comparison.DiffPixelsCount == 0 || comparison.Equal {
+				t.Fatalf("shifted stripes compared equal with %d differing pixels", comparison.DiffPixelsCount)
+			}
+			if measured := !math.IsNaN(comparison.SSIM); measured != tt.ssim {
+				t.Errorf("SSIM = %v, measured %v, want %v", comparison.SSIM, measured, tt.ssim)
+			}
+			if found := comparison.Regions != nil && comparison.Clusters != nil; found != tt.regions {
+				t.Errorf("found %d regions and %d clusters, want found %v", len(comparison.Regions), len(comparison.Clusters), tt.regions)
+			}
+		})
+	}
+}
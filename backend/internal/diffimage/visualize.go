@@ -0,0 +1,112 @@
+package diffimage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+const (
+	// sideBySideGap is the space left between the images of a side by side view
+	sideBySideGap = 16
+	// heatFade is how far unchanged pixels of a heatmap are faded towards white
+	heatFade = 0.7
+)
+
+// heatStops are the colours of the heatmap from the smallest difference to the largest
+var heatStops = []color.NRGBA{
+	{R: 0, G: 64, B: 255, A: 255},
+	{R: 0, G: 200, B: 0, A: 255},
+	{R: 255, G: 220, B: 0, A: 255},
+	{R: 255, G: 0, B: 0, A: 255},
+}
+
+// SideBySide places the base image left of the feature image, top aligned,
+// on a transparent background
+func SideBySide(base, feature image.Image) *image.NRGBA {
+	b, f := base.Bounds(), feature.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx()+sideBySideGap+f.Dx(), max(b.Dy(), f.Dy())))
+
+	draw.Draw(out, image.Rect(0, 0, b.Dx(), b.Dy()), base, b.Min, draw.Src)
+	draw.Draw(out, image.Rect(b.Dx()+sideBySideGap, 0, out.Rect.Dx(), f.Dy()), feature, f.Min, draw.Src)
+	return out
+}
+
+// Overlay draws the feature image over the base image at opacity (0 to 1),
+// both aligned by their top left corners
+func Overlay(base, feature image.Image, opacity float64) *image.NRGBA {
+	b, f := base.Bounds(), feature.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, max(b.Dx(), f.Dx()), max(b.Dy(), f.Dy())))
+
+	draw.Draw(out, image.Rect(0, 0, b.Dx(), b.Dy()), base, b.Min, draw.Src)
+	alpha := image.NewUniform(color.Alpha{A: uint8(math.Round(math.Max(0, math.Min(1, opacity)) * 255))})
+	draw.DrawMask(out, image.Rect(0, 0, f.Dx(), f.Dy()), feature, f.Min, alpha, image.Point{}, draw.Over)
+	return out
+}
+
+/*
+Heatmap colours every pixel by how much it differs between the images, both
+aligned by their top left corners. Unchanged pixels show the base image faded
+towards white, changed ones run from blue for the faintest difference through
+green and yellow to red for the largest. Area only one image covers is red.
+The difference is measured like Threshold, so a pixel just past a threshold
+of 0.5 sits halfway along the scale.
+*/
+func Heatmap(base, feature image.Image) *image.NRGBA {
+	img1, img2 := toNRGBA(base), toNRGBA(feature)
+	width := max(img1.Rect.Dx(), img2.Rect.Dx())
+	height := max(img1.Rect.Dy(), img2.Rect.Dy())
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			point := image.Point{x, y}
+			inBase, inFeature := point.In(img1.Rect), point.In(img2.Rect)
+			if !inBase || !inFeature {
+				out.SetNRGBA(x, y, heatColor(1))
+				continue
+			}
+
+			p1, p2 := img1.NRGBAAt(x, y), img2.NRGBAAt(x, y)
+			if p1 == p2 {
+				faded := uint8(255 - (255-luma(p1))*(1-heatFade))
+				out.SetNRGBA(x, y, color.NRGBA{R: faded, G: faded, B: faded, A: 255})
+				continue
+			}
+
+			out.SetNRGBA(x, y, heatColor(math.Sqrt(colorDelta(p1, p2)/maxDelta)))
+		}
+	}
+
+	return out
+}
+
+// heatColor returns the heatmap colour for a difference between 0 and 1
+func heatColor(delta float64) color.NRGBA {
+	position := math.Max(0, math.Min(1, delta)) * float64(len(heatStops)-1)
+	i := min(int(position), len(heatStops)-2)
+	t := position - float64(i)
+
+	from, to := heatStops[i], heatStops[i+1]
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+	}
+	return color.NRGBA{R: mix(from.R, to.R), G: mix(from.G, to.G), B: mix(from.B, to.B), A: 255}
+}
+
+// BlinkFrames returns the base and feature images on opaque white canvases of
+// the same size, aligned by their top left corners, to be shown in turn
+func BlinkFrames(base, feature image.Image) []image.Image {
+	b, f := base.Bounds(), feature.Bounds()
+	bounds := image.Rect(0, 0, max(b.Dx(), f.Dx()), max(b.Dy(), f.Dy()))
+
+	frames := make([]image.Image, 0, 2)
+	for _, img := range []image.Image{base, feature} {
+		frame := image.NewNRGBA(bounds)
+		draw.Draw(frame, bounds, image.White, image.Point{}, draw.Src)
+		draw.Draw(frame, img.Bounds().Sub(img.Bounds().Min), img, img.Bounds().Min, draw.Over)
+		frames = append(frames, frame)
+	}
+	return frames
+}
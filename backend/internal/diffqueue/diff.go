@@ -0,0 +1,119 @@
+package diffqueue
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	_ "image/png"
+
+	"github.com/crzytrane/diffit/internal/diffimage"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/google/uuid"
+)
+
+// maxStoredRegions is how many of the largest changed regions, and of the
+// largest clusters of them, are kept for a snapshot
+const maxStoredRegions = 50
+
+// diffResult is what comparing a snapshot with its base found
+type diffResult struct {
+	// Percentage is the share of changed pixels, 0 when the images count as equal
+	Percentage float64
+	// Path is where the diff image was saved, empty when the images count as equal
+	Path string
+	// Resized is true when the images have different dimensions
+	Resized     bool
+	SSIM        float64
+	RegionCount int
+	Regions     []models.DiffRegion
+	// Clusters group nearby changed pixels for reviewing, largest first
+	Clusters []models.SnapshotRegion
+}
+
+// performDiff compares two images, saving a diff image when they differ
+func (q *Queue) performDiff(projectID, snapshotID uuid.UUID, basePath, comparisonPath string, options diffimage.DiffOptions) (*diffResult, error) {
+	baseFile, err := q.storage.GetFile(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open base image: %w", err)
+	}
+	defer baseFile.Close()
+
+	comparisonFile, err := q.storage.GetFile(comparisonPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open comparison image: %w", err)
+	}
+	defer comparisonFile.Close()
+
+	baseImage, _, err := image.Decode(baseFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base image: %w", err)
+	}
+
+	comparisonImage, _, err := image.Decode(comparisonFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode comparison image: %w", err)
+	}
+
+	// Perform diff, differences that don't meet the change criterion count as
+	// unchanged. Snapshots store every metric, not only the criterion's.
+	options.MeasureSSIM, options.FindRegions = true, true
+	comparison := diffimage.Compare(baseImage, comparisonImage, options)
+
+	result := &diffResult{
+		Resized:     comparison.Resized,
+		SSIM:        comparison.SSIM,
+		RegionCount: len(comparison.Regions),
+	}
+	for _, region := range comparison.Regions[:min(len(comparison.Regions), maxStoredRegions)] {
+		result.Regions = append(result.Regions, models.DiffRegion{
+			X:      region.Bounds.Min.X,
+			Y:      region.Bounds.Min.Y,
+			Width:  region.Bounds.Dx(),
+			Height: region.Bounds.Dy(),
+			Pixels: region.Pixels,
+		})
+	}
+
+	for _, cluster := range comparison.Clusters[:min(len(comparison.Clusters), maxStoredRegions)] {
+		result.Clusters = append(result.Clusters, models.SnapshotRegion{
+			X:          cluster.Bounds.Min.X,
+			Y:          cluster.Bounds.Min.Y,
+			Width:      cluster.Bounds.Dx(),
+			Height:     cluster.Bounds.Dy(),
+			Pixels:     cluster.Pixels,
+			Base:       toRect(cluster.Base),
+			Comparison: toRect(cluster.Feature),
+		})
+	}
+
+	if comparison.Equal {
+		return result, nil
+	}
+	result.Percentage = comparison.DiffPercentage
+
+	// Save diff image
+	var buf bytes.Buffer
+	enc := &png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, comparison.Image); err != nil {
+		return nil, fmt.Errorf("failed to encode diff image: %w", err)
+	}
+
+	diffFilename := fmt.Sprintf("%s.png", snapshotID.String())
+	result.Path, err = q.storage.SaveFileWithName(projectID, storage.StorageTypeDiff, diffFilename, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save diff image: %w", err)
+	}
+
+	return result, nil
+}
+
+// toRect converts an image area, nil when it is empty
+func toRect(area image.Rectangle) *models.Rect {
+	if area.Empty() {
+		return nil
+	}
+	return &models.Rect{X: area.Min.X, Y: area.Min.Y, Width: area.Dx(), Height: area.Dy()}
+}
@@ -0,0 +1,445 @@
+/*
+Package diffqueue runs snapshot comparisons in the background. Uploads only
+persist the comparison image and enqueue a job, a pool of workers then claims
+jobs from Postgres and moves the snapshot through its processing states.
+*/
+package diffqueue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/branches"
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/diffsettings"
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/notify"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Options configures the worker pool
+type Options struct {
+	// Workers is the number of jobs processed concurrently
+	Workers int
+	// MaxAttempts is how many times a job is tried before the snapshot is failed
+	MaxAttempts int
+	// PollInterval is how often idle workers check for new jobs
+	PollInterval time.Duration
+	// RetryBackoff is the delay before the first retry, doubled on each attempt
+	RetryBackoff time.Duration
+	// StaleAfter is how long a running job can go without finishing before
+	// another worker is allowed to pick it up
+	StaleAfter time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers < 1 {
+		o.Workers = 1
+	}
+	if o.MaxAttempts < 1 {
+		o.MaxAttempts = 1
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 2 * time.Second
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 5 * time.Second
+	}
+	if o.StaleAfter <= 0 {
+		o.StaleAfter = 10 * time.Minute
+	}
+	return o
+}
+
+// Queue enqueues diff jobs and runs the workers that process them
+type Queue struct {
+	jobs         *repository.DiffJobRepository
+	snapshotRepo *repository.SnapshotRepository
+	regionRepo   *repository.SnapshotRegionRepository
+	buildRepo    *repository.BuildRepository
+	baselineRepo *repository.BaselineRepository
+	reviewRepo   *repository.BuildReviewRepository
+	settings     *diffsettings.Resolver
+	branches     *branches.Resolver
+	notifier     *notify.Notifier
+	bus          *events.Bus
+	storage      storage.Storage
+	cache        *derived.Cache
+	options      Options
+
+	wake chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a new Queue
+func New(pool *pgxpool.Pool, storage storage.Storage, notifier *notify.Notifier, bus *events.Bus, options Options) *Queue {
+	return &Queue{
+		jobs:         repository.NewDiffJobRepository(pool),
+		snapshotRepo: repository.NewSnapshotRepository(pool),
+		regionRepo:   repository.NewSnapshotRegionRepository(pool),
+		buildRepo:    repository.NewBuildRepository(pool),
+		baselineRepo: repository.NewBaselineRepository(pool),
+		reviewRepo:   repository.NewBuildReviewRepository(pool),
+		settings:     diffsettings.NewResolver(pool),
+		branches:     branches.NewResolver(pool),
+		notifier:     notifier,
+		bus:          bus,
+		storage:      storage,
+		cache:        derived.New(storage),
+		options:      options.withDefaults(),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// SetImage records a snapshot's uploaded image and schedules it to be diffed,
+// in one transaction so the snapshot isn't left with an image but no job
+func (q *Queue) SetImage(ctx context.Context, snapshotID uuid.UUID, path, hash string) error {
+	if err := q.snapshotRepo.SetComparisonImage(ctx, snapshotID, path, hash, q.options.MaxAttempts); err != nil {
+		return err
+	}
+
+	q.nudge()
+	return nil
+}
+
+// CreateSnapshots creates snapshots that already have their images and
+// schedules them to be diffed, in one transaction so none is left without a job
+func (q *Queue) CreateSnapshots(ctx context.Context, uploads []repository.SnapshotUpload) ([]models.Snapshot, error) {
+	snapshots, err := q.snapshotRepo.CreateWithImages(ctx, uploads, q.options.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	q.nudge()
+	return snapshots, nil
+}
+
+// nudge wakes an idle worker on this replica, others pick jobs up on their next poll
+func (q *Queue) nudge() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches the workers. They stop once ctx is cancelled, use Wait to
+// block until in-flight jobs have finished.
+func (q *Queue) Start(ctx context.Context) {
+	for i := 0; i < q.options.Workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.run(ctx)
+		}()
+	}
+}
+
+// Wait blocks until all workers have stopped
+func (q *Queue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *Queue) run(ctx context.Context) {
+	ticker := time.NewTicker(q.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.failStale(ctx)
+
+		// Drain the queue before going back to sleep
+		for ctx.Err() == nil {
+			job, err := q.jobs.Claim(ctx, q.options.StaleAfter)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("diff queue: %v", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.handle(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// handle processes a claimed job and records the outcome on both the job and
+// the snapshot. Outcomes are written with a fresh context so a shutdown in
+// the middle of a job doesn't leave it marked as running.
+func (q *Queue) handle(ctx context.Context, job *models.DiffJob) {
+	err := q.process(ctx, job.SnapshotID)
+
+	recordCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err == nil {
+		if err := q.jobs.Complete(recordCtx, job.ID); err != nil {
+			log.Printf("diff queue: %v", err)
+		}
+		return
+	}
+
+	reason := err.Error()
+	if job.Attempts < job.MaxAttempts {
+		backoff := q.options.RetryBackoff << (job.Attempts - 1)
+		log.Printf("diff queue: snapshot %s attempt %d/%d failed, retrying in %s: %v",
+			job.SnapshotID, job.Attempts, job.MaxAttempts, backoff, err)
+
+		if err := q.jobs.Retry(recordCtx, job.ID, reason, time.Now().Add(backoff)); err != nil {
+			log.Printf("diff queue: %v", err)
+		}
+		q.snapshotRepo.UpdateStatusWithReason(recordCtx, job.SnapshotID, models.SnapshotStatusPending, &reason)
+		return
+	}
+
+	log.Printf("diff queue: snapshot %s failed after %d attempts: %v", job.SnapshotID, job.Attempts, err)
+	if err := q.jobs.Fail(recordCtx, job.ID, reason); err != nil {
+		log.Printf("diff queue: %v", err)
+	}
+	q.failSnapshot(recordCtx, job.SnapshotID, reason)
+}
+
+// failStale fails the jobs whose last attempt went stale, whose worker most
+// likely died processing them
+func (q *Queue) failStale(ctx context.Context) {
+	reason := fmt.Sprintf("diff did not finish within %s on its last attempt", q.options.StaleAfter)
+	snapshotIDs, err := q.jobs.FailStale(ctx, q.options.StaleAfter, reason)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("diff queue: %v", err)
+		}
+		return
+	}
+
+	for _, snapshotID := range snapshotIDs {
+		log.Printf("diff queue: snapshot %s failed: %s", snapshotID, reason)
+		q.failSnapshot(ctx, snapshotID, reason)
+	}
+}
+
+// failSnapshot marks a snapshot whose job failed for good and lets its build
+// finish without it
+func (q *Queue) failSnapshot(ctx context.Context, snapshotID uuid.UUID, reason string) {
+	q.snapshotRepo.UpdateStatusWithReason(ctx, snapshotID, models.SnapshotStatusFailed, &reason)
+
+	if snapshot, err := q.snapshotRepo.GetByID(ctx, snapshotID); err == nil {
+		if build, err := q.buildRepo.GetByID(ctx, snapshot.BuildID); err == nil {
+			q.bus.Publish(ctx, build.ProjectID, models.EventSnapshotProcessed, snapshot)
+		}
+		q.finishBuild(ctx, snapshot.BuildID)
+	}
+}
+
+// finishBuild refreshes build stats and completes the build if it has been
+// finalized and this was the last snapshot it was waiting on, which settles
+// its review state
+func (q *Queue) finishBuild(ctx context.Context, buildID uuid.UUID) {
+	if err := q.buildRepo.UpdateStats(ctx, buildID); err != nil {
+		log.Printf("diff queue: %v", err)
+	}
+	if err := q.buildRepo.CompleteIfProcessed(ctx, buildID); err != nil {
+		log.Printf("diff queue: %v", err)
+	}
+	transition, err := q.reviewRepo.Refresh(ctx, buildID, nil, nil)
+	if err != nil {
+		log.Printf("diff queue: %v", err)
+	}
+	if transition != nil {
+		q.notifier.BuildChanged(buildID)
+	}
+
+	// Streams show the build's progress after every snapshot
+	if build, err := q.buildRepo.GetByID(ctx, buildID); err == nil {
+		q.bus.Publish(ctx, build.ProjectID, models.EventBuildUpdated, build)
+	}
+}
+
+func (q *Queue) process(ctx context.Context, snapshotID uuid.UUID) error {
+	snapshot, err := q.snapshotRepo.GetByID(ctx, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.ComparisonImagePath == nil {
+		return fmt.Errorf("snapshot has no comparison image")
+	}
+
+	build, err := q.buildRepo.GetByID(ctx, snapshot.BuildID)
+	if err != nil {
+		return err
+	}
+
+	if err := q.snapshotRepo.UpdateStatus(ctx, snapshot.ID, models.SnapshotStatusProcessing); err != nil {
+		return err
+	}
+
+	base, err := q.findBase(ctx, build, snapshot)
+	if err != nil {
+		return err
+	}
+
+	// Thumbnails and views rendered from an earlier diff show the wrong images
+	if err := q.cache.Invalidate(build.ProjectID, snapshot.ID); err != nil {
+		return err
+	}
+	// The diff image is rewritten in place, so its resized variants go too
+	if snapshot.DiffImagePath != nil {
+		if err := q.cache.InvalidateVariants(*snapshot.DiffImagePath); err != nil {
+			return err
+		}
+	}
+
+	var baseImagePath, diffImagePath *string
+	var diffPercentage *float64
+	var resized bool
+
+	if base != nil {
+		baseImagePath = &base.ImagePath
+
+		// Identical pixels need no comparing
+		result := &diffResult{SSIM: 1}
+		if !sameImage(base, snapshot) {
+			options, err := q.settings.ForSnapshot(ctx, build.ProjectID, snapshot.Name, base.BaselineID)
+			if err != nil {
+				return err
+			}
+
+			result, err = q.performDiff(build.ProjectID, snapshot.ID, base.ImagePath, *snapshot.ComparisonImagePath, options)
+			if err != nil {
+				return err
+			}
+		}
+
+		diffPercentage = &result.Percentage
+		resized = result.Resized
+		if result.Path != "" {
+			diffImagePath = &result.Path
+		}
+		if err := q.snapshotRepo.SetDiffMetrics(ctx, snapshot.ID, result.SSIM, result.RegionCount, result.Regions); err != nil {
+			return err
+		}
+		if err := q.regionRepo.Replace(ctx, snapshot.ID, result.Clusters); err != nil {
+			return err
+		}
+
+		// Link snapshot to what it was compared against
+		if base.SnapshotID != nil {
+			err = q.snapshotRepo.SetBaseSnapshot(ctx, snapshot.ID, *base.SnapshotID)
+		} else {
+			err = q.snapshotRepo.SetBaseline(ctx, snapshot.ID, *base.BaselineID)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		// New snapshot - nothing to compare against yet
+		zeroPercent := float64(0)
+		diffPercentage = &zeroPercent
+	}
+
+	if err := q.snapshotRepo.SetDiffResult(ctx, snapshot.ID, baseImagePath, diffImagePath, diffPercentage); err != nil {
+		return err
+	}
+
+	changeType := models.ChangeTypeUnchanged
+	if base == nil {
+		changeType = models.ChangeTypeNew
+	} else if resized {
+		changeType = models.ChangeTypeResized
+	} else if *diffPercentage > 0 {
+		changeType = models.ChangeTypeChanged
+	}
+	if err := q.snapshotRepo.SetChangeType(ctx, snapshot.ID, changeType); err != nil {
+		return err
+	}
+
+	if err := q.snapshotRepo.UpdateStatusWithReason(ctx, snapshot.ID, models.SnapshotStatusCompleted, nil); err != nil {
+		return err
+	}
+
+	if processed, err := q.snapshotRepo.GetByID(ctx, snapshot.ID); err == nil {
+		q.bus.Publish(ctx, build.ProjectID, models.EventSnapshotProcessed, processed)
+		if changeType != models.ChangeTypeUnchanged {
+			q.bus.Publish(ctx, build.ProjectID, models.EventSnapshotChanged, processed)
+		}
+	}
+
+	q.finishBuild(ctx, build.ID)
+
+	return nil
+}
+
+// comparisonBase is the image a snapshot is diffed against
+type comparisonBase struct {
+	ImagePath string
+	ImageHash *string
+	// BaselineID is the baseline the image belongs to, or for a base build
+	// snapshot the baseline that snapshot was compared against. It picks the
+	// baseline's ignore regions.
+	BaselineID *uuid.UUID
+	// SnapshotID is set when the image is a snapshot from the base build
+	SnapshotID *uuid.UUID
+}
+
+/*
+findBase finds what a snapshot is compared against. Builds with a base build
+compare against the same-named snapshot in it, so a pull request is diffed
+against its target branch as it was at the merge base; a snapshot missing
+from the base build is new. Other builds use the first baseline found along
+their branch's chain: the branch itself, its configured parents, then the
+project's default branch. Returns nil when there is nothing to compare
+against.
+*/
+func (q *Queue) findBase(ctx context.Context, build *models.Build, snapshot *models.Snapshot) (*comparisonBase, error) {
+	if build.BaseBuildID != nil {
+		baseSnapshot, err := q.snapshotRepo.FindInBuild(ctx, *build.BaseBuildID, snapshot.Name, snapshot.Browser, snapshot.Viewport)
+		if err != nil || baseSnapshot == nil {
+			return nil, err
+		}
+		return &comparisonBase{
+			ImagePath:  *baseSnapshot.ComparisonImagePath,
+			ImageHash:  baseSnapshot.ComparisonImageHash,
+			BaselineID: baseSnapshot.BaselineID,
+			SnapshotID: &baseSnapshot.ID,
+		}, nil
+	}
+
+	chain, err := q.branches.Chain(ctx, build.ProjectID, build.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline, err := q.baselineRepo.FindForBranches(ctx, build.ProjectID, snapshot.Name, chain, snapshot.Browser, snapshot.Viewport)
+	if err != nil || baseline == nil {
+		return nil, err
+	}
+
+	return &comparisonBase{
+		ImagePath:  baseline.ImagePath,
+		ImageHash:  baseline.ImageHash,
+		BaselineID: &baseline.ID,
+	}, nil
+}
+
+// sameImage reports whether the snapshot's image has the same content hash as the base image
+func sameImage(base *comparisonBase, snapshot *models.Snapshot) bool {
+	if base.ImagePath == *snapshot.ComparisonImagePath {
+		return true
+	}
+	return base.ImageHash != nil && snapshot.ComparisonImageHash != nil &&
+		*base.ImageHash == *snapshot.ComparisonImageHash
+}
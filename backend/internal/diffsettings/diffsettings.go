@@ -0,0 +1,150 @@
+/*
+Package diffsettings works out which diff options apply to a snapshot, from
+the project defaults, any matching diff rules and ignore regions
+*/
+package diffsettings
+
+import (
+	"context"
+	"image"
+	"path"
+
+	"github.com/crzytrane/diffit/internal/diffimage"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Resolver loads project settings and rules to build diff options
+type Resolver struct {
+	projectRepo *repository.ProjectRepository
+	ruleRepo    *repository.DiffRuleRepository
+	regionRepo  *repository.IgnoreRegionRepository
+}
+
+func NewResolver(pool *pgxpool.Pool) *Resolver {
+	return &Resolver{
+		projectRepo: repository.NewProjectRepository(pool),
+		ruleRepo:    repository.NewDiffRuleRepository(pool),
+		regionRepo:  repository.NewIgnoreRegionRepository(pool),
+	}
+}
+
+// Settings holds a project with its rules and ignore regions so options for
+// many snapshot names can be resolved without going back to the database
+type Settings struct {
+	Project *models.Project
+	Rules   []models.DiffRule
+	Regions []models.IgnoreRegion
+}
+
+// Load fetches the settings for a project
+func (r *Resolver) Load(ctx context.Context, projectID uuid.UUID) (*Settings, error) {
+	project, err := r.projectRepo.GetByID(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := r.ruleRepo.ListByProject(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	regions, err := r.regionRepo.ListByProject(ctx, projectID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Settings{Project: project, Rules: rules, Regions: regions}, nil
+}
+
+// LoadByRef fetches the settings for a project given either its ID or slug
+func (r *Resolver) LoadByRef(ctx context.Context, ref string) (*Settings, error) {
+	if projectID, err := uuid.Parse(ref); err == nil {
+		return r.Load(ctx, projectID)
+	}
+
+	project, err := r.projectRepo.GetBySlug(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return r.Load(ctx, project.ID)
+}
+
+// ForSnapshot resolves the diff options for a single snapshot compared
+// against the given baseline
+func (r *Resolver) ForSnapshot(ctx context.Context, projectID uuid.UUID, name string, baselineID *uuid.UUID) (diffimage.DiffOptions, error) {
+	settings, err := r.Load(ctx, projectID)
+	if err != nil {
+		return diffimage.DiffOptions{}, err
+	}
+	return settings.OptionsForBaseline(name, baselineID), nil
+}
+
+// Options returns the options for a snapshot name that isn't compared
+// against a stored baseline
+func (s *Settings) Options(name string) diffimage.DiffOptions {
+	return s.OptionsForBaseline(name, nil)
+}
+
+/*
+OptionsForBaseline returns the project defaults overridden by the highest
+priority rule whose pattern matches name. Rules must already be ordered by
+priority. Ignore regions are collected from every region whose pattern
+matches name or that is attached to baselineID.
+*/
+func (s *Settings) OptionsForBaseline(name string, baselineID *uuid.UUID) diffimage.DiffOptions {
+	options := diffimage.DiffOptions{
+		Threshold:            s.Project.DiffThreshold,
+		AcceptablePercentage: s.Project.AcceptableDiffPercentage,
+		IgnoreAntialiasing:   s.Project.IgnoreAntialiasing,
+		ColorMetric:          diffimage.ColorMetric(s.Project.ColorMetric),
+		Criterion:            diffimage.ChangeCriterion(s.Project.ChangeCriterion),
+		MinSSIM:              s.Project.MinSSIM,
+		MinRegionPixels:      s.Project.MinRegionPixels,
+		Alignment:            diffimage.Alignment(s.Project.Alignment),
+	}
+
+	for _, rule := range s.Rules {
+		if !Matches(rule.Pattern, name) {
+			continue
+		}
+
+		if rule.DiffThreshold != nil {
+			options.Threshold = *rule.DiffThreshold
+		}
+		if rule.AcceptableDiffPercentage != nil {
+			options.AcceptablePercentage = *rule.AcceptableDiffPercentage
+		}
+		if rule.IgnoreAntialiasing != nil {
+			options.IgnoreAntialiasing = *rule.IgnoreAntialiasing
+		}
+		break
+	}
+
+	for _, region := range s.Regions {
+		applies := (region.Pattern != nil && Matches(*region.Pattern, name)) ||
+			(region.BaselineID != nil && baselineID != nil && *region.BaselineID == *baselineID)
+		if applies {
+			options.IgnoreRegions = append(options.IgnoreRegions,
+				image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height))
+		}
+	}
+
+	return options
+}
+
+// Matches reports whether a snapshot name matches a rule pattern. Patterns use
+// path.Match syntax, so "checkout/*" matches "checkout/cart" but not
+// "checkout/cart/empty".
+func Matches(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// ValidPattern reports whether a pattern is well formed
+func ValidPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
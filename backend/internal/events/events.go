@@ -0,0 +1,81 @@
+/*
+Package events carries things that happened in diffit, such as a build being
+finalized or a snapshot reviewed, from the code that does them to whoever is
+listening. Publishing is synchronous so subscribers can record the event
+durably before the request that caused it returns.
+*/
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+)
+
+// Event is one thing that happened in a project. It is sent to webhooks as is.
+type Event struct {
+	ID        uuid.UUID        `json:"id"`
+	Type      models.EventType `json:"event"`
+	ProjectID uuid.UUID        `json:"project_id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      any              `json:"data"`
+}
+
+// Handler receives published events
+type Handler func(ctx context.Context, event Event)
+
+// Bus fans events out to its subscribers
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers a handler for every event published after it
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish sends an event to every subscriber and returns it
+func (b *Bus) Publish(ctx context.Context, projectID uuid.UUID, eventType models.EventType, data any) Event {
+	event := Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		ProjectID: projectID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(ctx, event)
+	}
+
+	return event
+}
+
+// BaselineAction says what happened in a baseline.updated event
+type BaselineAction string
+
+const (
+	BaselineSaved    BaselineAction = "saved"
+	BaselineRestored BaselineAction = "restored"
+	BaselineDeleted  BaselineAction = "deleted"
+)
+
+// BaselineUpdate is the data of a baseline.updated event
+type BaselineUpdate struct {
+	Action   BaselineAction   `json:"action"`
+	Baseline *models.Baseline `json:"baseline"`
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+type APITokenHandlers struct {
+	repo        *repository.APITokenRepository
+	projectRepo *repository.ProjectRepository
+}
+
+func NewAPITokenHandlers(repo *repository.APITokenRepository, projectRepo *repository.ProjectRepository) *APITokenHandlers {
+	return &APITokenHandlers{repo: repo, projectRepo: projectRepo}
+}
+
+// List lists a project's API tokens, without the tokens themselves
+func (h *APITokenHandlers) List(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	tokens, err := h.repo.ListByProject(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list API tokens")
+		return
+	}
+
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+
+	respondJSON(w, http.StatusOK, tokens)
+}
+
+// Create creates an API token. The response is the only time the token is shown.
+func (h *APITokenHandlers) Create(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	token, hash, prefix, err := auth.GenerateToken()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+
+	created, err := h.repo.Create(r.Context(), projectID, req.Name, hash, prefix)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, models.CreatedAPIToken{APIToken: *created, Token: token})
+}
+
+// Revoke revokes an API token, it can't be used again
+func (h *APITokenHandlers) Revoke(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	id, err := parseUUID(chi.URLParam(r, "tokenID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	existing, err := h.repo.GetByID(r.Context(), id)
+	if err != nil || existing.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "API token not found")
+		return
+	}
+
+	if _, err := h.repo.Revoke(r.Context(), id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to revoke API token")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
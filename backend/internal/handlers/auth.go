@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+)
+
+type AuthHandlers struct {
+	authn    *auth.Middleware
+	userRepo *repository.UserRepository
+}
+
+func NewAuthHandlers(authn *auth.Middleware, userRepo *repository.UserRepository) *AuthHandlers {
+	return &AuthHandlers{authn: authn, userRepo: userRepo}
+}
+
+func validateRegistration(req *models.RegisterRequest) string {
+	req.Email = strings.TrimSpace(req.Email)
+	req.Name = strings.TrimSpace(req.Name)
+
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return "A valid email is required"
+	}
+	if req.Name == "" {
+		return "Name is required"
+	}
+	if len(req.Password) < auth.MinPasswordLength {
+		return "Password must be at least 8 characters"
+	}
+	return ""
+}
+
+/*
+Register creates a user account. The very first account becomes a site admin
+and is logged in; after that only site admins can add users.
+*/
+func (h *AuthHandlers) Register(w http.ResponseWriter, r *http.Request) {
+	var req models.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := validateRegistration(&req); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	current := auth.UserFromContext(r.Context())
+	if current == nil {
+		user, err := h.userRepo.CreateFirst(r.Context(), req.Email, req.Name, passwordHash)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to create user")
+			return
+		}
+		if user == nil {
+			respondError(w, http.StatusUnauthorized, "Login required")
+			return
+		}
+
+		if err := h.authn.StartSession(w, r, user); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to start session")
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, user)
+		return
+	}
+
+	if !current.IsAdmin {
+		respondError(w, http.StatusForbidden, "Site admin required")
+		return
+	}
+
+	if existing, _ := h.userRepo.GetByEmail(r.Context(), req.Email); existing != nil {
+		respondError(w, http.StatusConflict, "A user with this email already exists")
+		return
+	}
+
+	user, err := h.userRepo.Create(r.Context(), req.Email, req.Name, passwordHash, req.IsAdmin)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, user)
+}
+
+// Login checks a password and starts a session
+func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
+	var req models.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.authn.Login(r.Context(), strings.TrimSpace(req.Email), req.Password)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+	if user == nil {
+		respondError(w, http.StatusUnauthorized, "Invalid email or password")
+		return
+	}
+
+	if err := h.authn.StartSession(w, r, user); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start session")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// Logout ends the current session
+func (h *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := h.authn.EndSession(w, r); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
+
+// Me returns the logged in user
+func (h *AuthHandlers) Me(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		respondError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// ListUsers lists every user account
+func (h *AuthHandlers) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.userRepo.List(r.Context())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list users")
+		return
+	}
+
+	if users == nil {
+		users = []models.User{}
+	}
+
+	respondJSON(w, http.StatusOK, users)
+}
@@ -1,38 +1,131 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/imagestore"
 	"github.com/crzytrane/diffit/internal/models"
 	"github.com/crzytrane/diffit/internal/repository"
 	"github.com/crzytrane/diffit/internal/storage"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 type BaselineHandlers struct {
 	repo         *repository.BaselineRepository
+	versionRepo  *repository.BaselineVersionRepository
 	projectRepo  *repository.ProjectRepository
+	buildRepo    *repository.BuildRepository
 	snapshotRepo *repository.SnapshotRepository
-	storage      *storage.Storage
+	storage      storage.Storage
+	images       *imagestore.Store
+	cache        *derived.Cache
+	bus          *events.Bus
 }
 
 func NewBaselineHandlers(
 	repo *repository.BaselineRepository,
+	versionRepo *repository.BaselineVersionRepository,
 	projectRepo *repository.ProjectRepository,
+	buildRepo *repository.BuildRepository,
 	snapshotRepo *repository.SnapshotRepository,
-	storage *storage.Storage,
+	storage storage.Storage,
+	images *imagestore.Store,
+	cache *derived.Cache,
+	bus *events.Bus,
 ) *BaselineHandlers {
 	return &BaselineHandlers{
 		repo:         repo,
+		versionRepo:  versionRepo,
 		projectRepo:  projectRepo,
+		buildRepo:    buildRepo,
 		snapshotRepo: snapshotRepo,
 		storage:      storage,
+		images:       images,
+		cache:        cache,
+		bus:          bus,
 	}
 }
 
+// approverOf is who a baseline change made by user is recorded against
+func approverOf(user *models.User) repository.Approver {
+	if user == nil {
+		return repository.Approver{}
+	}
+	name := reviewerName(user)
+	return repository.Approver{UserID: &user.ID, Name: &name}
+}
+
+// ProjectFromForm resolves the project a baseline is uploaded to from the project_id form value
+func (h *BaselineHandlers) ProjectFromForm(r *http.Request) (uuid.UUID, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Failed to parse multipart form"}
+	}
+
+	projectID, err := parseUUID(r.FormValue("project_id"))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid project ID"}
+	}
+
+	return projectID, nil
+}
+
+// ProjectFromSnapshotBody resolves the project of the snapshot a baseline is created from
+func (h *BaselineHandlers) ProjectFromSnapshotBody(r *http.Request) (uuid.UUID, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid request body"}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid request body"}
+	}
+
+	snapshotID, err := parseUUID(req.SnapshotID)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid snapshot ID"}
+	}
+
+	snapshot, err := h.snapshotRepo.GetByID(r.Context(), snapshotID)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusNotFound, Message: "Snapshot not found"}
+	}
+
+	build, err := h.buildRepo.GetByID(r.Context(), snapshot.BuildID)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusNotFound, Message: "Build not found"}
+	}
+
+	return build.ProjectID, nil
+}
+
+// ProjectFromURL resolves the project of the baseline in the URL
+func (h *BaselineHandlers) ProjectFromURL(r *http.Request) (uuid.UUID, error) {
+	id, err := parseUUID(chi.URLParam(r, "baselineID"))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid baseline ID"}
+	}
+
+	baseline, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusNotFound, Message: "Baseline not found"}
+	}
+
+	return baseline.ProjectID, nil
+}
+
 // Create creates a new baseline from an uploaded image
 func (h *BaselineHandlers) Create(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
@@ -95,28 +188,46 @@ func (h *BaselineHandlers) Create(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Save baseline image
-	imagePath, err := h.storage.SaveFile(projectID, storage.StorageTypeBaseline, fmt.Sprintf("%s.png", name), file)
+	blob, err := h.images.Put(r.Context(), projectID, file)
+	if errors.Is(err, imagestore.ErrInvalidImage) {
+		respondError(w, http.StatusBadRequest, "Image could not be decoded")
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to save image")
 		return
 	}
 
+	if width == nil {
+		width = &blob.Width
+	}
+	if height == nil {
+		height = &blob.Height
+	}
+
 	// Create or update baseline
 	baseline, err := h.repo.Upsert(r.Context(), repository.CreateBaselineParams{
 		ProjectID: projectID,
 		Name:      name,
 		Branch:    branch,
-		ImagePath: imagePath,
+		ImagePath: blob.Path,
+		ImageHash: &blob.Hash,
 		Width:     width,
 		Height:    height,
 		Browser:   browserPtr,
 		Viewport:  viewportPtr,
+		Approver:  approverOf(auth.UserFromContext(r.Context())),
 	})
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create baseline")
 		return
 	}
 
+	h.bus.Publish(r.Context(), baseline.ProjectID, models.EventBaselineUpdated, events.BaselineUpdate{
+		Action:   events.BaselineSaved,
+		Baseline: baseline,
+	})
+
 	respondJSON(w, http.StatusCreated, baseline)
 }
 
@@ -149,7 +260,7 @@ func (h *BaselineHandlers) CreateFromSnapshot(w http.ResponseWriter, r *http.Req
 	}
 
 	// Get build to get project ID
-	build, err := h.projectRepo.GetByID(r.Context(), snapshot.BuildID)
+	build, err := h.buildRepo.GetByID(r.Context(), snapshot.BuildID)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get build")
 		return
@@ -158,36 +269,51 @@ func (h *BaselineHandlers) CreateFromSnapshot(w http.ResponseWriter, r *http.Req
 	branch := req.Branch
 	if branch == "" {
 		// Use build's branch
-		buildData, _ := h.snapshotRepo.GetByID(r.Context(), snapshot.BuildID)
-		if buildData != nil {
-			branch = "main" // Default fallback
-		}
+		branch = build.Branch
 	}
 
-	// Copy image to baseline storage
-	newImagePath, err := h.storage.CopyFile(*snapshot.ComparisonImagePath, build.ID, storage.StorageTypeBaseline, fmt.Sprintf("%s.png", snapshot.Name))
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to copy image")
-		return
+	// Snapshots uploaded before images were hashed own their file, so store a
+	// hashed copy for the baseline rather than sharing it
+	imagePath, imageHash := *snapshot.ComparisonImagePath, snapshot.ComparisonImageHash
+	if imageHash == nil {
+		file, err := h.storage.GetFile(imagePath)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to read image")
+			return
+		}
+		blob, err := h.images.Put(r.Context(), build.ProjectID, file)
+		file.Close()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to copy image")
+			return
+		}
+		imagePath, imageHash = blob.Path, &blob.Hash
 	}
 
 	// Create baseline
 	baseline, err := h.repo.Upsert(r.Context(), repository.CreateBaselineParams{
-		ProjectID:        build.ID,
+		ProjectID:        build.ProjectID,
 		Name:             snapshot.Name,
 		Branch:           branch,
-		ImagePath:        newImagePath,
+		ImagePath:        imagePath,
+		ImageHash:        imageHash,
 		Width:            snapshot.Width,
 		Height:           snapshot.Height,
 		Browser:          snapshot.Browser,
 		Viewport:         snapshot.Viewport,
 		SourceSnapshotID: &snapshot.ID,
+		Approver:         approverOf(auth.UserFromContext(r.Context())),
 	})
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create baseline")
 		return
 	}
 
+	h.bus.Publish(r.Context(), baseline.ProjectID, models.EventBaselineUpdated, events.BaselineUpdate{
+		Action:   events.BaselineSaved,
+		Baseline: baseline,
+	})
+
 	respondJSON(w, http.StatusCreated, baseline)
 }
 
@@ -257,16 +383,7 @@ func (h *BaselineHandlers) GetImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := h.storage.GetFile(baseline.ImagePath)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "Image file not found")
-		return
-	}
-	defer file.Close()
-
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
-	io.Copy(w, file)
+	serveStoredImage(w, r, h.cache, baseline.ImagePath, baseline.UpdatedAt)
 }
 
 // Delete deletes a baseline
@@ -284,13 +401,104 @@ func (h *BaselineHandlers) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete image
-	h.storage.DeleteFile(baseline.ImagePath)
+	// Delete image, hashed images may be shared and are left to the image sweeper
+	if baseline.ImageHash == nil {
+		h.storage.DeleteFile(baseline.ImagePath)
+	}
 
 	if err := h.repo.Delete(r.Context(), id); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to delete baseline")
 		return
 	}
 
+	h.bus.Publish(r.Context(), baseline.ProjectID, models.EventBaselineUpdated, events.BaselineUpdate{
+		Action:   events.BaselineDeleted,
+		Baseline: baseline,
+	})
+
 	respondJSON(w, http.StatusNoContent, nil)
 }
+
+// ListVersions lists a baseline's history, newest first, with a link to
+// each version's image
+func (h *BaselineHandlers) ListVersions(w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(chi.URLParam(r, "baselineID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid baseline ID")
+		return
+	}
+
+	if _, err := h.repo.GetByID(r.Context(), id); err != nil {
+		respondError(w, http.StatusNotFound, "Baseline not found")
+		return
+	}
+
+	versions, err := h.versionRepo.ListByBaseline(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list baseline versions")
+		return
+	}
+
+	if versions == nil {
+		versions = []models.BaselineVersion{}
+	}
+	for i := range versions {
+		versions[i].ImageURL = fmt.Sprintf("/api/baselines/%s/versions/%s/image", id, versions[i].ID)
+	}
+
+	respondJSON(w, http.StatusOK, versions)
+}
+
+// GetVersionImage serves the image of a baseline version
+func (h *BaselineHandlers) GetVersionImage(w http.ResponseWriter, r *http.Request) {
+	version, ok := h.findVersion(w, r)
+	if !ok {
+		return
+	}
+
+	serveStoredImage(w, r, h.cache, version.ImagePath, version.CreatedAt)
+}
+
+// Rollback makes a previous version the baseline's current image again
+func (h *BaselineHandlers) Rollback(w http.ResponseWriter, r *http.Request) {
+	version, ok := h.findVersion(w, r)
+	if !ok {
+		return
+	}
+
+	baseline, err := h.repo.Restore(r.Context(), version.BaselineID, version.ID, approverOf(auth.UserFromContext(r.Context())))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to roll back baseline")
+		return
+	}
+
+	h.bus.Publish(r.Context(), baseline.ProjectID, models.EventBaselineUpdated, events.BaselineUpdate{
+		Action:   events.BaselineRestored,
+		Baseline: baseline,
+	})
+
+	respondJSON(w, http.StatusOK, baseline)
+}
+
+// findVersion loads the version from the URL and checks it belongs to the baseline in the URL
+func (h *BaselineHandlers) findVersion(w http.ResponseWriter, r *http.Request) (*models.BaselineVersion, bool) {
+	baselineID, err := parseUUID(chi.URLParam(r, "baselineID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid baseline ID")
+		return nil, false
+	}
+
+	id, err := parseUUID(chi.URLParam(r, "versionID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version ID")
+		return nil, false
+	}
+
+	version, err := h.versionRepo.GetByID(r.Context(), id)
+	if err != nil || version.BaselineID != baselineID {
+		respondError(w, http.StatusNotFound, "Baseline version not found")
+		return nil, false
+	}
+
+	return version, true
+}
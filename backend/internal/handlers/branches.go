@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/branches"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/crzytrane/diffit/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// mergeHookApprover is recorded on baseline versions promoted by a merge hook
+const mergeHookApprover = "merge hook"
+
+type BranchHandlers struct {
+	ruleRepo      *repository.BranchRuleRepository
+	baselineRepo  *repository.BaselineRepository
+	projectRepo   *repository.ProjectRepository
+	mergeHookRepo *repository.MergeHookRepository
+}
+
+func NewBranchHandlers(
+	ruleRepo *repository.BranchRuleRepository,
+	baselineRepo *repository.BaselineRepository,
+	projectRepo *repository.ProjectRepository,
+	mergeHookRepo *repository.MergeHookRepository,
+) *BranchHandlers {
+	return &BranchHandlers{
+		ruleRepo:      ruleRepo,
+		baselineRepo:  baselineRepo,
+		projectRepo:   projectRepo,
+		mergeHookRepo: mergeHookRepo,
+	}
+}
+
+func validateBranchRule(req models.BranchRuleRequest) string {
+	if req.Pattern == "" {
+		return "pattern is required"
+	}
+	if !branches.ValidPattern(req.Pattern) {
+		return "pattern is not a valid glob"
+	}
+	if req.ParentBranch == "" {
+		return "parent_branch is required"
+	}
+	return ""
+}
+
+// ListRules lists the branch rules for a project in match order
+func (h *BranchHandlers) ListRules(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	rules, err := h.ruleRepo.ListByProject(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list branch rules")
+		return
+	}
+
+	if rules == nil {
+		rules = []models.BranchRule{}
+	}
+
+	respondJSON(w, http.StatusOK, rules)
+}
+
+// CreateRule adds a branch rule to a project
+func (h *BranchHandlers) CreateRule(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.BranchRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := validateBranchRule(req); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	rule, err := h.ruleRepo.Create(r.Context(), projectID, req)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create branch rule")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+// UpdateRule replaces a branch rule
+func (h *BranchHandlers) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.findRule(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.BranchRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := validateBranchRule(req); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	rule, err := h.ruleRepo.Update(r.Context(), rule.ID, req)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update branch rule")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// DeleteRule removes a branch rule
+func (h *BranchHandlers) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.findRule(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.ruleRepo.Delete(r.Context(), rule.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete branch rule")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
+
+// findRule loads the rule from the URL and checks it belongs to the project in the URL
+func (h *BranchHandlers) findRule(w http.ResponseWriter, r *http.Request) (*models.BranchRule, bool) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return nil, false
+	}
+
+	id, err := parseUUID(chi.URLParam(r, "ruleID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid rule ID")
+		return nil, false
+	}
+
+	rule, err := h.ruleRepo.GetByID(r.Context(), id)
+	if err != nil || rule.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Branch rule not found")
+		return nil, false
+	}
+
+	return rule, true
+}
+
+// Promote copies a branch's baselines onto another branch, typically after
+// the branch has been merged
+func (h *BranchHandlers) Promote(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.SourceBranch == "" {
+		respondError(w, http.StatusBadRequest, "source_branch is required")
+		return
+	}
+
+	project, err := h.projectRepo.GetByID(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	if req.TargetBranch == "" {
+		req.TargetBranch = project.DefaultBranch
+	}
+	if req.TargetBranch == req.SourceBranch {
+		respondError(w, http.StatusBadRequest, "source_branch and target_branch must differ")
+		return
+	}
+
+	result, err := h.promote(r.Context(), projectID, req, approverOf(auth.UserFromContext(r.Context())))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to promote baselines")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+func (h *BranchHandlers) promote(ctx context.Context, projectID uuid.UUID, req models.PromoteRequest, approver repository.Approver) (*models.PromoteResult, error) {
+	promoted, deleted, err := h.baselineRepo.Promote(ctx, projectID, req.SourceBranch, req.TargetBranch, approver, req.DeleteSource)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PromoteResult{
+		SourceBranch: req.SourceBranch,
+		TargetBranch: req.TargetBranch,
+		Promoted:     promoted,
+		Deleted:      deleted,
+	}, nil
+}
+
+// GetMergeHook returns a project's merge hook settings
+func (h *BranchHandlers) GetMergeHook(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	hook, err := h.mergeHookRepo.Get(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get merge hook")
+		return
+	}
+	if hook == nil {
+		respondError(w, http.StatusNotFound, "Merge hook is not configured")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, hook)
+}
+
+// SaveMergeHook creates a project's merge hook, or rotates its secret, and
+// returns the secret and the URL to give the git host
+func (h *BranchHandlers) SaveMergeHook(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.MergeHookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save merge hook")
+		return
+	}
+
+	hook, err := h.mergeHookRepo.Save(r.Context(), models.MergeHook{
+		ProjectID:    projectID,
+		Secret:       secret,
+		DeleteSource: req.DeleteSource == nil || *req.DeleteSource,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save merge hook")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.CreatedMergeHook{
+		MergeHook: *hook,
+		Secret:    secret,
+		URL:       fmt.Sprintf("%s/api/hooks/merge/%s", requestBaseURL(r), projectID),
+	})
+}
+
+// DeleteMergeHook turns a project's merge hook off
+func (h *BranchHandlers) DeleteMergeHook(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	if err := h.mergeHookRepo.Delete(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete merge hook")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
+
+// requestBaseURL is the scheme and host the request was made to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// githubPullRequestEvent is the part of a GitHub pull_request event we use
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Merged bool `json:"merged"`
+		Head   struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+// gitlabMergeRequestEvent is the part of a GitLab merge request event we use
+type gitlabMergeRequestEvent struct {
+	ObjectKind       string `json:"object_kind"`
+	ObjectAttributes struct {
+		Action       string `json:"action"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+	} `json:"object_attributes"`
+}
+
+/*
+ReceiveMerge handles merge events sent by the git host. GitHub pull_request
+events are checked against the X-Hub-Signature-256 HMAC of the body, GitLab
+merge request events against the X-Gitlab-Token header. When a pull or merge
+request is merged its source branch's baselines are promoted onto its target
+branch; every other event is acknowledged and ignored.
+*/
+func (h *BranchHandlers) ReceiveMerge(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	hook, err := h.mergeHookRepo.Get(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get merge hook")
+		return
+	}
+	if hook == nil {
+		respondError(w, http.StatusNotFound, "Merge hook is not configured")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 5<<20))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var source, target string
+	switch {
+	case r.Header.Get("X-GitHub-Event") != "":
+		signature := r.Header.Get("X-Hub-Signature-256")
+		if !hmac.Equal([]byte(signature), []byte(webhooks.Sign(hook.Secret, body))) {
+			respondError(w, http.StatusUnauthorized, "Invalid signature")
+			return
+		}
+		if r.Header.Get("X-GitHub-Event") != "pull_request" {
+			break
+		}
+
+		var event githubPullRequestEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if event.Action == "closed" && event.PullRequest.Merged {
+			source, target = event.PullRequest.Head.Ref, event.PullRequest.Base.Ref
+		}
+
+	case r.Header.Get("X-Gitlab-Event") != "":
+		if !hmac.Equal([]byte(r.Header.Get("X-Gitlab-Token")), []byte(hook.Secret)) {
+			respondError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		var event gitlabMergeRequestEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if event.ObjectKind == "merge_request" && event.ObjectAttributes.Action == "merge" {
+			source, target = event.ObjectAttributes.SourceBranch, event.ObjectAttributes.TargetBranch
+		}
+
+	default:
+		respondError(w, http.StatusBadRequest, "Unsupported webhook, expected a GitHub or GitLab event")
+		return
+	}
+
+	if source == "" || target == "" || source == target {
+		respondJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	approver := mergeHookApprover
+	result, err := h.promote(r.Context(), projectID, models.PromoteRequest{
+		SourceBranch: source,
+		TargetBranch: target,
+		DeleteSource: hook.DeleteSource,
+	}, repository.Approver{Name: &approver})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to promote baselines")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
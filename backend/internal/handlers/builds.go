@@ -1,23 +1,97 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
+	"regexp"
+	"strings"
 
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/branches"
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/janitor"
 	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/notify"
 	"github.com/crzytrane/diffit/internal/repository"
 	"github.com/crzytrane/diffit/internal/storage"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+// commitSHAPattern matches full and abbreviated git commit SHAs
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
 type BuildHandlers struct {
-	repo        *repository.BuildRepository
-	projectRepo *repository.ProjectRepository
-	storage     *storage.Storage
+	repo            *repository.BuildRepository
+	projectRepo     *repository.ProjectRepository
+	snapshotRepo    *repository.SnapshotRepository
+	buildReviewRepo *repository.BuildReviewRepository
+	reviews         *reviews
+	branches        *branches.Resolver
+	notifier        *notify.Notifier
+	bus             *events.Bus
+	storage         storage.Storage
+	janitor         *janitor.Janitor
+}
+
+func NewBuildHandlers(
+	repo *repository.BuildRepository,
+	projectRepo *repository.ProjectRepository,
+	snapshotRepo *repository.SnapshotRepository,
+	buildReviewRepo *repository.BuildReviewRepository,
+	reviews *reviews,
+	branches *branches.Resolver,
+	notifier *notify.Notifier,
+	bus *events.Bus,
+	storage storage.Storage,
+	janitor *janitor.Janitor,
+) *BuildHandlers {
+	return &BuildHandlers{
+		repo:            repo,
+		projectRepo:     projectRepo,
+		snapshotRepo:    snapshotRepo,
+		buildReviewRepo: buildReviewRepo,
+		reviews:         reviews,
+		branches:        branches,
+		notifier:        notifier,
+		bus:             bus,
+		storage:         storage,
+		janitor:         janitor,
+	}
+}
+
+// ProjectFromBody resolves the project a build is being created in from the
+// request body, leaving the body in place for Create
+func (h *BuildHandlers) ProjectFromBody(r *http.Request) (uuid.UUID, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid request body"}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req models.CreateBuildRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid request body"}
+	}
+
+	return req.ProjectID, nil
 }
 
-func NewBuildHandlers(repo *repository.BuildRepository, projectRepo *repository.ProjectRepository, storage *storage.Storage) *BuildHandlers {
-	return &BuildHandlers{repo: repo, projectRepo: projectRepo, storage: storage}
+// ProjectFromURL resolves the project of the build in the URL
+func (h *BuildHandlers) ProjectFromURL(r *http.Request) (uuid.UUID, error) {
+	id, err := parseUUID(chi.URLParam(r, "buildID"))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid build ID"}
+	}
+
+	build, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusNotFound, Message: "Build not found"}
+	}
+
+	return build.ProjectID, nil
 }
 
 // Create creates a new build
@@ -40,12 +114,66 @@ func (h *BuildHandlers) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A retry of a request whose response was lost carries the same key and
+	// gets the build the first attempt created
+	key, ok := idempotencyKey(r)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+		return
+	}
+	if key != nil {
+		existing, err := h.repo.FindByIdempotencyKey(r.Context(), req.ProjectID, *key)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to create build")
+			return
+		}
+		if existing != nil {
+			respondJSON(w, http.StatusOK, existing)
+			return
+		}
+	}
+	req.IdempotencyKey = key
+
+	if req.BaseBuildID != nil {
+		base, err := h.repo.GetByID(r.Context(), *req.BaseBuildID)
+		if err != nil || base.ProjectID != req.ProjectID {
+			respondError(w, http.StatusNotFound, "Base build not found")
+			return
+		}
+	}
+
+	if req.BaseCommitSHA != nil {
+		sha := strings.ToLower(*req.BaseCommitSHA)
+		if !commitSHAPattern.MatchString(sha) {
+			respondError(w, http.StatusBadRequest, "base_commit_sha must be a commit SHA")
+			return
+		}
+		req.BaseCommitSHA = &sha
+
+		// Compare against the build of the merge base if it has been built,
+		// otherwise the branch baselines are used as usual
+		if req.BaseBuildID == nil {
+			base, err := h.repo.FindByCommit(r.Context(), req.ProjectID, sha)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to find base build")
+				return
+			}
+			if base != nil {
+				req.BaseBuildID = &base.ID
+			}
+		}
+	}
+
 	build, err := h.repo.Create(r.Context(), req)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create build")
 		return
 	}
 
+	// Let the pull request show the build as pending
+	h.notifier.BuildChanged(build.ID)
+	h.bus.Publish(r.Context(), build.ProjectID, models.EventBuildCreated, build)
+
 	respondJSON(w, http.StatusCreated, build)
 }
 
@@ -126,6 +254,7 @@ func (h *BuildHandlers) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 	if err := h.repo.UpdateStats(r.Context(), id); err != nil {
 		// Log but don't fail
 	}
+	h.buildReviewRepo.Refresh(r.Context(), id, nil, nil)
 
 	build, err := h.repo.GetByID(r.Context(), id)
 	if err != nil {
@@ -133,6 +262,9 @@ func (h *BuildHandlers) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.notifier.BuildChanged(build.ID)
+	h.bus.Publish(r.Context(), build.ProjectID, models.EventBuildUpdated, build)
+
 	respondJSON(w, http.StatusOK, build)
 }
 
@@ -145,24 +277,76 @@ func (h *BuildHandlers) Finalize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	build, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+
+	// Baselines the build has no screenshot for are recorded as removed
+	// snapshots, approving one deletes the baseline. Builds compared against
+	// a base build look for what's missing in that build, as their diffs do.
+	if build.BaseBuildID != nil {
+		_, err = h.snapshotRepo.CreateRemovedFromBase(r.Context(), build, *build.BaseBuildID)
+	} else {
+		var chain []string
+		chain, err = h.branches.Chain(r.Context(), build.ProjectID, build.Branch)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to resolve baseline branches")
+			return
+		}
+		_, err = h.snapshotRepo.CreateRemoved(r.Context(), build, chain)
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to detect removed snapshots")
+		return
+	}
+
 	// Update stats
 	if err := h.repo.UpdateStats(r.Context(), id); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update build stats")
 		return
 	}
 
-	// Set status to completed
-	if err := h.repo.UpdateStatus(r.Context(), id, models.BuildStatusCompleted); err != nil {
+	// Builds with snapshots still in the diff queue stay processing, the
+	// worker completes them once the last snapshot is done
+	unprocessed, err := h.snapshotRepo.CountUnprocessed(r.Context(), id)
+	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to finalize build")
 		return
 	}
 
-	build, err := h.repo.GetByID(r.Context(), id)
+	status := models.BuildStatusCompleted
+	if unprocessed > 0 {
+		status = models.BuildStatusProcessing
+	}
+
+	if err := h.repo.UpdateStatus(r.Context(), id, status); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to finalize build")
+		return
+	}
+
+	// The last job may have finished between counting and updating the status
+	if status == models.BuildStatusProcessing {
+		h.repo.CompleteIfProcessed(r.Context(), id)
+	}
+
+	// A completed build without changes is approved straight away
+	if _, err := h.buildReviewRepo.Refresh(r.Context(), id, nil, nil); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update build review state")
+		return
+	}
+
+	build, err = h.repo.GetByID(r.Context(), id)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get finalized build")
 		return
 	}
 
+	// Report the build as waiting for diffs, or its review outcome if it's done
+	h.notifier.BuildChanged(build.ID)
+	h.bus.Publish(r.Context(), build.ProjectID, models.EventBuildFinalized, build)
+
 	respondJSON(w, http.StatusOK, build)
 }
 
@@ -204,10 +388,109 @@ func (h *BuildHandlers) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.repo.Delete(r.Context(), id); err != nil {
+	build, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+
+	// Remove the build's diff images along with it, shared images are left
+	// to the image sweeper
+	if err := h.janitor.DeleteBuild(r.Context(), build); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to delete build")
 		return
 	}
 
 	respondJSON(w, http.StatusNoContent, nil)
 }
+
+// Approve approves every change in a build that hasn't been reviewed yet
+func (h *BuildHandlers) Approve(w http.ResponseWriter, r *http.Request) {
+	h.reviewRemaining(w, r, models.ReviewStatusApproved)
+}
+
+// Reject rejects every change in a build that hasn't been reviewed yet
+func (h *BuildHandlers) Reject(w http.ResponseWriter, r *http.Request) {
+	h.reviewRemaining(w, r, models.ReviewStatusRejected)
+}
+
+// reviewRemaining reviews the unreviewed changes in a build and responds with
+// the build and its new review state
+func (h *BuildHandlers) reviewRemaining(w http.ResponseWriter, r *http.Request, status models.ReviewStatus) {
+	id, err := parseUUID(chi.URLParam(r, "buildID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid build ID")
+		return
+	}
+
+	build, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+
+	if build.Status != models.BuildStatusCompleted {
+		respondError(w, http.StatusConflict, "Build has not finished processing")
+		return
+	}
+
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		respondError(w, http.StatusUnauthorized, "Login required")
+		return
+	}
+
+	snapshots, err := h.snapshotRepo.ReviewRemaining(r.Context(), id, models.ReviewSnapshotRequest{
+		ReviewStatus:     status,
+		ReviewedBy:       reviewerName(user),
+		ReviewedByUserID: user.ID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update review status")
+		return
+	}
+
+	for i := range snapshots {
+		if status == models.ReviewStatusApproved {
+			if err := h.reviews.applyApproval(r.Context(), &snapshots[i]); err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to update baseline")
+				return
+			}
+		}
+		h.bus.Publish(r.Context(), build.ProjectID, models.EventSnapshotReviewed, &snapshots[i])
+	}
+
+	if err := h.reviews.refreshBuild(r.Context(), id, user); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update build review state")
+		return
+	}
+
+	build, err = h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get reviewed build")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, build)
+}
+
+// ListReviews lists the changes of a build's review state, oldest first
+func (h *BuildHandlers) ListReviews(w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(chi.URLParam(r, "buildID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid build ID")
+		return
+	}
+
+	transitions, err := h.buildReviewRepo.ListByBuild(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list build reviews")
+		return
+	}
+
+	if transitions == nil {
+		transitions = []models.BuildReviewTransition{}
+	}
+
+	respondJSON(w, http.StatusOK, transitions)
+}
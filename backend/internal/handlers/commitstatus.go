@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultStatusContext is the check name statuses are posted under
+const defaultStatusContext = "diffit"
+
+type CommitStatusHandlers struct {
+	repo        *repository.CommitStatusConfigRepository
+	projectRepo *repository.ProjectRepository
+}
+
+func NewCommitStatusHandlers(repo *repository.CommitStatusConfigRepository, projectRepo *repository.ProjectRepository) *CommitStatusHandlers {
+	return &CommitStatusHandlers{repo: repo, projectRepo: projectRepo}
+}
+
+/*
+repositoryFromURL works out a repository path like "owner/name" from a clone
+or browse URL: https://github.com/owner/name.git, git@github.com:owner/name
+and ssh://git@gitlab.com/group/sub/name all work.
+*/
+func repositoryFromURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+
+	// scp-like syntax has no scheme, user@host:path
+	if !strings.Contains(raw, "://") {
+		if _, path, ok := strings.Cut(raw, ":"); ok {
+			raw = "ssh://host/" + path
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+}
+
+// Get returns a project's commit status config
+func (h *CommitStatusHandlers) Get(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	config, err := h.repo.Get(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get commit status config")
+		return
+	}
+	if config == nil {
+		respondError(w, http.StatusNotFound, "Commit statuses are not configured")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, config)
+}
+
+// Save creates or updates a project's commit status config
+func (h *CommitStatusHandlers) Save(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.CommitStatusConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Host != models.GitHostGitHub && req.Host != models.GitHostGitLab {
+		respondError(w, http.StatusBadRequest, "host must be github or gitlab")
+		return
+	}
+	if req.APIURL != nil {
+		u, err := url.Parse(*req.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			respondError(w, http.StatusBadRequest, "api_url must be an http or https URL")
+			return
+		}
+	}
+
+	project, err := h.projectRepo.GetByID(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	existing, err := h.repo.Get(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get commit status config")
+		return
+	}
+
+	config := models.CommitStatusConfig{
+		ProjectID:  projectID,
+		Host:       req.Host,
+		APIURL:     req.APIURL,
+		Repository: strings.Trim(req.Repository, "/"),
+		Context:    defaultStatusContext,
+		Enabled:    true,
+	}
+	if existing != nil {
+		config.Token = existing.Token
+		config.Context = existing.Context
+		config.Enabled = existing.Enabled
+	}
+	if req.Token != nil {
+		config.Token = *req.Token
+	}
+	if req.Context != nil {
+		config.Context = *req.Context
+	}
+	if req.Enabled != nil {
+		config.Enabled = *req.Enabled
+	}
+
+	if config.Repository == "" && project.RepositoryURL != nil {
+		config.Repository = repositoryFromURL(*project.RepositoryURL)
+	}
+
+	if config.Repository == "" {
+		respondError(w, http.StatusBadRequest, "repository is required when the project has no repository URL")
+		return
+	}
+	if config.Host == models.GitHostGitHub && strings.Count(config.Repository, "/") != 1 {
+		respondError(w, http.StatusBadRequest, "GitHub repository must be owner/name")
+		return
+	}
+	if config.Token == "" {
+		respondError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+	if config.Context == "" {
+		respondError(w, http.StatusBadRequest, "context must not be empty")
+		return
+	}
+
+	saved, err := h.repo.Save(r.Context(), config)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save commit status config")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, saved)
+}
+
+// Delete stops a project reporting commit statuses
+func (h *CommitStatusHandlers) Delete(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	if err := h.repo.Delete(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete commit status config")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
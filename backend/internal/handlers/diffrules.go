@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/crzytrane/diffit/internal/diffsettings"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+type DiffRuleHandlers struct {
+	repo        *repository.DiffRuleRepository
+	projectRepo *repository.ProjectRepository
+}
+
+func NewDiffRuleHandlers(repo *repository.DiffRuleRepository, projectRepo *repository.ProjectRepository) *DiffRuleHandlers {
+	return &DiffRuleHandlers{repo: repo, projectRepo: projectRepo}
+}
+
+// validateDiffSettings checks diff setting values shared by projects and rules
+func validateDiffSettings(threshold, acceptablePercentage *float64) string {
+	if threshold != nil && (*threshold < 0 || *threshold > 1) {
+		return "diff_threshold must be between 0 and 1"
+	}
+	if acceptablePercentage != nil && (*acceptablePercentage < 0 || *acceptablePercentage > 100) {
+		return "acceptable_diff_percentage must be between 0 and 100"
+	}
+	return ""
+}
+
+func validateDiffRule(req models.DiffRuleRequest) string {
+	if req.Pattern == "" {
+		return "pattern is required"
+	}
+	if !diffsettings.ValidPattern(req.Pattern) {
+		return "pattern is not a valid glob"
+	}
+	return validateDiffSettings(req.DiffThreshold, req.AcceptableDiffPercentage)
+}
+
+// List lists the diff rules for a project in match order
+func (h *DiffRuleHandlers) List(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	rules, err := h.repo.ListByProject(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list diff rules")
+		return
+	}
+
+	if rules == nil {
+		rules = []models.DiffRule{}
+	}
+
+	respondJSON(w, http.StatusOK, rules)
+}
+
+// Create adds a diff rule to a project
+func (h *DiffRuleHandlers) Create(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.DiffRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := validateDiffRule(req); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	rule, err := h.repo.Create(r.Context(), projectID, req)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create diff rule")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+// Update replaces a diff rule
+func (h *DiffRuleHandlers) Update(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	id, err := parseUUID(chi.URLParam(r, "ruleID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	var req models.DiffRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := validateDiffRule(req); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	existing, err := h.repo.GetByID(r.Context(), id)
+	if err != nil || existing.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Diff rule not found")
+		return
+	}
+
+	rule, err := h.repo.Update(r.Context(), id, req)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update diff rule")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// Delete removes a diff rule
+func (h *DiffRuleHandlers) Delete(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	id, err := parseUUID(chi.URLParam(r, "ruleID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	existing, err := h.repo.GetByID(r.Context(), id)
+	if err != nil || existing.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Diff rule not found")
+		return
+	}
+
+	if err := h.repo.Delete(r.Context(), id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete diff rule")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
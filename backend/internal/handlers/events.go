@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/live"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// heartbeatInterval keeps idle streams from being closed by proxies
+const heartbeatInterval = 25 * time.Second
+
+type EventStreamHandlers struct {
+	buildRepo *repository.BuildRepository
+	hub       *live.Hub
+}
+
+func NewEventStreamHandlers(buildRepo *repository.BuildRepository, hub *live.Hub) *EventStreamHandlers {
+	return &EventStreamHandlers{buildRepo: buildRepo, hub: hub}
+}
+
+// writeEvent writes an event in the Server-Sent Events format
+func writeEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+/*
+Stream sends a build's events as Server-Sent Events until the client goes
+away. The stream starts with a build.updated event holding the build as it is
+now, so a client that reconnects after missing events is back in sync.
+*/
+func (h *EventStreamHandlers) Stream(w http.ResponseWriter, r *http.Request) {
+	id, err := parseUUID(chi.URLParam(r, "buildID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid build ID")
+		return
+	}
+
+	// Subscribe before loading the build so nothing happens in between unseen
+	sub := h.hub.Subscribe(id)
+	defer sub.Close()
+
+	build, err := h.buildRepo.GetByID(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop nginx buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	err = writeEvent(w, events.Event{
+		ID:        uuid.New(),
+		Type:      models.EventBuildUpdated,
+		ProjectID: build.ProjectID,
+		CreatedAt: time.Now().UTC(),
+		Data:      build,
+	})
+	if err != nil || rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			// Dropped for falling behind or shutting down, the client reconnects
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
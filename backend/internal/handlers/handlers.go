@@ -5,40 +5,97 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/branches"
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/diffqueue"
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/imagestore"
+	"github.com/crzytrane/diffit/internal/janitor"
+	"github.com/crzytrane/diffit/internal/live"
 	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/notify"
 	"github.com/crzytrane/diffit/internal/repository"
 	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/crzytrane/diffit/internal/webhooks"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Handlers contains all HTTP handlers and their dependencies
 type Handlers struct {
-	Projects  *ProjectHandlers
-	Builds    *BuildHandlers
-	Snapshots *SnapshotHandlers
-	Baselines *BaselineHandlers
-	storage   *storage.Storage
+	Projects      *ProjectHandlers
+	Builds        *BuildHandlers
+	Snapshots     *SnapshotHandlers
+	Baselines     *BaselineHandlers
+	DiffRules     *DiffRuleHandlers
+	IgnoreRegions *IgnoreRegionHandlers
+	APITokens     *APITokenHandlers
+	Auth          *AuthHandlers
+	Members       *MemberHandlers
+	CommitStatus  *CommitStatusHandlers
+	Webhooks      *WebhookHandlers
+	Events        *EventStreamHandlers
+	Branches      *BranchHandlers
+	Retention     *RetentionHandlers
+	Regions       *RegionHandlers
+	storage       storage.Storage
 }
 
 // New creates a new Handlers instance with all dependencies
-func New(pool *pgxpool.Pool, storage *storage.Storage) *Handlers {
+func New(pool *pgxpool.Pool, storage storage.Storage, images *imagestore.Store, queue *diffqueue.Queue, notifier *notify.Notifier, bus *events.Bus, dispatcher *webhooks.Dispatcher, hub *live.Hub, janitor *janitor.Janitor, authn *auth.Middleware) *Handlers {
 	projectRepo := repository.NewProjectRepository(pool)
 	buildRepo := repository.NewBuildRepository(pool)
 	snapshotRepo := repository.NewSnapshotRepository(pool)
 	baselineRepo := repository.NewBaselineRepository(pool)
+	baselineVersionRepo := repository.NewBaselineVersionRepository(pool)
+	diffRuleRepo := repository.NewDiffRuleRepository(pool)
+	ignoreRegionRepo := repository.NewIgnoreRegionRepository(pool)
+	apiTokenRepo := repository.NewAPITokenRepository(pool)
+	userRepo := repository.NewUserRepository(pool)
+	memberRepo := repository.NewProjectMemberRepository(pool)
+	commitStatusRepo := repository.NewCommitStatusConfigRepository(pool)
+	buildReviewRepo := repository.NewBuildReviewRepository(pool)
+	webhookRepo := repository.NewWebhookRepository(pool)
+	branchRuleRepo := repository.NewBranchRuleRepository(pool)
+	mergeHookRepo := repository.NewMergeHookRepository(pool)
+	webhookDeliveryRepo := repository.NewWebhookDeliveryRepository(pool)
+	retentionRepo := repository.NewRetentionPolicyRepository(pool)
+	regionRepo := repository.NewSnapshotRegionRepository(pool)
+
+	cache := derived.New(storage)
+	reviews := newReviews(buildRepo, baselineRepo, buildReviewRepo, notifier, bus)
 
 	return &Handlers{
-		Projects:  NewProjectHandlers(projectRepo, storage),
-		Builds:    NewBuildHandlers(buildRepo, projectRepo, storage),
-		Snapshots: NewSnapshotHandlers(snapshotRepo, buildRepo, baselineRepo, storage),
-		Baselines: NewBaselineHandlers(baselineRepo, projectRepo, snapshotRepo, storage),
-		storage:   storage,
+		Projects:      NewProjectHandlers(projectRepo, memberRepo, storage),
+		Builds:        NewBuildHandlers(buildRepo, projectRepo, snapshotRepo, buildReviewRepo, reviews, branches.NewResolver(pool), notifier, bus, storage, janitor),
+		Snapshots:     NewSnapshotHandlers(snapshotRepo, buildRepo, baselineRepo, reviews, storage, images, cache, queue, bus),
+		Baselines:     NewBaselineHandlers(baselineRepo, baselineVersionRepo, projectRepo, buildRepo, snapshotRepo, storage, images, cache, bus),
+		DiffRules:     NewDiffRuleHandlers(diffRuleRepo, projectRepo),
+		IgnoreRegions: NewIgnoreRegionHandlers(ignoreRegionRepo, projectRepo, baselineRepo),
+		APITokens:     NewAPITokenHandlers(apiTokenRepo, projectRepo),
+		Auth:          NewAuthHandlers(authn, userRepo),
+		Members:       NewMemberHandlers(memberRepo, projectRepo, userRepo),
+		CommitStatus:  NewCommitStatusHandlers(commitStatusRepo, projectRepo),
+		Webhooks:      NewWebhookHandlers(webhookRepo, webhookDeliveryRepo, projectRepo, dispatcher),
+		Events:        NewEventStreamHandlers(buildRepo, hub),
+		Branches:      NewBranchHandlers(branchRuleRepo, baselineRepo, projectRepo, mergeHookRepo),
+		Retention:     NewRetentionHandlers(retentionRepo, projectRepo, janitor),
+		Regions:       NewRegionHandlers(regionRepo, snapshotRepo, buildRepo, cache),
+		storage:       storage,
 	}
 }
 
 // Helper functions
 
+// reviewerName is what's recorded as reviewed_by for a user
+func reviewerName(user *models.User) string {
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Email
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -51,6 +108,20 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respondJSON(w, status, map[string]string{"error": message})
 }
 
+// idempotencyKey reads the Idempotency-Key header a client sends so that a
+// retry of a request whose response was lost returns what the first attempt
+// created. ok is false if the key is too long to store.
+func idempotencyKey(r *http.Request) (key *string, ok bool) {
+	value := r.Header.Get("Idempotency-Key")
+	if value == "" {
+		return nil, true
+	}
+	if len(value) > 255 {
+		return nil, false
+	}
+	return &value, true
+}
+
 func parseUUID(s string) (uuid.UUID, error) {
 	return uuid.Parse(s)
 }
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/crzytrane/diffit/internal/diffsettings"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type IgnoreRegionHandlers struct {
+	repo         *repository.IgnoreRegionRepository
+	projectRepo  *repository.ProjectRepository
+	baselineRepo *repository.BaselineRepository
+}
+
+func NewIgnoreRegionHandlers(
+	repo *repository.IgnoreRegionRepository,
+	projectRepo *repository.ProjectRepository,
+	baselineRepo *repository.BaselineRepository,
+) *IgnoreRegionHandlers {
+	return &IgnoreRegionHandlers{
+		repo:         repo,
+		projectRepo:  projectRepo,
+		baselineRepo: baselineRepo,
+	}
+}
+
+// validate checks a region request and that its baseline belongs to the project
+func (h *IgnoreRegionHandlers) validate(r *http.Request, projectID uuid.UUID, req models.IgnoreRegionRequest) (int, string) {
+	if (req.BaselineID == nil) == (req.Pattern == nil) {
+		return http.StatusBadRequest, "Exactly one of baseline_id or pattern is required"
+	}
+	if req.Pattern != nil && (*req.Pattern == "" || !diffsettings.ValidPattern(*req.Pattern)) {
+		return http.StatusBadRequest, "pattern is not a valid glob"
+	}
+	if req.X < 0 || req.Y < 0 || req.Width <= 0 || req.Height <= 0 {
+		return http.StatusBadRequest, "x and y must not be negative, width and height must be positive"
+	}
+
+	if req.BaselineID != nil {
+		baseline, err := h.baselineRepo.GetByID(r.Context(), *req.BaselineID)
+		if err != nil || baseline.ProjectID != projectID {
+			return http.StatusNotFound, "Baseline not found"
+		}
+	}
+
+	return 0, ""
+}
+
+// List lists ignore regions for a project, optionally filtered by baseline_id
+func (h *IgnoreRegionHandlers) List(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var baselineID *uuid.UUID
+	if b := r.URL.Query().Get("baseline_id"); b != "" {
+		id, err := parseUUID(b)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid baseline ID")
+			return
+		}
+		baselineID = &id
+	}
+
+	regions, err := h.repo.ListByProject(r.Context(), projectID, baselineID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list ignore regions")
+		return
+	}
+
+	if regions == nil {
+		regions = []models.IgnoreRegion{}
+	}
+
+	respondJSON(w, http.StatusOK, regions)
+}
+
+// Create adds an ignore region to a project
+func (h *IgnoreRegionHandlers) Create(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.IgnoreRegionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	if status, msg := h.validate(r, projectID, req); msg != "" {
+		respondError(w, status, msg)
+		return
+	}
+
+	region, err := h.repo.Create(r.Context(), projectID, req)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create ignore region")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, region)
+}
+
+// Get retrieves an ignore region
+func (h *IgnoreRegionHandlers) Get(w http.ResponseWriter, r *http.Request) {
+	region, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, region)
+}
+
+// Update replaces an ignore region
+func (h *IgnoreRegionHandlers) Update(w http.ResponseWriter, r *http.Request) {
+	region, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.IgnoreRegionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if status, msg := h.validate(r, region.ProjectID, req); msg != "" {
+		respondError(w, status, msg)
+		return
+	}
+
+	region, err := h.repo.Update(r.Context(), region.ID, req)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update ignore region")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, region)
+}
+
+// Delete removes an ignore region
+func (h *IgnoreRegionHandlers) Delete(w http.ResponseWriter, r *http.Request) {
+	region, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.Delete(r.Context(), region.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete ignore region")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
+
+// find loads the region from the URL and checks it belongs to the project in the URL
+func (h *IgnoreRegionHandlers) find(w http.ResponseWriter, r *http.Request) (*models.IgnoreRegion, bool) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return nil, false
+	}
+
+	id, err := parseUUID(chi.URLParam(r, "regionID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid region ID")
+		return nil, false
+	}
+
+	region, err := h.repo.GetByID(r.Context(), id)
+	if err != nil || region.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Ignore region not found")
+		return nil, false
+	}
+
+	return region, true
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/storage"
+)
+
+// maxVariantSize bounds the width and height a resized image can be asked for
+const maxVariantSize = 4096
+
+/*
+parseVariant reads width, height and format from the query string. Nil means
+none were given and the stored image is served as it is. A format alone
+re-encodes the image at its own size.
+*/
+func parseVariant(r *http.Request) (*derived.Variant, string) {
+	query := r.URL.Query()
+	if !query.Has("width") && !query.Has("height") && !query.Has("format") {
+		return nil, ""
+	}
+
+	variant := &derived.Variant{Format: derived.FormatPNG}
+	for _, side := range []struct {
+		name  string
+		value *int
+	}{{"width", &variant.Width}, {"height", &variant.Height}} {
+		if value := query.Get(side.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxVariantSize {
+				return nil, fmt.Sprintf("%s must be between 1 and %d", side.name, maxVariantSize)
+			}
+			*side.value = parsed
+		}
+	}
+
+	if format := query.Get("format"); format != "" {
+		variant.Format = derived.Format(format)
+		if !derived.ValidFormat(variant.Format) {
+			return nil, "format must be png, webp or jpeg"
+		}
+	}
+
+	return variant, ""
+}
+
+/*
+serveStoredImage serves the PNG at relativePath, or the variant of it the
+request asks for. modified is when the image last changed, for
+Last-Modified and If-Modified-Since.
+*/
+func serveStoredImage(w http.ResponseWriter, r *http.Request, cache *derived.Cache, relativePath string, modified time.Time) {
+	variant, msg := parseVariant(r)
+	if msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	// Hashed paths name their contents, others can be rewritten and rely on
+	// modified changing with them
+	name := "original"
+	if variant != nil {
+		name = variant.Name()
+	}
+	if notModified(w, r, imageETag(relativePath, name, modified), modified) {
+		return
+	}
+
+	contentType := "image/png"
+	var file io.ReadCloser
+	var err error
+	if variant != nil {
+		contentType = variant.Format.ContentType()
+		file, err = cache.GetVariant(relativePath, *variant)
+	} else {
+		file, err = cache.Open(relativePath)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Image file not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate image")
+		return
+	}
+	defer file.Close()
+
+	serveImage(w, r, file, contentType, modified)
+}
+
+// imageETag is an ETag for the image name made from source and last changed
+// at modified, so requests can be answered without reading the image
+func imageETag(source, name string, modified time.Time) string {
+	sum := sha256.Sum256([]byte(source + "\n" + name + "\n" + modified.UTC().Format(time.RFC3339Nano)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+/*
+notModified sets an image's caching headers and, when the request already has
+the image, answers 304 Not Modified and returns true. Clients must revalidate
+before reusing an image, as the image behind a URL can change when a snapshot
+is re-diffed or a baseline updated.
+*/
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "no-cache")
+	if !modified.IsZero() {
+		header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since only counts when there's no If-None-Match
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as If-None-Match does
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveImage writes an image whose caching headers notModified has set,
+// with range requests when the file can seek
+func serveImage(w http.ResponseWriter, r *http.Request, file io.Reader, contentType string, modified time.Time) {
+	w.Header().Set("Content-Type", contentType)
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", modified, seeker)
+		return
+	}
+	io.Copy(w, file)
+}
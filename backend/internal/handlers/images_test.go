@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestServeStoredImageConditional(t *testing.T) {
+	store := storage.NewMemory()
+	cache := derived.New(store)
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewNRGBA(image.Rect(0, 0, 8, 4))); err != nil {
+		t.Fatal(err)
+	}
+	relativePath, err := store.SaveFile(uuid.New(), storage.StorageTypeComparison, "shot.png", &encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	serve := func(target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		serveStoredImage(w, r, cache, relativePath, modified)
+		return w
+	}
+
+	original := serve("/image", nil)
+	if original.Code != http.StatusOK || original.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("GET = %d %s, want 200 image/png", original.Code, original.Header().Get("Content-Type"))
+	}
+	etag := original.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	resized := serve("/image?width=4&format=webp", nil)
+	if resized.Code != http.StatusOK || resized.Header().Get("Content-Type") != "image/webp" {
+		t.Fatalf("GET variant = %d %s, want 200 image/webp", resized.Code, resized.Header().Get("Content-Type"))
+	}
+	if resized.Header().Get("ETag") == etag {
+		t.Error("a variant has the same ETag as the original")
+	}
+
+	// Conditional requests are answered without opening the file
+	if err := store.DeleteFile(relativePath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{name: "matching ETag", header: http.Header{"If-None-Match": {etag}}, code: http.StatusNotModified},
+		{name: "ETag in a list", header: http.Header{"If-None-Match": {`"other", W/` + etag}}, code: http.StatusNotModified},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, code: http.StatusNotModified},
+		{
+			name: "ETag wins over If-Modified-Since",
+			header: http.Header{
+				"If-None-Match":     {`"other"`},
+				"If-Modified-Since": {modified.Format(http.TimeFormat)},
+			},
+			code: http.StatusNotFound,
+		},
+		{name: "modified since", header: http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve("/image", tt.header)
+			if w.Code != tt.code {
+				t.Errorf("GET = %d, want %d", w.Code, tt.code)
+			}
+			if tt.code == http.StatusNotModified && w.Header().Get("ETag") != etag {
+				t.Errorf("304 ETag = %q, want %q", w.Header().Get("ETag"), etag)
+			}
+		})
+	}
+}
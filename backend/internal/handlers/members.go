@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+type MemberHandlers struct {
+	repo        *repository.ProjectMemberRepository
+	projectRepo *repository.ProjectRepository
+	userRepo    *repository.UserRepository
+}
+
+func NewMemberHandlers(
+	repo *repository.ProjectMemberRepository,
+	projectRepo *repository.ProjectRepository,
+	userRepo *repository.UserRepository,
+) *MemberHandlers {
+	return &MemberHandlers{repo: repo, projectRepo: projectRepo, userRepo: userRepo}
+}
+
+// List lists a project's members and their roles
+func (h *MemberHandlers) List(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	members, err := h.repo.ListByProject(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list members")
+		return
+	}
+
+	if members == nil {
+		members = []models.ProjectMember{}
+	}
+
+	respondJSON(w, http.StatusOK, members)
+}
+
+// Save adds a user to a project by email, or changes their role
+func (h *MemberHandlers) Save(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.ProjectMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !req.Role.Valid() {
+		respondError(w, http.StatusBadRequest, "role must be viewer, reviewer or admin")
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	user, err := h.userRepo.GetByEmail(r.Context(), strings.TrimSpace(req.Email))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	member, err := h.repo.Upsert(r.Context(), projectID, user.ID, req.Role)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save member")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, member)
+}
+
+// Delete removes a user from a project
+func (h *MemberHandlers) Delete(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	userID, err := parseUUID(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.repo.Delete(r.Context(), projectID, userID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete member")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/diffimage"
 	"github.com/crzytrane/diffit/internal/models"
 	"github.com/crzytrane/diffit/internal/repository"
 	"github.com/crzytrane/diffit/internal/storage"
@@ -11,12 +14,39 @@ import (
 )
 
 type ProjectHandlers struct {
-	repo    *repository.ProjectRepository
-	storage *storage.Storage
+	repo       *repository.ProjectRepository
+	memberRepo *repository.ProjectMemberRepository
+	storage    storage.Storage
 }
 
-func NewProjectHandlers(repo *repository.ProjectRepository, storage *storage.Storage) *ProjectHandlers {
-	return &ProjectHandlers{repo: repo, storage: storage}
+func NewProjectHandlers(repo *repository.ProjectRepository, memberRepo *repository.ProjectMemberRepository, storage storage.Storage) *ProjectHandlers {
+	return &ProjectHandlers{repo: repo, memberRepo: memberRepo, storage: storage}
+}
+
+// validateChangeSettings checks the project settings that pick the colour
+// metric and how a change is decided
+func validateChangeSettings(colorMetric, criterion *string, minSSIM *float64, minRegionPixels *int) string {
+	if colorMetric != nil && !diffimage.ValidColorMetric(diffimage.ColorMetric(*colorMetric)) {
+		return "color_metric must be yiq or ciede2000"
+	}
+	if criterion != nil && !diffimage.ValidChangeCriterion(diffimage.ChangeCriterion(*criterion)) {
+		return "change_criterion must be pixels, ssim or regions"
+	}
+	if minSSIM != nil && (*minSSIM < 0 || *minSSIM > 1) {
+		return "min_ssim must be between 0 and 1"
+	}
+	if minRegionPixels != nil && *minRegionPixels < 1 {
+		return "min_region_pixels must be at least 1"
+	}
+	return ""
+}
+
+// validateAlignment checks how a project lines up screenshots of different sizes
+func validateAlignment(alignment *string) string {
+	if alignment != nil && !diffimage.ValidAlignment(diffimage.Alignment(*alignment)) {
+		return "alignment must be top-left or content"
+	}
+	return ""
 }
 
 // Create creates a new project
@@ -32,12 +62,32 @@ func (h *ProjectHandlers) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if msg := validateDiffSettings(req.DiffThreshold, req.AcceptableDiffPercentage); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := validateChangeSettings(req.ColorMetric, req.ChangeCriterion, req.MinSSIM, req.MinRegionPixels); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := validateAlignment(req.Alignment); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
 	project, err := h.repo.Create(r.Context(), req)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create project")
 		return
 	}
 
+	// Whoever creates a project administers it
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		if _, err := h.memberRepo.Upsert(r.Context(), project.ID, user.ID, models.RoleAdmin); err != nil {
+			log.Printf("Failed to add %s as admin of project %s: %v", user.Email, project.ID, err)
+		}
+	}
+
 	respondJSON(w, http.StatusCreated, project)
 }
 
@@ -108,6 +158,19 @@ func (h *ProjectHandlers) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if msg := validateDiffSettings(req.DiffThreshold, req.AcceptableDiffPercentage); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := validateChangeSettings(req.ColorMetric, req.ChangeCriterion, req.MinSSIM, req.MinRegionPixels); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := validateAlignment(req.Alignment); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
 	project, err := h.repo.Update(r.Context(), id, req)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update project")
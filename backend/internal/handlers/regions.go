@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// thumbnailPadding is how much context is shown around a region
+	thumbnailPadding = 16
+	// thumbnailMaxSize bounds the width and height of region thumbnails
+	thumbnailMaxSize = 400
+)
+
+type RegionHandlers struct {
+	repo         *repository.SnapshotRegionRepository
+	snapshotRepo *repository.SnapshotRepository
+	buildRepo    *repository.BuildRepository
+	cache        *derived.Cache
+}
+
+func NewRegionHandlers(repo *repository.SnapshotRegionRepository, snapshotRepo *repository.SnapshotRepository, buildRepo *repository.BuildRepository, cache *derived.Cache) *RegionHandlers {
+	return &RegionHandlers{repo: repo, snapshotRepo: snapshotRepo, buildRepo: buildRepo, cache: cache}
+}
+
+// findSnapshot loads the snapshot in the URL, responding with an error if it can't
+func (h *RegionHandlers) findSnapshot(w http.ResponseWriter, r *http.Request) (*models.Snapshot, bool) {
+	id, err := parseUUID(chi.URLParam(r, "snapshotID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid snapshot ID")
+		return nil, false
+	}
+
+	snapshot, err := h.snapshotRepo.GetByID(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Snapshot not found")
+		return nil, false
+	}
+
+	return snapshot, true
+}
+
+// List lists the changed regions of a snapshot, largest first
+func (h *RegionHandlers) List(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := h.findSnapshot(w, r)
+	if !ok {
+		return
+	}
+
+	regions, err := h.repo.ListBySnapshot(r.Context(), snapshot.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list regions")
+		return
+	}
+
+	if regions == nil {
+		regions = []models.SnapshotRegion{}
+	}
+
+	respondJSON(w, http.StatusOK, regions)
+}
+
+/*
+GetImage serves a region cropped out of the base image (before), the
+comparison image (after) or the diff image (diff), with some context around
+it and scaled down to thumbnail size. Thumbnails are generated on first
+request and cached.
+*/
+func (h *RegionHandlers) GetImage(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := h.findSnapshot(w, r)
+	if !ok {
+		return
+	}
+
+	regionID, err := parseUUID(chi.URLParam(r, "regionID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid region ID")
+		return
+	}
+
+	region, err := h.repo.GetByID(r.Context(), snapshot.ID, regionID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get region")
+		return
+	}
+	if region == nil {
+		respondError(w, http.StatusNotFound, "Region not found")
+		return
+	}
+
+	imageType := chi.URLParam(r, "imageType")
+	var imagePath *string
+	var area *models.Rect
+	switch imageType {
+	case "before":
+		imagePath, area = snapshot.BaseImagePath, region.Base
+	case "after":
+		imagePath, area = snapshot.ComparisonImagePath, region.Comparison
+	case "diff":
+		imagePath = snapshot.DiffImagePath
+		area = &models.Rect{X: region.X, Y: region.Y, Width: region.Width, Height: region.Height}
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid image type")
+		return
+	}
+
+	if imagePath == nil || area == nil {
+		respondError(w, http.StatusNotFound, "Image not found")
+		return
+	}
+
+	build, err := h.buildRepo.GetByID(r.Context(), snapshot.BuildID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+
+	name := fmt.Sprintf("regions/%s-%s.png", region.ID, imageType)
+	if notModified(w, r, imageETag(*imagePath, name, region.CreatedAt), region.CreatedAt) {
+		return
+	}
+
+	file, err := h.cache.Get(build.ProjectID, snapshot.ID, name, func(w io.Writer) error {
+		img, err := h.cache.LoadImage(*imagePath)
+		if err != nil {
+			return err
+		}
+
+		bounds := image.Rect(area.X, area.Y, area.X+area.Width, area.Y+area.Height)
+		thumbnail := derived.Fit(derived.Crop(img, bounds, thumbnailPadding), thumbnailMaxSize, thumbnailMaxSize)
+		return derived.EncodePNG(w, thumbnail)
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Image file not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate thumbnail")
+		return
+	}
+	defer file.Close()
+
+	serveImage(w, r, file, "image/png", region.CreatedAt)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/crzytrane/diffit/internal/janitor"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+type RetentionHandlers struct {
+	repo        *repository.RetentionPolicyRepository
+	projectRepo *repository.ProjectRepository
+	janitor     *janitor.Janitor
+}
+
+func NewRetentionHandlers(repo *repository.RetentionPolicyRepository, projectRepo *repository.ProjectRepository, janitor *janitor.Janitor) *RetentionHandlers {
+	return &RetentionHandlers{repo: repo, projectRepo: projectRepo, janitor: janitor}
+}
+
+// Get returns a project's retention policy
+func (h *RetentionHandlers) Get(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	policy, err := h.repo.Get(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get retention policy")
+		return
+	}
+	if policy == nil {
+		respondError(w, http.StatusNotFound, "Retention policy is not configured")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// Save creates or replaces a project's retention policy
+func (h *RetentionHandlers) Save(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.RetentionPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.KeepBuildsPerBranch != nil && *req.KeepBuildsPerBranch < 1 {
+		respondError(w, http.StatusBadRequest, "keep_builds_per_branch must be at least 1")
+		return
+	}
+	if req.MaxAgeDays != nil && *req.MaxAgeDays < 1 {
+		respondError(w, http.StatusBadRequest, "max_age_days must be at least 1")
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	policy, err := h.repo.Save(r.Context(), models.RetentionPolicy{
+		ProjectID:           projectID,
+		KeepBuildsPerBranch: req.KeepBuildsPerBranch,
+		MaxAgeDays:          req.MaxAgeDays,
+		KeepBaselineSources: req.KeepBaselineSources == nil || *req.KeepBaselineSources,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save retention policy")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// Delete removes a project's retention policy so its builds are kept forever
+func (h *RetentionHandlers) Delete(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	if err := h.repo.Delete(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete retention policy")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
+
+// Report lists the builds and files garbage collection would delete from a
+// project right now, without deleting anything
+func (h *RetentionHandlers) Report(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	report, err := h.janitor.Collect(r.Context(), projectID, true)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to build retention report")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
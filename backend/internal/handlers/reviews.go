@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/notify"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/google/uuid"
+)
+
+// reviews applies what follows from reviewing snapshots, shared by the
+// snapshot and build review endpoints
+type reviews struct {
+	buildRepo       *repository.BuildRepository
+	baselineRepo    *repository.BaselineRepository
+	buildReviewRepo *repository.BuildReviewRepository
+	notifier        *notify.Notifier
+	bus             *events.Bus
+}
+
+func newReviews(
+	buildRepo *repository.BuildRepository,
+	baselineRepo *repository.BaselineRepository,
+	buildReviewRepo *repository.BuildReviewRepository,
+	notifier *notify.Notifier,
+	bus *events.Bus,
+) *reviews {
+	return &reviews{
+		buildRepo:       buildRepo,
+		baselineRepo:    baselineRepo,
+		buildReviewRepo: buildReviewRepo,
+		notifier:        notifier,
+		bus:             bus,
+	}
+}
+
+/*
+applyApproval updates the baselines for an approved snapshot. New and changed
+snapshots become the baseline for the build's branch. Approving a removed
+snapshot deletes its baseline, but only when the baseline belongs to the
+build's branch: a removal seen on a feature branch leaves the default
+branch's baseline alone. The baseline image stays in storage since the
+removed snapshot still shows it.
+*/
+func (rv *reviews) applyApproval(ctx context.Context, snapshot *models.Snapshot) error {
+	build, err := rv.buildRepo.GetByID(ctx, snapshot.BuildID)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.ChangeType != nil && *snapshot.ChangeType == models.ChangeTypeRemoved {
+		// Already deleted, the foreign key clears baseline_id
+		if snapshot.BaselineID == nil {
+			return nil
+		}
+
+		baseline, err := rv.baselineRepo.GetByID(ctx, *snapshot.BaselineID)
+		if err != nil {
+			return err
+		}
+		if baseline.Branch != build.Branch {
+			return nil
+		}
+		if err := rv.baselineRepo.Delete(ctx, baseline.ID); err != nil {
+			return err
+		}
+		rv.bus.Publish(ctx, build.ProjectID, models.EventBaselineUpdated, events.BaselineUpdate{
+			Action:   events.BaselineDeleted,
+			Baseline: baseline,
+		})
+		return nil
+	}
+
+	if snapshot.ComparisonImagePath == nil {
+		return nil
+	}
+
+	baseline, err := rv.baselineRepo.Upsert(ctx, repository.CreateBaselineParams{
+		ProjectID:        build.ProjectID,
+		Name:             snapshot.Name,
+		Branch:           build.Branch,
+		ImagePath:        *snapshot.ComparisonImagePath,
+		ImageHash:        snapshot.ComparisonImageHash,
+		Width:            snapshot.Width,
+		Height:           snapshot.Height,
+		Browser:          snapshot.Browser,
+		Viewport:         snapshot.Viewport,
+		SourceSnapshotID: &snapshot.ID,
+		Approver:         repository.Approver{UserID: snapshot.ReviewedByUserID, Name: snapshot.ReviewedBy},
+	})
+	if err != nil {
+		return err
+	}
+
+	rv.bus.Publish(ctx, build.ProjectID, models.EventBaselineUpdated, events.BaselineUpdate{
+		Action:   events.BaselineSaved,
+		Baseline: baseline,
+	})
+	return nil
+}
+
+// snapshotReviewed publishes a snapshot.reviewed event for a snapshot whose
+// review status was just set
+func (rv *reviews) snapshotReviewed(ctx context.Context, snapshot *models.Snapshot) {
+	build, err := rv.buildRepo.GetByID(ctx, snapshot.BuildID)
+	if err != nil {
+		return
+	}
+	rv.bus.Publish(ctx, build.ProjectID, models.EventSnapshotReviewed, snapshot)
+}
+
+// refreshBuild brings a build's stats and review state up to date after user
+// reviewed some of its snapshots, reporting a new review state to the git host
+func (rv *reviews) refreshBuild(ctx context.Context, buildID uuid.UUID, user *models.User) error {
+	if err := rv.buildRepo.UpdateStats(ctx, buildID); err != nil {
+		return err
+	}
+
+	var userID *uuid.UUID
+	var reviewedBy *string
+	if user != nil {
+		name := reviewerName(user)
+		userID, reviewedBy = &user.ID, &name
+	}
+
+	transition, err := rv.buildReviewRepo.Refresh(ctx, buildID, userID, reviewedBy)
+	if transition != nil {
+		rv.notifier.BuildChanged(buildID)
+	}
+
+	if build, err := rv.buildRepo.GetByID(ctx, buildID); err == nil {
+		rv.bus.Publish(ctx, build.ProjectID, models.EventBuildUpdated, build)
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/crzytrane/diffit/internal/archive"
+	"github.com/crzytrane/diffit/internal/imagestore"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxArchiveSize bounds the size of an uploaded archive
+const maxArchiveSize = 2 << 30
+
+// archiveProgress writes NDJSON progress lines, sending the response header
+// with the first one
+type archiveProgress struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+func (p *archiveProgress) send(progress models.ArchiveProgress) {
+	if !p.started {
+		p.w.Header().Set("Content-Type", "application/x-ndjson")
+		p.w.Header().Set("Cache-Control", "no-cache")
+		// Stop nginx buffering the stream
+		p.w.Header().Set("X-Accel-Buffering", "no")
+		p.w.WriteHeader(http.StatusOK)
+		p.started = true
+	}
+
+	json.NewEncoder(p.w).Encode(progress)
+	p.rc.Flush()
+}
+
+// fail ends the upload with an error, as a status code if nothing has been
+// streamed yet
+func (p *archiveProgress) fail(status int, message string) {
+	if !p.started {
+		respondError(p.w, status, message)
+		return
+	}
+	p.send(models.ArchiveProgress{Event: models.ArchiveEventError, Error: message})
+}
+
+/*
+UploadArchive creates a build's snapshots from a zip, tar, tar.gz or tar.zst
+of screenshots sent as the request body. The pattern query parameter maps paths in the
+archive to snapshot names, browsers and viewports, archive.DefaultPattern
+when not given. Each file's outcome is streamed back as a line of NDJSON as
+it is stored. The snapshots and their diff jobs are only created, in one
+transaction, once every matching file has been stored, so an archive with a
+bad image creates none.
+*/
+func (h *SnapshotHandlers) UploadArchive(w http.ResponseWriter, r *http.Request) {
+	buildID, err := parseUUID(chi.URLParam(r, "buildID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid build ID")
+		return
+	}
+
+	build, err := h.buildRepo.GetByID(r.Context(), buildID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+
+	patternStr := r.URL.Query().Get("pattern")
+	if patternStr == "" {
+		patternStr = archive.DefaultPattern
+	}
+	pattern, err := archive.ParsePattern(patternStr)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	progress := &archiveProgress{w: w, rc: http.NewResponseController(w)}
+
+	var uploads []repository.SnapshotUpload
+	seen := map[string]string{}
+	skipped, failed := 0, 0
+	var storeErr error
+
+	body := http.MaxBytesReader(w, r.Body, maxArchiveSize)
+	err = archive.Walk(body, archive.DefaultLimits, func(path string, contents io.Reader) error {
+		match, ok := pattern.Match(path)
+		if !ok {
+			skipped++
+			progress.send(models.ArchiveProgress{Event: models.ArchiveEventFile, Path: path, Status: models.ArchiveFileSkipped})
+			return nil
+		}
+
+		line := models.ArchiveProgress{
+			Event:    models.ArchiveEventFile,
+			Path:     path,
+			Name:     match.Name,
+			Browser:  optionalString(match.Browser),
+			Viewport: optionalString(match.Viewport),
+		}
+
+		key := match.Name + "\x00" + match.Browser + "\x00" + match.Viewport
+		if other, ok := seen[key]; ok {
+			failed++
+			line.Status = models.ArchiveFileFailed
+			line.Error = fmt.Sprintf("Same snapshot as %s", other)
+			progress.send(line)
+			return nil
+		}
+		seen[key] = path
+
+		blob, err := h.images.Put(r.Context(), build.ProjectID, contents)
+		if errors.Is(err, imagestore.ErrInvalidImage) {
+			failed++
+			line.Status = models.ArchiveFileFailed
+			line.Error = "Image could not be decoded"
+			progress.send(line)
+			return nil
+		}
+		if err != nil {
+			storeErr = err
+			return err
+		}
+
+		uploads = append(uploads, repository.SnapshotUpload{
+			Request: models.CreateSnapshotRequest{
+				BuildID:  build.ID,
+				Name:     match.Name,
+				Width:    &blob.Width,
+				Height:   &blob.Height,
+				Browser:  line.Browser,
+				Viewport: line.Viewport,
+			},
+			ImagePath: blob.Path,
+			ImageHash: blob.Hash,
+		})
+		line.Status = models.ArchiveFileStored
+		progress.send(line)
+		return nil
+	})
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, archive.ErrUnsupportedFormat):
+		progress.fail(http.StatusBadRequest, "Archive must be a zip, tar, tar.gz or tar.zst file")
+		return
+	case errors.Is(err, archive.ErrLimitExceeded):
+		progress.fail(http.StatusRequestEntityTooLarge, err.Error())
+		return
+	case errors.As(err, &tooLarge):
+		progress.fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("Archive must be at most %d bytes", maxArchiveSize))
+		return
+	}
+	if storeErr != nil {
+		progress.fail(http.StatusInternalServerError, "Failed to save image")
+		return
+	}
+	if err != nil {
+		progress.fail(http.StatusBadRequest, "Failed to read archive")
+		return
+	}
+
+	if failed > 0 {
+		progress.fail(http.StatusBadRequest, fmt.Sprintf("%d files failed, no snapshots were created", failed))
+		return
+	}
+	if len(uploads) == 0 {
+		progress.fail(http.StatusBadRequest, "No files in the archive matched the pattern")
+		return
+	}
+
+	// Diffing happens in the background, the snapshots stay pending until workers pick them up
+	snapshots, err := h.queue.CreateSnapshots(r.Context(), uploads)
+	if err != nil {
+		progress.fail(http.StatusInternalServerError, "Failed to create snapshots")
+		return
+	}
+	for i := range snapshots {
+		h.bus.Publish(r.Context(), build.ProjectID, models.EventSnapshotCreated, &snapshots[i])
+	}
+
+	progress.send(models.ArchiveProgress{
+		Event:     models.ArchiveEventComplete,
+		Created:   len(snapshots),
+		Skipped:   skipped,
+		Snapshots: snapshots,
+	})
+}
+
+// optionalString is nil for an empty string
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
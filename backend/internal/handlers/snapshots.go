@@ -1,48 +1,135 @@
 package handlers
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	_ "image/jpeg"
-	"image/png"
-	_ "image/png"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 
+	"github.com/crzytrane/diffit/internal/auth"
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/diffqueue"
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/imagestore"
 	"github.com/crzytrane/diffit/internal/models"
 	"github.com/crzytrane/diffit/internal/repository"
 	"github.com/crzytrane/diffit/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/n7olkachev/imgdiff/pkg/imgdiff"
 )
 
 type SnapshotHandlers struct {
 	repo         *repository.SnapshotRepository
 	buildRepo    *repository.BuildRepository
 	baselineRepo *repository.BaselineRepository
-	storage      *storage.Storage
+	reviews      *reviews
+	storage      storage.Storage
+	images       *imagestore.Store
+	cache        *derived.Cache
+	queue        *diffqueue.Queue
+	bus          *events.Bus
 }
 
 func NewSnapshotHandlers(
 	repo *repository.SnapshotRepository,
 	buildRepo *repository.BuildRepository,
 	baselineRepo *repository.BaselineRepository,
-	storage *storage.Storage,
+	reviews *reviews,
+	storage storage.Storage,
+	images *imagestore.Store,
+	cache *derived.Cache,
+	queue *diffqueue.Queue,
+	bus *events.Bus,
 ) *SnapshotHandlers {
 	return &SnapshotHandlers{
 		repo:         repo,
 		buildRepo:    buildRepo,
 		baselineRepo: baselineRepo,
+		reviews:      reviews,
 		storage:      storage,
+		images:       images,
+		cache:        cache,
+		queue:        queue,
+		bus:          bus,
 	}
 }
 
-// Create creates a new snapshot and processes the comparison image
+// ProjectFromForm resolves the project a snapshot is being uploaded to from
+// the build_id form value
+func (h *SnapshotHandlers) ProjectFromForm(r *http.Request) (uuid.UUID, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Failed to parse multipart form"}
+	}
+
+	buildID, err := parseUUID(r.FormValue("build_id"))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid build ID"}
+	}
+
+	build, err := h.buildRepo.GetByID(r.Context(), buildID)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusNotFound, Message: "Build not found"}
+	}
+
+	return build.ProjectID, nil
+}
+
+// ProjectFromURL resolves the project of the snapshot in the URL
+func (h *SnapshotHandlers) ProjectFromURL(r *http.Request) (uuid.UUID, error) {
+	id, err := parseUUID(chi.URLParam(r, "snapshotID"))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid snapshot ID"}
+	}
+
+	return h.projectOfSnapshot(r, id)
+}
+
+// ProjectFromBatch resolves the project of the snapshots in a batch review,
+// which must all belong to the same project
+func (h *SnapshotHandlers) ProjectFromBatch(r *http.Request) (uuid.UUID, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Invalid request body"}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req models.BatchReviewRequest
+	if err := json.Unmarshal(body, &req); err != nil || len(req.SnapshotIDs) == 0 {
+		return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "snapshot_ids is required"}
+	}
+
+	projectID := uuid.Nil
+	for _, id := range req.SnapshotIDs {
+		snapshotProjectID, err := h.projectOfSnapshot(r, id)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if projectID != uuid.Nil && snapshotProjectID != projectID {
+			return uuid.Nil, &auth.Error{Status: http.StatusBadRequest, Message: "Snapshots must all belong to the same project"}
+		}
+		projectID = snapshotProjectID
+	}
+
+	return projectID, nil
+}
+
+func (h *SnapshotHandlers) projectOfSnapshot(r *http.Request, id uuid.UUID) (uuid.UUID, error) {
+	snapshot, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusNotFound, Message: "Snapshot not found"}
+	}
+
+	build, err := h.buildRepo.GetByID(r.Context(), snapshot.BuildID)
+	if err != nil {
+		return uuid.Nil, &auth.Error{Status: http.StatusNotFound, Message: "Build not found"}
+	}
+
+	return build.ProjectID, nil
+}
+
+// Create creates a new snapshot and queues the comparison image for diffing
 func (h *SnapshotHandlers) Create(w http.ResponseWriter, r *http.Request) {
 	// Parse multipart form
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
@@ -96,147 +183,68 @@ func (h *SnapshotHandlers) Create(w http.ResponseWriter, r *http.Request) {
 		viewportPtr = &viewport
 	}
 
+	// A retry of an upload whose response was lost carries the same key
+	key, ok := idempotencyKey(r)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+		return
+	}
+
 	// Create snapshot record
 	snapshot, err := h.repo.Create(r.Context(), models.CreateSnapshotRequest{
-		BuildID:  buildID,
-		Name:     name,
-		Width:    width,
-		Height:   height,
-		Browser:  browserPtr,
-		Viewport: viewportPtr,
+		BuildID:        buildID,
+		Name:           name,
+		Width:          width,
+		Height:         height,
+		Browser:        browserPtr,
+		Viewport:       viewportPtr,
+		IdempotencyKey: key,
 	})
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create snapshot")
 		return
 	}
 
+	// The first attempt already stored the image and queued the diff
+	if key != nil && snapshot.ComparisonImagePath != nil {
+		respondJSON(w, http.StatusOK, snapshot)
+		return
+	}
+
 	// Handle image upload
 	file, _, err := r.FormFile("image")
 	if err != nil {
 		// No image uploaded - just return the snapshot
+		h.bus.Publish(r.Context(), build.ProjectID, models.EventSnapshotCreated, snapshot)
 		respondJSON(w, http.StatusCreated, snapshot)
 		return
 	}
 	defer file.Close()
 
-	// Save comparison image
-	comparisonPath, err := h.storage.SaveFile(build.ProjectID, storage.StorageTypeComparison, fmt.Sprintf("%s.png", snapshot.ID.String()), file)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to save image")
+	// Save comparison image, identical screenshots share one stored file
+	blob, err := h.images.Put(r.Context(), build.ProjectID, file)
+	if errors.Is(err, imagestore.ErrInvalidImage) {
+		respondError(w, http.StatusBadRequest, "Image could not be decoded")
 		return
 	}
-
-	// Find baseline for comparison
-	baseline, err := h.baselineRepo.FindByKey(r.Context(), build.ProjectID, name, build.Branch, browserPtr, viewportPtr)
 	if err != nil {
-		// Error finding baseline - continue without it
-		baseline = nil
-	}
-
-	// If no baseline on this branch, try default branch
-	if baseline == nil {
-		// Get project to get default branch
-		baseline, _ = h.baselineRepo.FindByKey(r.Context(), build.ProjectID, name, "main", browserPtr, viewportPtr)
-	}
-
-	var baseImagePath, diffImagePath *string
-	var diffPercentage *float64
-
-	if baseline != nil {
-		// Perform diff
-		baseImagePath = &baseline.ImagePath
-
-		diffPct, diffPath, err := h.performDiff(build.ProjectID, snapshot.ID, baseline.ImagePath, comparisonPath)
-		if err == nil {
-			diffPercentage = &diffPct
-			if diffPath != "" {
-				diffImagePath = &diffPath
-			}
-		}
-
-		// Link snapshot to baseline
-		h.repo.SetBaseline(r.Context(), snapshot.ID, baseline.ID)
-	} else {
-		// New snapshot - no baseline yet
-		zeroPercent := float64(0)
-		diffPercentage = &zeroPercent
+		respondError(w, http.StatusInternalServerError, "Failed to save image")
+		return
 	}
 
-	// Update snapshot with image paths
-	if err := h.repo.UpdateImagePaths(r.Context(), snapshot.ID, baseImagePath, &comparisonPath, diffImagePath, diffPercentage); err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update snapshot")
+	// Diffing happens in the background, the snapshot stays pending until a worker picks it up
+	if err := h.queue.SetImage(r.Context(), snapshot.ID, blob.Path, blob.Hash); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to queue snapshot for processing")
 		return
 	}
 
-	// Update snapshot status
-	h.repo.UpdateStatus(r.Context(), snapshot.ID, models.SnapshotStatusCompleted)
-
 	// Refresh snapshot
-	snapshot, _ = h.repo.GetByID(r.Context(), snapshot.ID)
-
-	respondJSON(w, http.StatusCreated, snapshot)
-}
-
-// performDiff compares two images and returns diff percentage and diff image path
-func (h *SnapshotHandlers) performDiff(projectID, snapshotID uuid.UUID, basePath, comparisonPath string) (float64, string, error) {
-	baseFile, err := h.storage.GetFile(basePath)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to open base image: %w", err)
-	}
-	defer baseFile.Close()
-
-	comparisonFile, err := h.storage.GetFile(comparisonPath)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to open comparison image: %w", err)
-	}
-	defer comparisonFile.Close()
-
-	baseImage, _, err := image.Decode(baseFile)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to decode base image: %w", err)
-	}
-
-	comparisonImage, _, err := image.Decode(comparisonFile)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to decode comparison image: %w", err)
-	}
-
-	// Perform diff
-	result := imgdiff.Diff(baseImage, comparisonImage, &imgdiff.Options{
-		Threshold: 0.1,
-		DiffImage: true,
-	})
-
-	if result.Equal {
-		return 0, "", nil
+	if refreshed, err := h.repo.GetByID(r.Context(), snapshot.ID); err == nil {
+		snapshot = refreshed
 	}
+	h.bus.Publish(r.Context(), build.ProjectID, models.EventSnapshotCreated, snapshot)
 
-	// Calculate diff percentage based on different pixels
-	bounds := baseImage.Bounds()
-	totalPixels := bounds.Dx() * bounds.Dy()
-	diffPercentage := float64(result.DiffPixelsCount) / float64(totalPixels) * 100
-
-	// Save diff image
-	diffFilename := fmt.Sprintf("%s.png", snapshotID.String())
-	diffDir := filepath.Join(h.storage.GetFullPath(""), projectID.String(), string(storage.StorageTypeDiff))
-	os.MkdirAll(diffDir, 0755)
-	diffFullPath := filepath.Join(diffDir, diffFilename)
-
-	diffFile, err := os.Create(diffFullPath)
-	if err != nil {
-		return diffPercentage, "", fmt.Errorf("failed to create diff file: %w", err)
-	}
-	defer diffFile.Close()
-
-	writer := bufio.NewWriter(diffFile)
-	enc := &png.Encoder{CompressionLevel: png.BestSpeed}
-	if err := enc.Encode(writer, result.Image); err != nil {
-		return diffPercentage, "", fmt.Errorf("failed to encode diff image: %w", err)
-	}
-	writer.Flush()
-
-	diffPath := filepath.Join(projectID.String(), string(storage.StorageTypeDiff), diffFilename)
-	return diffPercentage, diffPath, nil
+	respondJSON(w, http.StatusCreated, snapshot)
 }
 
 // Get retrieves a snapshot by ID
@@ -326,42 +334,37 @@ func (h *SnapshotHandlers) Review(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.ReviewedBy == "" {
-		respondError(w, http.StatusBadRequest, "reviewed_by is required")
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		respondError(w, http.StatusUnauthorized, "Login required")
 		return
 	}
+	req.ReviewedBy = reviewerName(user)
+	req.ReviewedByUserID = user.ID
 
 	if err := h.repo.UpdateReviewStatus(r.Context(), id, req); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update review status")
 		return
 	}
 
-	// If approved and this is on the default branch, update baseline
+	// Approving moves the branch baseline to match the snapshot
 	if req.ReviewStatus == models.ReviewStatusApproved {
-		snapshot, _ := h.repo.GetByID(r.Context(), id)
-		if snapshot != nil {
-			build, _ := h.buildRepo.GetByID(r.Context(), snapshot.BuildID)
-			if build != nil && snapshot.ComparisonImagePath != nil {
-				// Create or update baseline
-				h.baselineRepo.Upsert(r.Context(), repository.CreateBaselineParams{
-					ProjectID:        build.ProjectID,
-					Name:             snapshot.Name,
-					Branch:           build.Branch,
-					ImagePath:        *snapshot.ComparisonImagePath,
-					Width:            snapshot.Width,
-					Height:           snapshot.Height,
-					Browser:          snapshot.Browser,
-					Viewport:         snapshot.Viewport,
-					SourceSnapshotID: &snapshot.ID,
-				})
-			}
+		snapshot, err := h.repo.GetByID(r.Context(), id)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "Snapshot not found")
+			return
+		}
+		if err := h.reviews.applyApproval(r.Context(), snapshot); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to update baseline")
+			return
 		}
 	}
 
-	// Update build stats
+	// Update build stats and review state
 	snapshot, _ := h.repo.GetByID(r.Context(), id)
 	if snapshot != nil {
-		h.buildRepo.UpdateStats(r.Context(), snapshot.BuildID)
+		h.reviews.refreshBuild(r.Context(), snapshot.BuildID, user)
+		h.reviews.snapshotReviewed(r.Context(), snapshot)
 	}
 
 	respondJSON(w, http.StatusOK, snapshot)
@@ -375,52 +378,50 @@ func (h *SnapshotHandlers) BatchReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.SnapshotIDs) == 0 || req.ReviewedBy == "" {
-		respondError(w, http.StatusBadRequest, "snapshot_ids and reviewed_by are required")
+	if len(req.SnapshotIDs) == 0 {
+		respondError(w, http.StatusBadRequest, "snapshot_ids is required")
+		return
+	}
+
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		respondError(w, http.StatusUnauthorized, "Login required")
 		return
 	}
+	req.ReviewedBy = reviewerName(user)
+	req.ReviewedByUserID = user.ID
 
 	if err := h.repo.BatchUpdateReviewStatus(r.Context(), req); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to batch update review status")
 		return
 	}
 
-	// If approved, update baselines for each snapshot
-	if req.ReviewStatus == models.ReviewStatusApproved {
-		for _, snapshotID := range req.SnapshotIDs {
-			snapshot, err := h.repo.GetByID(r.Context(), snapshotID)
-			if err != nil || snapshot == nil || snapshot.ComparisonImagePath == nil {
-				continue
-			}
-
-			build, err := h.buildRepo.GetByID(r.Context(), snapshot.BuildID)
-			if err != nil || build == nil {
-				continue
-			}
-
-			h.baselineRepo.Upsert(r.Context(), repository.CreateBaselineParams{
-				ProjectID:        build.ProjectID,
-				Name:             snapshot.Name,
-				Branch:           build.Branch,
-				ImagePath:        *snapshot.ComparisonImagePath,
-				Width:            snapshot.Width,
-				Height:           snapshot.Height,
-				Browser:          snapshot.Browser,
-				Viewport:         snapshot.Viewport,
-				SourceSnapshotID: &snapshot.ID,
-			})
-
-			// Update build stats
-			h.buildRepo.UpdateStats(r.Context(), snapshot.BuildID)
+	// Approving moves the branch baselines to match, then every build
+	// touched has its stats and review state brought up to date
+	buildIDs := map[uuid.UUID]bool{}
+	for _, snapshotID := range req.SnapshotIDs {
+		snapshot, err := h.repo.GetByID(r.Context(), snapshotID)
+		if err != nil || snapshot == nil {
+			continue
+		}
+
+		if req.ReviewStatus == models.ReviewStatusApproved {
+			h.reviews.applyApproval(r.Context(), snapshot)
 		}
+		h.reviews.snapshotReviewed(r.Context(), snapshot)
+		buildIDs[snapshot.BuildID] = true
+	}
+
+	for buildID := range buildIDs {
+		h.reviews.refreshBuild(r.Context(), buildID, user)
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{"updated": len(req.SnapshotIDs)})
 }
 
-// GetImage serves a snapshot image
+// GetImage serves a snapshot image, stored or rendered as one of the views
 func (h *SnapshotHandlers) GetImage(w http.ResponseWriter, r *http.Request) {
-	imageType := chi.URLParam(r, "imageType") // base, comparison, or diff
+	imageType := chi.URLParam(r, "imageType") // base, comparison, diff or a view
 	idStr := chi.URLParam(r, "snapshotID")
 
 	id, err := parseUUID(idStr)
@@ -443,6 +444,9 @@ func (h *SnapshotHandlers) GetImage(w http.ResponseWriter, r *http.Request) {
 		imagePath = snapshot.ComparisonImagePath
 	case "diff":
 		imagePath = snapshot.DiffImagePath
+	case viewSideBySide, viewOverlay, viewHeatmap, viewBlink:
+		h.serveView(w, r, snapshot, imageType)
+		return
 	default:
 		respondError(w, http.StatusBadRequest, "Invalid image type")
 		return
@@ -453,16 +457,7 @@ func (h *SnapshotHandlers) GetImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := h.storage.GetFile(*imagePath)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "Image file not found")
-		return
-	}
-	defer file.Close()
-
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
-	io.Copy(w, file)
+	serveStoredImage(w, r, h.cache, *imagePath, snapshot.UpdatedAt)
 }
 
 // Delete deletes a snapshot
@@ -480,21 +475,25 @@ func (h *SnapshotHandlers) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete images
-	if snapshot.ComparisonImagePath != nil {
+	// Delete images. Hashed comparison images may be shared with other
+	// snapshots and baselines and are left to the image sweeper.
+	if snapshot.ComparisonImagePath != nil && snapshot.ComparisonImageHash == nil {
 		h.storage.DeleteFile(*snapshot.ComparisonImagePath)
 	}
 	if snapshot.DiffImagePath != nil {
 		h.storage.DeleteFile(*snapshot.DiffImagePath)
 	}
+	if build, err := h.buildRepo.GetByID(r.Context(), snapshot.BuildID); err == nil {
+		h.cache.Invalidate(build.ProjectID, snapshot.ID)
+	}
 
 	if err := h.repo.Delete(r.Context(), id); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to delete snapshot")
 		return
 	}
 
-	// Update build stats
-	h.buildRepo.UpdateStats(r.Context(), snapshot.BuildID)
+	// Update build stats, removing the last unreviewed change can settle the review
+	h.reviews.refreshBuild(r.Context(), snapshot.BuildID, auth.UserFromContext(r.Context()))
 
 	respondJSON(w, http.StatusNoContent, nil)
 }
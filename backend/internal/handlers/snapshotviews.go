@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/diffimage"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/storage"
+)
+
+// Views of a snapshot rendered from its base and comparison images
+const (
+	viewSideBySide = "side-by-side"
+	viewOverlay    = "overlay"
+	viewHeatmap    = "heatmap"
+	viewBlink      = "blink"
+)
+
+const (
+	defaultOverlayOpacity = 0.5
+	defaultBlinkInterval  = 500 * time.Millisecond
+	minBlinkInterval      = 100 * time.Millisecond
+	maxBlinkInterval      = 5 * time.Second
+)
+
+// view is a rendered view with the options it was asked for
+type view struct {
+	// name identifies the view and its options in the cache, with the file extension
+	name        string
+	contentType string
+	render      func(w io.Writer, base, comparison image.Image) error
+}
+
+/*
+parseView reads the options of a view from the query string: opacity (0 to 1)
+for overlay, and format (gif or apng) and interval (milliseconds) for blink.
+Opacity is kept to whole percents so near identical requests share a cache
+entry.
+*/
+func parseView(imageType string, r *http.Request) (*view, string) {
+	query := r.URL.Query()
+
+	switch imageType {
+	case viewSideBySide:
+		return &view{name: imageType + ".png", contentType: "image/png", render: func(w io.Writer, base, comparison image.Image) error {
+			return derived.EncodePNG(w, diffimage.SideBySide(base, comparison))
+		}}, ""
+
+	case viewHeatmap:
+		return &view{name: imageType + ".png", contentType: "image/png", render: func(w io.Writer, base, comparison image.Image) error {
+			return derived.EncodePNG(w, diffimage.Heatmap(base, comparison))
+		}}, ""
+
+	case viewOverlay:
+		opacity := defaultOverlayOpacity
+		if value := query.Get("opacity"); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				return nil, "opacity must be between 0 and 1"
+			}
+			opacity = parsed
+		}
+		percent := int(math.Round(opacity * 100))
+
+		return &view{name: fmt.Sprintf("%s-%d.png", imageType, percent), contentType: "image/png", render: func(w io.Writer, base, comparison image.Image) error {
+			return derived.EncodePNG(w, diffimage.Overlay(base, comparison, float64(percent)/100))
+		}}, ""
+
+	case viewBlink:
+		interval := defaultBlinkInterval
+		if value := query.Get("interval"); value != "" {
+			ms, err := strconv.Atoi(value)
+			interval = time.Duration(ms) * time.Millisecond
+			if err != nil || interval < minBlinkInterval || interval > maxBlinkInterval {
+				return nil, fmt.Sprintf("interval must be between %d and %d milliseconds", minBlinkInterval.Milliseconds(), maxBlinkInterval.Milliseconds())
+			}
+		}
+
+		name := fmt.Sprintf("%s-%d", imageType, interval.Milliseconds())
+		switch format := query.Get("format"); format {
+		case "", "gif":
+			return &view{name: name + ".gif", contentType: "image/gif", render: func(w io.Writer, base, comparison image.Image) error {
+				return derived.EncodeGIF(w, diffimage.BlinkFrames(base, comparison), interval)
+			}}, ""
+		case "apng":
+			return &view{name: name + ".apng", contentType: "image/apng", render: func(w io.Writer, base, comparison image.Image) error {
+				return derived.EncodeAPNG(w, diffimage.BlinkFrames(base, comparison), interval)
+			}}, ""
+		default:
+			return nil, "format must be gif or apng"
+		}
+	}
+
+	return nil, "Invalid image type"
+}
+
+// viewVersion identifies the pair of images a snapshot's views are rendered
+// from, so a view of a snapshot compared against a different baseline image
+// is never served from the cache
+func viewVersion(snapshot *models.Snapshot) string {
+	sum := sha256.Sum256([]byte(*snapshot.BaseImagePath + "\n" + *snapshot.ComparisonImagePath))
+	return hex.EncodeToString(sum[:8])
+}
+
+// serveView renders a view of a snapshot's base and comparison images, or
+// serves it from the cache
+func (h *SnapshotHandlers) serveView(w http.ResponseWriter, r *http.Request, snapshot *models.Snapshot, imageType string) {
+	view, msg := parseView(imageType, r)
+	if msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if snapshot.BaseImagePath == nil || snapshot.ComparisonImagePath == nil {
+		respondError(w, http.StatusNotFound, "Image not found")
+		return
+	}
+
+	build, err := h.buildRepo.GetByID(r.Context(), snapshot.BuildID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Build not found")
+		return
+	}
+
+	name := fmt.Sprintf("views/%s-%s", viewVersion(snapshot), view.name)
+	if notModified(w, r, imageETag(snapshot.ID.String(), name, snapshot.UpdatedAt), snapshot.UpdatedAt) {
+		return
+	}
+
+	file, err := h.cache.Get(build.ProjectID, snapshot.ID, name, func(w io.Writer) error {
+		base, err := h.cache.LoadImage(*snapshot.BaseImagePath)
+		if err != nil {
+			return err
+		}
+		comparison, err := h.cache.LoadImage(*snapshot.ComparisonImagePath)
+		if err != nil {
+			return err
+		}
+		return view.render(w, base, comparison)
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Image file not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to render image")
+		return
+	}
+	defer file.Close()
+
+	serveImage(w, r, file, view.contentType, snapshot.UpdatedAt)
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/crzytrane/diffit/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultDeliveryLimit is how many deliveries are listed when no limit is given
+const defaultDeliveryLimit = 50
+
+type WebhookHandlers struct {
+	repo         *repository.WebhookRepository
+	deliveryRepo *repository.WebhookDeliveryRepository
+	projectRepo  *repository.ProjectRepository
+	dispatcher   *webhooks.Dispatcher
+}
+
+func NewWebhookHandlers(
+	repo *repository.WebhookRepository,
+	deliveryRepo *repository.WebhookDeliveryRepository,
+	projectRepo *repository.ProjectRepository,
+	dispatcher *webhooks.Dispatcher,
+) *WebhookHandlers {
+	return &WebhookHandlers{
+		repo:         repo,
+		deliveryRepo: deliveryRepo,
+		projectRepo:  projectRepo,
+		dispatcher:   dispatcher,
+	}
+}
+
+func (h *WebhookHandlers) validateWebhook(req models.WebhookRequest) string {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an http or https URL"
+	}
+	if err := h.dispatcher.CheckHost(u.Hostname()); err != nil {
+		return "url must not point to a private network"
+	}
+	if req.Secret != nil && *req.Secret == "" {
+		return "secret must not be empty"
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			return "Unknown event " + strconv.Quote(string(event))
+		}
+	}
+	return ""
+}
+
+// List lists a project's webhooks
+func (h *WebhookHandlers) List(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	hooks, err := h.repo.ListByProject(r.Context(), projectID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list webhooks")
+		return
+	}
+
+	if hooks == nil {
+		hooks = []models.Webhook{}
+	}
+
+	respondJSON(w, http.StatusOK, hooks)
+}
+
+// Create adds a webhook to a project. The signing secret is only returned here.
+func (h *WebhookHandlers) Create(w http.ResponseWriter, r *http.Request) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := h.validateWebhook(req); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	// Verify project exists
+	if _, err := h.projectRepo.GetByID(r.Context(), projectID); err != nil {
+		respondError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	var secret string
+	if req.Secret != nil {
+		secret = *req.Secret
+	} else if secret, err = webhooks.NewSecret(); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	enabled := req.Enabled == nil || *req.Enabled
+	webhook, err := h.repo.Create(r.Context(), projectID, req.URL, secret, req.Events, enabled)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, models.CreatedWebhook{Webhook: *webhook, Secret: secret})
+}
+
+// Get retrieves a webhook
+func (h *WebhookHandlers) Get(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, webhook)
+}
+
+// Update replaces a webhook's URL and events, its secret and enabled flag
+// are kept unless given
+func (h *WebhookHandlers) Update(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if msg := h.validateWebhook(req); msg != "" {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	secret, enabled := webhook.Secret, webhook.Enabled
+	if req.Secret != nil {
+		secret = *req.Secret
+	}
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	webhook, err := h.repo.Update(r.Context(), webhook.ID, req.URL, secret, req.Events, enabled)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, webhook)
+}
+
+// Delete removes a webhook and its delivery log
+func (h *WebhookHandlers) Delete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.Delete(r.Context(), webhook.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusNoContent, nil)
+}
+
+// ListDeliveries lists a webhook's most recent deliveries, newest first
+func (h *WebhookHandlers) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > 500 {
+			respondError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := h.deliveryRepo.ListByWebhook(r.Context(), webhook.ID, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list deliveries")
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	respondJSON(w, http.StatusOK, deliveries)
+}
+
+// Redeliver queues a delivery to be sent again with the same payload
+func (h *WebhookHandlers) Redeliver(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := parseUUID(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	delivery, err := h.deliveryRepo.GetByID(r.Context(), deliveryID)
+	if err != nil || delivery.WebhookID != webhook.ID {
+		respondError(w, http.StatusNotFound, "Delivery not found")
+		return
+	}
+
+	if !webhook.Enabled {
+		respondError(w, http.StatusConflict, "Webhook is disabled")
+		return
+	}
+
+	redelivery, err := h.dispatcher.Redeliver(r.Context(), delivery)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to redeliver")
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, redelivery)
+}
+
+// find loads the webhook from the URL and checks it belongs to the project in the URL
+func (h *WebhookHandlers) find(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	projectID, err := parseUUID(chi.URLParam(r, "projectID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid project ID")
+		return nil, false
+	}
+
+	id, err := parseUUID(chi.URLParam(r, "webhookID"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return nil, false
+	}
+
+	webhook, err := h.repo.GetByID(r.Context(), id)
+	if err != nil || webhook.ProjectID != projectID {
+		respondError(w, http.StatusNotFound, "Webhook not found")
+		return nil, false
+	}
+
+	return webhook, true
+}
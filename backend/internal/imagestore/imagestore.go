@@ -0,0 +1,178 @@
+/*
+Package imagestore keeps uploaded images content-addressed. Every image is
+named by the SHA-256 of its decoded pixels, so the same screenshot uploaded in
+build after build, or approved into a baseline, is stored once per project.
+Nothing deletes these files directly: the sweeper removes blobs that no
+snapshot or baseline has pointed at for a grace period.
+*/
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrInvalidImage is returned by Put when the upload can't be decoded
+var ErrInvalidImage = errors.New("invalid image")
+
+// sweepBatchSize is how many candidate blobs the sweeper looks at per query
+const sweepBatchSize = 500
+
+type Store struct {
+	storage storage.Storage
+	blobs   *repository.ImageBlobRepository
+}
+
+func New(pool *pgxpool.Pool, storage storage.Storage) *Store {
+	return &Store{
+		storage: storage,
+		blobs:   repository.NewImageBlobRepository(pool),
+	}
+}
+
+/*
+Put decodes an image, stores it under its pixel hash and returns the stored
+blob. PNG uploads are kept byte for byte, other formats are re-encoded as PNG.
+If the project already has an image with the same pixels nothing is written.
+*/
+func (s *Store) Put(ctx context.Context, projectID uuid.UUID, reader io.Reader) (*models.ImageBlob, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	if format != "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		data = buf.Bytes()
+	}
+
+	hash := Hash(img)
+	name := fmt.Sprintf("%s/%s.png", hash[:2], hash)
+	bounds := img.Bounds()
+
+	// Record the blob before writing so a concurrent sweep either sees it as
+	// used or has finished removing the file by the time we check for it
+	blob, err := s.blobs.Touch(ctx, models.ImageBlob{
+		ProjectID: projectID,
+		Hash:      hash,
+		Path:      storage.Key(projectID, storage.StorageTypeObject, name),
+		Width:     bounds.Dx(),
+		Height:    bounds.Dy(),
+		SizeBytes: int64(len(data)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.storage.Exists(blob.Path) {
+		if _, err := s.storage.SaveFileWithName(projectID, storage.StorageTypeObject, name, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	return blob, nil
+}
+
+/*
+Hash returns the hex SHA-256 of an image's dimensions and non-premultiplied
+RGBA pixels. Fully transparent pixels hash the same whatever their colour
+channels, so encoders that drop or keep hidden colour agree.
+*/
+func Hash(img image.Image) string {
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+
+	h := sha256.New()
+	var size [8]byte
+	binary.BigEndian.PutUint32(size[:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(size[4:], uint32(bounds.Dy()))
+	h.Write(size[:])
+
+	for y := 0; y < bounds.Dy(); y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+bounds.Dx()*4]
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] == 0 {
+				row[i], row[i+1], row[i+2] = 0, 0, 0
+			}
+		}
+		h.Write(row)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sweep deletes blobs that nothing has referenced for at least grace and
+// returns how many were removed
+func (s *Store) Sweep(ctx context.Context, grace time.Duration) (int, error) {
+	before := time.Now().Add(-grace)
+	deleted := 0
+
+	for {
+		blobs, err := s.blobs.ListUnreferenced(ctx, before, sweepBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, blob := range blobs {
+			ok, err := s.blobs.DeleteUnreferenced(ctx, blob.ProjectID, blob.Hash, before, s.storage.DeleteFile)
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				deleted++
+			}
+		}
+
+		if len(blobs) < sweepBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// RunSweeper sweeps every interval until ctx is cancelled
+func (s *Store) RunSweeper(ctx context.Context, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.Sweep(ctx, grace)
+			if err != nil {
+				log.Printf("image sweeper: %v", err)
+			}
+			if deleted > 0 {
+				log.Printf("image sweeper: removed %d unreferenced images", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Package janitor deletes what a project no longer needs: builds its retention
+policy says have expired, and files in storage that no row points at any
+more. Derived files are kept while their snapshot exists, and image variants
+while the image they were made from is referenced. Content-addressed
+images are left alone, the image sweeper removes them once no snapshot or
+baseline references them.
+*/
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/derived"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/crzytrane/diffit/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Janitor struct {
+	storage  storage.Storage
+	projects *repository.ProjectRepository
+	policies *repository.RetentionPolicyRepository
+	builds   *repository.BuildRepository
+	files    *repository.StoredFileRepository
+	// fileGrace is how old an unreferenced file must be before it is deleted,
+	// so files written just before the row pointing at them are kept
+	fileGrace time.Duration
+}
+
+func New(pool *pgxpool.Pool, storage storage.Storage, fileGrace time.Duration) *Janitor {
+	return &Janitor{
+		storage:   storage,
+		projects:  repository.NewProjectRepository(pool),
+		policies:  repository.NewRetentionPolicyRepository(pool),
+		builds:    repository.NewBuildRepository(pool),
+		files:     repository.NewStoredFileRepository(pool),
+		fileGrace: fileGrace,
+	}
+}
+
+// DeleteBuild deletes a build with its snapshots and the files only they used
+func (j *Janitor) DeleteBuild(ctx context.Context, build *models.Build) error {
+	paths, err := j.files.ListBuildFiles(ctx, build.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := j.builds.Delete(ctx, build.ID); err != nil {
+		return err
+	}
+
+	unreferenced, err := j.files.Unreferenced(ctx, build.ProjectID, paths)
+	if err != nil {
+		return err
+	}
+	for _, path := range unreferenced {
+		if err := j.storage.DeleteFile(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Collect deletes a project's expired builds and orphaned files and reports
+what it removed. With dryRun nothing is deleted and the report lists what
+would have been: both are worked out as if the expired builds were already
+gone, so the files only they used are reported either way.
+*/
+func (j *Janitor) Collect(ctx context.Context, projectID uuid.UUID, dryRun bool) (*models.RetentionReport, error) {
+	report := &models.RetentionReport{
+		ProjectID:     projectID,
+		DryRun:        dryRun,
+		ExpiredBuilds: []models.ExpiredBuild{},
+		OrphanedFiles: []models.OrphanedFile{},
+	}
+
+	policy, err := j.policies.Get(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []models.ExpiredBuild
+	if policy != nil {
+		expired, err = j.builds.ListExpired(ctx, *policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Files of expired builds are deleted with them, however recent
+	expiredIDs := make([]uuid.UUID, 0, len(expired))
+	buildFiles := map[string]struct{}{}
+	for _, build := range expired {
+		expiredIDs = append(expiredIDs, build.ID)
+
+		paths, err := j.files.ListBuildFiles(ctx, build.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			buildFiles[path] = struct{}{}
+		}
+	}
+
+	files, err := j.storage.ListProjectFiles(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load references after listing so a file written in between is either
+	// too new to delete or already referenced
+	referenced, err := j.files.ListReferenced(ctx, projectID, expiredIDs)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := j.files.ListSnapshotIDs(ctx, projectID, expiredIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, build := range expired {
+		if !dryRun {
+			if err := j.builds.Delete(ctx, build.ID); err != nil {
+				return report, fmt.Errorf("failed to delete build %d: %w", build.BuildNumber, err)
+			}
+		}
+		report.ExpiredBuilds = append(report.ExpiredBuilds, build)
+	}
+
+	before := time.Now().Add(-j.fileGrace)
+	for _, file := range files {
+		if _, ok := referenced[file.Path]; ok {
+			continue
+		}
+		if _, ok := buildFiles[file.Path]; !ok && !file.ModTime.Before(before) {
+			continue
+		}
+		if owner, ok := derived.Owner(file.Path); ok {
+			if _, ok := snapshots[owner]; ok {
+				continue
+			}
+		}
+		if source, ok := derived.VariantSource(file.Path); ok {
+			if _, ok := referenced[source]; ok {
+				continue
+			}
+		}
+
+		if !dryRun {
+			if err := j.storage.DeleteFile(file.Path); err != nil {
+				return report, err
+			}
+		}
+		report.OrphanedFiles = append(report.OrphanedFiles, models.OrphanedFile{
+			Path:       file.Path,
+			SizeBytes:  file.Size,
+			ModifiedAt: file.ModTime,
+		})
+		report.OrphanedBytes += file.Size
+	}
+
+	return report, nil
+}
+
+// CollectAll runs Collect for every project. A project that fails is logged
+// and skipped, the first error is returned once the rest are done.
+func (j *Janitor) CollectAll(ctx context.Context, dryRun bool) ([]models.RetentionReport, error) {
+	projectIDs, err := j.projects.ListIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []models.RetentionReport
+	var firstErr error
+	for _, projectID := range projectIDs {
+		report, err := j.Collect(ctx, projectID, dryRun)
+		if report != nil {
+			reports = append(reports, *report)
+		}
+		if err != nil {
+			log.Printf("janitor: project %s: %v", projectID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if ctx.Err() != nil {
+			return reports, ctx.Err()
+		}
+	}
+
+	return reports, firstErr
+}
+
+// Run collects every interval until ctx is cancelled
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reports, _ := j.CollectAll(ctx, false)
+
+			builds, files := 0, 0
+			for _, report := range reports {
+				builds += len(report.ExpiredBuilds)
+				files += len(report.OrphanedFiles)
+			}
+			if builds > 0 || files > 0 {
+				log.Printf("janitor: removed %d expired builds and %d orphaned files", builds, files)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Package live pushes build progress to browsers as it happens. Events published
+on the bus are fanned out to the subscribers watching their build on this
+replica, and sent to every other replica with Postgres NOTIFY. Each replica
+LISTENs for those notifications and fans them out to its own subscribers, so
+it doesn't matter which replica a browser's stream is connected to.
+*/
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// channel is the Postgres notification channel events are sent on
+const channel = "diffit_events"
+
+// maxNotifyPayload keeps notifications under Postgres' 8000 byte limit
+const maxNotifyPayload = 7900
+
+// subscriptionBuffer is how many events a subscriber can fall behind by
+// before it is dropped
+const subscriptionBuffer = 64
+
+// reconnectDelay is how long to wait before listening again after the
+// listening connection is lost
+const reconnectDelay = 5 * time.Second
+
+// notification is what's sent between replicas
+type notification struct {
+	// Origin is the replica that published the event, which has already
+	// delivered it to its own subscribers
+	Origin  uuid.UUID    `json:"origin"`
+	BuildID uuid.UUID    `json:"build_id"`
+	Event   events.Event `json:"event"`
+}
+
+// Hub tracks the streams open on this replica by build
+type Hub struct {
+	pool   *pgxpool.Pool
+	origin uuid.UUID
+
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[*Subscription]struct{}
+	closed      bool
+}
+
+// New creates a Hub. Subscribe its Publish method to an events.Bus and run
+// Listen to receive events published on other replicas.
+func New(pool *pgxpool.Pool) *Hub {
+	return &Hub{
+		pool:        pool,
+		origin:      uuid.New(),
+		subscribers: map[uuid.UUID]map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives the events of one build
+type Subscription struct {
+	hub     *Hub
+	buildID uuid.UUID
+	events  chan events.Event
+}
+
+// Events returns the subscription's events. The channel is closed when the
+// subscriber falls too far behind or the hub shuts down.
+func (s *Subscription) Events() <-chan events.Event {
+	return s.events
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Subscribe starts receiving the events of a build
+func (h *Hub) Subscribe(buildID uuid.UUID) *Subscription {
+	sub := &Subscription{hub: h, buildID: buildID, events: make(chan events.Event, subscriptionBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+
+	if h.subscribers[buildID] == nil {
+		h.subscribers[buildID] = map[*Subscription]struct{}{}
+	}
+	h.subscribers[buildID][sub] = struct{}{}
+
+	return sub
+}
+
+// remove drops a subscription and closes its channel, h.mu must be held
+func (h *Hub) remove(sub *Subscription) {
+	subs := h.subscribers[sub.buildID]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subscribers, sub.buildID)
+	}
+	close(sub.events)
+}
+
+// Publish delivers an event to this replica's subscribers and notifies the
+// other replicas. Events that don't belong to a build are ignored.
+func (h *Hub) Publish(ctx context.Context, event events.Event) {
+	buildID, ok := BuildOf(event)
+	if !ok {
+		return
+	}
+
+	h.fanOut(buildID, event)
+
+	if err := h.notify(context.WithoutCancel(ctx), buildID, event); err != nil {
+		log.Printf("live: %v", err)
+	}
+}
+
+// fanOut sends an event to the build's subscribers, dropping any that have
+// fallen behind so one slow browser can't hold up the rest
+func (h *Hub) fanOut(buildID uuid.UUID, event events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers[buildID] {
+		select {
+		case sub.events <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// notify sends an event to the other replicas. Events too big for a
+// notification are sent without their data, subscribers then refetch.
+func (h *Hub) notify(ctx context.Context, buildID uuid.UUID, event events.Event) error {
+	payload, err := json.Marshal(notification{Origin: h.origin, BuildID: buildID, Event: event})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", event.Type, err)
+	}
+	if len(payload) > maxNotifyPayload {
+		event.Data = nil
+		if payload, err = json.Marshal(notification{Origin: h.origin, BuildID: buildID, Event: event}); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", event.Type, err)
+		}
+	}
+
+	if _, err := h.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, channel, string(payload)); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", event.Type, err)
+	}
+	return nil
+}
+
+// Listen receives events published on other replicas until ctx is
+// cancelled, reconnecting if the connection drops. Subscriptions are closed
+// when it returns.
+func (h *Hub) Listen(ctx context.Context) {
+	defer h.shutdown()
+
+	for {
+		err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("live: %v, listening again in %s", err, reconnectDelay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// listen holds a connection out of the pool for LISTEN and fans out what
+// arrives on it
+func (h *Hub) listen(ctx context.Context) error {
+	pooled, err := h.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	// A listening connection can't go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, `LISTEN `+channel); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	for {
+		received, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+
+		var n notification
+		if err := json.Unmarshal([]byte(received.Payload), &n); err != nil {
+			log.Printf("live: ignoring malformed notification: %v", err)
+			continue
+		}
+		if n.Origin == h.origin {
+			continue
+		}
+		h.fanOut(n.BuildID, n.Event)
+	}
+}
+
+// shutdown closes every subscription and refuses new ones
+func (h *Hub) shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.subscribers {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// BuildOf returns the build an event belongs to
+func BuildOf(event events.Event) (uuid.UUID, bool) {
+	switch data := event.Data.(type) {
+	case *models.Build:
+		return data.ID, true
+	case *models.Snapshot:
+		return data.BuildID, true
+	default:
+		return uuid.Nil, false
+	}
+}
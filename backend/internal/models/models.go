@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,6 +27,52 @@ const (
 	SnapshotStatusFailed     SnapshotStatus = "failed"
 )
 
+// ChangeType is how a snapshot differs from what it was compared against
+type ChangeType string
+
+const (
+	ChangeTypeNew       ChangeType = "new"
+	ChangeTypeChanged   ChangeType = "changed"
+	ChangeTypeUnchanged ChangeType = "unchanged"
+	// ChangeTypeResized marks a snapshot whose dimensions differ from what it
+	// was compared against
+	ChangeTypeResized ChangeType = "resized"
+	// ChangeTypeRemoved marks a record created when a build is finalized for
+	// a baseline the build has no snapshot for
+	ChangeTypeRemoved ChangeType = "removed"
+)
+
+// DiffJobStatus represents the state of a queued diff job
+type DiffJobStatus string
+
+const (
+	DiffJobStatusPending   DiffJobStatus = "pending"
+	DiffJobStatusRunning   DiffJobStatus = "running"
+	DiffJobStatusCompleted DiffJobStatus = "completed"
+	DiffJobStatusFailed    DiffJobStatus = "failed"
+)
+
+// Role is a user's access level within a project
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleReviewer Role = "reviewer"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleReviewer: 2, RoleAdmin: 3}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// Includes reports whether r grants at least the access of other
+func (r Role) Includes(other Role) bool {
+	return roleRank[r] >= roleRank[other]
+}
+
 // ReviewStatus represents the review status of a snapshot
 type ReviewStatus string
 
@@ -35,33 +82,134 @@ const (
 	ReviewStatusRejected   ReviewStatus = "rejected"
 )
 
+// BuildReviewState is the review outcome of a build as a whole, derived from
+// the review status of its new, changed and removed snapshots
+type BuildReviewState string
+
+const (
+	// BuildReviewStatePending means the build hasn't finished processing
+	BuildReviewStatePending     BuildReviewState = "pending"
+	BuildReviewStateNeedsReview BuildReviewState = "needs_review"
+	// BuildReviewStateApproved means every change was approved, or there were none
+	BuildReviewStateApproved BuildReviewState = "approved"
+	// BuildReviewStateRejected means at least one change was rejected
+	BuildReviewStateRejected BuildReviewState = "rejected"
+)
+
 // Project represents a visual testing project
 type Project struct {
-	ID            uuid.UUID  `json:"id"`
-	Name          string     `json:"name"`
-	Slug          string     `json:"slug"`
-	RepositoryURL *string    `json:"repository_url,omitempty"`
-	DefaultBranch string     `json:"default_branch"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+	ID            uuid.UUID `json:"id"`
+	Name          string    `json:"name"`
+	Slug          string    `json:"slug"`
+	RepositoryURL *string   `json:"repository_url,omitempty"`
+	DefaultBranch string    `json:"default_branch"`
+	// DiffThreshold is the per-pixel color difference (0 to 1) that counts as a change
+	DiffThreshold float64 `json:"diff_threshold"`
+	// AcceptableDiffPercentage is the share of changed pixels (0 to 100) below
+	// which a snapshot is treated as unchanged
+	AcceptableDiffPercentage float64 `json:"acceptable_diff_percentage"`
+	IgnoreAntialiasing       bool    `json:"ignore_antialiasing"`
+	// ColorMetric is how pixel colours are compared: "yiq" or "ciede2000"
+	ColorMetric string `json:"color_metric"`
+	// ChangeCriterion decides when a snapshot has changed: "pixels" uses
+	// AcceptableDiffPercentage, "ssim" a structural similarity below MinSSIM
+	// and "regions" a changed region of at least MinRegionPixels pixels
+	ChangeCriterion string  `json:"change_criterion"`
+	MinSSIM         float64 `json:"min_ssim"`
+	MinRegionPixels int     `json:"min_region_pixels"`
+	// Alignment is how screenshots of different sizes are lined up: "top-left"
+	// or "content", which matches rows to find inserted and removed content
+	Alignment string    `json:"alignment"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DiffRule overrides a project's diff settings for snapshots whose name
+// matches Pattern. Unset fields fall back to the project settings.
+type DiffRule struct {
+	ID                       uuid.UUID `json:"id"`
+	ProjectID                uuid.UUID `json:"project_id"`
+	Pattern                  string    `json:"pattern"`
+	Priority                 int       `json:"priority"`
+	DiffThreshold            *float64  `json:"diff_threshold,omitempty"`
+	AcceptableDiffPercentage *float64  `json:"acceptable_diff_percentage,omitempty"`
+	IgnoreAntialiasing       *bool     `json:"ignore_antialiasing,omitempty"`
+	CreatedAt                time.Time `json:"created_at"`
+	UpdatedAt                time.Time `json:"updated_at"`
 }
 
 // Build represents a collection of snapshots from a single CI run
 type Build struct {
-	ID                uuid.UUID   `json:"id"`
-	ProjectID         uuid.UUID   `json:"project_id"`
-	BuildNumber       int         `json:"build_number"`
-	Branch            string      `json:"branch"`
-	CommitSHA         *string     `json:"commit_sha,omitempty"`
-	CommitMessage     *string     `json:"commit_message,omitempty"`
-	PullRequestNumber *int        `json:"pull_request_number,omitempty"`
-	Status            BuildStatus `json:"status"`
-	TotalSnapshots    int         `json:"total_snapshots"`
-	ChangedSnapshots  int         `json:"changed_snapshots"`
-	ApprovedSnapshots int         `json:"approved_snapshots"`
-	CreatedAt         time.Time   `json:"created_at"`
-	UpdatedAt         time.Time   `json:"updated_at"`
-	FinishedAt        *time.Time  `json:"finished_at,omitempty"`
+	ID                 uuid.UUID        `json:"id"`
+	ProjectID          uuid.UUID        `json:"project_id"`
+	BuildNumber        int              `json:"build_number"`
+	Branch             string           `json:"branch"`
+	CommitSHA          *string          `json:"commit_sha,omitempty"`
+	CommitMessage      *string          `json:"commit_message,omitempty"`
+	PullRequestNumber  *int             `json:"pull_request_number,omitempty"`
+	BaseBuildID        *uuid.UUID       `json:"base_build_id,omitempty"`
+	BaseCommitSHA      *string          `json:"base_commit_sha,omitempty"`
+	Status             BuildStatus      `json:"status"`
+	ReviewState        BuildReviewState `json:"review_state"`
+	TotalSnapshots     int              `json:"total_snapshots"`
+	ChangedSnapshots   int              `json:"changed_snapshots"`
+	ApprovedSnapshots  int              `json:"approved_snapshots"`
+	NewSnapshots       int              `json:"new_snapshots"`
+	RemovedSnapshots   int              `json:"removed_snapshots"`
+	UnchangedSnapshots int              `json:"unchanged_snapshots"`
+	FailedSnapshots    int              `json:"failed_snapshots"`
+	CreatedAt          time.Time        `json:"created_at"`
+	UpdatedAt          time.Time        `json:"updated_at"`
+	FinishedAt         *time.Time       `json:"finished_at,omitempty"`
+}
+
+// GitHost is a git hosting service diffit can report commit statuses to
+type GitHost string
+
+const (
+	GitHostGitHub GitHost = "github"
+	GitHostGitLab GitHost = "gitlab"
+)
+
+// CommitStatusConfig is how a project reports build results to its git host.
+// The token is write-only and never returned by the API.
+type CommitStatusConfig struct {
+	ProjectID uuid.UUID `json:"project_id"`
+	Host      GitHost   `json:"host"`
+	// APIURL overrides the host's public API, for self-hosted instances
+	APIURL *string `json:"api_url,omitempty"`
+	// Repository is "owner/name" on GitHub, the project path or ID on GitLab
+	Repository string    `json:"repository"`
+	Token      string    `json:"-"`
+	Context    string    `json:"context"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CommitStatusConfigRequest saves a project's commit status config. Token may
+// be left out to keep the current one and Repository to derive it from the
+// project's repository URL.
+type CommitStatusConfigRequest struct {
+	Host       GitHost `json:"host"`
+	APIURL     *string `json:"api_url,omitempty"`
+	Repository string  `json:"repository"`
+	Token      *string `json:"token,omitempty"`
+	Context    *string `json:"context,omitempty"`
+	Enabled    *bool   `json:"enabled,omitempty"`
+}
+
+// BuildReviewTransition records a change of a build's review state. UserID
+// and ReviewedBy are empty for transitions made by the server, like a build
+// with no changes being approved when it completes.
+type BuildReviewTransition struct {
+	ID         uuid.UUID        `json:"id"`
+	BuildID    uuid.UUID        `json:"build_id"`
+	FromState  BuildReviewState `json:"from_state"`
+	ToState    BuildReviewState `json:"to_state"`
+	UserID     *uuid.UUID       `json:"user_id,omitempty"`
+	ReviewedBy *string          `json:"reviewed_by,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
 }
 
 // Snapshot represents a single screenshot comparison
@@ -69,6 +217,7 @@ type Snapshot struct {
 	ID                  uuid.UUID      `json:"id"`
 	BuildID             uuid.UUID      `json:"build_id"`
 	BaselineID          *uuid.UUID     `json:"baseline_id,omitempty"`
+	BaseSnapshotID      *uuid.UUID     `json:"base_snapshot_id,omitempty"`
 	Name                string         `json:"name"`
 	Width               *int           `json:"width,omitempty"`
 	Height              *int           `json:"height,omitempty"`
@@ -76,16 +225,64 @@ type Snapshot struct {
 	Viewport            *string        `json:"viewport,omitempty"`
 	BaseImagePath       *string        `json:"base_image_path,omitempty"`
 	ComparisonImagePath *string        `json:"comparison_image_path,omitempty"`
+	ComparisonImageHash *string        `json:"comparison_image_hash,omitempty"`
 	DiffImagePath       *string        `json:"diff_image_path,omitempty"`
 	DiffPercentage      *float64       `json:"diff_percentage,omitempty"`
+	SSIM                *float64       `json:"ssim,omitempty"`
+	DiffRegionCount     *int           `json:"diff_region_count,omitempty"`
+	DiffRegions         []DiffRegion   `json:"diff_regions,omitempty"`
+	ChangeType          *ChangeType    `json:"change_type,omitempty"`
 	Status              SnapshotStatus `json:"status"`
+	FailureReason       *string        `json:"failure_reason,omitempty"`
 	ReviewStatus        ReviewStatus   `json:"review_status"`
 	ReviewedBy          *string        `json:"reviewed_by,omitempty"`
+	ReviewedByUserID    *uuid.UUID     `json:"reviewed_by_user_id,omitempty"`
 	ReviewedAt          *time.Time     `json:"reviewed_at,omitempty"`
 	CreatedAt           time.Time      `json:"created_at"`
 	UpdatedAt           time.Time      `json:"updated_at"`
 }
 
+// DiffRegion is the bounding box, in diff image coordinates, of a connected
+// region of changed pixels. Snapshots keep the largest regions in
+// DiffRegions and count all of them in DiffRegionCount.
+type DiffRegion struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Pixels is how many changed pixels the region holds
+	Pixels int `json:"pixels"`
+}
+
+// Rect is an area of an image
+type Rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// SnapshotRegion is a cluster of nearby changed pixels in a snapshot's diff,
+// so reviewers can look at one change at a time. X, Y, Width and Height are
+// in diff image coordinates.
+type SnapshotRegion struct {
+	ID         uuid.UUID `json:"id"`
+	SnapshotID uuid.UUID `json:"snapshot_id"`
+	// Position orders a snapshot's regions, largest first
+	Position int `json:"position"`
+	X        int `json:"x"`
+	Y        int `json:"y"`
+	Width    int `json:"width"`
+	Height   int `json:"height"`
+	// Pixels is how many changed pixels the region holds
+	Pixels int `json:"pixels"`
+	// Base and Comparison are the areas of each image the region shows, nil
+	// when it only covers area the other image added or removed
+	Base       *Rect     `json:"base,omitempty"`
+	Comparison *Rect     `json:"comparison,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 // Baseline represents an approved baseline image for comparison
 type Baseline struct {
 	ID               uuid.UUID  `json:"id"`
@@ -93,6 +290,7 @@ type Baseline struct {
 	Name             string     `json:"name"`
 	Branch           string     `json:"branch"`
 	ImagePath        string     `json:"image_path"`
+	ImageHash        *string    `json:"image_hash,omitempty"`
 	Width            *int       `json:"width,omitempty"`
 	Height           *int       `json:"height,omitempty"`
 	Browser          *string    `json:"browser,omitempty"`
@@ -102,27 +300,365 @@ type Baseline struct {
 	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
+// BaselineVersion is one image a baseline has had. Versions never change,
+// rolling back records the restored image as a new version.
+type BaselineVersion struct {
+	ID               uuid.UUID  `json:"id"`
+	BaselineID       uuid.UUID  `json:"baseline_id"`
+	Version          int        `json:"version"`
+	ImagePath        string     `json:"image_path"`
+	ImageHash        *string    `json:"image_hash,omitempty"`
+	Width            *int       `json:"width,omitempty"`
+	Height           *int       `json:"height,omitempty"`
+	SourceSnapshotID *uuid.UUID `json:"source_snapshot_id,omitempty"`
+	ApprovedByUserID *uuid.UUID `json:"approved_by_user_id,omitempty"`
+	ApprovedBy       *string    `json:"approved_by,omitempty"`
+	// RestoredFromID is the version a rollback restored
+	RestoredFromID *uuid.UUID `json:"restored_from_id,omitempty"`
+	// Current is set on the version the baseline has now
+	Current   bool      `json:"current"`
+	ImageURL  string    `json:"image_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ImageBlob is a stored image addressed by the SHA-256 of its decoded pixels
+type ImageBlob struct {
+	ProjectID  uuid.UUID `json:"project_id"`
+	Hash       string    `json:"hash"`
+	Path       string    `json:"path"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	SizeBytes  int64     `json:"size_bytes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// User is a local user account
+type User struct {
+	ID        uuid.UUID `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	IsAdmin   bool      `json:"is_admin"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProjectMember is a user's role in a project
+type ProjectMember struct {
+	ProjectID uuid.UUID `json:"project_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// APIToken is a project-scoped token CI uses to upload builds. The token
+// itself is never stored, only its hash.
+type APIToken struct {
+	ID         uuid.UUID  `json:"id"`
+	ProjectID  uuid.UUID  `json:"project_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// DiffJob represents a queued comparison of a snapshot against its baseline
+type DiffJob struct {
+	ID          uuid.UUID     `json:"id"`
+	SnapshotID  uuid.UUID     `json:"snapshot_id"`
+	Status      DiffJobStatus `json:"status"`
+	Attempts    int           `json:"attempts"`
+	MaxAttempts int           `json:"max_attempts"`
+	LastError   *string       `json:"last_error,omitempty"`
+	RunAt       time.Time     `json:"run_at"`
+	LockedAt    *time.Time    `json:"locked_at,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// EventType names something that happened in diffit that webhooks can subscribe to
+type EventType string
+
+const (
+	EventBuildCreated     EventType = "build.created"
+	EventBuildFinalized   EventType = "build.finalized"
+	EventSnapshotChanged  EventType = "snapshot.changed"
+	EventSnapshotReviewed EventType = "snapshot.reviewed"
+	EventBaselineUpdated  EventType = "baseline.updated"
+
+	// Progress events are only streamed to the frontend, not sent to webhooks
+	EventBuildUpdated      EventType = "build.updated"
+	EventSnapshotCreated   EventType = "snapshot.created"
+	EventSnapshotProcessed EventType = "snapshot.processed"
+)
+
+// WebhookEvents are the events webhooks can subscribe to
+var WebhookEvents = []EventType{
+	EventBuildCreated,
+	EventBuildFinalized,
+	EventSnapshotChanged,
+	EventSnapshotReviewed,
+	EventBaselineUpdated,
+}
+
+// Webhook posts a project's events to a URL. Payloads are signed with the
+// secret, which is only returned when the webhook is created.
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	ProjectID uuid.UUID `json:"project_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	// Events filters what is sent, empty means everything
+	Events    []EventType `json:"events"`
+	Enabled   bool        `json:"enabled"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// CreatedWebhook is returned once when a webhook is created or its secret is
+// rotated, it's the only time the secret is shown
+type CreatedWebhook struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookRequest creates or updates a webhook. A secret is generated when
+// none is given on create, on update a missing secret keeps the current one.
+type WebhookRequest struct {
+	URL     string      `json:"url"`
+	Secret  *string     `json:"secret,omitempty"`
+	Events  []EventType `json:"events"`
+	Enabled *bool       `json:"enabled,omitempty"`
+}
+
+// WebhookDeliveryStatus represents the state of a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryStatusRunning   WebhookDeliveryStatus = "running"
+	WebhookDeliveryStatusSucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryStatusFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event sent, or waiting to be sent, to a webhook
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id"`
+	WebhookID      uuid.UUID             `json:"webhook_id"`
+	Event          EventType             `json:"event"`
+	EventID        uuid.UUID             `json:"event_id"`
+	Payload        json.RawMessage       `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	MaxAttempts    int                   `json:"max_attempts"`
+	ResponseStatus *int                  `json:"response_status,omitempty"`
+	ResponseBody   *string               `json:"response_body,omitempty"`
+	LastError      *string               `json:"last_error,omitempty"`
+	RunAt          time.Time             `json:"run_at"`
+	LockedAt       *time.Time            `json:"locked_at,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+	// RedeliveryOf is the delivery this one was resent from
+	RedeliveryOf *uuid.UUID `json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// BranchRule makes branches whose name matches Pattern inherit baselines from
+// ParentBranch when they have none of their own
+type BranchRule struct {
+	ID           uuid.UUID `json:"id"`
+	ProjectID    uuid.UUID `json:"project_id"`
+	Pattern      string    `json:"pattern"`
+	ParentBranch string    `json:"parent_branch"`
+	// Priority orders rules matching the same branch, highest first
+	Priority  int       `json:"priority"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MergeHook lets the git host tell diffit a branch was merged so its
+// baselines are promoted onto the branch it was merged into
+type MergeHook struct {
+	ProjectID uuid.UUID `json:"project_id"`
+	Secret    string    `json:"-"`
+	// DeleteSource removes the merged branch's baselines once promoted
+	DeleteSource bool      `json:"delete_source"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CreatedMergeHook is returned when a merge hook is created or its secret
+// rotated, it's the only time the secret is shown
+type CreatedMergeHook struct {
+	MergeHook
+	Secret string `json:"secret"`
+	// URL is where the git host should send merge events
+	URL string `json:"url"`
+}
+
+// RetentionPolicy decides which of a project's builds the janitor deletes. A
+// finished build expires once it is older than MaxAgeDays or has
+// KeepBuildsPerBranch newer builds on its branch. Unset limits never expire
+// anything. Builds that a kept build is compared against as its base build
+// are kept with it.
+type RetentionPolicy struct {
+	ProjectID           uuid.UUID `json:"project_id"`
+	KeepBuildsPerBranch *int      `json:"keep_builds_per_branch,omitempty"`
+	MaxAgeDays          *int      `json:"max_age_days,omitempty"`
+	// KeepBaselineSources keeps builds with a snapshot that was approved into
+	// a baseline, so the baseline's history still links to it
+	KeepBaselineSources bool      `json:"keep_baseline_sources"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// ExpiryReason is why a build expired
+type ExpiryReason string
+
+const (
+	ExpiryReasonCount ExpiryReason = "count"
+	ExpiryReasonAge   ExpiryReason = "age"
+)
+
+// ExpiredBuild is a build a retention policy says should be deleted
+type ExpiredBuild struct {
+	ID          uuid.UUID    `json:"id"`
+	BuildNumber int          `json:"build_number"`
+	Branch      string       `json:"branch"`
+	Reason      ExpiryReason `json:"reason"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// OrphanedFile is a stored file no row points at any more
+type OrphanedFile struct {
+	Path       string    `json:"path"`
+	SizeBytes  int64     `json:"size_bytes"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// RetentionReport lists what a garbage collection of a project deleted, or
+// would delete when DryRun is set
+type RetentionReport struct {
+	ProjectID     uuid.UUID      `json:"project_id"`
+	DryRun        bool           `json:"dry_run"`
+	ExpiredBuilds []ExpiredBuild `json:"expired_builds"`
+	OrphanedFiles []OrphanedFile `json:"orphaned_files"`
+	OrphanedBytes int64          `json:"orphaned_bytes"`
+}
+
+// IgnoreRegion is a rectangle, in pixel coordinates, excluded from diffs.
+// It applies either to a single baseline or to every snapshot whose name
+// matches Pattern.
+type IgnoreRegion struct {
+	ID         uuid.UUID  `json:"id"`
+	ProjectID  uuid.UUID  `json:"project_id"`
+	BaselineID *uuid.UUID `json:"baseline_id,omitempty"`
+	Pattern    *string    `json:"pattern,omitempty"`
+	X          int        `json:"x"`
+	Y          int        `json:"y"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	// Selector is the CSS selector the uploader used to locate the region, kept for reference
+	Selector  *string   `json:"selector,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // Request/Response types for API
 
 type CreateProjectRequest struct {
-	Name          string  `json:"name"`
-	Slug          string  `json:"slug"`
-	RepositoryURL *string `json:"repository_url,omitempty"`
-	DefaultBranch *string `json:"default_branch,omitempty"`
+	Name                     string   `json:"name"`
+	Slug                     string   `json:"slug"`
+	RepositoryURL            *string  `json:"repository_url,omitempty"`
+	DefaultBranch            *string  `json:"default_branch,omitempty"`
+	DiffThreshold            *float64 `json:"diff_threshold,omitempty"`
+	AcceptableDiffPercentage *float64 `json:"acceptable_diff_percentage,omitempty"`
+	IgnoreAntialiasing       *bool    `json:"ignore_antialiasing,omitempty"`
+	ColorMetric              *string  `json:"color_metric,omitempty"`
+	ChangeCriterion          *string  `json:"change_criterion,omitempty"`
+	MinSSIM                  *float64 `json:"min_ssim,omitempty"`
+	MinRegionPixels          *int     `json:"min_region_pixels,omitempty"`
+	Alignment                *string  `json:"alignment,omitempty"`
 }
 
 type UpdateProjectRequest struct {
-	Name          *string `json:"name,omitempty"`
-	RepositoryURL *string `json:"repository_url,omitempty"`
-	DefaultBranch *string `json:"default_branch,omitempty"`
+	Name                     *string  `json:"name,omitempty"`
+	RepositoryURL            *string  `json:"repository_url,omitempty"`
+	DefaultBranch            *string  `json:"default_branch,omitempty"`
+	DiffThreshold            *float64 `json:"diff_threshold,omitempty"`
+	AcceptableDiffPercentage *float64 `json:"acceptable_diff_percentage,omitempty"`
+	IgnoreAntialiasing       *bool    `json:"ignore_antialiasing,omitempty"`
+	ColorMetric              *string  `json:"color_metric,omitempty"`
+	ChangeCriterion          *string  `json:"change_criterion,omitempty"`
+	MinSSIM                  *float64 `json:"min_ssim,omitempty"`
+	MinRegionPixels          *int     `json:"min_region_pixels,omitempty"`
+	Alignment                *string  `json:"alignment,omitempty"`
 }
 
+type DiffRuleRequest struct {
+	Pattern                  string   `json:"pattern"`
+	Priority                 int      `json:"priority"`
+	DiffThreshold            *float64 `json:"diff_threshold,omitempty"`
+	AcceptableDiffPercentage *float64 `json:"acceptable_diff_percentage,omitempty"`
+	IgnoreAntialiasing       *bool    `json:"ignore_antialiasing,omitempty"`
+}
+
+// RetentionPolicyRequest replaces a project's retention policy. Leaving a
+// limit out removes it, KeepBaselineSources defaults to true.
+type RetentionPolicyRequest struct {
+	KeepBuildsPerBranch *int  `json:"keep_builds_per_branch,omitempty"`
+	MaxAgeDays          *int  `json:"max_age_days,omitempty"`
+	KeepBaselineSources *bool `json:"keep_baseline_sources,omitempty"`
+}
+
+type BranchRuleRequest struct {
+	Pattern      string `json:"pattern"`
+	ParentBranch string `json:"parent_branch"`
+	Priority     int    `json:"priority"`
+}
+
+type MergeHookRequest struct {
+	DeleteSource *bool `json:"delete_source,omitempty"`
+}
+
+// PromoteRequest copies a branch's baselines onto another branch, the
+// project's default branch when TargetBranch is empty
+type PromoteRequest struct {
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	// DeleteSource removes the source branch's baselines once copied
+	DeleteSource bool `json:"delete_source"`
+}
+
+// PromoteResult reports what a promotion did
+type PromoteResult struct {
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	// Promoted is how many baselines were copied onto the target branch
+	Promoted int `json:"promoted"`
+	// Deleted is how many baselines were removed from the source branch
+	Deleted int `json:"deleted"`
+}
+
+// CreateBuildRequest creates a build. Snapshots are diffed against the
+// same-named snapshot in the base build when one is given, either directly or
+// as the build of the base_commit_sha (a merge base), and against the branch
+// baselines otherwise.
 type CreateBuildRequest struct {
-	ProjectID         uuid.UUID `json:"project_id"`
-	Branch            string    `json:"branch"`
-	CommitSHA         *string   `json:"commit_sha,omitempty"`
-	CommitMessage     *string   `json:"commit_message,omitempty"`
-	PullRequestNumber *int      `json:"pull_request_number,omitempty"`
+	ProjectID         uuid.UUID  `json:"project_id"`
+	Branch            string     `json:"branch"`
+	CommitSHA         *string    `json:"commit_sha,omitempty"`
+	CommitMessage     *string    `json:"commit_message,omitempty"`
+	PullRequestNumber *int       `json:"pull_request_number,omitempty"`
+	BaseBuildID       *uuid.UUID `json:"base_build_id,omitempty"`
+	BaseCommitSHA     *string    `json:"base_commit_sha,omitempty"`
+	// IdempotencyKey makes a retried request return the build the first
+	// attempt created, taken from the Idempotency-Key header
+	IdempotencyKey *string `json:"-"`
 }
 
 type CreateSnapshotRequest struct {
@@ -132,25 +668,96 @@ type CreateSnapshotRequest struct {
 	Height   *int      `json:"height,omitempty"`
 	Browser  *string   `json:"browser,omitempty"`
 	Viewport *string   `json:"viewport,omitempty"`
+	// IdempotencyKey makes retried uploads return the snapshot the first
+	// attempt created, taken from the Idempotency-Key header
+	IdempotencyKey *string `json:"-"`
 }
 
+// ArchiveEvent is the kind of line an archive upload streams back
+type ArchiveEvent string
+
+const (
+	// ArchiveEventFile reports what happened to one file of the archive
+	ArchiveEventFile ArchiveEvent = "file"
+	// ArchiveEventComplete ends an upload whose snapshots were created
+	ArchiveEventComplete ArchiveEvent = "complete"
+	// ArchiveEventError ends an upload that created no snapshots
+	ArchiveEventError ArchiveEvent = "error"
+)
+
+// ArchiveFileStatus is what happened to a file of an uploaded archive
+type ArchiveFileStatus string
+
+const (
+	ArchiveFileStored  ArchiveFileStatus = "stored"
+	ArchiveFileSkipped ArchiveFileStatus = "skipped"
+	ArchiveFileFailed  ArchiveFileStatus = "failed"
+)
+
+// ArchiveProgress is one NDJSON line of an archive upload's response
+type ArchiveProgress struct {
+	Event     ArchiveEvent      `json:"event"`
+	Path      string            `json:"path,omitempty"`
+	Status    ArchiveFileStatus `json:"status,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Browser   *string           `json:"browser,omitempty"`
+	Viewport  *string           `json:"viewport,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Created   int               `json:"created,omitempty"`
+	Skipped   int               `json:"skipped,omitempty"`
+	Snapshots []Snapshot        `json:"snapshots,omitempty"`
+}
+
+// ReviewSnapshotRequest sets a review status. The reviewer is taken from the
+// session, never from the request body.
 type ReviewSnapshotRequest struct {
-	ReviewStatus ReviewStatus `json:"review_status"`
-	ReviewedBy   string       `json:"reviewed_by"`
+	ReviewStatus     ReviewStatus `json:"review_status"`
+	ReviewedBy       string       `json:"-"`
+	ReviewedByUserID uuid.UUID    `json:"-"`
 }
 
 type BatchReviewRequest struct {
-	SnapshotIDs  []uuid.UUID  `json:"snapshot_ids"`
-	ReviewStatus ReviewStatus `json:"review_status"`
-	ReviewedBy   string       `json:"reviewed_by"`
+	SnapshotIDs      []uuid.UUID  `json:"snapshot_ids"`
+	ReviewStatus     ReviewStatus `json:"review_status"`
+	ReviewedBy       string       `json:"-"`
+	ReviewedByUserID uuid.UUID    `json:"-"`
+}
+
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type ProjectMemberRequest struct {
+	Email string `json:"email"`
+	Role  Role   `json:"role"`
+}
+
+type IgnoreRegionRequest struct {
+	BaselineID *uuid.UUID `json:"baseline_id,omitempty"`
+	Pattern    *string    `json:"pattern,omitempty"`
+	X          int        `json:"x"`
+	Y          int        `json:"y"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	Selector   *string    `json:"selector,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name string `json:"name"`
 }
 
-// BuildWithStats includes build with aggregated stats
-type BuildWithStats struct {
-	Build
-	NewSnapshots      int `json:"new_snapshots"`
-	RemovedSnapshots  int `json:"removed_snapshots"`
-	UnchangedSnapshots int `json:"unchanged_snapshots"`
+// CreatedAPIToken is returned once when a token is created, with the token in plain text
+type CreatedAPIToken struct {
+	APIToken
+	Token string `json:"token"`
 }
 
 // Pagination helpers
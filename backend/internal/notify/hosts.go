@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Default API endpoints, self-hosted instances configure their own
+const (
+	DefaultGitHubAPIURL = "https://api.github.com"
+	DefaultGitLabAPIURL = "https://gitlab.com/api/v4"
+)
+
+// Host posts commit statuses to a git host's API
+type Host interface {
+	SetStatus(ctx context.Context, sha string, status Status) error
+}
+
+// GitHub posts statuses with the GitHub commit status API. Repository is
+// "owner/name" and Token a token allowed to write statuses.
+type GitHub struct {
+	APIURL     string
+	Repository string
+	Token      string
+	Client     *http.Client
+}
+
+func (g *GitHub) SetStatus(ctx context.Context, sha string, status Status) error {
+	body := map[string]string{
+		"state":       string(status.State),
+		"target_url":  status.TargetURL,
+		"description": truncate(status.Description, 140),
+		"context":     status.Context,
+	}
+
+	endpoint := fmt.Sprintf("%s/repos/%s/statuses/%s", strings.TrimRight(g.APIURL, "/"), g.Repository, url.PathEscape(sha))
+	return send(ctx, g.Client, http.MethodPost, endpoint, body, map[string]string{
+		"Authorization":        "Bearer " + g.Token,
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}, nil)
+}
+
+// GitLab posts statuses with the GitLab commit status API. Repository is the
+// project's path ("group/name") or numeric ID.
+type GitLab struct {
+	APIURL     string
+	Repository string
+	Token      string
+	Client     *http.Client
+}
+
+/*
+SetStatus posts a status unless the commit already has one in the same state.
+GitLab reuses a commit's running or pending status for the same name and
+refuses to move it back to pending or to running again, so unfinished builds
+are always reported as running and a repeated state isn't posted.
+*/
+func (g *GitLab) SetStatus(ctx context.Context, sha string, status Status) error {
+	state := "running"
+	switch status.State {
+	case StateSuccess:
+		state = "success"
+	case StateFailure:
+		state = "failed"
+	}
+
+	project := fmt.Sprintf("%s/projects/%s", strings.TrimRight(g.APIURL, "/"), url.PathEscape(g.Repository))
+	headers := map[string]string{
+		"PRIVATE-TOKEN": g.Token,
+	}
+
+	current, err := g.currentState(ctx, project, sha, status.Context, headers)
+	if err != nil {
+		return err
+	}
+	if current == state {
+		return nil
+	}
+
+	body := map[string]string{
+		"state":       state,
+		"target_url":  status.TargetURL,
+		"description": truncate(status.Description, 255),
+		"name":        status.Context,
+	}
+
+	endpoint := fmt.Sprintf("%s/statuses/%s", project, url.PathEscape(sha))
+	return send(ctx, g.Client, http.MethodPost, endpoint, body, headers, nil)
+}
+
+// currentState returns the state of the commit's latest status named name,
+// empty if it has none
+func (g *GitLab) currentState(ctx context.Context, project, sha, name string, headers map[string]string) (string, error) {
+	var statuses []struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	endpoint := fmt.Sprintf("%s/repository/commits/%s/statuses?name=%s", project, url.PathEscape(sha), url.QueryEscape(name))
+	if err := send(ctx, g.Client, http.MethodGet, endpoint, nil, headers, &statuses); err != nil {
+		return "", err
+	}
+
+	var latestID int64
+	state := ""
+	for _, status := range statuses {
+		if status.Name == name && status.ID > latestID {
+			latestID, state = status.ID, status.Status
+		}
+	}
+	return state, nil
+}
+
+// HostError is a non-2xx response from a git host
+type HostError struct {
+	Status int
+	Body   string
+}
+
+func (e *HostError) Error() string {
+	return fmt.Sprintf("git host responded %d: %s", e.Status, e.Body)
+}
+
+// send makes a JSON request to a git host, decoding the response into out
+// when it isn't nil
+func send(ctx context.Context, client *http.Client, method, endpoint string, body any, headers map[string]string, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return &HostError{Status: resp.StatusCode, Body: strings.TrimSpace(string(message))}
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,254 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedRequest is what a fake git host was sent
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   map[string]string
+}
+
+// fakeHost records requests and answers them with respond
+type fakeHost struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+func newFakeHost(t *testing.T, respond func(w http.ResponseWriter, r *http.Request)) *fakeHost {
+	f := &fakeHost{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := recordedRequest{Method: r.Method, Path: r.URL.EscapedPath(), Query: r.URL.RawQuery, Header: r.Header}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &request.Body); err != nil {
+				t.Errorf("%s %s: body isn't a JSON object: %v", r.Method, r.URL, err)
+			}
+		}
+
+		f.mu.Lock()
+		f.requests = append(f.requests, request)
+		f.mu.Unlock()
+
+		respond(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeHost) recorded() []recordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]recordedRequest(nil), f.requests...)
+}
+
+func created(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{}`))
+}
+
+func TestGitHubSetStatus(t *testing.T) {
+	server := newFakeHost(t, created)
+	github := &GitHub{APIURL: server.URL + "/", Repository: "acme/web", Token: "secret", Client: server.Client()}
+
+	err := github.SetStatus(context.Background(), "abc123", Status{
+		State:       StatePending,
+		Description: strings.Repeat("x", 200),
+		TargetURL:   "https://diffit.example/builds/1",
+		Context:     "diffit/visual",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.recorded()
+	if len(requests) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+
+	if request.Method != http.MethodPost || request.Path != "/repos/acme/web/statuses/abc123" {
+		t.Errorf("sent %s %s, want POST /repos/acme/web/statuses/abc123", request.Method, request.Path)
+	}
+	for name, want := range map[string]string{
+		"Authorization":        "Bearer secret",
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+		"Content-Type":         "application/json",
+	} {
+		if got := request.Header.Get(name); got != want {
+			t.Errorf("%s header = %q, want %q", name, got, want)
+		}
+	}
+
+	want := map[string]string{
+		"state":       "pending",
+		"target_url":  "https://diffit.example/builds/1",
+		"description": strings.Repeat("x", 139) + "…",
+		"context":     "diffit/visual",
+	}
+	assertBody(t, request.Body, want)
+}
+
+func TestGitLabSetStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is the commit's statuses as GitLab lists them
+		existing string
+		state    State
+		// posted is the state sent, empty if nothing should be
+		posted string
+	}{
+		{name: "pending is reported as running", existing: `[]`, state: StatePending, posted: "running"},
+		{name: "success", existing: `[]`, state: StateSuccess, posted: "success"},
+		{name: "failure", existing: `[]`, state: StateFailure, posted: "failed"},
+		{
+			name:     "still running",
+			existing: `[{"id": 7, "name": "diffit", "status": "running"}]`,
+			state:    StatePending,
+		},
+		{
+			name:     "repeated success",
+			existing: `[{"id": 7, "name": "diffit", "status": "success"}]`,
+			state:    StateSuccess,
+		},
+		{
+			name:     "latest status decides",
+			existing: `[{"id": 9, "name": "diffit", "status": "success"}, {"id": 7, "name": "diffit", "status": "running"}]`,
+			state:    StatePending,
+			posted:   "running",
+		},
+		{
+			name:     "other contexts are ignored",
+			existing: `[{"id": 7, "name": "other", "status": "running"}]`,
+			state:    StatePending,
+			posted:   "running",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeHost(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					w.Write([]byte(tt.existing))
+					return
+				}
+				created(w, r)
+			})
+			gitlab := &GitLab{APIURL: server.URL + "/api/v4", Repository: "group/web", Token: "secret", Client: server.Client()}
+
+			err := gitlab.SetStatus(context.Background(), "abc123", Status{
+				State:       tt.state,
+				Description: "Comparing screenshots",
+				TargetURL:   "https://diffit.example/builds/1",
+				Context:     "diffit",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			requests := server.recorded()
+			for _, request := range requests {
+				if got := request.Header.Get("PRIVATE-TOKEN"); got != "secret" {
+					t.Errorf("%s %s: PRIVATE-TOKEN = %q, want %q", request.Method, request.Path, got, "secret")
+				}
+			}
+
+			lookup := requests[0]
+			if lookup.Method != http.MethodGet || lookup.Path != "/api/v4/projects/group%2Fweb/repository/commits/abc123/statuses" || lookup.Query != "name=diffit" {
+				t.Errorf("looked up %s %s?%s, want the commit's statuses named diffit", lookup.Method, lookup.Path, lookup.Query)
+			}
+
+			if tt.posted == "" {
+				if len(requests) != 1 {
+					t.Errorf("sent %d requests, want only the lookup", len(requests))
+				}
+				return
+			}
+			if len(requests) != 2 {
+				t.Fatalf("sent %d requests, want a lookup and a post", len(requests))
+			}
+
+			post := requests[1]
+			if post.Method != http.MethodPost || post.Path != "/api/v4/projects/group%2Fweb/statuses/abc123" {
+				t.Errorf("sent %s %s, want POST /api/v4/projects/group%%2Fweb/statuses/abc123", post.Method, post.Path)
+			}
+			assertBody(t, post.Body, map[string]string{
+				"state":       tt.posted,
+				"target_url":  "https://diffit.example/builds/1",
+				"description": "Comparing screenshots",
+				"name":        "diffit",
+			})
+		})
+	}
+}
+
+func TestSetStatusRetries(t *testing.T) {
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = time.Millisecond
+
+	tests := []struct {
+		name      string
+		responses []int
+		attempts  int
+		// status is the HostError expected, 0 for success
+		status int
+	}{
+		{name: "succeeds first time", responses: []int{http.StatusCreated}, attempts: 1},
+		{name: "retries server errors and rate limits", responses: []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusCreated}, attempts: 3},
+		{name: "gives up after max attempts", responses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusCreated}, attempts: maxAttempts, status: http.StatusInternalServerError},
+		{name: "doesn't retry client errors", responses: []int{http.StatusUnprocessableEntity, http.StatusCreated}, attempts: 1, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := 0
+			server := newFakeHost(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.responses[attempt])
+				attempt++
+			})
+			github := &GitHub{APIURL: server.URL, Repository: "acme/web", Token: "secret", Client: server.Client()}
+
+			err := setStatus(context.Background(), github, "abc123", Status{State: StateSuccess, Context: "diffit"})
+
+			if got := len(server.recorded()); got != tt.attempts {
+				t.Errorf("made %d attempts, want %d", got, tt.attempts)
+			}
+			var hostErr *HostError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Errorf("setStatus = %v, want success", err)
+			case tt.status != 0 && (!errors.As(err, &hostErr) || hostErr.Status != tt.status):
+				t.Errorf("setStatus = %v, want a %d from the host", err, tt.status)
+			}
+		})
+	}
+}
+
+func assertBody(t *testing.T, got, want map[string]string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("body = %v, want %v", got, want)
+		return
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("body %s = %q, want %q", key, got[key], value)
+		}
+	}
+}
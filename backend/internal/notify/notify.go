@@ -0,0 +1,229 @@
+/*
+Package notify reports builds back to the git host as commit statuses, so a
+pull request shows whether its screenshots changed and whether the changes
+have been reviewed. Statuses are posted when a build is created, when it
+finishes processing and whenever its review state changes.
+*/
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// State is a commit status state
+type State string
+
+const (
+	StatePending State = "pending"
+	StateSuccess State = "success"
+	StateFailure State = "failure"
+)
+
+// Status is a commit status as posted to a git host
+type Status struct {
+	State       State
+	Description string
+	TargetURL   string
+	// Context names the check, statuses with the same context replace each other
+	Context string
+}
+
+// maxAttempts is how many times a status is posted before giving up
+const maxAttempts = 3
+
+// retryDelay is how long to wait before the first retry, doubling after each
+var retryDelay = time.Second
+
+type Notifier struct {
+	builds    *repository.BuildRepository
+	configs   *repository.CommitStatusConfigRepository
+	publicURL string
+	client    *http.Client
+
+	mu sync.Mutex
+	// inFlight holds the builds being reported, true once a build changed
+	// again and needs reporting once more
+	inFlight map[uuid.UUID]bool
+	wg       sync.WaitGroup
+	// report posts a build's state, Notify outside of tests
+	report func(ctx context.Context, buildID uuid.UUID) error
+}
+
+// New creates a Notifier linking statuses to builds in the frontend at publicURL
+func New(pool *pgxpool.Pool, publicURL string) *Notifier {
+	n := &Notifier{
+		builds:    repository.NewBuildRepository(pool),
+		configs:   repository.NewCommitStatusConfigRepository(pool),
+		publicURL: strings.TrimRight(publicURL, "/"),
+		client:    &http.Client{Timeout: 15 * time.Second},
+		inFlight:  map[uuid.UUID]bool{},
+	}
+	n.report = n.Notify
+	return n
+}
+
+/*
+BuildChanged reports a build's current state in the background so API
+requests don't wait on the git host. A build's statuses are posted one at a
+time: changes made while one is being posted are reported together once it's
+done, so an older state can never land after a newer one.
+*/
+func (n *Notifier) BuildChanged(buildID uuid.UUID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.inFlight[buildID]; ok {
+		n.inFlight[buildID] = true
+		return
+	}
+	n.inFlight[buildID] = false
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		for {
+			n.notifyInBackground(buildID)
+
+			n.mu.Lock()
+			again := n.inFlight[buildID]
+			if !again {
+				delete(n.inFlight, buildID)
+			} else {
+				n.inFlight[buildID] = false
+			}
+			n.mu.Unlock()
+
+			if !again {
+				return
+			}
+		}
+	}()
+}
+
+func (n *Notifier) notifyInBackground(buildID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := n.report(ctx, buildID); err != nil {
+		log.Printf("commit status: build %s: %v", buildID, err)
+	}
+}
+
+// Wait blocks until background notifications have finished
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+/*
+Notify posts a build's current state to its git host. Builds without a commit
+SHA and projects without an enabled commit status config are skipped. The
+state is read when the status is sent, so a late notification never reports
+anything older than the build's latest state.
+*/
+func (n *Notifier) Notify(ctx context.Context, buildID uuid.UUID) error {
+	build, err := n.builds.GetByID(ctx, buildID)
+	if err != nil {
+		return err
+	}
+	if build.CommitSHA == nil || *build.CommitSHA == "" {
+		return nil
+	}
+
+	config, err := n.configs.Get(ctx, build.ProjectID)
+	if err != nil || config == nil || !config.Enabled {
+		return err
+	}
+
+	host := HostFor(config, n.client)
+	status := StatusFor(build, fmt.Sprintf("%s/builds/%s", n.publicURL, build.ID))
+	status.Context = config.Context
+
+	return setStatus(ctx, host, *build.CommitSHA, status)
+}
+
+// setStatus posts a status, retrying errors that might be temporary with
+// backoff starting at retryDelay
+func setStatus(ctx context.Context, host Host, sha string, status Status) error {
+	for attempt := 1; ; attempt++ {
+		err := host.SetStatus(ctx, sha, status)
+		if err == nil || attempt == maxAttempts || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelay << (attempt - 1)):
+		}
+	}
+}
+
+// retryable reports whether posting a status might work if tried again
+func retryable(err error) bool {
+	var hostErr *HostError
+	if errors.As(err, &hostErr) {
+		return hostErr.Status >= 500 || hostErr.Status == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// HostFor returns the API client for a project's commit status config
+func HostFor(config *models.CommitStatusConfig, client *http.Client) Host {
+	switch config.Host {
+	case models.GitHostGitLab:
+		apiURL := DefaultGitLabAPIURL
+		if config.APIURL != nil {
+			apiURL = *config.APIURL
+		}
+		return &GitLab{APIURL: apiURL, Repository: config.Repository, Token: config.Token, Client: client}
+	default:
+		apiURL := DefaultGitHubAPIURL
+		if config.APIURL != nil {
+			apiURL = *config.APIURL
+		}
+		return &GitHub{APIURL: apiURL, Repository: config.Repository, Token: config.Token, Client: client}
+	}
+}
+
+// StatusFor describes a build as a commit status linking to targetURL
+func StatusFor(build *models.Build, targetURL string) Status {
+	status := Status{TargetURL: targetURL}
+	changes := build.ChangedSnapshots + build.NewSnapshots + build.RemovedSnapshots
+
+	switch {
+	case build.Status == models.BuildStatusFailed:
+		status.State, status.Description = StateFailure, "Build failed"
+	case build.Status == models.BuildStatusPending:
+		status.State, status.Description = StatePending, "Waiting for screenshots"
+	case build.Status != models.BuildStatusCompleted:
+		status.State, status.Description = StatePending, "Comparing screenshots"
+	case build.FailedSnapshots > 0:
+		status.State = StateFailure
+		status.Description = fmt.Sprintf("%d screenshots couldn't be compared", build.FailedSnapshots)
+	case build.ReviewState == models.BuildReviewStateRejected:
+		status.State, status.Description = StateFailure, "Visual changes were rejected"
+	case build.ReviewState == models.BuildReviewStateNeedsReview:
+		status.State = StatePending
+		status.Description = fmt.Sprintf("%d changed, %d new, %d removed, waiting for review",
+			build.ChangedSnapshots, build.NewSnapshots, build.RemovedSnapshots)
+	case changes == 0:
+		status.State, status.Description = StateSuccess, "No visual changes"
+	default:
+		status.State, status.Description = StateSuccess, "Visual changes approved"
+	}
+
+	return status
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestStatusFor(t *testing.T) {
+	completed := func(review models.BuildReviewState, changed, failed int) *models.Build {
+		return &models.Build{
+			Status:           models.BuildStatusCompleted,
+			ReviewState:      review,
+			ChangedSnapshots: changed,
+			FailedSnapshots:  failed,
+		}
+	}
+
+	tests := []struct {
+		name  string
+		build *models.Build
+		state State
+	}{
+		{name: "failed build", build: &models.Build{Status: models.BuildStatusFailed}, state: StateFailure},
+		{name: "uploading", build: &models.Build{Status: models.BuildStatusPending}, state: StatePending},
+		{name: "comparing", build: &models.Build{Status: models.BuildStatusProcessing}, state: StatePending},
+		{name: "no changes", build: completed(models.BuildReviewStateApproved, 0, 0), state: StateSuccess},
+		{name: "approved changes", build: completed(models.BuildReviewStateApproved, 2, 0), state: StateSuccess},
+		{name: "needs review", build: completed(models.BuildReviewStateNeedsReview, 2, 0), state: StatePending},
+		{name: "rejected", build: completed(models.BuildReviewStateRejected, 2, 0), state: StateFailure},
+		{name: "every diff failed", build: completed(models.BuildReviewStateNeedsReview, 0, 3), state: StateFailure},
+		// Failed snapshots outweigh the review state
+		{name: "approved with failed diffs", build: completed(models.BuildReviewStateApproved, 0, 1), state: StateFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusFor(tt.build, ""); got.State != tt.state {
+				t.Errorf("StatusFor = %s (%q), want %s", got.State, got.Description, tt.state)
+			}
+		})
+	}
+}
+
+func TestBuildChangedSerialisesPerBuild(t *testing.T) {
+	var mu sync.Mutex
+	running := map[uuid.UUID]int{}
+	reported := map[uuid.UUID]int{}
+	release := make(chan struct{})
+
+	n := &Notifier{inFlight: map[uuid.UUID]bool{}}
+	n.report = func(ctx context.Context, buildID uuid.UUID) error {
+		mu.Lock()
+		running[buildID]++
+		if running[buildID] > 1 {
+			t.Errorf("build %s reported concurrently", buildID)
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running[buildID]--
+		reported[buildID]++
+		mu.Unlock()
+		return nil
+	}
+
+	first, second := uuid.New(), uuid.New()
+	for range 5 {
+		n.BuildChanged(first)
+	}
+	n.BuildChanged(second)
+
+	// Both builds report at once, the first one's later changes wait
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running[first] == 1 && running[second] == 1
+	})
+	close(release)
+	n.Wait()
+
+	// The four changes made while the first report was being sent are
+	// reported together, once
+	if reported[first] != 2 {
+		t.Errorf("first build reported %d times, want 2", reported[first])
+	}
+	if reported[second] != 1 {
+		t.Errorf("second build reported %d times, want 1", reported[second])
+	}
+	if len(n.inFlight) != 0 {
+		t.Errorf("%d builds still in flight after Wait", len(n.inFlight))
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const apiTokenColumns = `id, project_id, name, token_prefix, last_used_at, revoked_at, created_at`
+
+func scanAPIToken(row pgx.Row, token *models.APIToken) error {
+	return row.Scan(
+		&token.ID,
+		&token.ProjectID,
+		&token.Name,
+		&token.Prefix,
+		&token.LastUsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+}
+
+type APITokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPITokenRepository(pool *pgxpool.Pool) *APITokenRepository {
+	return &APITokenRepository{pool: pool}
+}
+
+func (r *APITokenRepository) Create(ctx context.Context, projectID uuid.UUID, name, tokenHash, prefix string) (*models.APIToken, error) {
+	var token models.APIToken
+	err := scanAPIToken(r.pool.QueryRow(ctx, `
+		INSERT INTO api_tokens (project_id, name, token_hash, token_prefix)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+apiTokenColumns,
+		projectID, name, tokenHash, prefix), &token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *APITokenRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.APIToken, error) {
+	var token models.APIToken
+	err := scanAPIToken(r.pool.QueryRow(ctx, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = $1`, id), &token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *APITokenRepository) ListByProject(ctx context.Context, projectID uuid.UUID) ([]models.APIToken, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens
+		WHERE project_id = $1
+		ORDER BY created_at DESC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var token models.APIToken
+		if err := scanAPIToken(rows, &token); err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// Authenticate finds the unrevoked token with the given hash and records
+// that it was used. Returns nil if there is no such token.
+func (r *APITokenRepository) Authenticate(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+	err := scanAPIToken(r.pool.QueryRow(ctx, `
+		UPDATE api_tokens SET last_used_at = NOW()
+		WHERE token_hash = $1 AND revoked_at IS NULL
+		RETURNING `+apiTokenColumns,
+		tokenHash), &token)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate api token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *APITokenRepository) Revoke(ctx context.Context, id uuid.UUID) (*models.APIToken, error) {
+	var token models.APIToken
+	err := scanAPIToken(r.pool.QueryRow(ctx, `
+		UPDATE api_tokens SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1
+		RETURNING `+apiTokenColumns,
+		id), &token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke api token: %w", err)
+	}
+
+	return &token, nil
+}
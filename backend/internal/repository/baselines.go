@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/crzytrane/diffit/internal/models"
@@ -10,6 +11,43 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// baselineColumns is the column list scanned by scanBaseline
+const baselineColumns = `id, project_id, name, branch, image_path, image_hash, width, height, browser, viewport,
+	source_snapshot_id, created_at, updated_at`
+
+func scanBaseline(row pgx.Row, baseline *models.Baseline) error {
+	return row.Scan(
+		&baseline.ID,
+		&baseline.ProjectID,
+		&baseline.Name,
+		&baseline.Branch,
+		&baseline.ImagePath,
+		&baseline.ImageHash,
+		&baseline.Width,
+		&baseline.Height,
+		&baseline.Browser,
+		&baseline.Viewport,
+		&baseline.SourceSnapshotID,
+		&baseline.CreatedAt,
+		&baseline.UpdatedAt,
+	)
+}
+
+func scanBaselines(rows pgx.Rows) ([]models.Baseline, error) {
+	defer rows.Close()
+
+	var baselines []models.Baseline
+	for rows.Next() {
+		var baseline models.Baseline
+		if err := scanBaseline(rows, &baseline); err != nil {
+			return nil, fmt.Errorf("failed to scan baseline: %w", err)
+		}
+		baselines = append(baselines, baseline)
+	}
+
+	return baselines, nil
+}
+
 type BaselineRepository struct {
 	pool *pgxpool.Pool
 }
@@ -23,154 +61,210 @@ type CreateBaselineParams struct {
 	Name             string
 	Branch           string
 	ImagePath        string
+	ImageHash        *string
 	Width            *int
 	Height           *int
 	Browser          *string
 	Viewport         *string
 	SourceSnapshotID *uuid.UUID
+	// Approver is recorded on the baseline version the change creates
+	Approver Approver
 }
 
+// Create adds a baseline and records its image as the first version
 func (r *BaselineRepository) Create(ctx context.Context, params CreateBaselineParams) (*models.Baseline, error) {
 	var baseline models.Baseline
-	err := r.pool.QueryRow(ctx, `
-		INSERT INTO baselines (project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
-	`, params.ProjectID, params.Name, params.Branch, params.ImagePath, params.Width, params.Height,
-		params.Browser, params.Viewport, params.SourceSnapshotID).Scan(
-		&baseline.ID,
-		&baseline.ProjectID,
-		&baseline.Name,
-		&baseline.Branch,
-		&baseline.ImagePath,
-		&baseline.Width,
-		&baseline.Height,
-		&baseline.Browser,
-		&baseline.Viewport,
-		&baseline.SourceSnapshotID,
-		&baseline.CreatedAt,
-		&baseline.UpdatedAt,
-	)
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		err := scanBaseline(tx.QueryRow(ctx, `
+			INSERT INTO baselines (project_id, name, branch, image_path, image_hash, width, height, browser, viewport, source_snapshot_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING `+baselineColumns,
+			params.ProjectID, params.Name, params.Branch, params.ImagePath, params.ImageHash, params.Width, params.Height,
+			params.Browser, params.Viewport, params.SourceSnapshotID), &baseline)
+		if err != nil {
+			return fmt.Errorf("failed to create baseline: %w", err)
+		}
+		return insertBaselineVersion(ctx, tx, &baseline, params.Approver, nil, false)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create baseline: %w", err)
+		return nil, err
 	}
 
 	return &baseline, nil
 }
 
-func (r *BaselineRepository) Upsert(ctx context.Context, params CreateBaselineParams) (*models.Baseline, error) {
+// upsertBaseline creates or replaces the baseline with the same key in tx
+func upsertBaseline(ctx context.Context, tx pgx.Tx, params CreateBaselineParams) (*models.Baseline, error) {
 	var baseline models.Baseline
-	err := r.pool.QueryRow(ctx, `
-		INSERT INTO baselines (project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	err := scanBaseline(tx.QueryRow(ctx, `
+		INSERT INTO baselines (project_id, name, branch, image_path, image_hash, width, height, browser, viewport, source_snapshot_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		ON CONFLICT (project_id, name, branch, browser, viewport)
 		DO UPDATE SET
 			image_path = EXCLUDED.image_path,
+			image_hash = EXCLUDED.image_hash,
 			width = EXCLUDED.width,
 			height = EXCLUDED.height,
 			source_snapshot_id = EXCLUDED.source_snapshot_id,
 			updated_at = NOW()
-		RETURNING id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
-	`, params.ProjectID, params.Name, params.Branch, params.ImagePath, params.Width, params.Height,
-		params.Browser, params.Viewport, params.SourceSnapshotID).Scan(
-		&baseline.ID,
-		&baseline.ProjectID,
-		&baseline.Name,
-		&baseline.Branch,
-		&baseline.ImagePath,
-		&baseline.Width,
-		&baseline.Height,
-		&baseline.Browser,
-		&baseline.Viewport,
-		&baseline.SourceSnapshotID,
-		&baseline.CreatedAt,
-		&baseline.UpdatedAt,
-	)
+		RETURNING `+baselineColumns,
+		params.ProjectID, params.Name, params.Branch, params.ImagePath, params.ImageHash, params.Width, params.Height,
+		params.Browser, params.Viewport, params.SourceSnapshotID), &baseline)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upsert baseline: %w", err)
 	}
 
+	if err := insertBaselineVersion(ctx, tx, &baseline, params.Approver, nil, true); err != nil {
+		return nil, err
+	}
 	return &baseline, nil
 }
 
-func (r *BaselineRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Baseline, error) {
+// Upsert creates or replaces the baseline with the same key. A new image is
+// recorded as the baseline's next version, the previous ones are kept.
+func (r *BaselineRepository) Upsert(ctx context.Context, params CreateBaselineParams) (*models.Baseline, error) {
+	var baseline *models.Baseline
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var err error
+		baseline, err = upsertBaseline(ctx, tx, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return baseline, nil
+}
+
+/*
+Promote copies every baseline on the source branch onto the target branch,
+replacing the target's baselines with the same name, browser and viewport.
+Images that change are recorded as new versions of the target baselines.
+With deleteSource the source branch's baselines are removed afterwards.
+Everything happens in one transaction, so a failed promotion changes nothing.
+*/
+func (r *BaselineRepository) Promote(ctx context.Context, projectID uuid.UUID, source, target string, approver Approver, deleteSource bool) (promoted, deleted int, err error) {
+	err = pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT `+baselineColumns+`
+			FROM baselines
+			WHERE project_id = $1 AND branch = $2
+			ORDER BY name ASC
+			FOR UPDATE
+		`, projectID, source)
+		if err != nil {
+			return fmt.Errorf("failed to list baselines to promote: %w", err)
+		}
+		baselines, err := scanBaselines(rows)
+		if err != nil {
+			return err
+		}
+
+		for _, baseline := range baselines {
+			_, err := upsertBaseline(ctx, tx, CreateBaselineParams{
+				ProjectID:        projectID,
+				Name:             baseline.Name,
+				Branch:           target,
+				ImagePath:        baseline.ImagePath,
+				ImageHash:        baseline.ImageHash,
+				Width:            baseline.Width,
+				Height:           baseline.Height,
+				Browser:          baseline.Browser,
+				Viewport:         baseline.Viewport,
+				SourceSnapshotID: baseline.SourceSnapshotID,
+				Approver:         approver,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		promoted = len(baselines)
+
+		if deleteSource {
+			tag, err := tx.Exec(ctx, `DELETE FROM baselines WHERE project_id = $1 AND branch = $2`, projectID, source)
+			if err != nil {
+				return fmt.Errorf("failed to delete promoted baselines: %w", err)
+			}
+			deleted = int(tag.RowsAffected())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return promoted, deleted, nil
+}
+
+// ErrVersionNotInBaseline is returned by Restore for a version of another baseline
+var ErrVersionNotInBaseline = errors.New("version does not belong to baseline")
+
+/*
+Restore makes a previous version's image the baseline's current one again.
+The rollback is recorded as a new version pointing at the restored one, so
+the history still shows what was replaced. Returns ErrVersionNotInBaseline
+if the version belongs to another baseline.
+*/
+func (r *BaselineRepository) Restore(ctx context.Context, baselineID, versionID uuid.UUID, approver Approver) (*models.Baseline, error) {
 	var baseline models.Baseline
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
-		FROM baselines WHERE id = $1
-	`, id).Scan(
-		&baseline.ID,
-		&baseline.ProjectID,
-		&baseline.Name,
-		&baseline.Branch,
-		&baseline.ImagePath,
-		&baseline.Width,
-		&baseline.Height,
-		&baseline.Browser,
-		&baseline.Viewport,
-		&baseline.SourceSnapshotID,
-		&baseline.CreatedAt,
-		&baseline.UpdatedAt,
-	)
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var version models.BaselineVersion
+		err := scanBaselineVersion(tx.QueryRow(ctx, `
+			SELECT `+baselineVersionColumns+` FROM baseline_versions WHERE id = $1
+		`, versionID), &version)
+		if err != nil {
+			return fmt.Errorf("failed to get baseline version: %w", err)
+		}
+		if version.BaselineID != baselineID {
+			return ErrVersionNotInBaseline
+		}
+
+		err = scanBaseline(tx.QueryRow(ctx, `
+			UPDATE baselines
+			SET image_path = $2, image_hash = $3, width = $4, height = $5, source_snapshot_id = $6
+			WHERE id = $1
+			RETURNING `+baselineColumns,
+			baselineID, version.ImagePath, version.ImageHash, version.Width, version.Height, version.SourceSnapshotID), &baseline)
+		if err != nil {
+			return fmt.Errorf("failed to restore baseline: %w", err)
+		}
+		return insertBaselineVersion(ctx, tx, &baseline, approver, &version.ID, false)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get baseline: %w", err)
+		return nil, err
 	}
 
 	return &baseline, nil
 }
 
-func (r *BaselineRepository) FindByKey(ctx context.Context, projectID uuid.UUID, name, branch string, browser, viewport *string) (*models.Baseline, error) {
+func (r *BaselineRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Baseline, error) {
 	var baseline models.Baseline
-
-	// Handle nullable browser and viewport in the query
-	var err error
-	if browser == nil && viewport == nil {
-		err = r.pool.QueryRow(ctx, `
-			SELECT id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
-			FROM baselines
-			WHERE project_id = $1 AND name = $2 AND branch = $3 AND browser IS NULL AND viewport IS NULL
-		`, projectID, name, branch).Scan(
-			&baseline.ID, &baseline.ProjectID, &baseline.Name, &baseline.Branch, &baseline.ImagePath,
-			&baseline.Width, &baseline.Height, &baseline.Browser, &baseline.Viewport,
-			&baseline.SourceSnapshotID, &baseline.CreatedAt, &baseline.UpdatedAt,
-		)
-	} else if browser != nil && viewport == nil {
-		err = r.pool.QueryRow(ctx, `
-			SELECT id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
-			FROM baselines
-			WHERE project_id = $1 AND name = $2 AND branch = $3 AND browser = $4 AND viewport IS NULL
-		`, projectID, name, branch, *browser).Scan(
-			&baseline.ID, &baseline.ProjectID, &baseline.Name, &baseline.Branch, &baseline.ImagePath,
-			&baseline.Width, &baseline.Height, &baseline.Browser, &baseline.Viewport,
-			&baseline.SourceSnapshotID, &baseline.CreatedAt, &baseline.UpdatedAt,
-		)
-	} else if browser == nil && viewport != nil {
-		err = r.pool.QueryRow(ctx, `
-			SELECT id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
-			FROM baselines
-			WHERE project_id = $1 AND name = $2 AND branch = $3 AND browser IS NULL AND viewport = $4
-		`, projectID, name, branch, *viewport).Scan(
-			&baseline.ID, &baseline.ProjectID, &baseline.Name, &baseline.Branch, &baseline.ImagePath,
-			&baseline.Width, &baseline.Height, &baseline.Browser, &baseline.Viewport,
-			&baseline.SourceSnapshotID, &baseline.CreatedAt, &baseline.UpdatedAt,
-		)
-	} else {
-		err = r.pool.QueryRow(ctx, `
-			SELECT id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
-			FROM baselines
-			WHERE project_id = $1 AND name = $2 AND branch = $3 AND browser = $4 AND viewport = $5
-		`, projectID, name, branch, *browser, *viewport).Scan(
-			&baseline.ID, &baseline.ProjectID, &baseline.Name, &baseline.Branch, &baseline.ImagePath,
-			&baseline.Width, &baseline.Height, &baseline.Browser, &baseline.Viewport,
-			&baseline.SourceSnapshotID, &baseline.CreatedAt, &baseline.UpdatedAt,
-		)
+	err := scanBaseline(r.pool.QueryRow(ctx, `SELECT `+baselineColumns+` FROM baselines WHERE id = $1`, id), &baseline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get baseline: %w", err)
 	}
 
-	if err == pgx.ErrNoRows {
+	return &baseline, nil
+}
+
+// FindForBranches finds the baseline for a snapshot on the first of branches
+// that has one, or nil if none of them do
+func (r *BaselineRepository) FindForBranches(ctx context.Context, projectID uuid.UUID, name string, branches []string, browser, viewport *string) (*models.Baseline, error) {
+	var baseline models.Baseline
+	err := scanBaseline(r.pool.QueryRow(ctx, `
+		SELECT `+baselineColumns+`
+		FROM baselines
+		WHERE project_id = $1 AND name = $2 AND branch = ANY($3::text[])
+		  AND browser IS NOT DISTINCT FROM $4 AND viewport IS NOT DISTINCT FROM $5
+		ORDER BY array_position($3::text[], branch::text)
+		LIMIT 1
+	`, projectID, name, branches, browser, viewport), &baseline)
+	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find baseline by key: %w", err)
+		return nil, fmt.Errorf("failed to find baseline for branches: %w", err)
 	}
 
 	return &baseline, nil
@@ -184,7 +278,7 @@ func (r *BaselineRepository) ListByProject(ctx context.Context, projectID uuid.U
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
+		SELECT `+baselineColumns+`
 		FROM baselines
 		WHERE project_id = $1
 		ORDER BY name ASC
@@ -193,28 +287,10 @@ func (r *BaselineRepository) ListByProject(ctx context.Context, projectID uuid.U
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to list baselines: %w", err)
 	}
-	defer rows.Close()
 
-	var baselines []models.Baseline
-	for rows.Next() {
-		var baseline models.Baseline
-		if err := rows.Scan(
-			&baseline.ID,
-			&baseline.ProjectID,
-			&baseline.Name,
-			&baseline.Branch,
-			&baseline.ImagePath,
-			&baseline.Width,
-			&baseline.Height,
-			&baseline.Browser,
-			&baseline.Viewport,
-			&baseline.SourceSnapshotID,
-			&baseline.CreatedAt,
-			&baseline.UpdatedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan baseline: %w", err)
-		}
-		baselines = append(baselines, baseline)
+	baselines, err := scanBaselines(rows)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return baselines, total, nil
@@ -228,7 +304,7 @@ func (r *BaselineRepository) ListByProjectAndBranch(ctx context.Context, project
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, project_id, name, branch, image_path, width, height, browser, viewport, source_snapshot_id, created_at, updated_at
+		SELECT `+baselineColumns+`
 		FROM baselines
 		WHERE project_id = $1 AND branch = $2
 		ORDER BY name ASC
@@ -237,45 +313,15 @@ func (r *BaselineRepository) ListByProjectAndBranch(ctx context.Context, project
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to list baselines by branch: %w", err)
 	}
-	defer rows.Close()
 
-	var baselines []models.Baseline
-	for rows.Next() {
-		var baseline models.Baseline
-		if err := rows.Scan(
-			&baseline.ID,
-			&baseline.ProjectID,
-			&baseline.Name,
-			&baseline.Branch,
-			&baseline.ImagePath,
-			&baseline.Width,
-			&baseline.Height,
-			&baseline.Browser,
-			&baseline.Viewport,
-			&baseline.SourceSnapshotID,
-			&baseline.CreatedAt,
-			&baseline.UpdatedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan baseline: %w", err)
-		}
-		baselines = append(baselines, baseline)
+	baselines, err := scanBaselines(rows)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return baselines, total, nil
 }
 
-func (r *BaselineRepository) UpdateImagePath(ctx context.Context, id uuid.UUID, imagePath string, width, height *int) error {
-	_, err := r.pool.Exec(ctx, `
-		UPDATE baselines
-		SET image_path = $2, width = COALESCE($3, width), height = COALESCE($4, height)
-		WHERE id = $1
-	`, id, imagePath, width, height)
-	if err != nil {
-		return fmt.Errorf("failed to update baseline image path: %w", err)
-	}
-	return nil
-}
-
 func (r *BaselineRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	_, err := r.pool.Exec(ctx, `DELETE FROM baselines WHERE id = $1`, id)
 	if err != nil {
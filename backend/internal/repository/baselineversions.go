@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const baselineVersionColumns = `id, baseline_id, version, image_path, image_hash, width, height,
+	source_snapshot_id, approved_by_user_id, approved_by, restored_from_id, created_at`
+
+func scanBaselineVersion(row pgx.Row, version *models.BaselineVersion) error {
+	return row.Scan(
+		&version.ID,
+		&version.BaselineID,
+		&version.Version,
+		&version.ImagePath,
+		&version.ImageHash,
+		&version.Width,
+		&version.Height,
+		&version.SourceSnapshotID,
+		&version.ApprovedByUserID,
+		&version.ApprovedBy,
+		&version.RestoredFromID,
+		&version.CreatedAt,
+	)
+}
+
+// Approver is who made a baseline change, both fields are nil for changes
+// made without a logged in user
+type Approver struct {
+	UserID *uuid.UUID
+	Name   *string
+}
+
+/*
+insertBaselineVersion records a baseline's current image as its next
+version. With skipUnchanged nothing is recorded when the latest version
+already has the same image, so approving an identical screenshot again
+doesn't add to the history. The baseline row must be locked by tx.
+*/
+func insertBaselineVersion(ctx context.Context, tx pgx.Tx, baseline *models.Baseline, approver Approver, restoredFromID *uuid.UUID, skipUnchanged bool) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO baseline_versions (baseline_id, version, image_path, image_hash, width, height,
+			source_snapshot_id, approved_by_user_id, approved_by, restored_from_id)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6, $7, $8, $9
+		FROM baseline_versions
+		WHERE baseline_id = $1
+		HAVING NOT $10 OR (
+			SELECT image_path FROM baseline_versions
+			WHERE baseline_id = $1
+			ORDER BY version DESC
+			LIMIT 1
+		) IS DISTINCT FROM $2
+	`, baseline.ID, baseline.ImagePath, baseline.ImageHash, baseline.Width, baseline.Height,
+		baseline.SourceSnapshotID, approver.UserID, approver.Name, restoredFromID, skipUnchanged)
+	if err != nil {
+		return fmt.Errorf("failed to record baseline version: %w", err)
+	}
+	return nil
+}
+
+type BaselineVersionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewBaselineVersionRepository(pool *pgxpool.Pool) *BaselineVersionRepository {
+	return &BaselineVersionRepository{pool: pool}
+}
+
+func (r *BaselineVersionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.BaselineVersion, error) {
+	var version models.BaselineVersion
+	err := scanBaselineVersion(r.pool.QueryRow(ctx, `
+		SELECT `+baselineVersionColumns+` FROM baseline_versions WHERE id = $1
+	`, id), &version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get baseline version: %w", err)
+	}
+
+	return &version, nil
+}
+
+// ListByBaseline returns a baseline's history, newest first. The newest
+// version is marked current.
+func (r *BaselineVersionRepository) ListByBaseline(ctx context.Context, baselineID uuid.UUID) ([]models.BaselineVersion, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+baselineVersionColumns+`
+		FROM baseline_versions
+		WHERE baseline_id = $1
+		ORDER BY version DESC
+	`, baselineID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list baseline versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []models.BaselineVersion
+	for rows.Next() {
+		var version models.BaselineVersion
+		if err := scanBaselineVersion(rows, &version); err != nil {
+			return nil, fmt.Errorf("failed to scan baseline version: %w", err)
+		}
+		version.Current = len(versions) == 0
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
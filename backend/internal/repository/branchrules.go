@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const branchRuleColumns = `id, project_id, pattern, parent_branch, priority, created_at, updated_at`
+
+func scanBranchRule(row pgx.Row, rule *models.BranchRule) error {
+	return row.Scan(
+		&rule.ID,
+		&rule.ProjectID,
+		&rule.Pattern,
+		&rule.ParentBranch,
+		&rule.Priority,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+}
+
+type BranchRuleRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewBranchRuleRepository(pool *pgxpool.Pool) *BranchRuleRepository {
+	return &BranchRuleRepository{pool: pool}
+}
+
+func (r *BranchRuleRepository) Create(ctx context.Context, projectID uuid.UUID, req models.BranchRuleRequest) (*models.BranchRule, error) {
+	var rule models.BranchRule
+	err := scanBranchRule(r.pool.QueryRow(ctx, `
+		INSERT INTO branch_rules (project_id, pattern, parent_branch, priority)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+branchRuleColumns,
+		projectID, req.Pattern, req.ParentBranch, req.Priority), &rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create branch rule: %w", err)
+	}
+
+	return &rule, nil
+}
+
+func (r *BranchRuleRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.BranchRule, error) {
+	var rule models.BranchRule
+	err := scanBranchRule(r.pool.QueryRow(ctx, `SELECT `+branchRuleColumns+` FROM branch_rules WHERE id = $1`, id), &rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch rule: %w", err)
+	}
+
+	return &rule, nil
+}
+
+// ListByProject returns a project's rules in the order they are matched,
+// highest priority first
+func (r *BranchRuleRepository) ListByProject(ctx context.Context, projectID uuid.UUID) ([]models.BranchRule, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+branchRuleColumns+`
+		FROM branch_rules
+		WHERE project_id = $1
+		ORDER BY priority DESC, created_at ASC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branch rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []models.BranchRule
+	for rows.Next() {
+		var rule models.BranchRule
+		if err := scanBranchRule(rows, &rule); err != nil {
+			return nil, fmt.Errorf("failed to scan branch rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (r *BranchRuleRepository) Update(ctx context.Context, id uuid.UUID, req models.BranchRuleRequest) (*models.BranchRule, error) {
+	var rule models.BranchRule
+	err := scanBranchRule(r.pool.QueryRow(ctx, `
+		UPDATE branch_rules
+		SET pattern = $2, parent_branch = $3, priority = $4
+		WHERE id = $1
+		RETURNING `+branchRuleColumns,
+		id, req.Pattern, req.ParentBranch, req.Priority), &rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update branch rule: %w", err)
+	}
+
+	return &rule, nil
+}
+
+func (r *BranchRuleRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM branch_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete branch rule: %w", err)
+	}
+	return nil
+}
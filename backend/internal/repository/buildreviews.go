@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const buildReviewTransitionColumns = `id, build_id, from_state, to_state, user_id, reviewed_by, created_at`
+
+func scanBuildReviewTransition(row pgx.Row, transition *models.BuildReviewTransition) error {
+	return row.Scan(
+		&transition.ID,
+		&transition.BuildID,
+		&transition.FromState,
+		&transition.ToState,
+		&transition.UserID,
+		&transition.ReviewedBy,
+		&transition.CreatedAt,
+	)
+}
+
+type BuildReviewRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewBuildReviewRepository(pool *pgxpool.Pool) *BuildReviewRepository {
+	return &BuildReviewRepository{pool: pool}
+}
+
+// reviewState derives a build's review state from its status, how many of its
+// changes are rejected or still unreviewed and how many snapshots couldn't be
+// compared. A failed snapshot needs looking at as much as an unreviewed change.
+func reviewState(status models.BuildStatus, rejected, unreviewed, failed int) models.BuildReviewState {
+	switch {
+	case status != models.BuildStatusCompleted:
+		return models.BuildReviewStatePending
+	case rejected > 0:
+		return models.BuildReviewStateRejected
+	case unreviewed > 0 || failed > 0:
+		return models.BuildReviewStateNeedsReview
+	default:
+		return models.BuildReviewStateApproved
+	}
+}
+
+/*
+Refresh recomputes a build's review state from its snapshots and, if it has
+changed, stores it and records the transition against the user responsible.
+userID and reviewedBy are nil for changes the server makes on its own, such
+as approving a build without changes when it completes. Returns the recorded
+transition, or nil if the state didn't change.
+*/
+func (r *BuildReviewRepository) Refresh(ctx context.Context, buildID uuid.UUID, userID *uuid.UUID, reviewedBy *string) (*models.BuildReviewTransition, error) {
+	var transition *models.BuildReviewTransition
+
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		// Lock the build so concurrent reviews record transitions in order
+		var status models.BuildStatus
+		var current models.BuildReviewState
+		err := tx.QueryRow(ctx, `
+			SELECT status, review_state FROM builds WHERE id = $1 FOR UPDATE
+		`, buildID).Scan(&status, &current)
+		if err != nil {
+			return err
+		}
+
+		// Failed snapshots have no change type, so they're counted apart
+		var rejected, unreviewed, failed int
+		err = tx.QueryRow(ctx, `
+			SELECT COUNT(*) FILTER (WHERE change_type IN ($2, $3, $4, $7) AND review_status = $5),
+			       COUNT(*) FILTER (WHERE change_type IN ($2, $3, $4, $7) AND review_status = $6),
+			       COUNT(*) FILTER (WHERE status = $8)
+			FROM snapshots
+			WHERE build_id = $1
+		`, buildID, models.ChangeTypeNew, models.ChangeTypeChanged, models.ChangeTypeRemoved,
+			models.ReviewStatusRejected, models.ReviewStatusUnreviewed, models.ChangeTypeResized,
+			models.SnapshotStatusFailed).Scan(&rejected, &unreviewed, &failed)
+		if err != nil {
+			return err
+		}
+
+		next := reviewState(status, rejected, unreviewed, failed)
+		if next == current {
+			return nil
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE builds SET review_state = $2 WHERE id = $1`, buildID, next); err != nil {
+			return err
+		}
+
+		transition = &models.BuildReviewTransition{}
+		return scanBuildReviewTransition(tx.QueryRow(ctx, `
+			INSERT INTO build_review_transitions (build_id, from_state, to_state, user_id, reviewed_by)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+buildReviewTransitionColumns,
+			buildID, current, next, userID, reviewedBy), transition)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh build review state: %w", err)
+	}
+
+	return transition, nil
+}
+
+// ListByBuild lists a build's review state transitions, oldest first
+func (r *BuildReviewRepository) ListByBuild(ctx context.Context, buildID uuid.UUID) ([]models.BuildReviewTransition, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+buildReviewTransitionColumns+`
+		FROM build_review_transitions
+		WHERE build_id = $1
+		ORDER BY created_at ASC
+	`, buildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list build review transitions: %w", err)
+	}
+	defer rows.Close()
+
+	var transitions []models.BuildReviewTransition
+	for rows.Next() {
+		var transition models.BuildReviewTransition
+		if err := scanBuildReviewTransition(rows, &transition); err != nil {
+			return nil, fmt.Errorf("failed to scan build review transition: %w", err)
+		}
+		transitions = append(transitions, transition)
+	}
+
+	return transitions, rows.Err()
+}
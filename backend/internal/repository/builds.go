@@ -2,31 +2,24 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/crzytrane/diffit/internal/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type BuildRepository struct {
-	pool *pgxpool.Pool
-}
-
-func NewBuildRepository(pool *pgxpool.Pool) *BuildRepository {
-	return &BuildRepository{pool: pool}
-}
+// buildColumns is the column list scanned by scanBuild
+const buildColumns = `id, project_id, build_number, branch, commit_sha, commit_message,
+	pull_request_number, base_build_id, base_commit_sha, status, review_state, total_snapshots,
+	changed_snapshots, approved_snapshots, new_snapshots, removed_snapshots,
+	unchanged_snapshots, failed_snapshots, created_at, updated_at, finished_at`
 
-func (r *BuildRepository) Create(ctx context.Context, req models.CreateBuildRequest) (*models.Build, error) {
-	var build models.Build
-	err := r.pool.QueryRow(ctx, `
-		INSERT INTO builds (project_id, branch, commit_sha, commit_message, pull_request_number, status)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, project_id, build_number, branch, commit_sha, commit_message,
-		          pull_request_number, status, total_snapshots, changed_snapshots,
-		          approved_snapshots, created_at, updated_at, finished_at
-	`, req.ProjectID, req.Branch, req.CommitSHA, req.CommitMessage, req.PullRequestNumber, models.BuildStatusPending).Scan(
+func scanBuild(row pgx.Row, build *models.Build) error {
+	return row.Scan(
 		&build.ID,
 		&build.ProjectID,
 		&build.BuildNumber,
@@ -34,14 +27,62 @@ func (r *BuildRepository) Create(ctx context.Context, req models.CreateBuildRequ
 		&build.CommitSHA,
 		&build.CommitMessage,
 		&build.PullRequestNumber,
+		&build.BaseBuildID,
+		&build.BaseCommitSHA,
 		&build.Status,
+		&build.ReviewState,
 		&build.TotalSnapshots,
 		&build.ChangedSnapshots,
 		&build.ApprovedSnapshots,
+		&build.NewSnapshots,
+		&build.RemovedSnapshots,
+		&build.UnchangedSnapshots,
+		&build.FailedSnapshots,
 		&build.CreatedAt,
 		&build.UpdatedAt,
 		&build.FinishedAt,
 	)
+}
+
+func scanBuilds(rows pgx.Rows) ([]models.Build, error) {
+	defer rows.Close()
+
+	var builds []models.Build
+	for rows.Next() {
+		var build models.Build
+		if err := scanBuild(rows, &build); err != nil {
+			return nil, fmt.Errorf("failed to scan build: %w", err)
+		}
+		builds = append(builds, build)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate builds: %w", err)
+	}
+
+	return builds, nil
+}
+
+type BuildRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewBuildRepository(pool *pgxpool.Pool) *BuildRepository {
+	return &BuildRepository{pool: pool}
+}
+
+// Create creates a build. When the request has an idempotency key already
+// used in the project, the build created with it is returned instead.
+func (r *BuildRepository) Create(ctx context.Context, req models.CreateBuildRequest) (*models.Build, error) {
+	var build models.Build
+	err := scanBuild(r.pool.QueryRow(ctx, `
+		INSERT INTO builds (project_id, branch, commit_sha, commit_message, pull_request_number,
+		                    base_build_id, base_commit_sha, status, idempotency_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (project_id, idempotency_key) WHERE idempotency_key IS NOT NULL
+		DO UPDATE SET idempotency_key = EXCLUDED.idempotency_key
+		RETURNING `+buildColumns+`
+	`, req.ProjectID, req.Branch, req.CommitSHA, req.CommitMessage, req.PullRequestNumber,
+		req.BaseBuildID, req.BaseCommitSHA, models.BuildStatusPending, req.IdempotencyKey), &build)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create build: %w", err)
 	}
@@ -49,29 +90,28 @@ func (r *BuildRepository) Create(ctx context.Context, req models.CreateBuildRequ
 	return &build, nil
 }
 
+// FindByIdempotencyKey finds the build created with an idempotency key, or nil
+// if there isn't one
+func (r *BuildRepository) FindByIdempotencyKey(ctx context.Context, projectID uuid.UUID, key string) (*models.Build, error) {
+	var build models.Build
+	err := scanBuild(r.pool.QueryRow(ctx, `
+		SELECT `+buildColumns+` FROM builds WHERE project_id = $1 AND idempotency_key = $2
+	`, projectID, key), &build)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find build by idempotency key: %w", err)
+	}
+
+	return &build, nil
+}
+
 func (r *BuildRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Build, error) {
 	var build models.Build
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, project_id, build_number, branch, commit_sha, commit_message,
-		       pull_request_number, status, total_snapshots, changed_snapshots,
-		       approved_snapshots, created_at, updated_at, finished_at
-		FROM builds WHERE id = $1
-	`, id).Scan(
-		&build.ID,
-		&build.ProjectID,
-		&build.BuildNumber,
-		&build.Branch,
-		&build.CommitSHA,
-		&build.CommitMessage,
-		&build.PullRequestNumber,
-		&build.Status,
-		&build.TotalSnapshots,
-		&build.ChangedSnapshots,
-		&build.ApprovedSnapshots,
-		&build.CreatedAt,
-		&build.UpdatedAt,
-		&build.FinishedAt,
-	)
+	err := scanBuild(r.pool.QueryRow(ctx, `
+		SELECT `+buildColumns+` FROM builds WHERE id = $1
+	`, id), &build)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get build: %w", err)
 	}
@@ -79,6 +119,31 @@ func (r *BuildRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Bu
 	return &build, nil
 }
 
+/*
+FindByCommit finds the build to compare against for a commit, typically the
+merge base of a pull request. A full SHA or an abbreviated prefix matches.
+Completed builds are preferred over ones still running or failed, then the
+most recent. Returns nil if the commit has never been built.
+*/
+func (r *BuildRepository) FindByCommit(ctx context.Context, projectID uuid.UUID, commitSHA string) (*models.Build, error) {
+	var build models.Build
+	err := scanBuild(r.pool.QueryRow(ctx, `
+		SELECT `+buildColumns+`
+		FROM builds
+		WHERE project_id = $1 AND commit_sha LIKE $2 || '%'
+		ORDER BY status = $3 DESC, build_number DESC
+		LIMIT 1
+	`, projectID, commitSHA, models.BuildStatusCompleted), &build)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find build by commit: %w", err)
+	}
+
+	return &build, nil
+}
+
 func (r *BuildRepository) ListByProject(ctx context.Context, projectID uuid.UUID, pagination models.PaginationParams) ([]models.Build, int, error) {
 	var total int
 	err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM builds WHERE project_id = $1`, projectID).Scan(&total)
@@ -87,9 +152,7 @@ func (r *BuildRepository) ListByProject(ctx context.Context, projectID uuid.UUID
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, project_id, build_number, branch, commit_sha, commit_message,
-		       pull_request_number, status, total_snapshots, changed_snapshots,
-		       approved_snapshots, created_at, updated_at, finished_at
+		SELECT `+buildColumns+`
 		FROM builds
 		WHERE project_id = $1
 		ORDER BY build_number DESC
@@ -98,30 +161,10 @@ func (r *BuildRepository) ListByProject(ctx context.Context, projectID uuid.UUID
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to list builds: %w", err)
 	}
-	defer rows.Close()
 
-	var builds []models.Build
-	for rows.Next() {
-		var build models.Build
-		if err := rows.Scan(
-			&build.ID,
-			&build.ProjectID,
-			&build.BuildNumber,
-			&build.Branch,
-			&build.CommitSHA,
-			&build.CommitMessage,
-			&build.PullRequestNumber,
-			&build.Status,
-			&build.TotalSnapshots,
-			&build.ChangedSnapshots,
-			&build.ApprovedSnapshots,
-			&build.CreatedAt,
-			&build.UpdatedAt,
-			&build.FinishedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan build: %w", err)
-		}
-		builds = append(builds, build)
+	builds, err := scanBuilds(rows)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return builds, total, nil
@@ -135,9 +178,7 @@ func (r *BuildRepository) ListByBranch(ctx context.Context, projectID uuid.UUID,
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, project_id, build_number, branch, commit_sha, commit_message,
-		       pull_request_number, status, total_snapshots, changed_snapshots,
-		       approved_snapshots, created_at, updated_at, finished_at
+		SELECT `+buildColumns+`
 		FROM builds
 		WHERE project_id = $1 AND branch = $2
 		ORDER BY build_number DESC
@@ -146,30 +187,10 @@ func (r *BuildRepository) ListByBranch(ctx context.Context, projectID uuid.UUID,
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to list builds by branch: %w", err)
 	}
-	defer rows.Close()
 
-	var builds []models.Build
-	for rows.Next() {
-		var build models.Build
-		if err := rows.Scan(
-			&build.ID,
-			&build.ProjectID,
-			&build.BuildNumber,
-			&build.Branch,
-			&build.CommitSHA,
-			&build.CommitMessage,
-			&build.PullRequestNumber,
-			&build.Status,
-			&build.TotalSnapshots,
-			&build.ChangedSnapshots,
-			&build.ApprovedSnapshots,
-			&build.CreatedAt,
-			&build.UpdatedAt,
-			&build.FinishedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan build: %w", err)
-		}
-		builds = append(builds, build)
+	builds, err := scanBuilds(rows)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return builds, total, nil
@@ -191,46 +212,66 @@ func (r *BuildRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status
 	return nil
 }
 
+// UpdateStats recounts a build's snapshots. Removed snapshots are counted
+// separately and aren't part of the total since nothing was uploaded for them.
+// Failed snapshots are part of the total as well as counted on their own.
 func (r *BuildRepository) UpdateStats(ctx context.Context, id uuid.UUID) error {
 	_, err := r.pool.Exec(ctx, `
 		UPDATE builds SET
-			total_snapshots = (SELECT COUNT(*) FROM snapshots WHERE build_id = $1),
-			changed_snapshots = (SELECT COUNT(*) FROM snapshots WHERE build_id = $1 AND diff_percentage > 0),
-			approved_snapshots = (SELECT COUNT(*) FROM snapshots WHERE build_id = $1 AND review_status = 'approved')
+			total_snapshots = stats.total,
+			changed_snapshots = stats.changed,
+			approved_snapshots = stats.approved,
+			new_snapshots = stats.new,
+			removed_snapshots = stats.removed,
+			unchanged_snapshots = stats.unchanged,
+			failed_snapshots = stats.failed
+		FROM (
+			SELECT
+				COUNT(*) FILTER (WHERE change_type IS DISTINCT FROM $2) AS total,
+				COUNT(*) FILTER (WHERE change_type IN ($3, $7)) AS changed,
+				COUNT(*) FILTER (WHERE review_status = $4) AS approved,
+				COUNT(*) FILTER (WHERE change_type = $5) AS new,
+				COUNT(*) FILTER (WHERE change_type = $2) AS removed,
+				COUNT(*) FILTER (WHERE change_type = $6) AS unchanged,
+				COUNT(*) FILTER (WHERE status = $8) AS failed
+			FROM snapshots WHERE build_id = $1
+		) AS stats
 		WHERE id = $1
-	`, id)
+	`, id, models.ChangeTypeRemoved, models.ChangeTypeChanged, models.ReviewStatusApproved,
+		models.ChangeTypeNew, models.ChangeTypeUnchanged, models.ChangeTypeResized,
+		models.SnapshotStatusFailed)
 	if err != nil {
 		return fmt.Errorf("failed to update build stats: %w", err)
 	}
 	return nil
 }
 
+// CompleteIfProcessed moves a build that is waiting on diff jobs to completed
+// once none of its snapshots are pending or processing any more
+func (r *BuildRepository) CompleteIfProcessed(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE builds SET status = $2, finished_at = NOW()
+		WHERE id = $1 AND status = $3
+		  AND NOT EXISTS (
+			SELECT 1 FROM snapshots WHERE build_id = $1 AND status IN ($4, $5)
+		  )
+	`, id, models.BuildStatusCompleted, models.BuildStatusProcessing,
+		models.SnapshotStatusPending, models.SnapshotStatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to complete build: %w", err)
+	}
+	return nil
+}
+
 func (r *BuildRepository) GetLatestByBranch(ctx context.Context, projectID uuid.UUID, branch string) (*models.Build, error) {
 	var build models.Build
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, project_id, build_number, branch, commit_sha, commit_message,
-		       pull_request_number, status, total_snapshots, changed_snapshots,
-		       approved_snapshots, created_at, updated_at, finished_at
+	err := scanBuild(r.pool.QueryRow(ctx, `
+		SELECT `+buildColumns+`
 		FROM builds
 		WHERE project_id = $1 AND branch = $2
 		ORDER BY build_number DESC
 		LIMIT 1
-	`, projectID, branch).Scan(
-		&build.ID,
-		&build.ProjectID,
-		&build.BuildNumber,
-		&build.Branch,
-		&build.CommitSHA,
-		&build.CommitMessage,
-		&build.PullRequestNumber,
-		&build.Status,
-		&build.TotalSnapshots,
-		&build.ChangedSnapshots,
-		&build.ApprovedSnapshots,
-		&build.CreatedAt,
-		&build.UpdatedAt,
-		&build.FinishedAt,
-	)
+	`, projectID, branch), &build)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get latest build: %w", err)
 	}
@@ -238,6 +279,70 @@ func (r *BuildRepository) GetLatestByBranch(ctx context.Context, projectID uuid.
 	return &build, nil
 }
 
+/*
+ListExpired lists the finished builds of a project that policy says have
+expired, oldest first. A build expires when KeepBuildsPerBranch newer builds
+exist on its branch or it is older than MaxAgeDays; with KeepBaselineSources
+builds holding a snapshot some baseline or baseline version was approved
+from never expire. Neither does a build that a build being kept compares
+against as its base build, directly or through other base builds, since
+deleting it would switch that build back to branch baselines.
+*/
+func (r *BuildRepository) ListExpired(ctx context.Context, policy models.RetentionPolicy) ([]models.ExpiredBuild, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH RECURSIVE ranked AS (
+			SELECT id, build_number, branch, status, base_build_id, created_at,
+			       ROW_NUMBER() OVER (PARTITION BY branch ORDER BY build_number DESC) AS position
+			FROM builds
+			WHERE project_id = $1
+		), expiring AS (
+			SELECT b.id, b.build_number, b.branch,
+			       CASE WHEN $2::int IS NOT NULL AND b.position > $2::int THEN 'count' ELSE 'age' END AS reason,
+			       b.created_at
+			FROM ranked b
+			WHERE b.status IN ($5, $6)
+			  AND (
+			      ($2::int IS NOT NULL AND b.position > $2::int)
+			      OR ($3::int IS NOT NULL AND b.created_at < NOW() - make_interval(days => $3::int))
+			  )
+			  AND NOT ($4 AND EXISTS (
+			      SELECT 1 FROM snapshots s
+			      WHERE s.build_id = b.id AND (
+			          EXISTS (SELECT 1 FROM baselines bl WHERE bl.source_snapshot_id = s.id)
+			          OR EXISTS (SELECT 1 FROM baseline_versions bv WHERE bv.source_snapshot_id = s.id)
+			      )
+			  ))
+		), bases AS (
+			-- Base builds of the builds being kept, and their base builds in turn
+			SELECT base_build_id AS id FROM ranked
+			WHERE base_build_id IS NOT NULL AND id NOT IN (SELECT id FROM expiring)
+			UNION
+			SELECT b.base_build_id FROM builds b JOIN bases ON b.id = bases.id
+			WHERE b.base_build_id IS NOT NULL
+		)
+		SELECT id, build_number, branch, reason, created_at
+		FROM expiring
+		WHERE id NOT IN (SELECT id FROM bases)
+		ORDER BY created_at ASC
+	`, policy.ProjectID, policy.KeepBuildsPerBranch, policy.MaxAgeDays, policy.KeepBaselineSources,
+		models.BuildStatusCompleted, models.BuildStatusFailed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired builds: %w", err)
+	}
+	defer rows.Close()
+
+	var builds []models.ExpiredBuild
+	for rows.Next() {
+		var build models.ExpiredBuild
+		if err := rows.Scan(&build.ID, &build.BuildNumber, &build.Branch, &build.Reason, &build.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan expired build: %w", err)
+		}
+		builds = append(builds, build)
+	}
+
+	return builds, rows.Err()
+}
+
 func (r *BuildRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	_, err := r.pool.Exec(ctx, `DELETE FROM builds WHERE id = $1`, id)
 	if err != nil {
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const commitStatusConfigColumns = `project_id, host, api_url, repository, token, context, enabled, created_at, updated_at`
+
+func scanCommitStatusConfig(row pgx.Row, config *models.CommitStatusConfig) error {
+	return row.Scan(
+		&config.ProjectID,
+		&config.Host,
+		&config.APIURL,
+		&config.Repository,
+		&config.Token,
+		&config.Context,
+		&config.Enabled,
+		&config.CreatedAt,
+		&config.UpdatedAt,
+	)
+}
+
+type CommitStatusConfigRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewCommitStatusConfigRepository(pool *pgxpool.Pool) *CommitStatusConfigRepository {
+	return &CommitStatusConfigRepository{pool: pool}
+}
+
+// Get returns a project's commit status config, or nil if it has none
+func (r *CommitStatusConfigRepository) Get(ctx context.Context, projectID uuid.UUID) (*models.CommitStatusConfig, error) {
+	var config models.CommitStatusConfig
+	err := scanCommitStatusConfig(r.pool.QueryRow(ctx, `
+		SELECT `+commitStatusConfigColumns+` FROM commit_status_configs WHERE project_id = $1
+	`, projectID), &config)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit status config: %w", err)
+	}
+
+	return &config, nil
+}
+
+// Save creates or replaces a project's commit status config
+func (r *CommitStatusConfigRepository) Save(ctx context.Context, config models.CommitStatusConfig) (*models.CommitStatusConfig, error) {
+	var saved models.CommitStatusConfig
+	err := scanCommitStatusConfig(r.pool.QueryRow(ctx, `
+		INSERT INTO commit_status_configs (project_id, host, api_url, repository, token, context, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (project_id) DO UPDATE SET
+			host = EXCLUDED.host,
+			api_url = EXCLUDED.api_url,
+			repository = EXCLUDED.repository,
+			token = EXCLUDED.token,
+			context = EXCLUDED.context,
+			enabled = EXCLUDED.enabled
+		RETURNING `+commitStatusConfigColumns,
+		config.ProjectID, config.Host, config.APIURL, config.Repository, config.Token, config.Context, config.Enabled), &saved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save commit status config: %w", err)
+	}
+
+	return &saved, nil
+}
+
+func (r *CommitStatusConfigRepository) Delete(ctx context.Context, projectID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM commit_status_configs WHERE project_id = $1`, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete commit status config: %w", err)
+	}
+	return nil
+}
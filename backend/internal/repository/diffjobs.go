@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const diffJobColumns = `id, snapshot_id, status, attempts, max_attempts, last_error, run_at, locked_at, created_at, updated_at`
+
+func scanDiffJob(row pgx.Row, job *models.DiffJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.SnapshotID,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.LockedAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+}
+
+type DiffJobRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewDiffJobRepository(pool *pgxpool.Pool) *DiffJobRepository {
+	return &DiffJobRepository{pool: pool}
+}
+
+// enqueueDiffJob adds a job for snapshot $1 that is ready to run
+// immediately, inside the transaction that gives the snapshot its image
+const enqueueDiffJob = `
+	INSERT INTO diff_jobs (snapshot_id, status, max_attempts)
+	VALUES ($1, '` + string(models.DiffJobStatusPending) + `', $2)`
+
+// Claim locks the next runnable job and marks it as running. Jobs that have
+// been running for longer than staleAfter are assumed to belong to a worker
+// that died and are claimed again while they have attempts left, see
+// FailStale for the others. Returns nil when there is nothing to do.
+func (r *DiffJobRepository) Claim(ctx context.Context, staleAfter time.Duration) (*models.DiffJob, error) {
+	var job models.DiffJob
+	err := scanDiffJob(r.pool.QueryRow(ctx, `
+		UPDATE diff_jobs
+		SET status = $1, attempts = attempts + 1, locked_at = NOW()
+		WHERE id = (
+			SELECT id FROM diff_jobs
+			WHERE (status = $2 AND run_at <= NOW())
+			   OR (status = $1 AND locked_at < NOW() - $3::interval AND attempts < max_attempts)
+			ORDER BY run_at ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+diffJobColumns,
+		models.DiffJobStatusRunning, models.DiffJobStatusPending, staleAfter.String()), &job)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim diff job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// Complete marks a job as successfully processed
+func (r *DiffJobRepository) Complete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE diff_jobs SET status = $2, last_error = NULL, locked_at = NULL WHERE id = $1
+	`, id, models.DiffJobStatusCompleted)
+	if err != nil {
+		return fmt.Errorf("failed to complete diff job: %w", err)
+	}
+	return nil
+}
+
+// Retry puts a job back in the queue to run again at runAt
+func (r *DiffJobRepository) Retry(ctx context.Context, id uuid.UUID, lastError string, runAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE diff_jobs SET status = $2, last_error = $3, run_at = $4, locked_at = NULL WHERE id = $1
+	`, id, models.DiffJobStatusPending, lastError, runAt)
+	if err != nil {
+		return fmt.Errorf("failed to retry diff job: %w", err)
+	}
+	return nil
+}
+
+// FailStale marks jobs that went stale on their last attempt as permanently
+// failed, since running them again could kill another worker the same way.
+// Returns the snapshots of the failed jobs.
+func (r *DiffJobRepository) FailStale(ctx context.Context, staleAfter time.Duration, lastError string) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		UPDATE diff_jobs SET status = $2, last_error = $3, locked_at = NULL
+		WHERE status = $1 AND locked_at < NOW() - $4::interval AND attempts >= max_attempts
+		RETURNING snapshot_id
+	`, models.DiffJobStatusRunning, models.DiffJobStatusFailed, lastError, staleAfter.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fail stale diff jobs: %w", err)
+	}
+
+	defer rows.Close()
+
+	var snapshotIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan stale diff job: %w", err)
+		}
+		snapshotIDs = append(snapshotIDs, id)
+	}
+
+	return snapshotIDs, rows.Err()
+}
+
+// Fail marks a job as permanently failed
+func (r *DiffJobRepository) Fail(ctx context.Context, id uuid.UUID, lastError string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE diff_jobs SET status = $2, last_error = $3, locked_at = NULL WHERE id = $1
+	`, id, models.DiffJobStatusFailed, lastError)
+	if err != nil {
+		return fmt.Errorf("failed to fail diff job: %w", err)
+	}
+	return nil
+}
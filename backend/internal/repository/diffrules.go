@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const diffRuleColumns = `id, project_id, pattern, priority, diff_threshold,
+	acceptable_diff_percentage, ignore_antialiasing, created_at, updated_at`
+
+func scanDiffRule(row pgx.Row, rule *models.DiffRule) error {
+	return row.Scan(
+		&rule.ID,
+		&rule.ProjectID,
+		&rule.Pattern,
+		&rule.Priority,
+		&rule.DiffThreshold,
+		&rule.AcceptableDiffPercentage,
+		&rule.IgnoreAntialiasing,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+}
+
+type DiffRuleRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewDiffRuleRepository(pool *pgxpool.Pool) *DiffRuleRepository {
+	return &DiffRuleRepository{pool: pool}
+}
+
+func (r *DiffRuleRepository) Create(ctx context.Context, projectID uuid.UUID, req models.DiffRuleRequest) (*models.DiffRule, error) {
+	var rule models.DiffRule
+	err := scanDiffRule(r.pool.QueryRow(ctx, `
+		INSERT INTO diff_rules (project_id, pattern, priority, diff_threshold, acceptable_diff_percentage, ignore_antialiasing)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+diffRuleColumns,
+		projectID, req.Pattern, req.Priority, req.DiffThreshold, req.AcceptableDiffPercentage, req.IgnoreAntialiasing), &rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create diff rule: %w", err)
+	}
+
+	return &rule, nil
+}
+
+func (r *DiffRuleRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.DiffRule, error) {
+	var rule models.DiffRule
+	err := scanDiffRule(r.pool.QueryRow(ctx, `SELECT `+diffRuleColumns+` FROM diff_rules WHERE id = $1`, id), &rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff rule: %w", err)
+	}
+
+	return &rule, nil
+}
+
+// ListByProject returns a project's rules in the order they are matched,
+// highest priority first
+func (r *DiffRuleRepository) ListByProject(ctx context.Context, projectID uuid.UUID) ([]models.DiffRule, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+diffRuleColumns+`
+		FROM diff_rules
+		WHERE project_id = $1
+		ORDER BY priority DESC, created_at ASC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list diff rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []models.DiffRule
+	for rows.Next() {
+		var rule models.DiffRule
+		if err := scanDiffRule(rows, &rule); err != nil {
+			return nil, fmt.Errorf("failed to scan diff rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Update replaces every field of a rule, unset overrides are cleared
+func (r *DiffRuleRepository) Update(ctx context.Context, id uuid.UUID, req models.DiffRuleRequest) (*models.DiffRule, error) {
+	var rule models.DiffRule
+	err := scanDiffRule(r.pool.QueryRow(ctx, `
+		UPDATE diff_rules
+		SET pattern = $2, priority = $3, diff_threshold = $4,
+		    acceptable_diff_percentage = $5, ignore_antialiasing = $6
+		WHERE id = $1
+		RETURNING `+diffRuleColumns,
+		id, req.Pattern, req.Priority, req.DiffThreshold, req.AcceptableDiffPercentage, req.IgnoreAntialiasing), &rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update diff rule: %w", err)
+	}
+
+	return &rule, nil
+}
+
+func (r *DiffRuleRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM diff_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete diff rule: %w", err)
+	}
+	return nil
+}
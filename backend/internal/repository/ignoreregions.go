@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const ignoreRegionColumns = `id, project_id, baseline_id, pattern, x, y, width, height, selector, created_at, updated_at`
+
+func scanIgnoreRegion(row pgx.Row, region *models.IgnoreRegion) error {
+	return row.Scan(
+		&region.ID,
+		&region.ProjectID,
+		&region.BaselineID,
+		&region.Pattern,
+		&region.X,
+		&region.Y,
+		&region.Width,
+		&region.Height,
+		&region.Selector,
+		&region.CreatedAt,
+		&region.UpdatedAt,
+	)
+}
+
+type IgnoreRegionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewIgnoreRegionRepository(pool *pgxpool.Pool) *IgnoreRegionRepository {
+	return &IgnoreRegionRepository{pool: pool}
+}
+
+func (r *IgnoreRegionRepository) Create(ctx context.Context, projectID uuid.UUID, req models.IgnoreRegionRequest) (*models.IgnoreRegion, error) {
+	var region models.IgnoreRegion
+	err := scanIgnoreRegion(r.pool.QueryRow(ctx, `
+		INSERT INTO ignore_regions (project_id, baseline_id, pattern, x, y, width, height, selector)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+ignoreRegionColumns,
+		projectID, req.BaselineID, req.Pattern, req.X, req.Y, req.Width, req.Height, req.Selector), &region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ignore region: %w", err)
+	}
+
+	return &region, nil
+}
+
+func (r *IgnoreRegionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.IgnoreRegion, error) {
+	var region models.IgnoreRegion
+	err := scanIgnoreRegion(r.pool.QueryRow(ctx, `SELECT `+ignoreRegionColumns+` FROM ignore_regions WHERE id = $1`, id), &region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ignore region: %w", err)
+	}
+
+	return &region, nil
+}
+
+// ListByProject lists a project's regions, optionally only those attached to one baseline
+func (r *IgnoreRegionRepository) ListByProject(ctx context.Context, projectID uuid.UUID, baselineID *uuid.UUID) ([]models.IgnoreRegion, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+ignoreRegionColumns+`
+		FROM ignore_regions
+		WHERE project_id = $1 AND ($2::uuid IS NULL OR baseline_id = $2)
+		ORDER BY created_at ASC
+	`, projectID, baselineID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ignore regions: %w", err)
+	}
+	defer rows.Close()
+
+	var regions []models.IgnoreRegion
+	for rows.Next() {
+		var region models.IgnoreRegion
+		if err := scanIgnoreRegion(rows, &region); err != nil {
+			return nil, fmt.Errorf("failed to scan ignore region: %w", err)
+		}
+		regions = append(regions, region)
+	}
+
+	return regions, nil
+}
+
+func (r *IgnoreRegionRepository) Update(ctx context.Context, id uuid.UUID, req models.IgnoreRegionRequest) (*models.IgnoreRegion, error) {
+	var region models.IgnoreRegion
+	err := scanIgnoreRegion(r.pool.QueryRow(ctx, `
+		UPDATE ignore_regions
+		SET baseline_id = $2, pattern = $3, x = $4, y = $5, width = $6, height = $7, selector = $8
+		WHERE id = $1
+		RETURNING `+ignoreRegionColumns,
+		id, req.BaselineID, req.Pattern, req.X, req.Y, req.Width, req.Height, req.Selector), &region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update ignore region: %w", err)
+	}
+
+	return &region, nil
+}
+
+func (r *IgnoreRegionRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM ignore_regions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete ignore region: %w", err)
+	}
+	return nil
+}
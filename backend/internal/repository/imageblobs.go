@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const imageBlobColumns = `project_id, hash, path, width, height, size_bytes, created_at, last_used_at`
+
+func scanImageBlob(row pgx.Row, blob *models.ImageBlob) error {
+	return row.Scan(
+		&blob.ProjectID,
+		&blob.Hash,
+		&blob.Path,
+		&blob.Width,
+		&blob.Height,
+		&blob.SizeBytes,
+		&blob.CreatedAt,
+		&blob.LastUsedAt,
+	)
+}
+
+// unreferencedBlob matches blobs (aliased b) no snapshot, baseline or
+// baseline version points at
+const unreferencedBlob = `
+	NOT EXISTS (SELECT 1 FROM snapshots s WHERE s.comparison_image_path = b.path)
+	AND NOT EXISTS (SELECT 1 FROM snapshots s WHERE s.base_image_path = b.path)
+	AND NOT EXISTS (SELECT 1 FROM baselines bl WHERE bl.image_path = b.path)
+	AND NOT EXISTS (SELECT 1 FROM baseline_versions bv WHERE bv.image_path = b.path)`
+
+type ImageBlobRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewImageBlobRepository(pool *pgxpool.Pool) *ImageBlobRepository {
+	return &ImageBlobRepository{pool: pool}
+}
+
+// Touch records a blob, or marks an existing one with the same hash as used
+// now. The returned blob is the stored one, so its path wins over blob.Path.
+func (r *ImageBlobRepository) Touch(ctx context.Context, blob models.ImageBlob) (*models.ImageBlob, error) {
+	var stored models.ImageBlob
+	err := scanImageBlob(r.pool.QueryRow(ctx, `
+		INSERT INTO image_blobs (project_id, hash, path, width, height, size_bytes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (project_id, hash) DO UPDATE SET last_used_at = NOW()
+		RETURNING `+imageBlobColumns,
+		blob.ProjectID, blob.Hash, blob.Path, blob.Width, blob.Height, blob.SizeBytes), &stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record image blob: %w", err)
+	}
+
+	return &stored, nil
+}
+
+// ListUnreferenced lists blobs unused since before and not referenced by any
+// snapshot, baseline or baseline version
+func (r *ImageBlobRepository) ListUnreferenced(ctx context.Context, before time.Time, limit int) ([]models.ImageBlob, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+imageBlobColumns+`
+		FROM image_blobs b
+		WHERE b.last_used_at < $1 AND `+unreferencedBlob+`
+		ORDER BY b.last_used_at ASC
+		LIMIT $2
+	`, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unreferenced image blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []models.ImageBlob
+	for rows.Next() {
+		var blob models.ImageBlob
+		if err := scanImageBlob(rows, &blob); err != nil {
+			return nil, fmt.Errorf("failed to scan image blob: %w", err)
+		}
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, nil
+}
+
+/*
+DeleteUnreferenced deletes a blob if it is still unreferenced and unused since
+before, calling remove with its path before the deletion commits. Holding the
+row lock while the file is removed makes a concurrent Touch of the same hash
+wait, so the uploader sees the file is gone and writes it again. Reports
+whether the blob was deleted.
+*/
+func (r *ImageBlobRepository) DeleteUnreferenced(ctx context.Context, projectID uuid.UUID, hash string, before time.Time, remove func(path string) error) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var path string
+	err = tx.QueryRow(ctx, `
+		DELETE FROM image_blobs b
+		WHERE b.project_id = $1 AND b.hash = $2 AND b.last_used_at < $3 AND `+unreferencedBlob+`
+		RETURNING b.path
+	`, projectID, hash, before).Scan(&path)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete image blob: %w", err)
+	}
+
+	if err := remove(path); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit image blob deletion: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const mergeHookColumns = `project_id, secret, delete_source, created_at, updated_at`
+
+func scanMergeHook(row pgx.Row, hook *models.MergeHook) error {
+	return row.Scan(
+		&hook.ProjectID,
+		&hook.Secret,
+		&hook.DeleteSource,
+		&hook.CreatedAt,
+		&hook.UpdatedAt,
+	)
+}
+
+type MergeHookRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewMergeHookRepository(pool *pgxpool.Pool) *MergeHookRepository {
+	return &MergeHookRepository{pool: pool}
+}
+
+// Get returns a project's merge hook, or nil if it has none
+func (r *MergeHookRepository) Get(ctx context.Context, projectID uuid.UUID) (*models.MergeHook, error) {
+	var hook models.MergeHook
+	err := scanMergeHook(r.pool.QueryRow(ctx, `
+		SELECT `+mergeHookColumns+` FROM merge_hooks WHERE project_id = $1
+	`, projectID), &hook)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge hook: %w", err)
+	}
+
+	return &hook, nil
+}
+
+// Save creates or replaces a project's merge hook
+func (r *MergeHookRepository) Save(ctx context.Context, hook models.MergeHook) (*models.MergeHook, error) {
+	var saved models.MergeHook
+	err := scanMergeHook(r.pool.QueryRow(ctx, `
+		INSERT INTO merge_hooks (project_id, secret, delete_source)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (project_id) DO UPDATE SET
+			secret = EXCLUDED.secret,
+			delete_source = EXCLUDED.delete_source
+		RETURNING `+mergeHookColumns,
+		hook.ProjectID, hook.Secret, hook.DeleteSource), &saved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save merge hook: %w", err)
+	}
+
+	return &saved, nil
+}
+
+func (r *MergeHookRepository) Delete(ctx context.Context, projectID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM merge_hooks WHERE project_id = $1`, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete merge hook: %w", err)
+	}
+	return nil
+}
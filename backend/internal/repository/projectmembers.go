@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const projectMemberColumns = `m.project_id, m.user_id, u.email, u.name, m.role, m.created_at, m.updated_at`
+
+func scanProjectMember(row pgx.Row, member *models.ProjectMember) error {
+	return row.Scan(
+		&member.ProjectID,
+		&member.UserID,
+		&member.Email,
+		&member.Name,
+		&member.Role,
+		&member.CreatedAt,
+		&member.UpdatedAt,
+	)
+}
+
+type ProjectMemberRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewProjectMemberRepository(pool *pgxpool.Pool) *ProjectMemberRepository {
+	return &ProjectMemberRepository{pool: pool}
+}
+
+// Upsert gives a user a role in a project, replacing any role they had
+func (r *ProjectMemberRepository) Upsert(ctx context.Context, projectID, userID uuid.UUID, role models.Role) (*models.ProjectMember, error) {
+	var member models.ProjectMember
+	err := scanProjectMember(r.pool.QueryRow(ctx, `
+		WITH saved AS (
+			INSERT INTO project_members (project_id, user_id, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (project_id, user_id) DO UPDATE SET role = EXCLUDED.role
+			RETURNING project_id, user_id, role, created_at, updated_at
+		)
+		SELECT `+projectMemberColumns+`
+		FROM saved m
+		JOIN users u ON u.id = m.user_id
+	`, projectID, userID, role), &member)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save project member: %w", err)
+	}
+
+	return &member, nil
+}
+
+// GetRole returns a user's role in a project, or "" if they aren't a member
+func (r *ProjectMemberRepository) GetRole(ctx context.Context, projectID, userID uuid.UUID) (models.Role, error) {
+	var role models.Role
+	err := r.pool.QueryRow(ctx, `
+		SELECT role FROM project_members WHERE project_id = $1 AND user_id = $2
+	`, projectID, userID).Scan(&role)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get project role: %w", err)
+	}
+
+	return role, nil
+}
+
+func (r *ProjectMemberRepository) ListByProject(ctx context.Context, projectID uuid.UUID) ([]models.ProjectMember, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+projectMemberColumns+`
+		FROM project_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.project_id = $1
+		ORDER BY u.email ASC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.ProjectMember
+	for rows.Next() {
+		var member models.ProjectMember
+		if err := scanProjectMember(rows, &member); err != nil {
+			return nil, fmt.Errorf("failed to scan project member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+func (r *ProjectMemberRepository) Delete(ctx context.Context, projectID, userID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM project_members WHERE project_id = $1 AND user_id = $2`, projectID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete project member: %w", err)
+	}
+	return nil
+}
@@ -6,9 +6,35 @@ import (
 
 	"github.com/crzytrane/diffit/internal/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// projectColumns is the column list scanned by scanProject
+const projectColumns = `id, name, slug, repository_url, default_branch,
+	diff_threshold, acceptable_diff_percentage, ignore_antialiasing, color_metric, change_criterion,
+	min_ssim, min_region_pixels, alignment, created_at, updated_at`
+
+func scanProject(row pgx.Row, project *models.Project) error {
+	return row.Scan(
+		&project.ID,
+		&project.Name,
+		&project.Slug,
+		&project.RepositoryURL,
+		&project.DefaultBranch,
+		&project.DiffThreshold,
+		&project.AcceptableDiffPercentage,
+		&project.IgnoreAntialiasing,
+		&project.ColorMetric,
+		&project.ChangeCriterion,
+		&project.MinSSIM,
+		&project.MinRegionPixels,
+		&project.Alignment,
+		&project.CreatedAt,
+		&project.UpdatedAt,
+	)
+}
+
 type ProjectRepository struct {
 	pool *pgxpool.Pool
 }
@@ -24,19 +50,17 @@ func (r *ProjectRepository) Create(ctx context.Context, req models.CreateProject
 	}
 
 	var project models.Project
-	err := r.pool.QueryRow(ctx, `
-		INSERT INTO projects (name, slug, repository_url, default_branch)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, name, slug, repository_url, default_branch, created_at, updated_at
-	`, req.Name, req.Slug, req.RepositoryURL, defaultBranch).Scan(
-		&project.ID,
-		&project.Name,
-		&project.Slug,
-		&project.RepositoryURL,
-		&project.DefaultBranch,
-		&project.CreatedAt,
-		&project.UpdatedAt,
-	)
+	err := scanProject(r.pool.QueryRow(ctx, `
+		INSERT INTO projects (name, slug, repository_url, default_branch,
+		                      diff_threshold, acceptable_diff_percentage, ignore_antialiasing,
+		                      color_metric, change_criterion, min_ssim, min_region_pixels, alignment)
+		VALUES ($1, $2, $3, $4, COALESCE($5, 0.1), COALESCE($6, 0), COALESCE($7, FALSE),
+		        COALESCE($8, 'yiq'), COALESCE($9, 'pixels'), COALESCE($10, 0.99), COALESCE($11, 1),
+		        COALESCE($12, 'top-left'))
+		RETURNING `+projectColumns,
+		req.Name, req.Slug, req.RepositoryURL, defaultBranch,
+		req.DiffThreshold, req.AcceptableDiffPercentage, req.IgnoreAntialiasing,
+		req.ColorMetric, req.ChangeCriterion, req.MinSSIM, req.MinRegionPixels, req.Alignment), &project)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create project: %w", err)
 	}
@@ -46,18 +70,7 @@ func (r *ProjectRepository) Create(ctx context.Context, req models.CreateProject
 
 func (r *ProjectRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Project, error) {
 	var project models.Project
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, name, slug, repository_url, default_branch, created_at, updated_at
-		FROM projects WHERE id = $1
-	`, id).Scan(
-		&project.ID,
-		&project.Name,
-		&project.Slug,
-		&project.RepositoryURL,
-		&project.DefaultBranch,
-		&project.CreatedAt,
-		&project.UpdatedAt,
-	)
+	err := scanProject(r.pool.QueryRow(ctx, `SELECT `+projectColumns+` FROM projects WHERE id = $1`, id), &project)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get project: %w", err)
 	}
@@ -67,18 +80,7 @@ func (r *ProjectRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.
 
 func (r *ProjectRepository) GetBySlug(ctx context.Context, slug string) (*models.Project, error) {
 	var project models.Project
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, name, slug, repository_url, default_branch, created_at, updated_at
-		FROM projects WHERE slug = $1
-	`, slug).Scan(
-		&project.ID,
-		&project.Name,
-		&project.Slug,
-		&project.RepositoryURL,
-		&project.DefaultBranch,
-		&project.CreatedAt,
-		&project.UpdatedAt,
-	)
+	err := scanProject(r.pool.QueryRow(ctx, `SELECT `+projectColumns+` FROM projects WHERE slug = $1`, slug), &project)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get project by slug: %w", err)
 	}
@@ -94,7 +96,7 @@ func (r *ProjectRepository) List(ctx context.Context, pagination models.Paginati
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, name, slug, repository_url, default_branch, created_at, updated_at
+		SELECT `+projectColumns+`
 		FROM projects
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2
@@ -107,15 +109,7 @@ func (r *ProjectRepository) List(ctx context.Context, pagination models.Paginati
 	var projects []models.Project
 	for rows.Next() {
 		var project models.Project
-		if err := rows.Scan(
-			&project.ID,
-			&project.Name,
-			&project.Slug,
-			&project.RepositoryURL,
-			&project.DefaultBranch,
-			&project.CreatedAt,
-			&project.UpdatedAt,
-		); err != nil {
+		if err := scanProject(rows, &project); err != nil {
 			return nil, 0, fmt.Errorf("failed to scan project: %w", err)
 		}
 		projects = append(projects, project)
@@ -124,26 +118,48 @@ func (r *ProjectRepository) List(ctx context.Context, pagination models.Paginati
 	return projects, total, nil
 }
 
+// ListIDs returns the ID of every project
+func (r *ProjectRepository) ListIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id FROM projects ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
 func (r *ProjectRepository) Update(ctx context.Context, id uuid.UUID, req models.UpdateProjectRequest) (*models.Project, error) {
 	// Build dynamic update query
 	var project models.Project
-	err := r.pool.QueryRow(ctx, `
+	err := scanProject(r.pool.QueryRow(ctx, `
 		UPDATE projects
 		SET
 			name = COALESCE($2, name),
 			repository_url = COALESCE($3, repository_url),
-			default_branch = COALESCE($4, default_branch)
+			default_branch = COALESCE($4, default_branch),
+			diff_threshold = COALESCE($5, diff_threshold),
+			acceptable_diff_percentage = COALESCE($6, acceptable_diff_percentage),
+			ignore_antialiasing = COALESCE($7, ignore_antialiasing),
+			color_metric = COALESCE($8, color_metric),
+			change_criterion = COALESCE($9, change_criterion),
+			min_ssim = COALESCE($10, min_ssim),
+			min_region_pixels = COALESCE($11, min_region_pixels),
+			alignment = COALESCE($12, alignment)
 		WHERE id = $1
-		RETURNING id, name, slug, repository_url, default_branch, created_at, updated_at
-	`, id, req.Name, req.RepositoryURL, req.DefaultBranch).Scan(
-		&project.ID,
-		&project.Name,
-		&project.Slug,
-		&project.RepositoryURL,
-		&project.DefaultBranch,
-		&project.CreatedAt,
-		&project.UpdatedAt,
-	)
+		RETURNING `+projectColumns,
+		id, req.Name, req.RepositoryURL, req.DefaultBranch,
+		req.DiffThreshold, req.AcceptableDiffPercentage, req.IgnoreAntialiasing,
+		req.ColorMetric, req.ChangeCriterion, req.MinSSIM, req.MinRegionPixels, req.Alignment), &project)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update project: %w", err)
 	}
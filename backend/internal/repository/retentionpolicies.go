@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const retentionPolicyColumns = `project_id, keep_builds_per_branch, max_age_days, keep_baseline_sources, created_at, updated_at`
+
+func scanRetentionPolicy(row pgx.Row, policy *models.RetentionPolicy) error {
+	return row.Scan(
+		&policy.ProjectID,
+		&policy.KeepBuildsPerBranch,
+		&policy.MaxAgeDays,
+		&policy.KeepBaselineSources,
+		&policy.CreatedAt,
+		&policy.UpdatedAt,
+	)
+}
+
+type RetentionPolicyRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRetentionPolicyRepository(pool *pgxpool.Pool) *RetentionPolicyRepository {
+	return &RetentionPolicyRepository{pool: pool}
+}
+
+// Get returns a project's retention policy, or nil if it has none
+func (r *RetentionPolicyRepository) Get(ctx context.Context, projectID uuid.UUID) (*models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	err := scanRetentionPolicy(r.pool.QueryRow(ctx, `
+		SELECT `+retentionPolicyColumns+` FROM retention_policies WHERE project_id = $1
+	`, projectID), &policy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retention policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// Save creates or replaces a project's retention policy
+func (r *RetentionPolicyRepository) Save(ctx context.Context, policy models.RetentionPolicy) (*models.RetentionPolicy, error) {
+	var saved models.RetentionPolicy
+	err := scanRetentionPolicy(r.pool.QueryRow(ctx, `
+		INSERT INTO retention_policies (project_id, keep_builds_per_branch, max_age_days, keep_baseline_sources)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (project_id) DO UPDATE SET
+			keep_builds_per_branch = EXCLUDED.keep_builds_per_branch,
+			max_age_days = EXCLUDED.max_age_days,
+			keep_baseline_sources = EXCLUDED.keep_baseline_sources
+		RETURNING `+retentionPolicyColumns,
+		policy.ProjectID, policy.KeepBuildsPerBranch, policy.MaxAgeDays, policy.KeepBaselineSources), &saved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save retention policy: %w", err)
+	}
+
+	return &saved, nil
+}
+
+func (r *RetentionPolicyRepository) Delete(ctx context.Context, projectID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM retention_policies WHERE project_id = $1`, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete retention policy: %w", err)
+	}
+	return nil
+}
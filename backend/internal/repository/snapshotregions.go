@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const snapshotRegionColumns = `id, snapshot_id, position, x, y, width, height, pixels,
+	base_x, base_y, base_width, base_height,
+	comparison_x, comparison_y, comparison_width, comparison_height, created_at`
+
+func scanSnapshotRegion(row pgx.Row, region *models.SnapshotRegion) error {
+	var base, comparison [4]*int
+	err := row.Scan(
+		&region.ID,
+		&region.SnapshotID,
+		&region.Position,
+		&region.X,
+		&region.Y,
+		&region.Width,
+		&region.Height,
+		&region.Pixels,
+		&base[0], &base[1], &base[2], &base[3],
+		&comparison[0], &comparison[1], &comparison[2], &comparison[3],
+		&region.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	region.Base = nullableRect(base)
+	region.Comparison = nullableRect(comparison)
+	return nil
+}
+
+// nullableRect builds a rect from nullable x, y, width and height columns
+func nullableRect(columns [4]*int) *models.Rect {
+	for _, column := range columns {
+		if column == nil {
+			return nil
+		}
+	}
+	return &models.Rect{X: *columns[0], Y: *columns[1], Width: *columns[2], Height: *columns[3]}
+}
+
+// rectColumns splits a rect into nullable x, y, width and height values
+func rectColumns(rect *models.Rect) [4]*int {
+	if rect == nil {
+		return [4]*int{}
+	}
+	return [4]*int{&rect.X, &rect.Y, &rect.Width, &rect.Height}
+}
+
+type SnapshotRegionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSnapshotRegionRepository(pool *pgxpool.Pool) *SnapshotRegionRepository {
+	return &SnapshotRegionRepository{pool: pool}
+}
+
+// Replace swaps a snapshot's regions for the ones found by its latest diff,
+// numbering them in order
+func (r *SnapshotRegionRepository) Replace(ctx context.Context, snapshotID uuid.UUID, regions []models.SnapshotRegion) error {
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM snapshot_regions WHERE snapshot_id = $1`, snapshotID); err != nil {
+			return err
+		}
+
+		for position, region := range regions {
+			base, comparison := rectColumns(region.Base), rectColumns(region.Comparison)
+			_, err := tx.Exec(ctx, `
+				INSERT INTO snapshot_regions (snapshot_id, position, x, y, width, height, pixels,
+				                              base_x, base_y, base_width, base_height,
+				                              comparison_x, comparison_y, comparison_width, comparison_height)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			`, snapshotID, position, region.X, region.Y, region.Width, region.Height, region.Pixels,
+				base[0], base[1], base[2], base[3],
+				comparison[0], comparison[1], comparison[2], comparison[3])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace snapshot regions: %w", err)
+	}
+	return nil
+}
+
+// GetByID returns a region of a snapshot, or nil when the snapshot has no such region
+func (r *SnapshotRegionRepository) GetByID(ctx context.Context, snapshotID, id uuid.UUID) (*models.SnapshotRegion, error) {
+	var region models.SnapshotRegion
+	err := scanSnapshotRegion(r.pool.QueryRow(ctx, `
+		SELECT `+snapshotRegionColumns+` FROM snapshot_regions WHERE id = $1 AND snapshot_id = $2
+	`, id, snapshotID), &region)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot region: %w", err)
+	}
+
+	return &region, nil
+}
+
+// ListBySnapshot lists a snapshot's regions, largest first
+func (r *SnapshotRegionRepository) ListBySnapshot(ctx context.Context, snapshotID uuid.UUID) ([]models.SnapshotRegion, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+snapshotRegionColumns+`
+		FROM snapshot_regions
+		WHERE snapshot_id = $1
+		ORDER BY position ASC
+	`, snapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot regions: %w", err)
+	}
+	defer rows.Close()
+
+	var regions []models.SnapshotRegion
+	for rows.Next() {
+		var region models.SnapshotRegion
+		if err := scanSnapshotRegion(rows, &region); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot region: %w", err)
+		}
+		regions = append(regions, region)
+	}
+
+	return regions, rows.Err()
+}
@@ -2,35 +2,27 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/crzytrane/diffit/internal/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type SnapshotRepository struct {
-	pool *pgxpool.Pool
-}
-
-func NewSnapshotRepository(pool *pgxpool.Pool) *SnapshotRepository {
-	return &SnapshotRepository{pool: pool}
-}
+// snapshotColumns is the column list scanned by scanSnapshot
+const snapshotColumns = `id, build_id, baseline_id, base_snapshot_id, name, width, height, browser, viewport,
+	base_image_path, comparison_image_path, comparison_image_hash, diff_image_path, diff_percentage,
+	ssim, diff_region_count, diff_regions, change_type, status, failure_reason, review_status, reviewed_by, reviewed_by_user_id, reviewed_at, created_at, updated_at`
 
-func (r *SnapshotRepository) Create(ctx context.Context, req models.CreateSnapshotRequest) (*models.Snapshot, error) {
-	var snapshot models.Snapshot
-	err := r.pool.QueryRow(ctx, `
-		INSERT INTO snapshots (build_id, name, width, height, browser, viewport, status, review_status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, build_id, baseline_id, name, width, height, browser, viewport,
-		          base_image_path, comparison_image_path, diff_image_path, diff_percentage,
-		          status, review_status, reviewed_by, reviewed_at, created_at, updated_at
-	`, req.BuildID, req.Name, req.Width, req.Height, req.Browser, req.Viewport,
-		models.SnapshotStatusPending, models.ReviewStatusUnreviewed).Scan(
+func scanSnapshot(row pgx.Row, snapshot *models.Snapshot) error {
+	return row.Scan(
 		&snapshot.ID,
 		&snapshot.BuildID,
 		&snapshot.BaselineID,
+		&snapshot.BaseSnapshotID,
 		&snapshot.Name,
 		&snapshot.Width,
 		&snapshot.Height,
@@ -38,15 +30,62 @@ func (r *SnapshotRepository) Create(ctx context.Context, req models.CreateSnapsh
 		&snapshot.Viewport,
 		&snapshot.BaseImagePath,
 		&snapshot.ComparisonImagePath,
+		&snapshot.ComparisonImageHash,
 		&snapshot.DiffImagePath,
 		&snapshot.DiffPercentage,
+		&snapshot.SSIM,
+		&snapshot.DiffRegionCount,
+		&snapshot.DiffRegions,
+		&snapshot.ChangeType,
 		&snapshot.Status,
+		&snapshot.FailureReason,
 		&snapshot.ReviewStatus,
 		&snapshot.ReviewedBy,
+		&snapshot.ReviewedByUserID,
 		&snapshot.ReviewedAt,
 		&snapshot.CreatedAt,
 		&snapshot.UpdatedAt,
 	)
+}
+
+func scanSnapshots(rows pgx.Rows) ([]models.Snapshot, error) {
+	defer rows.Close()
+
+	var snapshots []models.Snapshot
+	for rows.Next() {
+		var snapshot models.Snapshot
+		if err := scanSnapshot(rows, &snapshot); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate snapshots: %w", err)
+	}
+
+	return snapshots, nil
+}
+
+type SnapshotRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSnapshotRepository(pool *pgxpool.Pool) *SnapshotRepository {
+	return &SnapshotRepository{pool: pool}
+}
+
+// Create creates a snapshot. When the request has an idempotency key already
+// used in the build, the snapshot created with it is returned instead.
+func (r *SnapshotRepository) Create(ctx context.Context, req models.CreateSnapshotRequest) (*models.Snapshot, error) {
+	var snapshot models.Snapshot
+	err := scanSnapshot(r.pool.QueryRow(ctx, `
+		INSERT INTO snapshots (build_id, name, width, height, browser, viewport, status, review_status, idempotency_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (build_id, idempotency_key) WHERE idempotency_key IS NOT NULL
+		DO UPDATE SET idempotency_key = EXCLUDED.idempotency_key
+		RETURNING `+snapshotColumns,
+		req.BuildID, req.Name, req.Width, req.Height, req.Browser, req.Viewport,
+		models.SnapshotStatusPending, models.ReviewStatusUnreviewed, req.IdempotencyKey), &snapshot)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create snapshot: %w", err)
 	}
@@ -54,33 +93,46 @@ func (r *SnapshotRepository) Create(ctx context.Context, req models.CreateSnapsh
 	return &snapshot, nil
 }
 
+// SnapshotUpload is a snapshot to create along with its stored comparison image
+type SnapshotUpload struct {
+	Request   models.CreateSnapshotRequest
+	ImagePath string
+	ImageHash string
+}
+
+// CreateWithImages creates snapshots that already have their comparison
+// images along with their diff jobs, all of them or none
+func (r *SnapshotRepository) CreateWithImages(ctx context.Context, uploads []SnapshotUpload, maxAttempts int) ([]models.Snapshot, error) {
+	snapshots := make([]models.Snapshot, len(uploads))
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		for i, upload := range uploads {
+			req := upload.Request
+			err := scanSnapshot(tx.QueryRow(ctx, `
+				INSERT INTO snapshots (build_id, name, width, height, browser, viewport,
+				                       comparison_image_path, comparison_image_hash, status, review_status)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+				RETURNING `+snapshotColumns,
+				req.BuildID, req.Name, req.Width, req.Height, req.Browser, req.Viewport,
+				upload.ImagePath, upload.ImageHash, models.SnapshotStatusPending, models.ReviewStatusUnreviewed), &snapshots[i])
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, enqueueDiffJob, snapshots[i].ID, maxAttempts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshots: %w", err)
+	}
+
+	return snapshots, nil
+}
+
 func (r *SnapshotRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Snapshot, error) {
 	var snapshot models.Snapshot
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, build_id, baseline_id, name, width, height, browser, viewport,
-		       base_image_path, comparison_image_path, diff_image_path, diff_percentage,
-		       status, review_status, reviewed_by, reviewed_at, created_at, updated_at
-		FROM snapshots WHERE id = $1
-	`, id).Scan(
-		&snapshot.ID,
-		&snapshot.BuildID,
-		&snapshot.BaselineID,
-		&snapshot.Name,
-		&snapshot.Width,
-		&snapshot.Height,
-		&snapshot.Browser,
-		&snapshot.Viewport,
-		&snapshot.BaseImagePath,
-		&snapshot.ComparisonImagePath,
-		&snapshot.DiffImagePath,
-		&snapshot.DiffPercentage,
-		&snapshot.Status,
-		&snapshot.ReviewStatus,
-		&snapshot.ReviewedBy,
-		&snapshot.ReviewedAt,
-		&snapshot.CreatedAt,
-		&snapshot.UpdatedAt,
-	)
+	err := scanSnapshot(r.pool.QueryRow(ctx, `SELECT `+snapshotColumns+` FROM snapshots WHERE id = $1`, id), &snapshot)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get snapshot: %w", err)
 	}
@@ -96,9 +148,7 @@ func (r *SnapshotRepository) ListByBuild(ctx context.Context, buildID uuid.UUID,
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, build_id, baseline_id, name, width, height, browser, viewport,
-		       base_image_path, comparison_image_path, diff_image_path, diff_percentage,
-		       status, review_status, reviewed_by, reviewed_at, created_at, updated_at
+		SELECT `+snapshotColumns+`
 		FROM snapshots
 		WHERE build_id = $1
 		ORDER BY name ASC
@@ -107,34 +157,10 @@ func (r *SnapshotRepository) ListByBuild(ctx context.Context, buildID uuid.UUID,
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to list snapshots: %w", err)
 	}
-	defer rows.Close()
 
-	var snapshots []models.Snapshot
-	for rows.Next() {
-		var snapshot models.Snapshot
-		if err := rows.Scan(
-			&snapshot.ID,
-			&snapshot.BuildID,
-			&snapshot.BaselineID,
-			&snapshot.Name,
-			&snapshot.Width,
-			&snapshot.Height,
-			&snapshot.Browser,
-			&snapshot.Viewport,
-			&snapshot.BaseImagePath,
-			&snapshot.ComparisonImagePath,
-			&snapshot.DiffImagePath,
-			&snapshot.DiffPercentage,
-			&snapshot.Status,
-			&snapshot.ReviewStatus,
-			&snapshot.ReviewedBy,
-			&snapshot.ReviewedAt,
-			&snapshot.CreatedAt,
-			&snapshot.UpdatedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan snapshot: %w", err)
-		}
-		snapshots = append(snapshots, snapshot)
+	snapshots, err := scanSnapshots(rows)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return snapshots, total, nil
@@ -154,9 +180,7 @@ func (r *SnapshotRepository) ListByBuildWithFilter(ctx context.Context, buildID
 	}
 
 	query := `
-		SELECT id, build_id, baseline_id, name, width, height, browser, viewport,
-		       base_image_path, comparison_image_path, diff_image_path, diff_percentage,
-		       status, review_status, reviewed_by, reviewed_at, created_at, updated_at
+		SELECT ` + snapshotColumns + `
 		FROM snapshots
 		WHERE build_id = $1
 	`
@@ -174,50 +198,61 @@ func (r *SnapshotRepository) ListByBuildWithFilter(ctx context.Context, buildID
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to list snapshots: %w", err)
 	}
-	defer rows.Close()
 
-	var snapshots []models.Snapshot
-	for rows.Next() {
-		var snapshot models.Snapshot
-		if err := rows.Scan(
-			&snapshot.ID,
-			&snapshot.BuildID,
-			&snapshot.BaselineID,
-			&snapshot.Name,
-			&snapshot.Width,
-			&snapshot.Height,
-			&snapshot.Browser,
-			&snapshot.Viewport,
-			&snapshot.BaseImagePath,
-			&snapshot.ComparisonImagePath,
-			&snapshot.DiffImagePath,
-			&snapshot.DiffPercentage,
-			&snapshot.Status,
-			&snapshot.ReviewStatus,
-			&snapshot.ReviewedBy,
-			&snapshot.ReviewedAt,
-			&snapshot.CreatedAt,
-			&snapshot.UpdatedAt,
-		); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan snapshot: %w", err)
-		}
-		snapshots = append(snapshots, snapshot)
+	snapshots, err := scanSnapshots(rows)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return snapshots, total, nil
 }
 
-func (r *SnapshotRepository) UpdateImagePaths(ctx context.Context, id uuid.UUID, baseImagePath, comparisonImagePath, diffImagePath *string, diffPercentage *float64) error {
+// SetDiffResult records the outcome of diffing a snapshot. Every value is
+// written as given, so a nil clears what an earlier diff left, like the diff
+// image of a snapshot that has since become new or resized.
+func (r *SnapshotRepository) SetDiffResult(ctx context.Context, id uuid.UUID, baseImagePath, diffImagePath *string, diffPercentage *float64) error {
 	_, err := r.pool.Exec(ctx, `
 		UPDATE snapshots
-		SET base_image_path = COALESCE($2, base_image_path),
-		    comparison_image_path = COALESCE($3, comparison_image_path),
-		    diff_image_path = COALESCE($4, diff_image_path),
-		    diff_percentage = COALESCE($5, diff_percentage)
+		SET base_image_path = $2, diff_image_path = $3, diff_percentage = $4
 		WHERE id = $1
-	`, id, baseImagePath, comparisonImagePath, diffImagePath, diffPercentage)
+	`, id, baseImagePath, diffImagePath, diffPercentage)
 	if err != nil {
-		return fmt.Errorf("failed to update snapshot image paths: %w", err)
+		return fmt.Errorf("failed to update snapshot diff result: %w", err)
+	}
+	return nil
+}
+
+// SetDiffMetrics records the perceptual metrics from a snapshot's diff
+func (r *SnapshotRepository) SetDiffMetrics(ctx context.Context, id uuid.UUID, ssim float64, regionCount int, regions []models.DiffRegion) error {
+	if regions == nil {
+		regions = []models.DiffRegion{}
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		UPDATE snapshots SET ssim = $2, diff_region_count = $3, diff_regions = $4 WHERE id = $1
+	`, id, ssim, regionCount, regions)
+	if err != nil {
+		return fmt.Errorf("failed to update snapshot diff metrics: %w", err)
+	}
+	return nil
+}
+
+// SetComparisonImage records the uploaded image and its content hash and adds
+// a diff job for the snapshot, in one transaction so a snapshot never has an
+// image without a job
+func (r *SnapshotRepository) SetComparisonImage(ctx context.Context, id uuid.UUID, path, hash string, maxAttempts int) error {
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE snapshots SET comparison_image_path = $2, comparison_image_hash = $3 WHERE id = $1
+		`, id, path, hash)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, enqueueDiffJob, id, maxAttempts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update snapshot comparison image: %w", err)
 	}
 	return nil
 }
@@ -230,13 +265,23 @@ func (r *SnapshotRepository) UpdateStatus(ctx context.Context, id uuid.UUID, sta
 	return nil
 }
 
+// UpdateStatusWithReason updates the processing status and records why the
+// last processing attempt failed. A nil reason clears any previous failure.
+func (r *SnapshotRepository) UpdateStatusWithReason(ctx context.Context, id uuid.UUID, status models.SnapshotStatus, reason *string) error {
+	_, err := r.pool.Exec(ctx, `UPDATE snapshots SET status = $2, failure_reason = $3 WHERE id = $1`, id, status, reason)
+	if err != nil {
+		return fmt.Errorf("failed to update snapshot status: %w", err)
+	}
+	return nil
+}
+
 func (r *SnapshotRepository) UpdateReviewStatus(ctx context.Context, id uuid.UUID, req models.ReviewSnapshotRequest) error {
 	now := time.Now()
 	_, err := r.pool.Exec(ctx, `
 		UPDATE snapshots
-		SET review_status = $2, reviewed_by = $3, reviewed_at = $4
+		SET review_status = $2, reviewed_by = $3, reviewed_by_user_id = $4, reviewed_at = $5
 		WHERE id = $1
-	`, id, req.ReviewStatus, req.ReviewedBy, now)
+	`, id, req.ReviewStatus, req.ReviewedBy, req.ReviewedByUserID, now)
 	if err != nil {
 		return fmt.Errorf("failed to update review status: %w", err)
 	}
@@ -247,15 +292,32 @@ func (r *SnapshotRepository) BatchUpdateReviewStatus(ctx context.Context, req mo
 	now := time.Now()
 	_, err := r.pool.Exec(ctx, `
 		UPDATE snapshots
-		SET review_status = $2, reviewed_by = $3, reviewed_at = $4
+		SET review_status = $2, reviewed_by = $3, reviewed_by_user_id = $4, reviewed_at = $5
 		WHERE id = ANY($1)
-	`, req.SnapshotIDs, req.ReviewStatus, req.ReviewedBy, now)
+	`, req.SnapshotIDs, req.ReviewStatus, req.ReviewedBy, req.ReviewedByUserID, now)
 	if err != nil {
 		return fmt.Errorf("failed to batch update review status: %w", err)
 	}
 	return nil
 }
 
+// ReviewRemaining sets the review status of every new, changed, resized or removed
+// snapshot in a build that is still unreviewed and returns them
+func (r *SnapshotRepository) ReviewRemaining(ctx context.Context, buildID uuid.UUID, req models.ReviewSnapshotRequest) ([]models.Snapshot, error) {
+	rows, err := r.pool.Query(ctx, `
+		UPDATE snapshots
+		SET review_status = $2, reviewed_by = $3, reviewed_by_user_id = $4, reviewed_at = NOW()
+		WHERE build_id = $1 AND review_status = $5 AND change_type IN ($6, $7, $8, $9)
+		RETURNING `+snapshotColumns,
+		buildID, req.ReviewStatus, req.ReviewedBy, req.ReviewedByUserID, models.ReviewStatusUnreviewed,
+		models.ChangeTypeNew, models.ChangeTypeChanged, models.ChangeTypeResized, models.ChangeTypeRemoved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to review remaining snapshots: %w", err)
+	}
+
+	return scanSnapshots(rows)
+}
+
 func (r *SnapshotRepository) SetBaseline(ctx context.Context, id uuid.UUID, baselineID uuid.UUID) error {
 	_, err := r.pool.Exec(ctx, `UPDATE snapshots SET baseline_id = $2 WHERE id = $1`, id, baselineID)
 	if err != nil {
@@ -264,49 +326,137 @@ func (r *SnapshotRepository) SetBaseline(ctx context.Context, id uuid.UUID, base
 	return nil
 }
 
+// SetChangeType records how a processed snapshot differs from its baseline
+func (r *SnapshotRepository) SetChangeType(ctx context.Context, id uuid.UUID, changeType models.ChangeType) error {
+	_, err := r.pool.Exec(ctx, `UPDATE snapshots SET change_type = $2 WHERE id = $1`, id, changeType)
+	if err != nil {
+		return fmt.Errorf("failed to set change type: %w", err)
+	}
+	return nil
+}
+
+/*
+CreateRemoved adds a removed snapshot to a build for every baseline in use on
+its branch that the build has no snapshot for. branches is the chain the
+build's baselines are looked up in, starting with its own branch; the first
+branch with a baseline for a name wins, as it does when diffing. Calling it
+again for the same build adds nothing new. Returns the number of removed
+snapshots created.
+*/
+func (r *SnapshotRepository) CreateRemoved(ctx context.Context, build *models.Build, branches []string) (int, error) {
+	tag, err := r.pool.Exec(ctx, `
+		INSERT INTO snapshots (build_id, baseline_id, name, width, height, browser, viewport,
+		                       base_image_path, change_type, status)
+		SELECT $1, b.id, b.name, b.width, b.height, b.browser, b.viewport, b.image_path, $4, $5
+		FROM (
+			SELECT DISTINCT ON (name, browser, viewport) *
+			FROM baselines
+			WHERE project_id = $2 AND branch = ANY($3::text[])
+			ORDER BY name, browser, viewport, array_position($3::text[], branch::text)
+		) AS b
+		WHERE NOT EXISTS (
+			SELECT 1 FROM snapshots s
+			WHERE s.build_id = $1 AND s.name = b.name
+			  AND s.browser IS NOT DISTINCT FROM b.browser
+			  AND s.viewport IS NOT DISTINCT FROM b.viewport
+		)
+	`, build.ID, build.ProjectID, branches, models.ChangeTypeRemoved, models.SnapshotStatusCompleted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create removed snapshots: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+/*
+CreateRemovedFromBase is CreateRemoved for a build compared against a base
+build: every screenshot the base build has that the build doesn't is added as
+a removed snapshot pointing at the base build's snapshot and the baseline it
+was diffed against, the same base a diff of it would have had. Calling it
+again for the same build adds nothing new. Returns the number of removed
+snapshots created.
+*/
+func (r *SnapshotRepository) CreateRemovedFromBase(ctx context.Context, build *models.Build, baseBuildID uuid.UUID) (int, error) {
+	tag, err := r.pool.Exec(ctx, `
+		INSERT INTO snapshots (build_id, baseline_id, base_snapshot_id, name, width, height, browser, viewport,
+		                       base_image_path, change_type, status)
+		SELECT $1, b.baseline_id, b.id, b.name, b.width, b.height, b.browser, b.viewport,
+		       b.comparison_image_path, $3, $4
+		FROM (
+			SELECT DISTINCT ON (name, browser, viewport) *
+			FROM snapshots
+			WHERE build_id = $2 AND comparison_image_path IS NOT NULL
+			ORDER BY name, browser, viewport, created_at DESC
+		) AS b
+		WHERE NOT EXISTS (
+			SELECT 1 FROM snapshots s
+			WHERE s.build_id = $1 AND s.name = b.name
+			  AND s.browser IS NOT DISTINCT FROM b.browser
+			  AND s.viewport IS NOT DISTINCT FROM b.viewport
+		)
+	`, build.ID, baseBuildID, models.ChangeTypeRemoved, models.SnapshotStatusCompleted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create removed snapshots: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// SetBaseSnapshot records the snapshot from the base build this one was diffed against
+func (r *SnapshotRepository) SetBaseSnapshot(ctx context.Context, id uuid.UUID, baseSnapshotID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `UPDATE snapshots SET base_snapshot_id = $2 WHERE id = $1`, id, baseSnapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to set base snapshot: %w", err)
+	}
+	return nil
+}
+
+// FindInBuild finds the snapshot with an image for a name, browser and
+// viewport in a build, the most recent upload if there are several. Returns
+// nil if the build has no such snapshot.
+func (r *SnapshotRepository) FindInBuild(ctx context.Context, buildID uuid.UUID, name string, browser, viewport *string) (*models.Snapshot, error) {
+	var snapshot models.Snapshot
+	err := scanSnapshot(r.pool.QueryRow(ctx, `
+		SELECT `+snapshotColumns+`
+		FROM snapshots
+		WHERE build_id = $1 AND name = $2
+		  AND browser IS NOT DISTINCT FROM $3 AND viewport IS NOT DISTINCT FROM $4
+		  AND comparison_image_path IS NOT NULL
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, buildID, name, browser, viewport), &snapshot)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find snapshot in build: %w", err)
+	}
+
+	return &snapshot, nil
+}
+
 func (r *SnapshotRepository) GetChangedSnapshots(ctx context.Context, buildID uuid.UUID) ([]models.Snapshot, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, build_id, baseline_id, name, width, height, browser, viewport,
-		       base_image_path, comparison_image_path, diff_image_path, diff_percentage,
-		       status, review_status, reviewed_by, reviewed_at, created_at, updated_at
+		SELECT `+snapshotColumns+`
 		FROM snapshots
-		WHERE build_id = $1 AND (diff_percentage > 0 OR baseline_id IS NULL)
+		WHERE build_id = $1 AND change_type IN ($2, $3, $4, $5)
 		ORDER BY name ASC
-	`, buildID)
+	`, buildID, models.ChangeTypeNew, models.ChangeTypeChanged, models.ChangeTypeResized, models.ChangeTypeRemoved)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get changed snapshots: %w", err)
 	}
-	defer rows.Close()
 
-	var snapshots []models.Snapshot
-	for rows.Next() {
-		var snapshot models.Snapshot
-		if err := rows.Scan(
-			&snapshot.ID,
-			&snapshot.BuildID,
-			&snapshot.BaselineID,
-			&snapshot.Name,
-			&snapshot.Width,
-			&snapshot.Height,
-			&snapshot.Browser,
-			&snapshot.Viewport,
-			&snapshot.BaseImagePath,
-			&snapshot.ComparisonImagePath,
-			&snapshot.DiffImagePath,
-			&snapshot.DiffPercentage,
-			&snapshot.Status,
-			&snapshot.ReviewStatus,
-			&snapshot.ReviewedBy,
-			&snapshot.ReviewedAt,
-			&snapshot.CreatedAt,
-			&snapshot.UpdatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
-		}
-		snapshots = append(snapshots, snapshot)
-	}
+	return scanSnapshots(rows)
+}
 
-	return snapshots, nil
+// CountUnprocessed counts snapshots in a build that are still waiting for a diff
+func (r *SnapshotRepository) CountUnprocessed(ctx context.Context, buildID uuid.UUID) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM snapshots WHERE build_id = $1 AND status IN ($2, $3)
+	`, buildID, models.SnapshotStatusPending, models.SnapshotStatusProcessing).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unprocessed snapshots: %w", err)
+	}
+	return count, nil
 }
 
 func (r *SnapshotRepository) Delete(ctx context.Context, id uuid.UUID) error {
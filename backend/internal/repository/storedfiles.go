@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// referencedPaths selects every storage path of project $1 that a row points
+// at, ignoring the snapshots of the builds in $2. Content-addressed images are
+// included through image_blobs, so only the image sweeper removes them.
+const referencedPaths = `
+	SELECT s.base_image_path::text AS path FROM snapshots s JOIN builds b ON b.id = s.build_id
+	WHERE b.project_id = $1 AND s.base_image_path IS NOT NULL AND b.id <> ALL($2::uuid[])
+	UNION
+	SELECT s.comparison_image_path::text FROM snapshots s JOIN builds b ON b.id = s.build_id
+	WHERE b.project_id = $1 AND s.comparison_image_path IS NOT NULL AND b.id <> ALL($2::uuid[])
+	UNION
+	SELECT s.diff_image_path::text FROM snapshots s JOIN builds b ON b.id = s.build_id
+	WHERE b.project_id = $1 AND s.diff_image_path IS NOT NULL AND b.id <> ALL($2::uuid[])
+	UNION
+	SELECT image_path::text FROM baselines WHERE project_id = $1
+	UNION
+	SELECT bv.image_path::text FROM baseline_versions bv JOIN baselines bl ON bl.id = bv.baseline_id
+	WHERE bl.project_id = $1
+	UNION
+	SELECT path::text FROM image_blobs WHERE project_id = $1`
+
+// StoredFileRepository answers which files in storage the database still uses
+type StoredFileRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewStoredFileRepository(pool *pgxpool.Pool) *StoredFileRepository {
+	return &StoredFileRepository{pool: pool}
+}
+
+// ListReferenced returns the set of a project's storage paths that rows point
+// at, as if the builds in without had already been deleted
+func (r *StoredFileRepository) ListReferenced(ctx context.Context, projectID uuid.UUID, without []uuid.UUID) (map[string]struct{}, error) {
+	rows, err := r.pool.Query(ctx, referencedPaths, projectID, uuidArray(without))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referenced files: %w", err)
+	}
+	defer rows.Close()
+
+	paths := map[string]struct{}{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan referenced file: %w", err)
+		}
+		paths[path] = struct{}{}
+	}
+
+	return paths, rows.Err()
+}
+
+// Unreferenced returns the paths in paths that no row of the project points at
+func (r *StoredFileRepository) Unreferenced(ctx context.Context, projectID uuid.UUID, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT unnest($3::text[]) EXCEPT (`+referencedPaths+`)
+	`, projectID, []uuid.UUID{}, paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check file references: %w", err)
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan unreferenced file: %w", err)
+		}
+		unreferenced = append(unreferenced, path)
+	}
+
+	return unreferenced, rows.Err()
+}
+
+// ListBuildFiles returns the paths the snapshots of a build point at
+func (r *StoredFileRepository) ListBuildFiles(ctx context.Context, buildID uuid.UUID) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT DISTINCT path FROM snapshots s,
+			unnest(ARRAY[s.base_image_path::text, s.comparison_image_path::text, s.diff_image_path::text]) AS path
+		WHERE s.build_id = $1 AND path IS NOT NULL
+	`, buildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list build files: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan build file: %w", err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, rows.Err()
+}
+
+// ListSnapshotIDs returns the set of a project's snapshot IDs, which own its
+// derived files, leaving out the snapshots of the builds in without
+func (r *StoredFileRepository) ListSnapshotIDs(ctx context.Context, projectID uuid.UUID, without []uuid.UUID) (map[uuid.UUID]struct{}, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT s.id FROM snapshots s JOIN builds b ON b.id = s.build_id
+		WHERE b.project_id = $1 AND b.id <> ALL($2::uuid[])
+	`, projectID, uuidArray(without))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot IDs: %w", err)
+	}
+	defer rows.Close()
+
+	ids := map[uuid.UUID]struct{}{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot ID: %w", err)
+		}
+		ids[id] = struct{}{}
+	}
+
+	return ids, rows.Err()
+}
+
+// uuidArray is ids as a query argument, never nil so it isn't sent as NULL
+func uuidArray(ids []uuid.UUID) []uuid.UUID {
+	if ids == nil {
+		return []uuid.UUID{}
+	}
+	return ids
+}
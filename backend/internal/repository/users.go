@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const userColumns = `id, email, name, is_admin, created_at, updated_at`
+
+func scanUser(row pgx.Row, user *models.User, extra ...any) error {
+	return row.Scan(append([]any{
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.IsAdmin,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	}, extra...)...)
+}
+
+type UserRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewUserRepository(pool *pgxpool.Pool) *UserRepository {
+	return &UserRepository{pool: pool}
+}
+
+func (r *UserRepository) Create(ctx context.Context, email, name, passwordHash string, isAdmin bool) (*models.User, error) {
+	var user models.User
+	err := scanUser(r.pool.QueryRow(ctx, `
+		INSERT INTO users (email, name, password_hash, is_admin)
+		VALUES (LOWER($1), $2, $3, $4)
+		RETURNING `+userColumns,
+		email, name, passwordHash, isAdmin), &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// CreateFirst creates a site admin, but only if there are no users yet.
+// Returns nil if another user already exists.
+func (r *UserRepository) CreateFirst(ctx context.Context, email, name, passwordHash string) (*models.User, error) {
+	var user models.User
+	err := scanUser(r.pool.QueryRow(ctx, `
+		INSERT INTO users (email, name, password_hash, is_admin)
+		SELECT LOWER($1), $2, $3, TRUE
+		WHERE NOT EXISTS (SELECT 1 FROM users)
+		RETURNING `+userColumns,
+		email, name, passwordHash), &user)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id), &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	err := scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = LOWER($1)`, email), &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by email: %w", err)
+	}
+
+	return &user, nil
+}
+
+// GetCredentials returns a user and their password hash, or nil if there is no such user
+func (r *UserRepository) GetCredentials(ctx context.Context, email string) (*models.User, string, error) {
+	var user models.User
+	var passwordHash string
+	err := scanUser(r.pool.QueryRow(ctx, `
+		SELECT `+userColumns+`, password_hash FROM users WHERE email = LOWER($1)
+	`, email), &user, &passwordHash)
+	if err == pgx.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get user credentials: %w", err)
+	}
+
+	return &user, passwordHash, nil
+}
+
+func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+userColumns+` FROM users ORDER BY email ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+type SessionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSessionRepository(pool *pgxpool.Pool) *SessionRepository {
+	return &SessionRepository{pool: pool}
+}
+
+func (r *SessionRepository) Create(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO sessions (user_id, token_hash, expires_at) VALUES ($1, $2, $3)
+	`, userID, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetUser returns the user an unexpired session belongs to, or nil
+func (r *SessionRepository) GetUser(ctx context.Context, tokenHash string) (*models.User, error) {
+	var user models.User
+	err := scanUser(r.pool.QueryRow(ctx, `
+		SELECT u.id, u.email, u.name, u.is_admin, u.created_at, u.updated_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.expires_at > NOW()
+	`, tokenHash), &user)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *SessionRepository) Delete(ctx context.Context, tokenHash string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	return nil
+}
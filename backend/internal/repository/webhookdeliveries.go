@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const webhookDeliveryColumns = `id, webhook_id, event, event_id, payload, status, attempts, max_attempts,
+	response_status, response_body, last_error, run_at, locked_at, delivered_at, redelivery_of,
+	created_at, updated_at`
+
+func scanWebhookDelivery(row pgx.Row, delivery *models.WebhookDelivery) error {
+	return row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&delivery.EventID,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.MaxAttempts,
+		&delivery.ResponseStatus,
+		&delivery.ResponseBody,
+		&delivery.LastError,
+		&delivery.RunAt,
+		&delivery.LockedAt,
+		&delivery.DeliveredAt,
+		&delivery.RedeliveryOf,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	)
+}
+
+// WebhookDeliveryRepository is both the delivery log and the queue the
+// webhook workers claim from
+type WebhookDeliveryRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebhookDeliveryRepository(pool *pgxpool.Pool) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{pool: pool}
+}
+
+// Enqueue adds a delivery that is ready to send immediately. redeliveryOf
+// links a manual resend to the delivery it was copied from.
+func (r *WebhookDeliveryRepository) Enqueue(ctx context.Context, webhookID uuid.UUID, event models.EventType, eventID uuid.UUID, payload []byte, maxAttempts int, redeliveryOf *uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := scanWebhookDelivery(r.pool.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, event_id, payload, status, max_attempts, redelivery_of)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+webhookDeliveryColumns,
+		webhookID, event, eventID, payload, models.WebhookDeliveryStatusPending, maxAttempts, redeliveryOf), &delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enqueue webhook delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
+
+func (r *WebhookDeliveryRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := scanWebhookDelivery(r.pool.QueryRow(ctx, `
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = $1
+	`, id), &delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
+
+// ListByWebhook returns a webhook's most recent deliveries, newest first
+func (r *WebhookDeliveryRepository) ListByWebhook(ctx context.Context, webhookID uuid.UUID, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanWebhookDelivery(rows, &delivery); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// Claim locks the next delivery that is due and marks it as running.
+// Deliveries running for longer than staleAfter are claimed again. Returns
+// nil when there is nothing to do.
+func (r *WebhookDeliveryRepository) Claim(ctx context.Context, staleAfter time.Duration) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := scanWebhookDelivery(r.pool.QueryRow(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = attempts + 1, locked_at = NOW()
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE (status = $2 AND run_at <= NOW())
+			   OR (status = $1 AND locked_at < NOW() - $3::interval)
+			ORDER BY run_at ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns,
+		models.WebhookDeliveryStatusRunning, models.WebhookDeliveryStatusPending, staleAfter.String()), &delivery)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
+
+// Succeed records a 2xx response
+func (r *WebhookDeliveryRepository) Succeed(ctx context.Context, id uuid.UUID, responseStatus int, responseBody string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, response_status = $3, response_body = $4, last_error = NULL,
+		    locked_at = NULL, delivered_at = NOW()
+		WHERE id = $1
+	`, id, models.WebhookDeliveryStatusSucceeded, responseStatus, responseBody)
+	if err != nil {
+		return fmt.Errorf("failed to complete webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// Retry records a failed attempt and puts the delivery back in the queue to
+// be sent again at runAt. responseStatus is nil when no response was received.
+func (r *WebhookDeliveryRepository) Retry(ctx context.Context, id uuid.UUID, responseStatus *int, responseBody *string, lastError string, runAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, response_status = $3, response_body = $4, last_error = $5,
+		    run_at = $6, locked_at = NULL
+		WHERE id = $1
+	`, id, models.WebhookDeliveryStatusPending, responseStatus, responseBody, lastError, runAt)
+	if err != nil {
+		return fmt.Errorf("failed to retry webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// Fail records the last failed attempt and gives up on the delivery
+func (r *WebhookDeliveryRepository) Fail(ctx context.Context, id uuid.UUID, responseStatus *int, responseBody *string, lastError string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, response_status = $3, response_body = $4, last_error = $5, locked_at = NULL
+		WHERE id = $1
+	`, id, models.WebhookDeliveryStatusFailed, responseStatus, responseBody, lastError)
+	if err != nil {
+		return fmt.Errorf("failed to fail webhook delivery: %w", err)
+	}
+	return nil
+}
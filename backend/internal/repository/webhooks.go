@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const webhookColumns = `id, project_id, url, secret, events, enabled, created_at, updated_at`
+
+func scanWebhook(row pgx.Row, webhook *models.Webhook) error {
+	var events []string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.ProjectID,
+		&webhook.URL,
+		&webhook.Secret,
+		&events,
+		&webhook.Enabled,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	webhook.Events = make([]models.EventType, len(events))
+	for i, event := range events {
+		webhook.Events[i] = models.EventType(event)
+	}
+	return nil
+}
+
+func scanWebhooks(rows pgx.Rows) ([]models.Webhook, error) {
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		var webhook models.Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// eventNames converts event types to the text[] stored in the database
+func eventNames(events []models.EventType) []string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = string(event)
+	}
+	return names
+}
+
+type WebhookRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebhookRepository(pool *pgxpool.Pool) *WebhookRepository {
+	return &WebhookRepository{pool: pool}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, projectID uuid.UUID, url, secret string, events []models.EventType, enabled bool) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := scanWebhook(r.pool.QueryRow(ctx, `
+		INSERT INTO webhooks (project_id, url, secret, events, enabled)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+webhookColumns,
+		projectID, url, secret, eventNames(events), enabled), &webhook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+func (r *WebhookRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := scanWebhook(r.pool.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id), &webhook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+func (r *WebhookRepository) ListByProject(ctx context.Context, projectID uuid.UUID) ([]models.Webhook, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks
+		WHERE project_id = $1
+		ORDER BY created_at ASC
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	return scanWebhooks(rows)
+}
+
+// ListSubscribed returns a project's enabled webhooks that want an event
+func (r *WebhookRepository) ListSubscribed(ctx context.Context, projectID uuid.UUID, event models.EventType) ([]models.Webhook, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks
+		WHERE project_id = $1 AND enabled
+		  AND (cardinality(events) = 0 OR $2 = ANY(events))
+		ORDER BY created_at ASC
+	`, projectID, string(event))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscribed webhooks: %w", err)
+	}
+
+	return scanWebhooks(rows)
+}
+
+func (r *WebhookRepository) Update(ctx context.Context, id uuid.UUID, url, secret string, events []models.EventType, enabled bool) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := scanWebhook(r.pool.QueryRow(ctx, `
+		UPDATE webhooks
+		SET url = $2, secret = $3, events = $4, enabled = $5
+		WHERE id = $1
+		RETURNING `+webhookColumns,
+		id, url, secret, eventNames(events), enabled), &webhook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+func (r *WebhookRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
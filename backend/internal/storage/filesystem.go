@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+var _ Storage = (*FileSystem)(nil)
+
+// FileSystem stores files in a directory on the local disk
+type FileSystem struct {
+	basePath string
+}
+
+// NewFileSystem creates a new FileSystem storage rooted at basePath
+func NewFileSystem(basePath string) (*FileSystem, error) {
+	// Ensure base directory exists
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	return &FileSystem{basePath: basePath}, nil
+}
+
+// SaveFile saves a file and returns the relative path
+func (s *FileSystem) SaveFile(projectID uuid.UUID, storageType StorageType, filename string, reader io.Reader) (string, error) {
+	// Create directory structure: basePath/projectID/storageType/
+	dir := filepath.Join(s.basePath, projectID.String(), string(storageType))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	uniqueFilename := uniqueName(filename)
+	fullPath := filepath.Join(dir, uniqueFilename)
+
+	// Create file
+	file, err := os.Create(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	// Copy content
+	if _, err := io.Copy(file, reader); err != nil {
+		os.Remove(fullPath)
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+
+	// Return relative path from base
+	relativePath := Key(projectID, storageType, uniqueFilename)
+	return relativePath, nil
+}
+
+// SaveFileWithName saves a file with a specific name (for baselines)
+func (s *FileSystem) SaveFileWithName(projectID uuid.UUID, storageType StorageType, filename string, reader io.Reader) (string, error) {
+	// filename may contain slashes, so create its parent rather than the type directory
+	fullPath := s.fullPath(Key(projectID, storageType, filename))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	file, err := os.Create(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		os.Remove(fullPath)
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+
+	relativePath := Key(projectID, storageType, filename)
+	return relativePath, nil
+}
+
+// GetFile returns a reader for the given path
+func (s *FileSystem) GetFile(relativePath string) (io.ReadCloser, error) {
+	fullPath := s.fullPath(relativePath)
+	file, err := os.Open(fullPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open file: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// DeleteFile removes a file
+func (s *FileSystem) DeleteFile(relativePath string) error {
+	fullPath := s.fullPath(relativePath)
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// DeleteDir removes a directory and everything in it
+func (s *FileSystem) DeleteDir(relativePath string) error {
+	if err := os.RemoveAll(s.fullPath(relativePath)); err != nil {
+		return fmt.Errorf("failed to delete directory: %w", err)
+	}
+	return nil
+}
+
+// DeleteProjectFiles removes all files for a project
+func (s *FileSystem) DeleteProjectFiles(projectID uuid.UUID) error {
+	dir := filepath.Join(s.basePath, projectID.String())
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to delete project files: %w", err)
+	}
+	return nil
+}
+
+// ListProjectFiles walks a project's directory
+func (s *FileSystem) ListProjectFiles(projectID uuid.UUID) ([]FileInfo, error) {
+	var files []FileInfo
+	root := filepath.Join(s.basePath, projectID.String())
+
+	err := filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(s.basePath, fullPath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, FileInfo{
+			Path:    filepath.ToSlash(relativePath),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project files: %w", err)
+	}
+
+	return files, nil
+}
+
+// CopyFile copies a file from one path to another
+func (s *FileSystem) CopyFile(srcRelativePath string, projectID uuid.UUID, storageType StorageType, filename string) (string, error) {
+	srcFile, err := s.GetFile(srcRelativePath)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+
+	return s.SaveFile(projectID, storageType, filename, srcFile)
+}
+
+// Exists checks if a file exists
+func (s *FileSystem) Exists(relativePath string) bool {
+	fullPath := s.fullPath(relativePath)
+	_, err := os.Stat(fullPath)
+	return err == nil
+}
+
+// fullPath returns the location on disk of a relative storage path
+func (s *FileSystem) fullPath(relativePath string) string {
+	return filepath.Join(s.basePath, filepath.FromSlash(relativePath))
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var _ Storage = (*Memory)(nil)
+
+// Memory keeps files in memory. It's meant for tests of code built on
+// Storage, nothing survives the process.
+type Memory struct {
+	mu    sync.Mutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory creates an empty Memory storage
+func NewMemory() *Memory {
+	return &Memory{files: map[string]memoryFile{}}
+}
+
+// SaveFile saves a file and returns the relative path
+func (s *Memory) SaveFile(projectID uuid.UUID, storageType StorageType, filename string, reader io.Reader) (string, error) {
+	return s.SaveFileWithName(projectID, storageType, uniqueName(filename), reader)
+}
+
+// SaveFileWithName saves a file with a specific name (for baselines)
+func (s *Memory) SaveFileWithName(projectID uuid.UUID, storageType StorageType, filename string, reader io.Reader) (string, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+
+	relativePath := Key(projectID, storageType, filename)
+	s.put(relativePath, data)
+	return relativePath, nil
+}
+
+// GetFile returns a reader for the given path
+func (s *Memory) GetFile(relativePath string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	file, ok := s.files[relativePath]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("failed to open file: %w", ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// DeleteFile removes a file
+func (s *Memory) DeleteFile(relativePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, relativePath)
+	return nil
+}
+
+// DeleteDir removes every file under a directory
+func (s *Memory) DeleteDir(relativePath string) error {
+	s.deletePrefix(strings.TrimSuffix(relativePath, "/") + "/")
+	return nil
+}
+
+// DeleteProjectFiles removes all files for a project
+func (s *Memory) DeleteProjectFiles(projectID uuid.UUID) error {
+	s.deletePrefix(projectID.String() + "/")
+	return nil
+}
+
+// ListProjectFiles lists a project's files sorted by path
+func (s *Memory) ListProjectFiles(projectID uuid.UUID) ([]FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := projectID.String() + "/"
+	var files []FileInfo
+	for relativePath, file := range s.files {
+		if strings.HasPrefix(relativePath, prefix) {
+			files = append(files, FileInfo{Path: relativePath, Size: int64(len(file.data)), ModTime: file.modTime})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return files, nil
+}
+
+// CopyFile copies a file from one path to another
+func (s *Memory) CopyFile(srcRelativePath string, projectID uuid.UUID, storageType StorageType, filename string) (string, error) {
+	s.mu.Lock()
+	file, ok := s.files[srcRelativePath]
+	s.mu.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("failed to copy file: %w", ErrNotFound)
+	}
+
+	relativePath := Key(projectID, storageType, uniqueName(filename))
+	s.put(relativePath, file.data)
+	return relativePath, nil
+}
+
+// Exists checks if a file exists
+func (s *Memory) Exists(relativePath string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.files[relativePath]
+	return ok
+}
+
+// SetModTime changes when a file was last modified, letting tests age files
+func (s *Memory) SetModTime(relativePath string, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if file, ok := s.files[relativePath]; ok {
+		file.modTime = modTime
+		s.files[relativePath] = file
+	}
+}
+
+func (s *Memory) put(relativePath string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[relativePath] = memoryFile{data: data, modTime: time.Now()}
+}
+
+func (s *Memory) deletePrefix(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for relativePath := range s.files {
+		if strings.HasPrefix(relativePath, prefix) {
+			delete(s.files, relativePath)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var _ Storage = (*S3)(nil)
+
+// S3Options configures an S3-compatible storage backend
+type S3Options struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.amazonaws.com
+	// or http://localhost:9000 for MinIO
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every object key, allowing several deployments to share a bucket
+	Prefix string
+	// UsePathStyle addresses the bucket as endpoint/bucket/key instead of
+	// bucket.endpoint/key, which most self-hosted services need
+	UsePathStyle bool
+	// Timeout bounds each request to the service
+	Timeout time.Duration
+	// Client is the HTTP client used for requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// S3 stores files in a bucket of an S3-compatible object store. Requests are
+// signed with AWS Signature Version 4.
+type S3 struct {
+	endpoint *url.URL
+	options  S3Options
+	client   *http.Client
+}
+
+// NewS3 creates a new S3 storage
+func NewS3(options S3Options) (*S3, error) {
+	if options.Endpoint == "" || options.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage needs an endpoint and a bucket")
+	}
+
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", options.Endpoint)
+	}
+
+	if options.Region == "" {
+		options.Region = "us-east-1"
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	options.Prefix = strings.Trim(options.Prefix, "/")
+
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &S3{endpoint: endpoint, options: options, client: client}, nil
+}
+
+// SaveFile saves a file and returns the relative path
+func (s *S3) SaveFile(projectID uuid.UUID, storageType StorageType, filename string, reader io.Reader) (string, error) {
+	return s.SaveFileWithName(projectID, storageType, uniqueName(filename), reader)
+}
+
+// SaveFileWithName saves a file with a specific name (for baselines)
+func (s *S3) SaveFileWithName(projectID uuid.UUID, storageType StorageType, filename string, reader io.Reader) (string, error) {
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	relativePath := Key(projectID, storageType, filename)
+
+	header := http.Header{}
+	if contentType := mime.TypeByExtension(path.Ext(filename)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(http.MethodPut, relativePath, nil, header, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	resp.Body.Close()
+
+	return relativePath, nil
+}
+
+// GetFile returns a reader for the given path
+func (s *S3) GetFile(relativePath string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, relativePath, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// DeleteFile removes a file
+func (s *S3) DeleteFile(relativePath string) error {
+	resp, err := s.do(http.MethodDelete, relativePath, nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteDir removes every object under a directory's prefix
+func (s *S3) DeleteDir(relativePath string) error {
+	files, err := s.list(strings.TrimSuffix(relativePath, "/") + "/")
+	if err != nil {
+		return fmt.Errorf("failed to delete directory: %w", err)
+	}
+
+	for _, file := range files {
+		if err := s.DeleteFile(file.Path); err != nil {
+			return fmt.Errorf("failed to delete directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeleteProjectFiles removes all files for a project
+func (s *S3) DeleteProjectFiles(projectID uuid.UUID) error {
+	files, err := s.list(projectID.String() + "/")
+	if err != nil {
+		return fmt.Errorf("failed to delete project files: %w", err)
+	}
+
+	for _, file := range files {
+		if err := s.DeleteFile(file.Path); err != nil {
+			return fmt.Errorf("failed to delete project files: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListProjectFiles lists the objects under a project's prefix
+func (s *S3) ListProjectFiles(projectID uuid.UUID) ([]FileInfo, error) {
+	files, err := s.list(projectID.String() + "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project files: %w", err)
+	}
+	return files, nil
+}
+
+// CopyFile copies a file from one path to another without downloading it
+func (s *S3) CopyFile(srcRelativePath string, projectID uuid.UUID, storageType StorageType, filename string) (string, error) {
+	relativePath := Key(projectID, storageType, uniqueName(filename))
+
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+s.options.Bucket+"/"+escapePath(s.key(srcRelativePath)))
+
+	resp, err := s.do(http.MethodPut, relativePath, nil, header, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy file: %w", err)
+	}
+	resp.Body.Close()
+
+	return relativePath, nil
+}
+
+// Exists checks if a file exists
+func (s *S3) Exists(relativePath string) bool {
+	resp, err := s.do(http.MethodHead, relativePath, nil, nil, nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns every object under prefix
+func (s *S3) list(prefix string) ([]FileInfo, error) {
+	var files []FileInfo
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.key(prefix))
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object listing: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			key := object.Key
+			if s.options.Prefix != "" {
+				key = strings.TrimPrefix(key, s.options.Prefix+"/")
+			}
+			files = append(files, FileInfo{Path: key, Size: object.Size, ModTime: object.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// key maps a relative storage path to an object key in the bucket
+func (s *S3) key(relativePath string) string {
+	if s.options.Prefix == "" {
+		return relativePath
+	}
+	return s.options.Prefix + "/" + relativePath
+}
+
+// objectURL returns the URL for an object, or for the bucket when relativePath is empty
+func (s *S3) objectURL(relativePath string, query url.Values) *url.URL {
+	u := *s.endpoint
+	objectPath := ""
+	if relativePath != "" {
+		objectPath = "/" + s.key(relativePath)
+	}
+
+	if s.options.UsePathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.options.Bucket + objectPath
+	} else {
+		u.Host = s.options.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + cmp.Or(objectPath, "/")
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request and turns non-2xx responses into errors
+func (s *S3) do(method, relativePath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+
+	u := s.objectURL(relativePath, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		cancel()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	// Keep the context alive until the caller is done reading the body
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// sign adds AWS Signature Version 4 headers to a request
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host, content type and every x-amz-* header
+	names := []string{"host"}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.options.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.options.SecretAccessKey), date)
+	key = hmacSHA256(key, s.options.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.options.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath percent-encodes each segment of a path as SigV4 expects
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape percent-encodes everything except RFC 3986 unreserved characters
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 expects
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, escape(name)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
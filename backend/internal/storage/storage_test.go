@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testStorage checks the behaviour every Storage must share
+func testStorage(t *testing.T, store Storage) {
+	t.Helper()
+
+	projectID := uuid.New()
+	otherProjectID := uuid.New()
+
+	saved, err := store.SaveFileWithName(projectID, StorageTypeBaseline, "home page/chrome.png", strings.NewReader("baseline"))
+	if err != nil {
+		t.Fatalf("SaveFileWithName: %v", err)
+	}
+	if want := Key(projectID, StorageTypeBaseline, "home page/chrome.png"); saved != want {
+		t.Errorf("SaveFileWithName path = %q, want %q", saved, want)
+	}
+	if got := readFile(t, store, saved); got != "baseline" {
+		t.Errorf("GetFile = %q, want %q", got, "baseline")
+	}
+
+	// Saving under the same name replaces the file
+	if _, err := store.SaveFileWithName(projectID, StorageTypeBaseline, "home page/chrome.png", strings.NewReader("replaced")); err != nil {
+		t.Fatalf("SaveFileWithName: %v", err)
+	}
+	if got := readFile(t, store, saved); got != "replaced" {
+		t.Errorf("GetFile after replace = %q, want %q", got, "replaced")
+	}
+
+	unique, err := store.SaveFile(projectID, StorageTypeSnapshot, "shot.png", strings.NewReader("snapshot"))
+	if err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	if !strings.HasPrefix(unique, projectID.String()+"/snapshots/") || !strings.HasSuffix(unique, ".png") {
+		t.Errorf("SaveFile path = %q, want a unique .png under the project's snapshots", unique)
+	}
+
+	copied, err := store.CopyFile(unique, projectID, StorageTypeDiff, "copy.png")
+	if err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if got := readFile(t, store, copied); got != "snapshot" {
+		t.Errorf("copied file = %q, want %q", got, "snapshot")
+	}
+
+	derived, err := store.SaveFileWithName(projectID, StorageTypeDerived, "abc/thumb.webp", strings.NewReader("derived"))
+	if err != nil {
+		t.Fatalf("SaveFileWithName: %v", err)
+	}
+	if _, err := store.SaveFileWithName(otherProjectID, StorageTypeBaseline, "other.png", strings.NewReader("other")); err != nil {
+		t.Fatalf("SaveFileWithName: %v", err)
+	}
+
+	if got, want := listPaths(t, store, projectID), sorted(saved, unique, copied, derived); !slices.Equal(got, want) {
+		t.Errorf("ListProjectFiles = %v, want %v", got, want)
+	}
+
+	if !store.Exists(derived) {
+		t.Errorf("Exists(%q) = false, want true", derived)
+	}
+	if err := store.DeleteDir(Key(projectID, StorageTypeDerived, "abc")); err != nil {
+		t.Fatalf("DeleteDir: %v", err)
+	}
+	if store.Exists(derived) {
+		t.Errorf("Exists(%q) after DeleteDir = true, want false", derived)
+	}
+	if err := store.DeleteDir(Key(projectID, StorageTypeDerived, "missing")); err != nil {
+		t.Errorf("DeleteDir of a missing directory: %v", err)
+	}
+
+	if err := store.DeleteFile(copied); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if err := store.DeleteFile(copied); err != nil {
+		t.Errorf("DeleteFile of a missing file: %v", err)
+	}
+	if _, err := store.GetFile(copied); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetFile of a deleted file = %v, want ErrNotFound", err)
+	}
+
+	if err := store.DeleteProjectFiles(projectID); err != nil {
+		t.Fatalf("DeleteProjectFiles: %v", err)
+	}
+	if got := listPaths(t, store, projectID); len(got) != 0 {
+		t.Errorf("ListProjectFiles after DeleteProjectFiles = %v, want none", got)
+	}
+	if got := listPaths(t, store, otherProjectID); len(got) != 1 {
+		t.Errorf("DeleteProjectFiles removed another project's files, left %v", got)
+	}
+}
+
+func readFile(t *testing.T, store Storage, relativePath string) string {
+	t.Helper()
+
+	file, err := store.GetFile(relativePath)
+	if err != nil {
+		t.Fatalf("GetFile(%q): %v", relativePath, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("reading %q: %v", relativePath, err)
+	}
+	return string(data)
+}
+
+func listPaths(t *testing.T, store Storage, projectID uuid.UUID) []string {
+	t.Helper()
+
+	files, err := store.ListProjectFiles(projectID)
+	if err != nil {
+		t.Fatalf("ListProjectFiles: %v", err)
+	}
+
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	return sorted(paths...)
+}
+
+func sorted(paths ...string) []string {
+	slices.Sort(paths)
+	return paths
+}
+
+func TestFileSystem(t *testing.T) {
+	store, err := NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, store)
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}
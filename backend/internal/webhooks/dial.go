@@ -0,0 +1,89 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when a webhook's host resolves to an address
+// inside a private network, which deliveries could otherwise be used to probe
+var ErrForbiddenAddress = errors.New("webhook address is not publicly routable")
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+/*
+newClient returns the client deliveries are sent with. Unless private
+networks are allowed, every connection it makes, redirects included, is
+checked once the host has been resolved, so a name can't be pointed at an
+internal address after the webhook was saved. No proxy is used, as the
+connection would then be made to the proxy rather than the receiver.
+*/
+func newClient(options Options) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = publicOnly
+	}
+
+	return &http.Client{
+		Timeout: options.Timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// publicOnly refuses connections to addresses that aren't publicly routable
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	if !publicAddr(addr) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+	}
+	return nil
+}
+
+// publicAddr reports whether addr can be reached from the public internet
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+/*
+CheckHost refuses a webhook host that is itself an address, or localhost,
+deliveries wouldn't be allowed to reach. Other names can resolve anywhere and
+are checked each time a delivery connects.
+*/
+func (d *Dispatcher) CheckHost(host string) error {
+	if d.options.AllowPrivateNetworks {
+		return nil
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
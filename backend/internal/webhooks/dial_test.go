@@ -0,0 +1,87 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := publicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("publicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if _, err := newClient(Options{}).Get(server.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("GET %s = %v, want ErrForbiddenAddress", server.URL, err)
+	}
+
+	resp, err := newClient(Options{AllowPrivateNetworks: true}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET with private networks allowed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("GET with private networks allowed = %d, want 204", resp.StatusCode)
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"hooks.example.com", true},
+		{"93.184.216.34", true},
+		{"localhost", false},
+		{"LOCALHOST.", false},
+		{"api.localhost", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"::1", false},
+	}
+
+	d := &Dispatcher{}
+	for _, tt := range tests {
+		if err := d.CheckHost(tt.host); (err == nil) != tt.allowed {
+			t.Errorf("CheckHost(%q) = %v, want allowed %v", tt.host, err, tt.allowed)
+		}
+	}
+
+	allowing := &Dispatcher{options: Options{AllowPrivateNetworks: true}}
+	if err := allowing.CheckHost("127.0.0.1"); err != nil {
+		t.Errorf("CheckHost with private networks allowed = %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+/*
+Package webhooks posts project events to the URLs projects have subscribed.
+Each event is written to the delivery log for every matching webhook when it
+is published, then a pool of workers claims deliveries from Postgres and sends
+them, retrying failures with exponential backoff. Payloads are signed with the
+webhook's secret so receivers can check they came from diffit.
+*/
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/crzytrane/diffit/internal/events"
+	"github.com/crzytrane/diffit/internal/models"
+	"github.com/crzytrane/diffit/internal/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Diffit-Event"
+	DeliveryHeader  = "X-Diffit-Delivery"
+	SignatureHeader = "X-Diffit-Signature-256"
+)
+
+// maxResponseBody is how much of a receiver's response is kept in the log
+const maxResponseBody = 4 << 10
+
+// Options configures the delivery workers
+type Options struct {
+	// Workers is the number of deliveries sent concurrently
+	Workers int
+	// MaxAttempts is how many times a delivery is tried before it is failed
+	MaxAttempts int
+	// Timeout is how long a receiver has to respond
+	Timeout time.Duration
+	// PollInterval is how often idle workers check for due deliveries
+	PollInterval time.Duration
+	// RetryBackoff is the delay before the first retry, doubled on each attempt
+	RetryBackoff time.Duration
+	// StaleAfter is how long a delivery can be in flight before another
+	// worker is allowed to pick it up
+	StaleAfter time.Duration
+	// AllowPrivateNetworks lets deliveries go to loopback, private and
+	// link-local addresses, for receivers on the same network as diffit
+	AllowPrivateNetworks bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers < 1 {
+		o.Workers = 1
+	}
+	if o.MaxAttempts < 1 {
+		o.MaxAttempts = 1
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 5 * time.Second
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 30 * time.Second
+	}
+	if o.StaleAfter <= 0 {
+		o.StaleAfter = 5 * time.Minute
+	}
+	return o
+}
+
+// Dispatcher records and sends webhook deliveries
+type Dispatcher struct {
+	webhooks   *repository.WebhookRepository
+	deliveries *repository.WebhookDeliveryRepository
+	client     *http.Client
+	options    Options
+
+	wake chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a Dispatcher, subscribe its Handle method to an events.Bus
+func New(pool *pgxpool.Pool, options Options) *Dispatcher {
+	options = options.withDefaults()
+	return &Dispatcher{
+		webhooks:   repository.NewWebhookRepository(pool),
+		deliveries: repository.NewWebhookDeliveryRepository(pool),
+		client:     newClient(options),
+		options:    options,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Handle queues a delivery of the event to each webhook subscribed to it.
+// Failing to queue is logged rather than failing whatever caused the event.
+func (d *Dispatcher) Handle(ctx context.Context, event events.Event) {
+	// Progress events are only for the frontend
+	if !slices.Contains(models.WebhookEvents, event.Type) {
+		return
+	}
+
+	// Record the deliveries even if the request that caused the event is gone
+	ctx = context.WithoutCancel(ctx)
+
+	subscribed, err := d.webhooks.ListSubscribed(ctx, event.ProjectID, event.Type)
+	if err != nil {
+		log.Printf("webhooks: %s: %v", event.Type, err)
+		return
+	}
+	if len(subscribed) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhooks: failed to encode %s: %v", event.Type, err)
+		return
+	}
+
+	for _, webhook := range subscribed {
+		if _, err := d.deliveries.Enqueue(ctx, webhook.ID, event.Type, event.ID, payload, d.options.MaxAttempts, nil); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+	}
+	d.nudge()
+}
+
+// Redeliver queues a fresh copy of a delivery, keeping its event ID so
+// receivers can tell it is the same event
+func (d *Dispatcher) Redeliver(ctx context.Context, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	redelivery, err := d.deliveries.Enqueue(ctx, delivery.WebhookID, delivery.Event, delivery.EventID, delivery.Payload, d.options.MaxAttempts, &delivery.ID)
+	if err != nil {
+		return nil, err
+	}
+	d.nudge()
+	return redelivery, nil
+}
+
+// nudge wakes an idle worker on this replica, others pick deliveries up on
+// their next poll
+func (d *Dispatcher) nudge() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches the workers. They stop once ctx is cancelled, use Wait to
+// block until in-flight deliveries have finished.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < d.options.Workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.run(ctx)
+		}()
+	}
+}
+
+// Wait blocks until all workers have stopped
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(d.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			delivery, err := d.deliveries.Claim(ctx, d.options.StaleAfter)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("webhooks: %v", err)
+				}
+				break
+			}
+			if delivery == nil {
+				break
+			}
+			d.handle(ctx, delivery)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// handle sends a claimed delivery and records the outcome with a fresh
+// context so a shutdown mid-request doesn't leave it marked as running
+func (d *Dispatcher) handle(ctx context.Context, delivery *models.WebhookDelivery) {
+	responseStatus, responseBody, err := d.send(ctx, delivery)
+
+	recordCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err == nil {
+		if err := d.deliveries.Succeed(recordCtx, delivery.ID, *responseStatus, *responseBody); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+		return
+	}
+
+	reason := err.Error()
+	if delivery.Attempts < delivery.MaxAttempts && err != errDisabled {
+		backoff := d.options.RetryBackoff << (delivery.Attempts - 1)
+		if err := d.deliveries.Retry(recordCtx, delivery.ID, responseStatus, responseBody, reason, time.Now().Add(backoff)); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+		return
+	}
+
+	log.Printf("webhooks: delivery %s failed after %d attempts: %v", delivery.ID, delivery.Attempts, err)
+	if err := d.deliveries.Fail(recordCtx, delivery.ID, responseStatus, responseBody, reason); err != nil {
+		log.Printf("webhooks: %v", err)
+	}
+}
+
+// errDisabled fails deliveries queued before their webhook was disabled
+var errDisabled = errors.New("webhook is disabled")
+
+// send posts a delivery to its webhook. The response status and body are
+// returned whenever the receiver answered, even if it answered with an error.
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) (*int, *string, error) {
+	webhook, err := d.webhooks.GetByID(ctx, delivery.WebhookID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !webhook.Enabled {
+		return nil, nil, errDisabled
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "diffit-webhooks")
+	req.Header.Set(EventHeader, string(delivery.Event))
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	responseBody := string(bytes.ToValidUTF8(body, nil))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &resp.StatusCode, &responseBody, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+
+	return &resp.StatusCode, &responseBody, nil
+}
+
+/*
+Sign returns the signature header value for a payload: "sha256=" followed by
+the hex HMAC-SHA256 of the body keyed with the webhook secret. Receivers
+should compute the same over the raw body and compare in constant time.
+*/
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SecretPrefix starts every generated signing secret
+const SecretPrefix = "whsec_"
+
+// NewSecret generates a random signing secret
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}